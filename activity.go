@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const activityWindow = 30 * 24 * time.Hour
+
+// ProfileActivity is what a pubkey has been up to lately, shown as a strip on their profile page
+type ProfileActivity struct {
+	Posted            []KindCount
+	ReactionsReceived int
+	ZapsReceived      int
+	SatsReceived      int64
+}
+
+type KindCount struct {
+	Kind  int
+	Name  string
+	Count int
+}
+
+func (kc KindCount) String() string {
+	name := kc.Name
+	if name == "" {
+		name = "Kind " + strconv.Itoa(kc.Kind)
+	}
+	return name + " × " + strconv.Itoa(kc.Count)
+}
+
+func (pa ProfileActivity) IsEmpty() bool {
+	return len(pa.Posted) == 0 && pa.ReactionsReceived == 0 && pa.ZapsReceived == 0
+}
+
+// activityAggregator summarizes the recent events by and to a pubkey
+type activityAggregator struct {
+	// fetch may return anything recent authored by or tagging the pubkey, the summary sorts them out
+	fetch func(ctx context.Context, pubkey string, since nostr.Timestamp) []*nostr.Event
+}
+
+var profileActivity = activityAggregator{fetch: fetchProfileActivity}
+
+func (aa activityAggregator) summarize(ctx context.Context, pubkey string) ProfileActivity {
+	since := nostr.Timestamp(time.Now().Add(-activityWindow).Unix())
+	return summarizeActivity(pubkey, since, aa.fetch(ctx, pubkey, since))
+}
+
+func summarizeActivity(pubkey string, since nostr.Timestamp, events []*nostr.Event) ProfileActivity {
+	activity := ProfileActivity{}
+	seen := make(map[string]struct{}, len(events))
+
+	for _, evt := range events {
+		if evt.CreatedAt < since {
+			continue
+		}
+		if _, ok := seen[evt.ID]; ok {
+			continue
+		}
+		seen[evt.ID] = struct{}{}
+
+		switch {
+		case evt.Kind == nostr.KindZap:
+			if evt.Tags.FindWithValue("p", pubkey) != nil {
+				activity.ZapsReceived++
+				activity.SatsReceived += zapAmount(evt) / 1000
+			}
+		case evt.PubKey == pubkey && !nostr.IsReplaceableKind(evt.Kind):
+			idx := slices.IndexFunc(activity.Posted, func(kc KindCount) bool { return kc.Kind == evt.Kind })
+			if idx == -1 {
+				activity.Posted = append(activity.Posted, KindCount{Kind: evt.Kind, Name: kindNames[evt.Kind]})
+				idx = len(activity.Posted) - 1
+			}
+			activity.Posted[idx].Count++
+		case evt.Kind == nostr.KindReaction:
+			if evt.Tags.FindWithValue("p", pubkey) != nil {
+				activity.ReactionsReceived++
+			}
+		}
+	}
+
+	slices.SortStableFunc(activity.Posted, func(a, b KindCount) int { return b.Count - a.Count })
+	return activity
+}
+
+// zapAmount returns the millisatoshis paid by a zap receipt, as in its bolt11 invoice,
+// or, if that can't be read, as in the zap request it carries
+func zapAmount(receipt *nostr.Event) int64 {
+	if tag := receipt.Tags.Find("bolt11"); tag != nil {
+		if msats, ok := bolt11Amount(tag[1]); ok {
+			return msats
+		}
+	}
+
+	if tag := receipt.Tags.Find("description"); tag != nil {
+		request := nostr.Event{}
+		if err := json.Unmarshal([]byte(tag[1]), &request); err == nil {
+			if amount := request.Tags.Find("amount"); amount != nil {
+				if msats, err := strconv.ParseInt(amount[1], 10, 64); err == nil && msats > 0 {
+					return msats
+				}
+			}
+		}
+	}
+
+	return 0
+}
+
+// bolt11Multipliers are the millisatoshis in each unit an invoice amount can be given in
+var bolt11Multipliers = map[byte]float64{'m': 100_000_000, 'u': 100_000, 'n': 100, 'p': 0.1}
+
+// bolt11Amount reads the amount from the human-readable part of a lightning invoice, like "lnbc2500u1..."
+func bolt11Amount(invoice string) (int64, bool) {
+	invoice = strings.ToLower(invoice)
+	sep := strings.LastIndexByte(invoice, '1')
+	if !strings.HasPrefix(invoice, "ln") || sep == -1 {
+		return 0, false
+	}
+	hrp := invoice[2:sep]
+
+	// skip the network prefix (bc, tb, bcrt...) until the amount digits start
+	start := strings.IndexAny(hrp, "0123456789")
+	if start == -1 {
+		return 0, false
+	}
+	amount := hrp[start:]
+
+	// no multiplier means the amount is in whole bitcoins
+	multiplier := 100_000_000_000.0
+	if m, ok := bolt11Multipliers[amount[len(amount)-1]]; ok {
+		multiplier = m
+		amount = amount[:len(amount)-1]
+	}
+
+	value, err := strconv.ParseInt(amount, 10, 64)
+	if err != nil || value <= 0 {
+		return 0, false
+	}
+	return int64(float64(value) * multiplier), true
+}
+
+// activityRefresh keeps the background fetches for profile activity to one per pubkey every so often
+var activityRefresh = newRelayRefresher(time.Minute*15, time.Second*10, 8)
+
+// fetchProfileActivity reads what we have locally and asks the author's relays for more in the background,
+// so the summary gets more complete on the next visits without making this one slower
+func fetchProfileActivity(ctx context.Context, pubkey string, since nostr.Timestamp) []*nostr.Event {
+	filters := nostr.Filters{
+		{Authors: []string{pubkey}, Since: &since, Limit: 500},
+		{Kinds: []int{nostr.KindReaction, nostr.KindZap}, Tags: nostr.TagMap{"p": []string{pubkey}}, Since: &since, Limit: 500},
+	}
+
+	var events []*nostr.Event
+	for _, filter := range filters {
+		res, _ := sys.StoreRelay.QuerySync(ctx, filter)
+		events = append(events, res...)
+	}
+
+	activityRefresh.refresh(pubkey, func(ctx context.Context) {
+		relays := sys.FetchOutboxRelays(ctx, pubkey, 3)
+		for len(relays) < 3 {
+			relays = appendUnique(relays, sys.FallbackRelays.Next())
+		}
+		for _, filter := range filters {
+			for ie := range sys.Pool.FetchMany(ctx, relays, filter, nostr.WithLabel("activity")) {
+				sys.Store.SaveEvent(ctx, ie.Event)
+			}
+		}
+	})
+
+	return events
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProfileActivitySummary(t *testing.T) {
+	now := nostr.Now()
+	zapRequest, _ := json.Marshal(nostr.Event{Kind: 9734, Tags: nostr.Tags{{"amount", "21000"}, {"p", testPubkey1}}})
+	events := []*nostr.Event{
+		{ID: "n1", PubKey: testPubkey1, Kind: 1, CreatedAt: now - 10},
+		{ID: "n2", PubKey: testPubkey1, Kind: 1, CreatedAt: now - 20},
+		{ID: "n2", PubKey: testPubkey1, Kind: 1, CreatedAt: now - 20}, // the same note from another relay
+		{ID: "a1", PubKey: testPubkey1, Kind: 30023, CreatedAt: now - 30},
+		{ID: "p1", PubKey: testPubkey1, Kind: 0, CreatedAt: now - 30},
+		{ID: "old", PubKey: testPubkey1, Kind: 1, CreatedAt: now - 60*60*24*60},
+		{ID: "r1", PubKey: testPubkey2, Kind: 7, CreatedAt: now - 5, Tags: nostr.Tags{{"p", testPubkey1}}},
+		{ID: "r2", PubKey: testPubkey2, Kind: 7, CreatedAt: now - 5, Tags: nostr.Tags{{"p", testPubkey2}}},
+		{ID: "z1", PubKey: testPubkey2, Kind: 9735, CreatedAt: now - 5, Tags: nostr.Tags{{"p", testPubkey1}, {"bolt11", "lnbc2500u1pvjluezpp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypq"}}},
+		{ID: "z2", PubKey: testPubkey2, Kind: 9735, CreatedAt: now - 5, Tags: nostr.Tags{{"p", testPubkey1}, {"description", string(zapRequest)}}},
+	}
+	aggregator := activityAggregator{fetch: func(ctx context.Context, pubkey string, since nostr.Timestamp) []*nostr.Event {
+		return events
+	}}
+
+	activity := aggregator.summarize(context.Background(), testPubkey1)
+	assert.Equal(t, []KindCount{{Kind: 1, Name: kindNames[1], Count: 2}, {Kind: 30023, Name: kindNames[30023], Count: 1}}, activity.Posted)
+	assert.Equal(t, 1, activity.ReactionsReceived)
+	assert.Equal(t, 2, activity.ZapsReceived)
+	assert.Equal(t, int64(250000+21), activity.SatsReceived)
+
+	var buf bytes.Buffer
+	assert.NoError(t, profileActivityTemplate(activity).Render(context.Background(), &buf))
+	doc, err := goquery.NewDocumentFromReader(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, kindNames[1]+" × 2", doc.Find(".activity-posted").First().Text())
+	assert.Equal(t, "1 reactions received", doc.Find(".activity-reactions").Text())
+	assert.Equal(t, "2 zaps received (250021 sats)", doc.Find(".activity-zaps").Text())
+
+	assert.True(t, summarizeActivity(testPubkey2, 0, nil).IsEmpty())
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"regexp"
 	"strings"
@@ -32,6 +33,33 @@ func parseWikilinks(asciidoc string) string {
 	return transformedText
 }
 
+// normalizeWikiHandle turns a title into the form used in the d tag of wiki articles
+func normalizeWikiHandle(title string) string {
+	return strings.ToLower(strings.Join(strings.Fields(title), "-"))
+}
+
+var (
+	asciidocMarkers = regexp.MustCompile(`(?m)^(=+ \S|:[a-z-]+:|image::|\[source|----$|\*\*\*\*$)|link:\S+\[`)
+	markdownMarkers = regexp.MustCompile(`(?m)^(#{1,6} \S|` + "```" + `)|\]\(\S+\)`)
+)
+
+// looksLikeMarkdown tells if wiki content was written in markdown rather than asciidoc,
+// by counting syntax constructs that are exclusive to each (or at least much more common in one)
+func looksLikeMarkdown(content string) bool {
+	md := len(markdownMarkers.FindAllStringIndex(content, -1))
+	adoc := len(asciidocMarkers.FindAllStringIndex(content, -1))
+	return md > adoc
+}
+
+// wikiContentToHTML renders wiki content, which NIP-54 says is asciidoc, but which many clients
+// publish as markdown anyway
+func wikiContentToHTML(ctx context.Context, content string) string {
+	if looksLikeMarkdown(content) {
+		return mdToHTML(ctx, content, false)
+	}
+	return asciidocToHTML(content)
+}
+
 func asciidocToHTML(asciidoc string) string {
 	// Parsing wikilinks
 	asciidoc = parseWikilinks(asciidoc)
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWikiArticle(t *testing.T) {
+	asciidoc := nostr.Event{
+		Kind:      30818,
+		CreatedAt: 1710000000,
+		Tags:      nostr.Tags{{"d", "bitcoin-script"}},
+		Content:   "Bitcoin Script is a *stack-based* language.\n\n== Opcodes\n\nSee link:https://example.com/ops[the list].",
+	}
+	wiki := parseKind30818Metadata(asciidoc)
+	assert.Equal(t, "bitcoin script", wiki.Title)
+	assert.Equal(t, "bitcoin-script", normalizeWikiHandle(wiki.Title))
+	assert.Equal(t, asciidoc.CreatedAt.Time(), wiki.PublishedAt)
+
+	var buf bytes.Buffer
+	err := wikiInnerBlock(WikiPageParams{
+		WikiEvent: wiki,
+		Content:   wikiContentToHTML(context.Background(), asciidoc.Content),
+	}).Render(context.Background(), &buf)
+	assert.NoError(t, err)
+	doc, err := goquery.NewDocumentFromReader(&buf)
+	assert.NoError(t, err)
+	assert.Contains(t, doc.Find("h1").Text(), "bitcoin script")
+	assert.Equal(t, "stack-based", doc.Find("strong").First().Text())
+	assert.Equal(t, "Opcodes", strings.TrimSpace(doc.Find("h2").First().Text()))
+	assert.Equal(t, "https://example.com/ops", doc.Find("a").First().AttrOr("href", ""))
+
+	markdown := nostr.Event{
+		Kind:    30818,
+		Tags:    nostr.Tags{{"d", "lightning-network"}, {"title", "Lightning Network"}, {"published_at", "1700000000"}},
+		Content: "# Overview\n\nA **layer two** protocol, see [the paper](https://lightning.network/paper.pdf).\n\n```\nlncli getinfo\n```",
+	}
+	wiki = parseKind30818Metadata(markdown)
+	assert.Equal(t, "Lightning Network", wiki.Title)
+	assert.Equal(t, int64(1700000000), wiki.PublishedAt.Unix())
+	assert.True(t, looksLikeMarkdown(markdown.Content))
+	assert.False(t, looksLikeMarkdown(asciidoc.Content))
+
+	doc, err = goquery.NewDocumentFromReader(strings.NewReader(wikiContentToHTML(context.Background(), markdown.Content)))
+	assert.NoError(t, err)
+	assert.Equal(t, "Overview", doc.Find("h1").Text())
+	assert.Equal(t, "layer two", doc.Find("strong").Text())
+	assert.Equal(t, "https://lightning.network/paper.pdf", doc.Find("a").AttrOr("href", ""))
+	assert.Contains(t, doc.Find("pre code").Text(), "lncli getinfo")
+}
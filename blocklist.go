@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// Blocklist holds the pubkeys and event ids the operator doesn't want rendered at all, usually
+// because of a takedown request, unlike bans it comes from the settings and can't be changed at runtime
+type Blocklist struct {
+	pubkeys map[string]struct{}
+	events  map[string]struct{}
+}
+
+var blocklist Blocklist
+
+// newBlocklist takes pubkeys and event ids either as hex or as NIP-19 codes (npub, nprofile, note, nevent)
+func newBlocklist(pubkeys []string, events []string) (Blocklist, error) {
+	bl := Blocklist{
+		pubkeys: make(map[string]struct{}, len(pubkeys)),
+		events:  make(map[string]struct{}, len(events)),
+	}
+
+	for _, entry := range pubkeys {
+		pubkey := entry
+		if !nostr.IsValidPublicKey(pubkey) {
+			switch prefix, value, _ := nip19.Decode(entry); prefix {
+			case "npub":
+				pubkey = value.(string)
+			case "nprofile":
+				pubkey = value.(nostr.ProfilePointer).PublicKey
+			default:
+				return bl, fmt.Errorf("invalid blocked pubkey %q", entry)
+			}
+		}
+		bl.pubkeys[pubkey] = struct{}{}
+	}
+
+	for _, entry := range events {
+		id := entry
+		if !nostr.IsValid32ByteHex(id) {
+			switch prefix, value, _ := nip19.Decode(entry); prefix {
+			case "note":
+				id = value.(string)
+			case "nevent":
+				id = value.(nostr.EventPointer).ID
+			default:
+				return bl, fmt.Errorf("invalid blocked event %q", entry)
+			}
+		}
+		bl.events[id] = struct{}{}
+	}
+
+	return bl, nil
+}
+
+func (bl Blocklist) blocksPubkey(pubkey string) bool {
+	_, blocked := bl.pubkeys[pubkey]
+	return blocked
+}
+
+// blocks tells if the event or its author are in the blocklist
+func (bl Blocklist) blocks(evt *nostr.Event) bool {
+	if _, blocked := bl.events[evt.ID]; blocked {
+		return true
+	}
+	return bl.blocksPubkey(evt.PubKey)
+}
+
+// filter removes the blocked events from a list, for feeds and sitemaps
+func (bl Blocklist) filter(events []EnhancedEvent) []EnhancedEvent {
+	if len(bl.pubkeys) == 0 && len(bl.events) == 0 {
+		return events
+	}
+
+	allowed := events[:0]
+	for _, ee := range events {
+		if !bl.blocks(ee.Event) {
+			allowed = append(allowed, ee)
+		}
+	}
+	return allowed
+}
+
+// renderBlocked writes a generic page with a 451 status, we don't say what was blocked or why
+func renderBlocked(ctx context.Context, w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "max-age=60")
+	w.WriteHeader(http.StatusUnavailableForLegalReasons)
+	errorTemplate(ErrorPageParams{
+		HeadParams: HeadParams{NoIndex: true},
+		Errors:     "unavailable",
+		Message:    "This content is not available on this server.",
+	}).Render(ctx, w)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlocklist(t *testing.T) {
+	sign := func(content string) nostr.Event {
+		evt := nostr.Event{Kind: 1, CreatedAt: 1710000000, Tags: nostr.Tags{}, Content: content}
+		assert.NoError(t, evt.Sign(nostr.GeneratePrivateKey()))
+		return evt
+	}
+	preview := func(evt nostr.Event) *httptest.ResponseRecorder { return testPreview(t, "/preview", evt) }
+
+	blockedAuthor := sign("from a blocked author")
+	blockedEvent := sign("a blocked event")
+	allowed := sign("nothing wrong here")
+
+	npub, _ := nip19.EncodePublicKey(blockedAuthor.PubKey)
+	nevent, _ := nip19.EncodeEvent(blockedEvent.ID, nil, "")
+	bl, err := newBlocklist([]string{npub}, []string{nevent})
+	assert.NoError(t, err)
+
+	previous := blocklist
+	blocklist = bl
+	defer func() { blocklist = previous }()
+
+	w := preview(blockedAuthor)
+	assert.Equal(t, http.StatusUnavailableForLegalReasons, w.Code)
+	assert.NotContains(t, w.Body.String(), "from a blocked author")
+
+	w = preview(blockedEvent)
+	assert.Equal(t, http.StatusUnavailableForLegalReasons, w.Code)
+	assert.NotContains(t, w.Body.String(), "a blocked event")
+
+	w = preview(allowed)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "nothing wrong here")
+
+	feed := blocklist.filter([]EnhancedEvent{{Event: &blockedAuthor}, {Event: &allowed}, {Event: &blockedEvent}})
+	assert.Len(t, feed, 1)
+	assert.Equal(t, allowed.ID, feed[0].ID)
+
+	_, err = newBlocklist([]string{nevent}, nil)
+	assert.Error(t, err)
+	_, err = newBlocklist(nil, []string{"nonsense"})
+	assert.Error(t, err)
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"cmp"
+	"slices"
 	"strings"
 
 	"github.com/a-h/templ"
+	"github.com/nbd-wtf/go-nostr/nip19"
 )
 
 type ClientReference struct {
@@ -12,6 +15,10 @@ type ClientReference struct {
 	Base     string
 	URL      templ.SafeURL
 	Platform string
+
+	// WithRelays is set for clients that find events better when the code carries relay hints,
+	// the others get the code without them
+	WithRelays bool
 }
 
 const (
@@ -21,38 +28,40 @@ const (
 )
 
 var (
-	native = ClientReference{ID: "native", Name: "Your default app", Base: "nostr:{code}", Platform: "native"}
+	native = ClientReference{ID: "native", Name: "Your default app", Base: "nostr:{code}", Platform: "native", WithRelays: true}
 
 	nosta        = ClientReference{ID: "nosta", Name: "Nosta", Base: "https://nosta.me/{code}", Platform: platformWeb}
-	snort        = ClientReference{ID: "snort", Name: "Snort", Base: "https://snort.social/{code}", Platform: platformWeb}
+	snort        = ClientReference{ID: "snort", Name: "Snort", Base: "https://snort.social/{code}", Platform: platformWeb, WithRelays: true}
 	olasWeb      = ClientReference{ID: "olas", Name: "Olas", Base: "https://olas.app/e/{code}", Platform: platformWeb}
 	primalWeb    = ClientReference{ID: "primal", Name: "Primal", Base: "https://primal.net/e/{code}", Platform: platformWeb}
-	nostrudel    = ClientReference{ID: "nostrudel", Name: "Nostrudel", Base: "https://nostrudel.ninja/l/{code}", Platform: platformWeb}
-	nostter      = ClientReference{ID: "nostter", Name: "Nostter", Base: "https://nostter.app/{code}", Platform: platformWeb}
+	nostrudel    = ClientReference{ID: "nostrudel", Name: "Nostrudel", Base: "https://nostrudel.ninja/l/{code}", Platform: platformWeb, WithRelays: true}
+	nostter      = ClientReference{ID: "nostter", Name: "Nostter", Base: "https://nostter.app/{code}", Platform: platformWeb, WithRelays: true}
 	nostterRelay = ClientReference{ID: "nostter", Name: "Nostter", Base: "https://nostter.app/relays/wss%3A%2F%2F{code}", Platform: platformWeb}
-	jumble       = ClientReference{ID: "jumble", Name: "Jumble", Base: "https://jumble.social/notes/{code}", Platform: platformWeb}
+	jumble       = ClientReference{ID: "jumble", Name: "Jumble", Base: "https://jumble.social/notes/{code}", Platform: platformWeb, WithRelays: true}
 	jumbleRelay  = ClientReference{ID: "jumble", Name: "Jumble", Base: "https://jumble.social/?r=wss://{code}", Platform: platformWeb}
-	coracle      = ClientReference{ID: "coracle", Name: "Coracle", Base: "https://coracle.social/{code}", Platform: platformWeb}
+	coracle      = ClientReference{ID: "coracle", Name: "Coracle", Base: "https://coracle.social/{code}", Platform: platformWeb, WithRelays: true}
 	coracleRelay = ClientReference{ID: "coracle", Name: "Coracle", Base: "https://coracle.social/relays/wss%3A%2F%2F{code}", Platform: platformWeb}
 	relayTools   = ClientReference{ID: "relay.tools", Name: "relay.tools", Base: "https://relay.tools/posts/?relay=wss://{code}"}
-	iris         = ClientReference{ID: "iris", Name: "Iris", Base: "https://iris.to/{code}", Platform: "web"}
+	iris         = ClientReference{ID: "iris", Name: "Iris", Base: "https://iris.to/{code}", Platform: "web", WithRelays: true}
 
-	zapStream = ClientReference{ID: "zap.stream", Name: "zap.stream", Base: "https://zap.stream/{code}", Platform: platformWeb}
+	zapStream = ClientReference{ID: "zap.stream", Name: "zap.stream", Base: "https://zap.stream/{code}", Platform: platformWeb, WithRelays: true}
 
-	yakihonne = ClientReference{ID: "yakihonne", Name: "YakiHonne", Base: "https://yakihonne.com/{code}", Platform: platformWeb}
-	habla     = ClientReference{ID: "habla", Name: "Habla", Base: "https://habla.news/a/{code}", Platform: platformWeb}
+	yakihonne   = ClientReference{ID: "yakihonne", Name: "YakiHonne", Base: "https://yakihonne.com/{code}", Platform: platformWeb}
+	habla       = ClientReference{ID: "habla", Name: "Habla", Base: "https://habla.news/a/{code}", Platform: platformWeb}
+	highlighter = ClientReference{ID: "highlighter", Name: "Highlighter", Base: "https://highlighter.com/a/{code}", Platform: platformWeb}
+	blogstack   = ClientReference{ID: "blogstack", Name: "Blogstack", Base: "https://blogstack.io/{code}", Platform: platformWeb}
 
-	voyage           = ClientReference{ID: "voyage", Name: "Voyage", Base: "intent:{code}#Intent;scheme=nostr;package=com.dluvian.voyage;end`;", Platform: platformAndroid}
+	voyage           = ClientReference{ID: "voyage", Name: "Voyage", Base: "intent:{code}#Intent;scheme=nostr;package=com.dluvian.voyage;end`;", Platform: platformAndroid, WithRelays: true}
 	olasAndroid      = ClientReference{ID: "olas", Name: "Olas", Base: "intent:{code}#Intent;scheme=nostr;package=com.pablof7z.snapstr;end`;", Platform: platformAndroid}
 	primalAndroid    = ClientReference{ID: "primal", Name: "Primal", Base: "intent:{code}#Intent;scheme=nostr;package=net.primal.android;end`;", Platform: platformAndroid}
 	yakihonneAndroid = ClientReference{ID: "yakihonne", Name: "Yakihonne", Base: "intent:{code}#Intent;scheme=nostr;package=com.yakihonne.yakihonne;end`;", Platform: platformAndroid}
 	freeFromAndroid  = ClientReference{ID: "freefrom", Name: "FreeFrom", Base: "intent:{code}#Intent;scheme=nostr;package=com.freefrom;end`;", Platform: platformAndroid}
-	yanaAndroid      = ClientReference{ID: "yana", Name: "Yana", Base: "intent:{code}#Intent;scheme=nostr;package=yana.nostr;end`;", Platform: platformAndroid}
-	amethyst         = ClientReference{ID: "amethyst", Name: "Amethyst", Base: "intent:{code}#Intent;scheme=nostr;package=com.vitorpamplona.amethyst;end`;", Platform: platformAndroid}
+	yanaAndroid      = ClientReference{ID: "yana", Name: "Yana", Base: "intent:{code}#Intent;scheme=nostr;package=yana.nostr;end`;", Platform: platformAndroid, WithRelays: true}
+	amethyst         = ClientReference{ID: "amethyst", Name: "Amethyst", Base: "intent:{code}#Intent;scheme=nostr;package=com.vitorpamplona.amethyst;end`;", Platform: platformAndroid, WithRelays: true}
 
-	nos          = ClientReference{ID: "nos", Name: "Nos", Base: "nos:{code}", Platform: platformIOS}
-	damus        = ClientReference{ID: "damus", Name: "Damus", Base: "damus:{code}", Platform: platformIOS}
-	nostur       = ClientReference{ID: "nostur", Name: "Nostur", Base: "nostur:{code}", Platform: platformIOS}
+	nos          = ClientReference{ID: "nos", Name: "Nos", Base: "nos:{code}", Platform: platformIOS, WithRelays: true}
+	damus        = ClientReference{ID: "damus", Name: "Damus", Base: "damus:{code}", Platform: platformIOS, WithRelays: true}
+	nostur       = ClientReference{ID: "nostur", Name: "Nostur", Base: "nostur:{code}", Platform: platformIOS, WithRelays: true}
 	olasIOS      = ClientReference{ID: "olas", Name: "Olas", Base: "olas:{code}", Platform: platformIOS}
 	primalIOS    = ClientReference{ID: "primal", Name: "Primal", Base: "primal:{code}", Platform: platformIOS}
 	freeFromIOS  = ClientReference{ID: "freefrom", Name: "FreeFrom", Base: "freefrom:{code}", Platform: platformIOS}
@@ -60,6 +69,8 @@ var (
 
 	wikistr     = ClientReference{ID: "wikistr", Name: "Wikistr", Base: "https://Wikistr.com/{handle}*{authorPubkey}", Platform: "web"}
 	wikifreedia = ClientReference{ID: "wikifreedia", Name: "Wikifreedia", Base: "https://wikifreedia.xyz/{handle}/{npub}", Platform: "web"}
+
+	gitworkshop = ClientReference{ID: "gitworkshop", Name: "gitworkshop.dev", Base: "https://gitworkshop.dev/{code}", Platform: platformWeb}
 )
 
 func generateClientList(
@@ -100,7 +111,7 @@ func generateClientList(
 			native,
 			damus, nos, nostur, yakihonneIOS,
 			yakihonneAndroid, amethyst,
-			yakihonne, habla,
+			yakihonne, habla, highlighter, blogstack,
 		}
 	case 1063:
 		clients = []ClientReference{
@@ -125,6 +136,11 @@ func generateClientList(
 			native,
 			wikistr, wikifreedia,
 		}
+	case 30617, 1617:
+		clients = []ClientReference{
+			native,
+			gitworkshop,
+		}
 	case 31922, 31923:
 		clients = []ClientReference{
 			native,
@@ -148,3 +164,48 @@ func generateClientList(
 
 	return clients
 }
+
+// withRelaysInCode is a modifier for generateClientList that puts the relays in the code for the
+// clients that can make use of them, the code given to generateClientList should be the bare one
+func withRelaysInCode(code string, relays []string) func(ClientReference, string) string {
+	hinted := code
+	if len(relays) > 0 {
+		if _, decoded, err := nip19.Decode(code); err == nil {
+			if withRelays := withRelayHints(decoded, relays); withRelays != "" {
+				hinted = withRelays
+			}
+		}
+	}
+
+	return func(c ClientReference, url string) string {
+		if !c.WithRelays || hinted == code || code == "" {
+			return url
+		}
+		return strings.Replace(url, code, hinted, -1)
+	}
+}
+
+// mergeClientMaps merges maps of clients keyed by id (later maps override earlier ones)
+// and returns them sorted by name, so the output doesn't depend on map iteration order
+func mergeClientMaps(maps ...map[string]ClientReference) []ClientReference {
+	merged := make(map[string]ClientReference)
+	for _, m := range maps {
+		for id, c := range m {
+			merged[id] = c
+		}
+	}
+
+	clients := make([]ClientReference, 0, len(merged))
+	for _, c := range merged {
+		clients = append(clients, c)
+	}
+	slices.SortFunc(clients, func(a, b ClientReference) int {
+		return cmp.Or(
+			cmp.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)),
+			cmp.Compare(a.Platform, b.Platform),
+			cmp.Compare(a.ID, b.ID),
+		)
+	})
+
+	return clients
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeClientMapsIsStable(t *testing.T) {
+	builtin := map[string]ClientReference{
+		"snort":     snort,
+		"coracle":   coracle,
+		"nostrudel": nostrudel,
+		"damus":     damus,
+	}
+	configured := map[string]ClientReference{
+		"jumble": jumble,
+		"snort":  {ID: "snort", Name: "Snort (custom)", Base: "https://snort.example/{code}", Platform: platformWeb},
+	}
+
+	first := mergeClientMaps(builtin, configured)
+	names := make([]string, len(first))
+	for i, c := range first {
+		names[i] = c.Name
+	}
+	assert.Equal(t, []string{"Coracle", "Damus", "Jumble", "Nostrudel", "Snort (custom)"}, names)
+
+	for i := 0; i < 50; i++ {
+		assert.Equal(t, first, mergeClientMaps(builtin, configured))
+	}
+}
+
+func TestLongFormClientLinks(t *testing.T) {
+	naddr := "naddr1qqxnzd3cxqmrzv3exgmr2wfeqgsxu35yyt0mwjjh8pcz4zprhxegz69t4wr9t74vk6zne58wzh0waycrqsqqqa28pjfdhz"
+
+	urls := make(map[string]string)
+	for _, client := range generateClientList(30023, naddr) {
+		urls[client.ID] = string(client.URL)
+	}
+
+	assert.Equal(t, "https://highlighter.com/a/"+naddr, urls["highlighter"])
+	assert.Equal(t, "https://habla.news/a/"+naddr, urls["habla"])
+	assert.Equal(t, "https://blogstack.io/"+naddr, urls["blogstack"])
+}
+
+func TestClientRelayHints(t *testing.T) {
+	id := "7b2f0b0d8a4bd1db3c8f2e9f1bd8a0ef2cac07ec4a2a866c795a4e32e1d23a63"
+	nevent, _ := nip19.EncodeEvent(id, nil, "")
+	relays := []string{"wss://nos.lol", "wss://relay.damus.io"}
+	hinted, _ := nip19.EncodeEvent(id, relays, "")
+
+	urls := make(map[string]string)
+	for _, client := range generateClientList(1, nevent, withRelaysInCode(nevent, relays)) {
+		urls[client.ID+"/"+client.Platform] = string(client.URL)
+	}
+	assert.Equal(t, "https://coracle.social/"+hinted, urls["coracle/web"])
+	assert.Equal(t, "https://jumble.social/notes/"+hinted, urls["jumble/web"])
+	assert.Equal(t, "nostr:"+hinted, urls["native/native"])
+	assert.Equal(t, "https://primal.net/e/"+nevent, urls["primal/web"])
+	assert.Equal(t, "yakihhone:"+nevent, urls["yakihonne/ios"])
+
+	naddr, _ := nip19.EncodeEntity(testPubkey1, 30023, "hello", nil)
+	hintedNaddr, _ := nip19.EncodeEntity(testPubkey1, 30023, "hello", relays)
+	urls = make(map[string]string)
+	for _, client := range generateClientList(30023, naddr, withRelaysInCode(naddr, relays)) {
+		urls[client.ID+"/"+client.Platform] = string(client.URL)
+	}
+	assert.Equal(t, "intent:"+hintedNaddr+"#Intent;scheme=nostr;package=com.vitorpamplona.amethyst;end`;", urls["amethyst/android"])
+	assert.Equal(t, "https://habla.news/a/"+naddr, urls["habla/web"])
+
+	// without relays everybody gets the same code
+	for _, client := range generateClientList(1, nevent, withRelaysInCode(nevent, nil)) {
+		assert.Contains(t, string(client.URL), nevent)
+	}
+}
@@ -3,9 +3,12 @@ package main
 import (
 	"context"
 	"fmt"
+	"html"
+	"net/http"
 	"regexp"
 	"slices"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/sdk"
@@ -15,6 +18,152 @@ func isMaliciousBridged(pm sdk.ProfileMetadata) bool {
 	return strings.Contains(pm.NIP05, "rape.pet") || strings.Contains(pm.NIP05, "rape-pet")
 }
 
+// moderation is what we have decided about showing an event, see moderate()
+type moderation int
+
+const (
+	allowed moderation = iota
+	blockedByConfig
+	bannedEvent
+	bannedPubkey
+	deletedByAuthor
+	prohibitedContent
+)
+
+// moderate applies everything that can keep an event off our domain: the configured blocklist, the events
+// and pubkeys banned by the admins, deletion requests from the author and prohibited content
+func moderate(ctx context.Context, ee EnhancedEvent) (decision moderation, reason string) {
+	if blocklist.blocks(ee.Event) {
+		return blockedByConfig, ""
+	}
+	if banned, reason := internal.isBannedEvent(ee.ID); banned {
+		return bannedEvent, reason
+	}
+	if banned, reason := internal.isBannedPubkey(ee.PubKey); banned {
+		return bannedPubkey, reason
+	}
+	if isDeleted(ee.Event, deletionRequests(ctx, ee.Event)) {
+		return deletedByAuthor, ""
+	}
+	hasURL := urlRegex.MatchString(ee.Content)
+	if isMaliciousBridged(ee.author) ||
+		(hasURL && hasProhibitedWordOrTag(ee.Event)) ||
+		(hasURL && hasExplicitMedia(ctx, ee.Event)) {
+		return prohibitedContent, ""
+	}
+	return allowed, ""
+}
+
+// renderIfNotAllowed writes the appropriate error if moderate() says the event can't be shown,
+// returning true in that case so the caller stops rendering
+func renderIfNotAllowed(ctx context.Context, w http.ResponseWriter, ee EnhancedEvent) bool {
+	decision, reason := moderate(ctx, ee)
+	switch decision {
+	case allowed:
+		return false
+	case blockedByConfig:
+		renderBlocked(ctx, w)
+	case bannedEvent:
+		w.Header().Set("Cache-Control", "max-age=60")
+		log.Warn().Str("event", ee.ID).Str("reason", reason).Msg("event banned")
+		http.Error(w, "event banned", http.StatusNotFound)
+	case bannedPubkey:
+		w.Header().Set("Cache-Control", "max-age=60")
+		log.Warn().Str("event", ee.ID).Str("reason", reason).Msg("pubkey banned")
+		http.Error(w, "pubkey banned", http.StatusNotFound)
+	case deletedByAuthor:
+		renderDeleted(ctx, w)
+	case prohibitedContent:
+		log.Warn().Str("event", ee.ID).Msg("detect prohibited content")
+		http.Error(w, "event is not allowed", http.StatusNotFound)
+	}
+	return true
+}
+
+// filterAllowed removes from a list the events moderate() says can't be shown
+func filterAllowed(ctx context.Context, events []EnhancedEvent) []EnhancedEvent {
+	allowedEvents := events[:0]
+	for _, ee := range events {
+		if decision, _ := moderate(ctx, ee); decision == allowed {
+			allowedEvents = append(allowedEvents, ee)
+		}
+	}
+	return allowedEvents
+}
+
+var embeddedMediaMatcher = regexp.MustCompile(`<img [^>]*>|<video[^>]*>.*?</video>`)
+
+// shouldBlurMedia tells if media posted by this author must be hidden until
+// clicked, which happens for everybody not in the allowlist when blurUntrusted is enabled
+func shouldBlurMedia(pubkey string, blurUntrusted bool, allowlist []string) bool {
+	return blurUntrusted && !slices.Contains(allowlist, pubkey)
+}
+
+// blurMedia wraps all the images and videos in an already rendered content in a collapsed <details>
+// that reveals them when clicked, which works without any scripts
+func blurMedia(content string) string {
+	return embeddedMediaMatcher.ReplaceAllString(content,
+		`<details class="blurred-media my-2">`+
+			`<summary class="inline-block cursor-pointer select-none rounded bg-neutral-200 px-2 text-sm dark:bg-neutral-700">show media</summary>`+
+			`$0</details>`)
+}
+
+var imageSrcMatcher = regexp.MustCompile(`src="([^"]*)"`)
+
+// blurSensitiveImages is like blurMedia, but only for the images flagged as sensitive, the others stay as they are
+func blurSensitiveImages(content string, sensitive map[string]string) string {
+	if len(sensitive) == 0 {
+		return content
+	}
+
+	flagged := make(map[string]string, len(sensitive))
+	for url, reason := range sensitive {
+		flagged[asciiURL(url)] = reason
+	}
+
+	return embeddedMediaMatcher.ReplaceAllStringFunc(content, func(media string) string {
+		src := imageSrcMatcher.FindStringSubmatch(media)
+		if !strings.HasPrefix(media, "<img ") || src == nil {
+			return media
+		}
+		reason, ok := flagged[html.UnescapeString(src[1])]
+		if !ok {
+			return media
+		}
+
+		label := "show sensitive image"
+		if reason != "" {
+			label += ": " + html.EscapeString(reason)
+		}
+		return `<details class="blurred-media sensitive-image my-2">` +
+			`<summary class="inline-block cursor-pointer select-none rounded bg-neutral-200 px-2 text-sm dark:bg-neutral-700">` + label + `</summary>` +
+			media + `</details>`
+	})
+}
+
+// toValidUTF8 replaces invalid (or truncated) UTF-8 sequences with the replacement character
+func toValidUTF8(text string) string {
+	if utf8.ValidString(text) {
+		return text
+	}
+	return strings.ToValidUTF8(text, "\uFFFD")
+}
+
+// normalizeInvisibleCharacters removes the bidirectional embedding, override and isolate controls,
+// which can be used to make a link or a name read differently from what it is, and, when stripZeroWidth
+// is set, also the zero-width characters (these are kept by default as joiners are part of emoji sequences)
+func normalizeInvisibleCharacters(text string, stripZeroWidth bool) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= '\u202A' && r <= '\u202E', r >= '\u2066' && r <= '\u2069':
+			return -1
+		case stripZeroWidth && (r >= '\u200B' && r <= '\u200D' || r == '\u2060' || r == '\uFEFF'):
+			return -1
+		}
+		return r
+	}, text)
+}
+
 func hasProhibitedWordOrTag(event *nostr.Event) bool {
 	for _, tag := range event.Tags {
 		if len(tag) >= 2 && tag[0] == "t" && slices.Contains(pornTags, strings.ToLower(tag[1])) {
@@ -39,6 +188,13 @@ func hasExplicitMedia(ctx context.Context, event *nostr.Event) bool {
 		}
 	}
 
+	// and images from media hosts, which don't have extensions
+	for _, u := range urlMatcher.FindAllString(event.Content, -1) {
+		if !imageExtensionMatcher.MatchString(u) && isMediaHostURL(u) {
+			mediaURLs = append(mediaURLs, u)
+		}
+	}
+
 	// find video URLs
 	vidMatches := videoExtensionMatcher.FindAllStringSubmatch(event.Content, -1)
 	for _, match := range vidMatches {
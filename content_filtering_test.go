@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"html"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlurUntrustedMedia(t *testing.T) {
+	content := basicFormatting(context.Background(), html.EscapeString("look at this\nhttps://example.com/cat.jpg\nand https://example.com/cat.mp4"), true, false, false)
+	allowlist := []string{testPubkey1}
+
+	// disabled by default
+	assert.False(t, shouldBlurMedia(testPubkey2, false, allowlist))
+
+	// trusted author
+	assert.False(t, shouldBlurMedia(testPubkey1, true, allowlist))
+
+	// unknown author
+	assert.True(t, shouldBlurMedia(testPubkey2, true, allowlist))
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(blurMedia(content)))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, doc.Find("details.blurred-media").Length())
+	assert.Equal(t, "https://example.com/cat.jpg", doc.Find(".blurred-media img").AttrOr("src", ""))
+	assert.Equal(t, 1, doc.Find(".blurred-media video source").Length())
+	assert.Equal(t, 2, doc.Find(".blurred-media summary").Length())
+	assert.Contains(t, doc.Text(), "look at this")
+}
+
+func TestInvisibleCharacters(t *testing.T) {
+	// an override that makes "https://evil.com/moc.knab" read as a bank url
+	spoofed := "login at \u202Ehttps://evil.com/moc.knab\u202C now"
+	assert.Equal(t, "login at https://evil.com/moc.knab now", normalizeInvisibleCharacters(spoofed, false))
+	assert.Equal(t, "login at https://evil.com/moc.knab now", normalizeInvisibleCharacters(spoofed, true))
+
+	isolated := "name: \u2067abc\u2069"
+	assert.Equal(t, "name: abc", normalizeInvisibleCharacters(isolated, false))
+
+	// joiners are kept unless we are told to strip them
+	family := "we are \U0001F468\u200D\U0001F469\u200D\U0001F467 and zero\u200Bwidth"
+	assert.Equal(t, family, normalizeInvisibleCharacters(family, false))
+	assert.Equal(t, "we are \U0001F468\U0001F469\U0001F467 and zerowidth", normalizeInvisibleCharacters(family, true))
+
+	// right-to-left text itself is left alone
+	assert.Equal(t, "שלום עולם", normalizeInvisibleCharacters("שלום עולם", true))
+}
+
+func TestContentWithoutJavaScript(t *testing.T) {
+	previous := s.BlurUntrustedMedia
+	s.BlurUntrustedMedia = true
+	defer func() { s.BlurUntrustedMedia = previous }()
+
+	evt := nostr.Event{
+		Kind:      1,
+		CreatedAt: 1710000000,
+		Tags:      nostr.Tags{},
+		Content:   "pickles are great, look at them\nhttps://example.com/pickles.jpg",
+	}
+
+	// we only look at the html as it came from the server, no scripts are run here
+	doc := testPreviewPage(t, evt)
+	articleBody := doc.Find(`[itemprop="articleBody"]`)
+	assert.Contains(t, articleBody.Text(), "pickles are great, look at them")
+
+	// the media of an untrusted author is still there, behind a <details> the browser can open by itself
+	media := articleBody.Find("details.blurred-media")
+	assert.Equal(t, 1, media.Length())
+	assert.Equal(t, "https://example.com/pickles.jpg", media.Find("img").AttrOr("src", ""))
+	assert.Equal(t, 1, media.Find("summary").Length())
+	assert.Equal(t, 0, articleBody.Find("[_]").Length())
+}
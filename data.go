@@ -9,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip19"
 	"github.com/nbd-wtf/go-nostr/nip31"
 	"github.com/nbd-wtf/go-nostr/nip52"
@@ -22,6 +23,7 @@ type Data struct {
 	templateId               TemplateID
 	event                    EnhancedEvent
 	nevent                   string
+	relayHints               []string
 	neventNaked              string
 	naddr                    string
 	naddrNaked               string
@@ -31,6 +33,7 @@ type Data struct {
 	kindNIP                  string
 	video                    string
 	videoType                string
+	videoFirst               bool
 	image                    string
 	cover                    string
 	content                  string
@@ -40,8 +43,22 @@ type Data struct {
 	kind31922Or31923Metadata *Kind31922Or31923Metadata
 	Kind30818Metadata        Kind30818Metadata
 	Kind9802Metadata         Kind9802Metadata
+	kind30402Metadata        Kind30402Metadata
+	kind1984Metadata         Kind1984Metadata
+	kind1111Metadata         Kind1111Metadata
+	kind8Metadata            Kind8Metadata
+	kind30008Metadata        Kind30008Metadata
+	kind30009Metadata        BadgeDefinition
+	encryptedMetadata        *EncryptedMetadata
+	kind9041Metadata         Kind9041Metadata
+	kind30617Metadata        Kind30617Metadata
+	kind1617Metadata         Kind1617Metadata
+	kind10002Metadata        Kind10002Metadata
 }
 
+// maxEncryptedRecipients is how many of the "p" tags of an encrypted message are shown as its recipients
+const maxEncryptedRecipients = 10
+
 func grabData(ctx context.Context, code string, withRelays bool) (Data, error) {
 	// code can be a nevent or naddr, in which case we try to fetch the associated event
 	event, relays, err := getEvent(ctx, code, withRelays)
@@ -49,9 +66,19 @@ func grabData(ctx context.Context, code string, withRelays bool) (Data, error) {
 		return Data{}, fmt.Errorf("error fetching event: %w", err)
 	}
 
+	ee := NewEnhancedEvent(ctx, event)
+	ee.relays = relays
+
+	return prepareData(ctx, ee, withRelays), nil
+}
+
+// prepareData gathers everything we need to render an event we already have
+func prepareData(ctx context.Context, ee EnhancedEvent, withRelays bool) Data {
+	event := ee.Event
+
 	relaysForNip19 := make([]string, 0, 3)
 	c := 0
-	for _, relayUrl := range relays {
+	for _, relayUrl := range ee.relays {
 		if sdk.IsVirtualRelay(relayUrl) {
 			continue
 		}
@@ -61,18 +88,16 @@ func grabData(ctx context.Context, code string, withRelays bool) (Data, error) {
 		}
 	}
 
-	ee := NewEnhancedEvent(ctx, event)
-	ee.relays = relays
-
 	data := Data{
-		event: ee,
+		event:      ee,
+		relayHints: relaysForNip19,
 	}
 
 	data.nevent, _ = nip19.EncodeEvent(event.ID, relaysForNip19, event.PubKey)
 	data.neventNaked, _ = nip19.EncodeEvent(event.ID, nil, event.PubKey)
 	data.naddr = ""
 	data.naddrNaked = ""
-	data.createdAt = time.Unix(int64(event.CreatedAt), 0).Format("2006-01-02 15:04:05 MST")
+	data.createdAt = ee.CreatedAtStrIn(viewerTimezone(ctx))
 
 	if event.Kind >= 30000 && event.Kind < 40000 {
 		if dTag := event.Tags.Find("d"); dTag != nil {
@@ -86,13 +111,13 @@ func grabData(ctx context.Context, code string, withRelays bool) (Data, error) {
 	switch event.Kind {
 	case 1, 7:
 		data.templateId = Note
-		data.content = event.Content
+		data.content = ee.content
 	case 30023, 30024:
 		data.templateId = LongForm
-		data.content = event.Content
+		data.content = ee.content
 	case 20:
 		data.templateId = Note
-		data.content = event.Content
+		data.content = ee.content
 	case 6:
 		data.templateId = Note
 		if reposted := event.Tags.Find("e"); reposted != nil {
@@ -107,34 +132,95 @@ func grabData(ctx context.Context, code string, withRelays bool) (Data, error) {
 		data.kind30311Metadata = &Kind30311Metadata{LiveEvent: nip53.ParseLiveEvent(*event)}
 		host := data.kind30311Metadata.GetHost()
 		if host != nil {
-			hostProfile := sys.FetchProfileMetadata(ctx, host.PubKey)
+			hostProfile := fetchProfileMetadata(ctx, host.PubKey)
 			data.kind30311Metadata.Host = &hostProfile
 		}
+	case 4, 1059:
+		// we can't decrypt these, so we don't even try to format the content
+		data.templateId = Encrypted
+		data.encryptedMetadata = &EncryptedMetadata{Label: "🔒 Encrypted direct message"}
+		if event.Kind == 1059 {
+			data.encryptedMetadata = &EncryptedMetadata{Label: "🎁 Gift-wrapped (encrypted) event", GiftWrap: true}
+		}
+		recipients := make([]string, 0, maxEncryptedRecipients)
+		for tag := range event.Tags.FindAll("p") {
+			if nostr.IsValidPublicKey(tag[1]) {
+				recipients = appendUnique(recipients, tag[1])
+			}
+			if len(recipients) == maxEncryptedRecipients {
+				break
+			}
+		}
+		if len(recipients) > 0 {
+			data.encryptedMetadata.Recipients = mentionResolver.resolveList(ctx, recipients)
+		}
+	case 7375, 7376, 17375, 37375:
+		// NIP-60 wallets keep their keys and proofs encrypted, and they would be money for anyone if they weren't
+		data.templateId = Encrypted
+		data.encryptedMetadata = &EncryptedMetadata{Label: "👛 Cashu wallet", Wallet: true, Mints: parseWalletMints(event.Tags)}
+		switch event.Kind {
+		case 7375:
+			data.encryptedMetadata.Label = "🥜 Cashu wallet tokens"
+		case 7376:
+			data.encryptedMetadata.Label = "🧾 Cashu wallet history"
+		}
+	case 31234:
+		// drafts are encrypted to their author, we can't show anything from them either
+		data.templateId = Encrypted
+		data.encryptedMetadata = &EncryptedMetadata{Label: "🔒 Encrypted draft", DraftOf: "something"}
+		if tag := event.Tags.Find("k"); tag != nil {
+			if kind, err := strconv.Atoi(tag[1]); err == nil && kindNames[kind] != "" {
+				data.encryptedMetadata.DraftOf = kindNames[kind]
+			}
+		}
 	case 1311:
 		data.templateId = LiveEventMessage
-		data.content = event.Content
+		data.content = ee.content
 	case 31922, 31923:
 		data.templateId = CalendarEvent
 		data.kind31922Or31923Metadata = &Kind31922Or31923Metadata{CalendarEvent: nip52.ParseCalendarEvent(*event)}
-		data.content = event.Content
+		data.content = ee.content
 	case 30818:
 		data.templateId = WikiEvent
-		data.Kind30818Metadata.Handle = event.Tags.GetD()
-		data.Kind30818Metadata.Title = data.Kind30818Metadata.Handle
-		if titleTag := event.Tags.Find("title"); titleTag != nil {
-			data.Kind30818Metadata.Title = titleTag[1]
-		}
-		data.Kind30818Metadata.Summary = func() string {
-			if tag := event.Tags.Find("summary"); tag != nil {
-				value := tag[1]
-				return value
-			}
-			return ""
-		}()
-		data.content = event.Content
+		data.Kind30818Metadata = parseKind30818Metadata(*event)
+		data.content = ee.content
+	case 8:
+		data.templateId = Badge
+		data.kind8Metadata = parseKind8Metadata(*event)
+		data.content = ee.content
+	case 30008:
+		data.templateId = Badge
+		data.kind30008Metadata = parseKind30008Metadata(*event)
+	case 30009:
+		data.templateId = Badge
+		data.kind30009Metadata = parseKind30009Metadata(*event)
+	case 9041:
+		data.templateId = ZapGoal
+		data.kind9041Metadata = parseKind9041Metadata(*event)
+	case 30617:
+		data.templateId = Git
+		data.kind30617Metadata = parseKind30617Metadata(*event)
+	case 1617:
+		data.templateId = Git
+		data.kind1617Metadata = parseKind1617Metadata(*event)
+	case 10002:
+		data.templateId = RelayList
+		data.kind10002Metadata = parseKind10002Metadata(*event)
+	case 1111:
+		data.templateId = Comment
+		data.kind1111Metadata = parseKind1111Metadata(*event)
+		data.content = ee.content
+	case 1984:
+		data.templateId = Report
+		data.kind1984Metadata = parseKind1984Metadata(*event)
+		data.content = ee.content
+	case 30402:
+		data.templateId = Classified
+		data.kind30402Metadata = parseKind30402Metadata(*event)
+		data.content = ee.content
 	case 9802:
 		data.templateId = Highlight
-		data.content = event.Content
+		data.content = ee.content
 		if sourceEvent := event.Tags.Find("e"); sourceEvent != nil {
 			data.Kind9802Metadata.SourceEvent = sourceEvent[1]
 			data.Kind9802Metadata.SourceName = "#" + shortenString(sourceEvent[1], 8, 4)
@@ -153,21 +239,22 @@ func grabData(ctx context.Context, code string, withRelays bool) (Data, error) {
 		}
 		if data.Kind9802Metadata.SourceEvent != "" {
 			// Retrieve the title
-			sourceEvent, _, _ := getEvent(ctx, data.Kind9802Metadata.SourceEvent, withRelays)
-			if title := sourceEvent.Tags.Find("title"); title != nil {
-				data.Kind9802Metadata.SourceName = title[1]
-			} else {
-				data.Kind9802Metadata.SourceName = "Note dated " + sourceEvent.CreatedAt.Time().Format("January 1, 2006 15:04")
+			if sourceEvent, _, err := getEvent(ctx, data.Kind9802Metadata.SourceEvent, withRelays); err == nil {
+				if title := sourceEvent.Tags.Find("title"); title != nil {
+					data.Kind9802Metadata.SourceName = title[1]
+				} else {
+					data.Kind9802Metadata.SourceName = "Note dated " + sourceEvent.CreatedAt.Time().Format("January 1, 2006 15:04")
+				}
+				// Retrieve the author using the event, ignore the `p` tag in the highlight event
+				ctx, cancel := context.WithTimeout(ctx, time.Second*3)
+				defer cancel()
+				data.Kind9802Metadata.Author = fetchProfileMetadata(ctx, sourceEvent.PubKey)
 			}
-			// Retrieve the author using the event, ignore the `p` tag in the highlight event
-			ctx, cancel := context.WithTimeout(ctx, time.Second*3)
-			defer cancel()
-			data.Kind9802Metadata.Author = sys.FetchProfileMetadata(ctx, sourceEvent.PubKey)
 		}
 		if author := event.Tags.Find("p"); author != nil {
 			ctx, cancel := context.WithTimeout(ctx, time.Second*3)
 			defer cancel()
-			data.Kind9802Metadata.Author = sys.FetchProfileMetadata(ctx, author[1])
+			data.Kind9802Metadata.Author = fetchProfileMetadata(ctx, author[1])
 		}
 		if context := event.Tags.Find("context"); context != nil {
 			data.Kind9802Metadata.Context = context[1]
@@ -187,7 +274,7 @@ func grabData(ctx context.Context, code string, withRelays bool) (Data, error) {
 			}
 		}
 		if comment := event.Tags.Find("comment"); comment != nil {
-			data.Kind9802Metadata.Comment = basicFormatting(comment[1], false, false, false)
+			data.Kind9802Metadata.Comment = basicFormatting(ctx, comment[1], false, false, false)
 		}
 
 	default:
@@ -209,6 +296,7 @@ func grabData(ctx context.Context, code string, withRelays bool) (Data, error) {
 		} else if data.kind1063Metadata.IsVideo() {
 			data.video = data.kind1063Metadata.URL
 			data.videoType = strings.Split(data.kind1063Metadata.M, "/")[1]
+			data.videoFirst = true
 		}
 	} else if event.Kind == 20 {
 		imeta := nip92.ParseTags(event.Tags)
@@ -231,13 +319,14 @@ func grabData(ctx context.Context, code string, withRelays bool) (Data, error) {
 		urls := urlMatcher.FindAllString(event.Content, -1)
 		for _, url := range urls {
 			switch {
-			case imageExtensionMatcher.MatchString(url):
+			case isImageLink(url):
 				if data.image == "" {
 					data.image = url
 				}
 			case videoExtensionMatcher.MatchString(url):
 				if data.video == "" {
 					data.video = url
+					data.videoFirst = data.image == ""
 					if strings.HasSuffix(data.video, "mp4") {
 						data.videoType = "mp4"
 					} else if strings.HasSuffix(data.video, "mov") {
@@ -250,5 +339,5 @@ func grabData(ctx context.Context, code string, withRelays bool) (Data, error) {
 		}
 	}
 
-	return data, nil
+	return data
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/sdk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptedDirectMessagePlaceholder(t *testing.T) {
+	ciphertext := "zJxfaJ32rN5Dg1ODjOlEew==?iv=EV5bUjcc4OX2Km/zPp4ndQ=="
+	ee := testEnhancedEvent(&nostr.Event{
+		Kind:    4,
+		Content: ciphertext,
+		Tags:    nostr.Tags{{"p", testPubkey2}},
+	})
+
+	params := EncryptedPageParams{
+		BaseEventPageParams: BaseEventPageParams{Event: ee},
+		HeadParams:          HeadParams{NoIndex: true},
+		Details:             DetailsParams{Metadata: ee.author},
+		Encrypted: EncryptedMetadata{
+			Label:      "🔒 Encrypted direct message",
+			Recipients: []sdk.ProfileMetadata{{PubKey: testPubkey2, Name: "hodlbod"}},
+		},
+	}
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, encryptedTemplate(params, false).Render(context.Background(), buf))
+	page := buf.String()
+
+	assert.Contains(t, page, "🔒 Encrypted direct message")
+	assert.Contains(t, page, `<meta name="robots" content="noindex">`)
+	assert.Contains(t, page, `href="/`+ee.author.Npub()+`"`)
+	assert.Contains(t, page, `href="/`+params.Encrypted.Recipients[0].Npub()+`">hodlbod</a>`)
+	assert.NotContains(t, page, ciphertext)
+
+	// the recipients are looked up all at once, and only so many of them
+	var fetched [][]string
+	mentionResolver = profileResolver{
+		cache: testMetadataCache{},
+		fetch: func(ctx context.Context, requested []string) []sdk.ProfileMetadata {
+			fetched = append(fetched, requested)
+			return []sdk.ProfileMetadata{{PubKey: testPubkey2, Name: "hodlbod"}}
+		},
+	}
+	defer func() { mentionResolver = profileResolver{} }()
+
+	tags := nostr.Tags{{"p", testPubkey2}, {"p", testPubkey2}}
+	for range 30 {
+		pubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+		tags = append(tags, nostr.Tag{"p", pubkey})
+	}
+	data := prepareData(context.Background(), testEnhancedEvent(&nostr.Event{Kind: 4, Content: ciphertext, Tags: tags}), false)
+	assert.Len(t, fetched, 1)
+	assert.Len(t, fetched[0], maxEncryptedRecipients)
+	assert.Len(t, data.encryptedMetadata.Recipients, maxEncryptedRecipients)
+	assert.Equal(t, "hodlbod", data.encryptedMetadata.Recipients[0].Name)
+	assert.Equal(t, fetched[0][1], data.encryptedMetadata.Recipients[1].PubKey)
+}
+
+func TestEncryptedDraftPlaceholder(t *testing.T) {
+	ciphertext := "AqzBtDhcnYOcw8sDS2pY2YBc6ZD0xNzbNa0CuCov9aP4Ugt5OER6v50LMneOmx2mD3JUchrKh4dHCojq6Q"
+	evt := nostr.Event{
+		Kind:      31234,
+		PubKey:    testPubkey1,
+		CreatedAt: 1710000000,
+		Content:   ciphertext,
+		Tags:      nostr.Tags{{"d", "my-draft"}, {"k", "30023"}},
+	}
+	assert.NoError(t, evt.Sign(nostr.GeneratePrivateKey()))
+	w := testPreview(t, "/preview", evt)
+	assert.Equal(t, http.StatusOK, w.Code)
+	page := w.Body.String()
+
+	assert.Contains(t, page, "🔒 Encrypted draft")
+	assert.Contains(t, page, "drafting Long-form Content")
+	assert.Contains(t, page, `<meta name="robots" content="noindex">`)
+	npub, _ := nip19.EncodePublicKey(evt.PubKey)
+	assert.Contains(t, page, `href="/`+npub+`"`)
+	// it's only in the raw event json in the details
+	assert.Equal(t, 1, strings.Count(page, ciphertext))
+}
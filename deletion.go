@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// deletionRequests returns kind 5 events that may refer to evt, it can be replaced in tests
+var deletionRequests = fetchDeletionRequests
+
+// isDeleted tells if the author of evt has asked for it to be deleted (NIP-09), deletion
+// requests from anyone else don't count
+func isDeleted(evt *nostr.Event, deletions []*nostr.Event) bool {
+	address := eventAddress(evt)
+
+	for _, deletion := range deletions {
+		if deletion.Kind != nostr.KindDeletion || deletion.PubKey != evt.PubKey {
+			continue
+		}
+		for _, tag := range deletion.Tags {
+			if len(tag) < 2 {
+				continue
+			}
+			if tag[0] == "e" && tag[1] == evt.ID {
+				return true
+			}
+			// deleting an address only affects the versions published before the deletion
+			if tag[0] == "a" && address != "" && tag[1] == address && deletion.CreatedAt >= evt.CreatedAt {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// eventAddress returns the "<kind>:<pubkey>:<d>" reference for replaceable events, or "" for the others
+func eventAddress(evt *nostr.Event) string {
+	if !nostr.IsReplaceableKind(evt.Kind) && !nostr.IsAddressableKind(evt.Kind) {
+		return ""
+	}
+	return fmt.Sprintf("%d:%s:%s", evt.Kind, evt.PubKey, evt.Tags.GetD())
+}
+
+// deletionRefresh is how often we go back to the relays to look for deletions of an event we have none for
+var deletionRefresh = newRelayRefresher(time.Minute*30, time.Second*5, 16)
+
+// fetchDeletionRequests only reads what we have locally, the relays the event was seen on are asked
+// for deletions in the background so the next render knows about them
+func fetchDeletionRequests(ctx context.Context, evt *nostr.Event) []*nostr.Event {
+	filter := nostr.Filter{
+		Kinds:   []int{nostr.KindDeletion},
+		Authors: []string{evt.PubKey},
+		Tags:    nostr.TagMap{"e": []string{evt.ID}},
+	}
+	if address := eventAddress(evt); address != "" {
+		filter.Tags = nostr.TagMap{"a": []string{address}}
+	}
+
+	deletions, _ := sys.StoreRelay.QuerySync(ctx, filter)
+	if len(deletions) > 0 || localOnly(ctx) {
+		return deletions
+	}
+
+	relays := internal.getRelaysForEvent(evt.ID)
+	if len(relays) == 0 {
+		return nil
+	}
+
+	deletionRefresh.refresh(evt.ID, func(ctx context.Context) {
+		for ie := range sys.Pool.FetchMany(ctx, relays, filter, nostr.WithLabel("deletions")) {
+			sys.Store.SaveEvent(ctx, ie.Event)
+		}
+	})
+
+	return nil
+}
+
+func renderDeleted(ctx context.Context, w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "max-age=3600")
+	w.WriteHeader(http.StatusGone)
+	errorTemplate(ErrorPageParams{
+		HeadParams: HeadParams{NoIndex: true},
+		Errors:     "event deleted",
+		Message:    "This event was deleted by its author.",
+	}).Render(ctx, w)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeletedEventIsGone(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	signed := func(evt *nostr.Event) *nostr.Event {
+		assert.NoError(t, evt.Sign(sk))
+		return evt
+	}
+	deleted := signed(&nostr.Event{Kind: 1, CreatedAt: 1000, Content: "oops"})
+	normal := signed(&nostr.Event{Kind: 1, CreatedAt: 1000, Content: "fine"})
+	article := signed(&nostr.Event{Kind: 30023, CreatedAt: 1000, Tags: nostr.Tags{{"d", "hello"}}})
+
+	// someone else can't delete our events
+	other := &nostr.Event{Kind: 5, CreatedAt: 2000, Tags: nostr.Tags{{"e", normal.ID}}}
+	assert.NoError(t, other.Sign(nostr.GeneratePrivateKey()))
+
+	deletions := []*nostr.Event{
+		signed(&nostr.Event{Kind: 5, CreatedAt: 2000, Tags: nostr.Tags{{"e", deleted.ID}}}),
+		other,
+		signed(&nostr.Event{Kind: 5, CreatedAt: 2000, Tags: nostr.Tags{{"a", "30023:" + pk + ":hello"}}}),
+	}
+	for _, deletion := range deletions {
+		assert.NoError(t, sys.Store.SaveEvent(context.Background(), deletion))
+	}
+
+	ctx := withLocalOnly(context.Background())
+	w := httptest.NewRecorder()
+	assert.True(t, renderIfNotAllowed(ctx, w, testEnhancedEvent(deleted)))
+	assert.Equal(t, http.StatusGone, w.Code)
+	assert.Contains(t, w.Body.String(), "deleted by its author")
+
+	w = httptest.NewRecorder()
+	assert.False(t, renderIfNotAllowed(ctx, w, testEnhancedEvent(normal)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	w = httptest.NewRecorder()
+	assert.True(t, renderIfNotAllowed(ctx, w, testEnhancedEvent(article)))
+	assert.Equal(t, http.StatusGone, w.Code)
+
+	// a version published after the deletion is alive again
+	article.CreatedAt = 3000
+	assert.False(t, isDeleted(article, deletions))
+}
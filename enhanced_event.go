@@ -2,16 +2,20 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"html"
 	"html/template"
+	"net/url"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip10"
+	"github.com/nbd-wtf/go-nostr/nip13"
 	"github.com/nbd-wtf/go-nostr/nip19"
 	"github.com/nbd-wtf/go-nostr/sdk"
 	"github.com/texttheater/golang-levenshtein/levenshtein"
@@ -22,14 +26,30 @@ type EnhancedEvent struct {
 	relays  []string
 	subject string
 	summary string
-	author  sdk.ProfileMetadata
+	// publishedAt is when an article was first published, its created_at is from the last edit
+	publishedAt nostr.Timestamp
+	author      sdk.ProfileMetadata
+	// content is the event content as we show it, with invalid bytes replaced, the event itself is
+	// shared with the cache and the store so it is never changed
+	content string
 }
 
 func NewEnhancedEvent(
 	ctx context.Context,
 	event *nostr.Event,
 ) EnhancedEvent {
-	ee := EnhancedEvent{Event: event}
+	if event.Kind == 0 {
+		return enhanceEvent(event, parseProfileMetadata(event))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*3)
+	defer cancel()
+	return enhanceEvent(event, fetchProfileMetadata(ctx, event.PubKey))
+}
+
+// enhanceEvent is like NewEnhancedEvent for when we already have the author
+func enhanceEvent(event *nostr.Event, author sdk.ProfileMetadata) EnhancedEvent {
+	ee := EnhancedEvent{Event: event, author: author, content: toValidUTF8(event.Content)}
 
 	for _, tag := range event.Tags {
 		if len(tag) < 2 {
@@ -37,23 +57,84 @@ func NewEnhancedEvent(
 		}
 
 		if tag[0] == "subject" || tag[0] == "title" {
-			ee.subject = tag[1]
+			ee.subject = toValidUTF8(tag[1])
 		}
 		if tag[0] == "summary" {
-			ee.summary = tag[1]
+			ee.summary = toValidUTF8(tag[1])
+		}
+		if tag[0] == "published_at" && ee.isArticle() {
+			if ts, err := strconv.ParseInt(tag[1], 10, 64); err == nil && ts > 0 {
+				ee.publishedAt = nostr.Timestamp(ts)
+			}
 		}
 	}
 
-	if event.Kind == 0 {
-		spm, _ := sdk.ParseMetadata(event)
-		ee.author = spm
-	} else {
-		ctx, cancel := context.WithTimeout(ctx, time.Second*3)
-		defer cancel()
-		ee.author = sys.FetchProfileMetadata(ctx, event.PubKey)
+	return ee
+}
+
+const maxProfileMetadataSize = 1 << 17
+
+// parseProfileMetadata is like sdk.ParseMetadata, but it takes each field separately so one field
+// with an unexpected type doesn't spoil all the others, and if the content is not valid JSON
+// (or is too big) we still get a profile with just the pubkey
+func parseProfileMetadata(event *nostr.Event) sdk.ProfileMetadata {
+	meta := sdk.ProfileMetadata{PubKey: event.PubKey, Event: event}
+	if len(event.Content) > maxProfileMetadataSize {
+		return meta
 	}
 
-	return ee
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(event.Content), &fields); err != nil {
+		return meta
+	}
+
+	for key, target := range map[string]*string{
+		"name":         &meta.Name,
+		"display_name": &meta.DisplayName,
+		"about":        &meta.About,
+		"website":      &meta.Website,
+		"picture":      &meta.Picture,
+		"banner":       &meta.Banner,
+		"nip05":        &meta.NIP05,
+		"lud16":        &meta.LUD16,
+	} {
+		if raw, ok := fields[key]; ok {
+			var value string
+			if err := json.Unmarshal(raw, &value); err == nil {
+				*target = value
+			}
+		}
+	}
+
+	// some clients still write the camelCase variant
+	if raw, ok := fields["displayName"]; ok && meta.DisplayName == "" {
+		json.Unmarshal(raw, &meta.DisplayName)
+	}
+
+	return meta
+}
+
+// ProfileDisplayName is the name we show for a profile: display_name, then the displayName some
+// clients write instead, then name, and if none of these are set, the shortened npub
+func ProfileDisplayName(meta sdk.ProfileMetadata) string {
+	if name := strings.TrimSpace(meta.DisplayName); name != "" {
+		return name
+	}
+	if meta.Event != nil {
+		// the metadata may not have come from parseProfileMetadata, so look at the raw event
+		var legacy struct {
+			DisplayName string `json:"displayName"`
+		}
+		if err := json.Unmarshal([]byte(meta.Event.Content), &legacy); err == nil {
+			if name := strings.TrimSpace(legacy.DisplayName); name != "" {
+				return name
+			}
+		}
+	}
+	if name := strings.TrimSpace(meta.Name); name != "" {
+		return name
+	}
+	return meta.NpubShort()
 }
 
 func (ee EnhancedEvent) authorLong() string {
@@ -86,12 +167,192 @@ func (ee EnhancedEvent) getParentNevent() string {
 	return parentNevent
 }
 
+// mentionedPubkeys returns the pubkeys from "p" tags, without the author and without the
+// author of the event this is replying to, if any
+func (ee EnhancedEvent) mentionedPubkeys() []string {
+	skip := ee.PubKey
+	if parent := nip10.GetImmediateParent(ee.Tags); parent != nil && parent.Author != "" {
+		skip = parent.Author
+	}
+
+	pubkeys := make([]string, 0, len(ee.Tags))
+	for tag := range ee.Tags.FindAll("p") {
+		if tag[1] == ee.PubKey || tag[1] == skip || !nostr.IsValidPublicKey(tag[1]) {
+			continue
+		}
+		pubkeys = appendUnique(pubkeys, tag[1])
+	}
+	return pubkeys
+}
+
+// references returns the unique http(s) URLs from "r" tags
+func (ee EnhancedEvent) references() []string {
+	urls := make([]string, 0, 4)
+	for tag := range ee.Tags.FindAll("r") {
+		u, err := url.Parse(strings.TrimSpace(tag[1]))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			continue
+		}
+		urls = appendUnique(urls, u.String())
+	}
+	return urls
+}
+
+// hashtags returns the unique values of the "t" tags, lowercased and without a leading "#"
+func (ee EnhancedEvent) hashtags() []string {
+	hashtags := make([]string, 0, 4)
+	for tag := range ee.Tags.FindAll("t") {
+		if hashtag := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag[1]), "#")); hashtag != "" {
+			hashtags = appendUnique(hashtags, hashtag)
+		}
+	}
+	return hashtags
+}
+
+// addressReferences returns pointers to the addressable events referenced by valid "a" tags
+func (ee EnhancedEvent) addressReferences() []nostr.EntityPointer {
+	pointers := make([]nostr.EntityPointer, 0, 2)
+	for tag := range ee.Tags.FindAll("a") {
+		pointer, err := nostr.EntityPointerFromTag(tag)
+		if err != nil || !nostr.IsAddressableKind(pointer.Kind) {
+			continue
+		}
+		if slices.ContainsFunc(pointers, func(p nostr.EntityPointer) bool { return p.AsTagReference() == pointer.AsTagReference() }) {
+			continue
+		}
+		pointers = append(pointers, pointer)
+	}
+	return pointers
+}
+
+// quotedEvent returns a pointer to the event referenced by a NIP-18 "q" tag, if any
+func (ee EnhancedEvent) quotedEvent() nostr.Pointer {
+	tag := ee.Tags.Find("q")
+	if tag == nil {
+		return nil
+	}
+
+	if nostr.IsValid32ByteHex(tag[1]) {
+		pointer := nostr.EventPointer{ID: tag[1]}
+		if len(tag) >= 3 && nostr.IsValidRelayURL(tag[2]) {
+			pointer.Relays = []string{tag[2]}
+		}
+		if len(tag) >= 4 && nostr.IsValidPublicKey(tag[3]) {
+			pointer.Author = tag[3]
+		}
+		return pointer
+	}
+
+	if pointer, err := nostr.EntityPointerFromTag(tag); err == nil {
+		return pointer
+	}
+	return nil
+}
+
+// contentWarning tells if the author flagged the event as sensitive (NIP-36), with the reason they gave, if any
+func (ee EnhancedEvent) contentWarning() (reason string, ok bool) {
+	for _, tag := range ee.Tags {
+		if len(tag) >= 1 && tag[0] == "content-warning" {
+			if len(tag) >= 2 {
+				reason = strings.TrimSpace(tag[1])
+			}
+			return reason, true
+		}
+	}
+	return "", false
+}
+
+// sensitiveImages returns the urls of the images the author flagged one by one with a "content-warning"
+// in their imeta tags, keyed to the reason given, which may be empty
+func (ee EnhancedEvent) sensitiveImages() map[string]string {
+	var sensitive map[string]string
+	for tag := range ee.Tags.FindAll("imeta") {
+		url, reason, flagged := "", "", false
+		for _, entry := range tag[1:] {
+			key, value, _ := strings.Cut(entry, " ")
+			switch key {
+			case "url":
+				url = strings.TrimSpace(value)
+			case "content-warning":
+				reason, flagged = strings.TrimSpace(value), true
+			}
+		}
+		if url != "" && flagged {
+			if sensitive == nil {
+				sensitive = make(map[string]string)
+			}
+			sensitive[url] = reason
+		}
+	}
+	return sensitive
+}
+
+// postedVia returns the app credited in the NIP-89 "client" tag, if any
+func (ee EnhancedEvent) postedVia() *ClientCredit {
+	tag := ee.Tags.Find("client")
+	if tag == nil || strings.TrimSpace(tag[1]) == "" {
+		return nil
+	}
+
+	credit := &ClientCredit{Name: strings.TrimSpace(tag[1])}
+	if len(tag) >= 3 {
+		aTag := nostr.Tag{"a", tag[2]}
+		if len(tag) >= 4 {
+			aTag = append(aTag, tag[3])
+		}
+		if pointer, err := nostr.EntityPointerFromTag(aTag); err == nil && pointer.Kind == 31990 {
+			credit.Code = nip19.EncodePointer(pointer)
+		}
+	}
+	return credit
+}
+
+// bridgedFrom returns the origin given in the NIP-48 "proxy" tag of events bridged from other protocols
+func (ee EnhancedEvent) bridgedFrom() *BridgedOrigin {
+	tag := ee.Tags.Find("proxy")
+	if tag == nil || len(tag) < 3 || strings.TrimSpace(tag[2]) == "" {
+		return nil
+	}
+
+	origin := &BridgedOrigin{Protocol: strings.ToLower(strings.TrimSpace(tag[2]))}
+	id := strings.TrimSpace(tag[1])
+	switch {
+	case strings.HasPrefix(id, "https://") || strings.HasPrefix(id, "http://"):
+		origin.URL = id
+	case origin.Protocol == "atproto" && strings.HasPrefix(id, "at://"):
+		// at://<did>/app.bsky.feed.post/<rkey> is a post people can see on bsky.app
+		if parts := strings.Split(strings.TrimPrefix(id, "at://"), "/"); len(parts) == 3 && parts[1] == "app.bsky.feed.post" {
+			origin.URL = "https://bsky.app/profile/" + parts[0] + "/post/" + parts[2]
+		}
+	}
+	return origin
+}
+
+// proofOfWork returns the NIP-13 difficulty of the event id when the event has a "nonce" tag
+func (ee EnhancedEvent) proofOfWork() *ProofOfWork {
+	tag := ee.Tags.Find("nonce")
+	if tag == nil || !nostr.IsValid32ByteHex(ee.ID) {
+		return nil
+	}
+
+	pow := &ProofOfWork{Difficulty: nip13.Difficulty(ee.ID)}
+	if len(tag) >= 3 {
+		pow.Target, _ = strconv.Atoi(tag[2])
+	}
+	return pow
+}
+
+// isArticle is for long-form articles and their drafts, which can be edited and have a published_at
+func (ee EnhancedEvent) isArticle() bool {
+	return ee.Kind == 30023 || ee.Kind == 30024
+}
+
 func (ee EnhancedEvent) isReply() bool {
 	return nip10.GetImmediateParent(ee.Event.Tags) != nil
 }
 
 func (ee EnhancedEvent) Preview() template.HTML {
-	lines := strings.Split(html.EscapeString(ee.Event.Content), "\n")
+	lines := strings.Split(html.EscapeString(ee.content), "\n")
 	var processedLines []string
 	for _, line := range lines {
 		if strings.TrimSpace(line) == "" {
@@ -136,8 +397,8 @@ func (ee EnhancedEvent) RssTitle() string {
 }
 
 func (ee EnhancedEvent) RssContent() string {
-	content := ee.Event.Content
-	content = basicFormatting(html.EscapeString(content), true, false, false)
+	content := ee.content
+	content = basicFormatting(context.Background(), html.EscapeString(content), true, false, false)
 	content = renderQuotesAsHTML(context.Background(), content, false)
 	if nevent := ee.getParentNevent(); nevent != "" {
 		neventShort := nevent[:8] + "…" + nevent[len(nevent)-4:]
@@ -148,7 +409,7 @@ func (ee EnhancedEvent) RssContent() string {
 
 func (ee EnhancedEvent) Thumb() string {
 	imgRegex := regexp.MustCompile(`(https?://[^\s]+\.(?:png|jpe?g|gif|bmp|svg)(?:/[^\s]*)?)`)
-	matches := imgRegex.FindAllStringSubmatch(ee.Event.Content, -1)
+	matches := imgRegex.FindAllStringSubmatch(ee.content, -1)
 	if len(matches) > 0 {
 		// The first match group captures the image URL
 		return matches[0][1]
@@ -171,8 +432,32 @@ func (ee EnhancedEvent) Nevent() string {
 	return nevent
 }
 
+// maxClockSkew is how far in the future an event can be before we call it out, as clocks are never quite right
+const maxClockSkew = 15 * time.Minute
+
 func (ee EnhancedEvent) CreatedAtStr() string {
-	return time.Unix(int64(ee.Event.CreatedAt), 0).Format("2006-01-02 15:04:05 MST")
+	return ee.CreatedAtStrIn(time.UTC)
+}
+
+// CreatedAtStrIn is the date of the event as read in the viewer's timezone
+func (ee EnhancedEvent) CreatedAtStrIn(loc *time.Location) string {
+	if ee.Event.CreatedAt <= 0 {
+		return "unknown date"
+	}
+	return time.Unix(int64(ee.Event.CreatedAt), 0).In(loc).Format("2006-01-02 15:04:05 MST")
+}
+
+// isFutureDated tells if the event claims to be from after now, which means its date can't be trusted
+func (ee EnhancedEvent) isFutureDated() bool {
+	return ee.Event.CreatedAt.Time().After(time.Now().Add(maxClockSkew))
+}
+
+func (ee EnhancedEvent) PublishedAtStr() string {
+	return ee.PublishedAtStrIn(time.UTC)
+}
+
+func (ee EnhancedEvent) PublishedAtStrIn(loc *time.Location) string {
+	return time.Unix(int64(ee.publishedAt), 0).In(loc).Format("2006-01-02 15:04:05 MST")
 }
 
 func (ee EnhancedEvent) ModifiedAtStr() string {
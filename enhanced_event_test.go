@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"html"
+	"html/template"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/sdk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNoteMentions(t *testing.T) {
+	const testPubkey3 = "ee11a5dff40c19a555f41fe42b48f00e618c91225622ae37b6c2bb67b76c4e49"
+
+	ee := testEnhancedEvent(&nostr.Event{
+		Kind:    1,
+		Content: "hello friends",
+		Tags: nostr.Tags{
+			{"p", testPubkey2},
+			{"p", testPubkey1}, // the author
+			{"p", testPubkey3},
+			{"p", testPubkey2},
+		},
+	})
+	assert.Equal(t, []string{testPubkey2, testPubkey3}, ee.mentionedPubkeys())
+
+	names := map[string]string{testPubkey2: "hodlbod", testPubkey3: "mike"}
+	var fetched [][]string
+	mentionResolver = profileResolver{
+		cache: testMetadataCache{},
+		fetch: func(ctx context.Context, requested []string) []sdk.ProfileMetadata {
+			fetched = append(fetched, requested)
+			profiles := make([]sdk.ProfileMetadata, 0, len(requested))
+			for _, pubkey := range requested {
+				profiles = append(profiles, sdk.ProfileMetadata{PubKey: pubkey, Name: names[pubkey]})
+			}
+			return profiles
+		},
+	}
+	defer func() { mentionResolver = profileResolver{} }()
+
+	// all of them are asked for at once, in the order they were mentioned
+	mentions := mentionResolver.resolveList(context.Background(), ee.mentionedPubkeys())
+	assert.Equal(t, [][]string{{testPubkey2, testPubkey3}}, fetched)
+	assert.Equal(t, []string{testPubkey2, testPubkey3}, []string{mentions[0].PubKey, mentions[1].PubKey})
+
+	buf := &bytes.Buffer{}
+	params := NotePageParams{BaseEventPageParams: BaseEventPageParams{Event: ee}, Mentions: mentions}
+	assert.NoError(t, noteInnerBlock(params).Render(context.Background(), buf))
+	assert.Contains(t, buf.String(), `href="/`+mentions[0].Npub()+`" class="mr-1 text-strongpink">@hodlbod</a>`)
+	assert.Contains(t, buf.String(), `@mike</a>`)
+	assert.NotContains(t, buf.String(), `@fiatjaf`)
+}
+
+func TestNoteReferences(t *testing.T) {
+	ee := testEnhancedEvent(&nostr.Event{
+		Kind:    1,
+		Content: "interesting reads",
+		Tags: nostr.Tags{
+			{"r", "https://example.com/article"},
+			{"r", "http://blog.example.org/post?id=1"},
+			{"r", "https://example.com/article"},
+		},
+	})
+	assert.Equal(t, []string{"https://example.com/article", "http://blog.example.org/post?id=1"}, ee.references())
+
+	buf := &bytes.Buffer{}
+	params := NotePageParams{BaseEventPageParams: BaseEventPageParams{Event: ee}}
+	assert.NoError(t, noteInnerBlock(params).Render(context.Background(), buf))
+	assert.Contains(t, buf.String(), "references:")
+	assert.Contains(t, buf.String(), `href="https://example.com/article"`)
+	assert.Contains(t, buf.String(), `href="http://blog.example.org/post?id=1"`)
+
+	ee = testEnhancedEvent(&nostr.Event{
+		Kind:    1,
+		Content: "nothing to see",
+		Tags:    nostr.Tags{{"r", "not a url"}, {"r", "javascript:alert(1)"}, {"r", "wss://relay.example.com"}},
+	})
+	assert.Empty(t, ee.references())
+
+	buf.Reset()
+	params = NotePageParams{BaseEventPageParams: BaseEventPageParams{Event: ee}}
+	assert.NoError(t, noteInnerBlock(params).Render(context.Background(), buf))
+	assert.NotContains(t, buf.String(), "references:")
+}
+
+func TestProfileDisplayName(t *testing.T) {
+	npub, _ := nip19.EncodePublicKey(testPubkey1)
+	for content, expected := range map[string]string{
+		`{"name": "fj", "display_name": "fiatjaf", "displayName": "Fiat Jaf"}`: "fiatjaf",
+		`{"name": "fj", "display_name": "  ", "displayName": "Fiat Jaf"}`:      "Fiat Jaf",
+		`{"name": "fj", "displayName": "Fiat Jaf"}`:                            "Fiat Jaf",
+		`{"name": "fj"}`:                   "fj",
+		`{"name": "", "display_name": ""}`: npub[0:7] + "…" + npub[58:],
+		`not even json`:                    npub[0:7] + "…" + npub[58:],
+	} {
+		meta := parseProfileMetadata(&nostr.Event{Kind: 0, PubKey: testPubkey1, Content: content})
+		assert.Equal(t, expected, ProfileDisplayName(meta), content)
+	}
+
+	// metadata parsed elsewhere only has display_name, but displayName is still in the event
+	legacy := &nostr.Event{Kind: 0, PubKey: testPubkey1, Content: `{"name": "fj", "displayName": "Fiat Jaf"}`}
+	assert.Equal(t, "Fiat Jaf", ProfileDisplayName(sdk.ProfileMetadata{PubKey: testPubkey1, Event: legacy, Name: "fj"}))
+	assert.Equal(t, npub[0:7]+"…"+npub[58:], ProfileDisplayName(sdk.ProfileMetadata{PubKey: testPubkey1}))
+}
+
+func TestTolerantProfileMetadata(t *testing.T) {
+	// a numeric name doesn't spoil the other fields
+	meta := parseProfileMetadata(&nostr.Event{
+		Kind:    0,
+		PubKey:  testPubkey1,
+		Content: `{"name": 42, "display_name": "fiatjaf", "about": "~", "picture": 7, "nip05": "_@fiatjaf.com"}`,
+	})
+	assert.Equal(t, testPubkey1, meta.PubKey)
+	assert.Empty(t, meta.Name)
+	assert.Empty(t, meta.Picture)
+	assert.Equal(t, "fiatjaf", meta.DisplayName)
+	assert.Equal(t, "~", meta.About)
+	assert.Equal(t, "_@fiatjaf.com", meta.NIP05)
+	assert.Equal(t, "fiatjaf", meta.ShortName())
+
+	// a missing about
+	meta = parseProfileMetadata(&nostr.Event{
+		Kind:    0,
+		PubKey:  testPubkey1,
+		Content: `{"name": "fiatjaf", "picture": "https://fiatjaf.com/static/favicon.jpg"}`,
+	})
+	assert.Equal(t, "fiatjaf", meta.Name)
+	assert.Equal(t, "https://fiatjaf.com/static/favicon.jpg", meta.Picture)
+	assert.Empty(t, meta.About)
+
+	// completely invalid json still gives us a profile we can display with the npub
+	event := &nostr.Event{Kind: 0, PubKey: testPubkey1, Content: `{"name": "fiatjaf", "about": `}
+	meta = parseProfileMetadata(event)
+	assert.Equal(t, testPubkey1, meta.PubKey)
+	assert.Equal(t, event, meta.Event)
+	assert.Empty(t, meta.Name)
+	assert.Equal(t, meta.NpubShort(), meta.ShortName())
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, authorHeaderTemplate(meta).Render(context.Background(), buf))
+	assert.Contains(t, buf.String(), meta.NpubShort())
+}
+
+func TestProofOfWorkBadge(t *testing.T) {
+	// the example from NIP-13
+	const minedID = "000006d8c378af1779d2feebc7603a125d99eca0ccf1085959b307f64e5dd358"
+
+	met := testEnhancedEvent(&nostr.Event{ID: minedID, Kind: 1, Tags: nostr.Tags{{"nonce", "776797", "20"}}})
+	pow := met.proofOfWork()
+	assert.Equal(t, 21, pow.Difficulty)
+	assert.Equal(t, 20, pow.Target)
+	assert.True(t, pow.Met())
+
+	notMet := testEnhancedEvent(&nostr.Event{ID: minedID, Kind: 1, Tags: nostr.Tags{{"nonce", "776797", "24"}}})
+	assert.False(t, notMet.proofOfWork().Met())
+
+	assert.Nil(t, testEnhancedEvent(&nostr.Event{ID: minedID, Kind: 1}).proofOfWork())
+
+	var buf bytes.Buffer
+	assert.NoError(t, noteInnerBlock(NotePageParams{BaseEventPageParams: BaseEventPageParams{Event: met}}).Render(context.Background(), &buf))
+	doc, err := goquery.NewDocumentFromReader(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "PoW 21", doc.Find(".pow-badge").Text())
+	assert.Contains(t, doc.Text(), "meets the target of 20")
+
+	buf.Reset()
+	assert.NoError(t, noteInnerBlock(NotePageParams{BaseEventPageParams: BaseEventPageParams{Event: notMet}}).Render(context.Background(), &buf))
+	assert.Contains(t, buf.String(), "below the target of 24")
+}
+
+func TestInvalidUTF8(t *testing.T) {
+	// a truncated 3-byte sequence and a stray continuation byte
+	content := "pickles \xe2\x82 are \x80great"
+	assert.False(t, utf8.ValidString(content))
+
+	ee := testEnhancedEvent(&nostr.Event{Kind: 1, Content: content, Tags: nostr.Tags{{"subject", "bad \xff subject"}}})
+	assert.True(t, utf8.ValidString(ee.content))
+	assert.Equal(t, "pickles \uFFFD are \uFFFDgreat", ee.content)
+	assert.True(t, utf8.ValidString(ee.subject))
+
+	// the event is shared with the cache and the store, it must stay as it was signed
+	assert.Equal(t, content, ee.Event.Content)
+
+	var buf bytes.Buffer
+	note := NotePageParams{BaseEventPageParams: BaseEventPageParams{Event: ee}, Content: template.HTML(basicFormatting(context.Background(), html.EscapeString(ee.content), false, false, false))}
+	assert.NoError(t, noteTemplate(note, false).Render(context.Background(), &buf))
+	assert.True(t, utf8.Valid(buf.Bytes()))
+
+	assert.Equal(t, "already fine ✓", toValidUTF8("already fine ✓"))
+}
+
+func TestSensitiveImages(t *testing.T) {
+	evt := nostr.Event{
+		Kind:      1,
+		CreatedAt: 1710000000,
+		Tags: nostr.Tags{
+			{"imeta", "url https://example.com/gore.jpg", "m image/jpeg", "content-warning blood"},
+			{"imeta", "url https://example.com/kitten.jpg", "m image/jpeg"},
+		},
+		Content: "the operation went well https://example.com/gore.jpg and here is my cat https://example.com/kitten.jpg",
+	}
+	doc := testPreviewPage(t, evt)
+
+	images := doc.Find(`article img[src="https://example.com/gore.jpg"], article img[src="https://example.com/kitten.jpg"]`)
+	assert.NotZero(t, images.Length())
+	images.Each(func(_ int, img *goquery.Selection) {
+		blurred := img.ParentsFiltered("details.sensitive-image")
+		if img.AttrOr("src", "") == "https://example.com/gore.jpg" {
+			assert.Equal(t, 1, blurred.Length())
+			assert.Equal(t, "show sensitive image: blood", blurred.Find("summary").Text())
+		} else {
+			assert.Equal(t, 0, blurred.Length())
+		}
+	})
+
+	assert.Equal(t, map[string]string{"https://example.com/x.png": ""},
+		testEnhancedEvent(&nostr.Event{Tags: nostr.Tags{{"imeta", "url https://example.com/x.png", "content-warning"}}}).sensitiveImages())
+	assert.Empty(t, testEnhancedEvent(&nostr.Event{Tags: nostr.Tags{{"imeta", "url https://example.com/x.png"}}}).sensitiveImages())
+}
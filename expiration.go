@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// expiration is when the author said the event should stop being served (NIP-40), a tag
+// we can't read is ignored as if it wasn't there
+func (ee EnhancedEvent) expiration() (nostr.Timestamp, bool) {
+	tag := ee.Tags.Find("expiration")
+	if tag == nil {
+		return 0, false
+	}
+	ts, err := strconv.ParseInt(tag[1], 10, 64)
+	if err != nil || ts <= 0 {
+		return 0, false
+	}
+	return nostr.Timestamp(ts), true
+}
+
+func (ee EnhancedEvent) isExpired() bool {
+	expiration, ok := ee.expiration()
+	return ok && expiration.Time().Before(time.Now())
+}
+
+// ExpirationNotice says when the event expires or how long ago it has expired, or nothing if it doesn't
+func (ee EnhancedEvent) ExpirationNotice() string {
+	expiration, ok := ee.expiration()
+	if !ok {
+		return ""
+	}
+	if until := time.Until(expiration.Time()); until > 0 {
+		return "expires in " + humanDuration(until)
+	}
+	return "expired " + humanDuration(time.Since(expiration.Time())) + " ago"
+}
+
+func humanDuration(d time.Duration) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return "1 " + unit
+		}
+		return strconv.Itoa(n) + " " + unit + "s"
+	}
+
+	switch {
+	case d < time.Minute:
+		return "less than a minute"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute")
+	case d < 48*time.Hour:
+		return plural(int(d/time.Hour), "hour")
+	default:
+		return plural(int(d/(24*time.Hour)), "day")
+	}
+}
+
+// renderIfExpired writes a 410 page for events that have already expired, when EXPIRED_EVENTS_GONE is on,
+// returning true in that case so the caller stops rendering
+func renderIfExpired(ctx context.Context, w http.ResponseWriter, ee EnhancedEvent) bool {
+	if !s.ExpiredEventsGone || !ee.isExpired() {
+		return false
+	}
+
+	w.Header().Set("Cache-Control", "max-age=3600")
+	w.WriteHeader(http.StatusGone)
+	errorTemplate(ErrorPageParams{
+		HeadParams: HeadParams{NoIndex: true},
+		Errors:     "event expired",
+		Message:    "This event has expired and is no longer available.",
+	}).Render(ctx, w)
+	return true
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpiration(t *testing.T) {
+	previous := s.ExpiredEventsGone
+	defer func() { s.ExpiredEventsGone = previous }()
+
+	render := func(expiration string) (*httptest.ResponseRecorder, *goquery.Document) {
+		evt := nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"expiration", expiration}}, Content: "here today, gone tomorrow"}
+		w := testPreview(t, "/preview", evt)
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(w.Body.Bytes()))
+		assert.NoError(t, err)
+		return w, doc
+	}
+	in := func(d time.Duration) string { return strconv.FormatInt(time.Now().Add(d).Unix(), 10) }
+
+	s.ExpiredEventsGone = false
+
+	w, doc := render(in(3*time.Hour + time.Minute))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "expires in 3 hours", strings.TrimSpace(doc.Find(".expiration").Text()))
+	assert.Equal(t, 0, doc.Find(".expired").Length())
+
+	w, doc = render(in(-50 * time.Hour))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "expired 2 days ago", strings.TrimSpace(doc.Find(".expiration.expired").Text()))
+	assert.Contains(t, w.Body.String(), "here today, gone tomorrow")
+
+	for _, malformed := range []string{"tomorrow", "-5", "", "1.5e9"} {
+		w, doc = render(malformed)
+		assert.Equal(t, http.StatusOK, w.Code, malformed)
+		assert.Equal(t, 0, doc.Find(".expiration").Length(), malformed)
+	}
+
+	s.ExpiredEventsGone = true
+
+	w, _ = render(in(-50 * time.Hour))
+	assert.Equal(t, http.StatusGone, w.Code)
+	assert.NotContains(t, w.Body.String(), "here today, gone tomorrow")
+
+	w, doc = render(in(time.Hour + time.Minute))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "expires in 1 hour", strings.TrimSpace(doc.Find(".expiration").Text()))
+
+	w, _ = render("tomorrow")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"time"
+
+	"github.com/dgraph-io/ristretto"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/sdk"
+	"github.com/nfnt/resize"
+)
+
+const (
+	profileFaviconSize    = 64
+	defaultProfileFavicon = "/njump/static/favicon/profile/favicon-32x32.png?v=2"
+)
+
+// profileFavicons are the resized pictures already served, keyed by the picture URL so they are
+// made again when someone changes their picture
+var profileFavicons, _ = ristretto.NewCache(&ristretto.Config[string, []byte]{
+	NumCounters: 1e5,     // number of keys to track frequency of (100k)
+	MaxCost:     1 << 24, // maximum cost of cache (16MB)
+	BufferItems: 64,      // number of keys per Get buffer
+})
+
+// renderProfileFavicon serves the picture of a profile as a small square png, to be the icon of the profile page,
+// when there is no picture or it can't be loaded it redirects to the default icon
+func renderProfileFavicon(
+	fetchProfile func(ctx context.Context, code string) (sdk.ProfileMetadata, error),
+	fetchImage func(ctx context.Context, url string) (image.Image, error),
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), time.Second*3)
+		defer cancel()
+
+		fallback := func() {
+			w.Header().Set("Cache-Control", "max-age=600")
+			http.Redirect(w, r, defaultProfileFavicon, http.StatusFound)
+		}
+
+		profile, err := fetchProfile(ctx, r.PathValue("code"))
+		if err != nil || profile.Picture == "" || blocklist.blocksPubkey(profile.PubKey) {
+			fallback()
+			return
+		}
+		if banned, _ := internal.isBannedPubkey(profile.PubKey); banned {
+			fallback()
+			return
+		}
+
+		favicon, ok := profileFavicons.Get(profile.Picture)
+		if !ok {
+			img, err := fetchImage(ctx, profile.Picture)
+			if err != nil {
+				log.Debug().Err(err).Str("picture", profile.Picture).Msg("failed to fetch picture for favicon")
+				fallback()
+				return
+			}
+
+			buf := &bytes.Buffer{}
+			if err := png.Encode(buf, squareThumbnail(img, profileFaviconSize)); err != nil {
+				fallback()
+				return
+			}
+			favicon = buf.Bytes()
+			profileFavicons.SetWithTTL(profile.Picture, favicon, int64(len(favicon)), 24*time.Hour)
+		}
+
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "max-age=86400")
+		w.Write(favicon)
+	}
+}
+
+// squareThumbnail scales the image down so its smaller side is size, then crops the middle of it
+func squareThumbnail(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	if bounds.Dx() > bounds.Dy() {
+		img = resize.Resize(0, uint(size), img, resize.Lanczos3)
+	} else {
+		img = resize.Resize(uint(size), 0, img, resize.Lanczos3)
+	}
+	return cropToSquare(img)
+}
+
+// fetchProfileForFavicon only takes npubs and nprofiles, as looking up a NIP-05 address for every icon
+// request would have us make requests wherever anyone pleases
+func fetchProfileForFavicon(ctx context.Context, code string) (sdk.ProfileMetadata, error) {
+	var pubkey string
+	prefix, value, err := nip19.Decode(code)
+	if err != nil {
+		return sdk.ProfileMetadata{}, err
+	}
+	switch prefix {
+	case "npub":
+		pubkey = value.(string)
+	case "nprofile":
+		pubkey = value.(nostr.ProfilePointer).PublicKey
+	default:
+		return sdk.ProfileMetadata{}, fmt.Errorf("%s is not a profile code", prefix)
+	}
+
+	profile := sys.FetchProfileMetadata(ctx, pubkey)
+	if profile.Event != nil {
+		profile = parseProfileMetadata(profile.Event)
+	}
+	return profile, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/sdk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProfileFavicon(t *testing.T) {
+	var err error
+	previous := internal
+	internal, err = NewInternalDB(t.TempDir())
+	assert.NoError(t, err)
+	defer func() { internal = previous }()
+
+	profiles := map[string]sdk.ProfileMetadata{
+		"with-picture":    {PubKey: testPubkey1, Picture: "https://example.com/alice.png"},
+		"without-picture": {PubKey: testPubkey2},
+		"broken-picture":  {PubKey: testPubkey2, Picture: "https://example.com/broken.png"},
+	}
+	fetches := 0
+	handler := renderProfileFavicon(
+		func(ctx context.Context, code string) (sdk.ProfileMetadata, error) {
+			if profile, ok := profiles[code]; ok {
+				return profile, nil
+			}
+			return sdk.ProfileMetadata{}, fmt.Errorf("not found")
+		},
+		func(ctx context.Context, url string) (image.Image, error) {
+			if url != "https://example.com/alice.png" {
+				return nil, fmt.Errorf("failed to load")
+			}
+			fetches++
+			return image.NewRGBA(image.Rect(0, 0, 200, 100)), nil
+		},
+	)
+
+	get := func(code string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/njump/favicon/"+code, nil)
+		r.SetPathValue("code", code)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	// the picture becomes a small square png
+	w := get("with-picture")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	img, err := png.Decode(bytes.NewReader(w.Body.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, profileFaviconSize, profileFaviconSize), img.Bounds())
+
+	// and it is kept for next time
+	profileFavicons.Wait()
+	w = get("with-picture")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, 1, fetches)
+
+	// everything else gets the default icon
+	for _, code := range []string{"without-picture", "broken-picture", "unknown"} {
+		w = get(code)
+		assert.Equal(t, 302, w.Code, code)
+		assert.Equal(t, defaultProfileFavicon, w.Header().Get("Location"), code)
+	}
+}
+
+func TestProfileFaviconFetching(t *testing.T) {
+	// only profile codes, no NIP-05 lookups or anything else
+	nevent, _ := nip19.EncodeEvent(testPubkey1, nil, "")
+	for _, code := range []string{"alice@example.com", "example.com", nevent} {
+		_, err := fetchProfileForFavicon(context.Background(), code)
+		assert.Error(t, err, code)
+	}
+
+	// pictures are not fetched from internal addresses
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		png.Encode(w, image.NewRGBA(image.Rect(0, 0, 10, 10)))
+	}))
+	defer server.Close()
+	_, err := fetchImageFromURL(context.Background(), server.URL+"/picture.png")
+	assert.ErrorIs(t, err, errProxyForbiddenAddress)
+
+	// nor decoded when they say they are huge
+	small := &bytes.Buffer{}
+	assert.NoError(t, png.Encode(small, image.NewRGBA(image.Rect(0, 0, 1, 1))))
+	img, err := decodeImage(small.Bytes(), maxImagePixels)
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 1, 1), img.Bounds())
+
+	bomb := bytes.Clone(small.Bytes())
+	binary.BigEndian.PutUint32(bomb[16:], 100000) // width and height in the IHDR chunk
+	binary.BigEndian.PutUint32(bomb[20:], 100000)
+	binary.BigEndian.PutUint32(bomb[29:], crc32.ChecksumIEEE(bomb[12:29]))
+	_, err = decodeImage(bomb, maxImagePixels)
+	assert.ErrorIs(t, err, errImageTooBig)
+}
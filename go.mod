@@ -4,9 +4,11 @@ go 1.24.2
 
 require (
 	fiatjaf.com/leafdb v0.0.7
+	github.com/HugoSmits86/nativewebp v1.3.0
 	github.com/PuerkitoBio/goquery v1.10.1
 	github.com/a-h/templ v0.3.865
 	github.com/bytesparadise/libasciidoc v0.8.0
+	github.com/coder/websocket v1.8.13
 	github.com/dgraph-io/ristretto v1.0.0
 	github.com/fiatjaf/eventstore v0.16.4
 	github.com/fiatjaf/khatru v0.17.5
@@ -28,7 +30,9 @@ require (
 	github.com/stretchr/testify v1.10.0
 	github.com/texttheater/golang-levenshtein v1.0.1
 	github.com/tylermmorton/tmpl v0.0.0-20231025031313-5552ee818c6d
-	golang.org/x/image v0.17.0
+	golang.org/x/image v0.24.0
+	golang.org/x/net v0.39.0
+	golang.org/x/sync v0.13.0
 	google.golang.org/protobuf v1.36.2
 	mvdan.cc/xurls/v2 v2.5.0
 )
@@ -49,7 +53,6 @@ require (
 	github.com/bytedance/sonic/loader v0.2.4 // indirect
 	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/cloudwego/base64x v0.1.5 // indirect
-	github.com/coder/websocket v1.8.13 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/decred/dcrd/crypto/blake256 v1.1.0 // indirect
 	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.4.0 // indirect
@@ -88,8 +91,6 @@ require (
 	golang.org/x/arch v0.15.0 // indirect
 	golang.org/x/exp v0.0.0-20250305212735-054e65f0b394 // indirect
 	golang.org/x/mod v0.24.0 // indirect
-	golang.org/x/net v0.39.0 // indirect
-	golang.org/x/sync v0.13.0 // indirect
 	golang.org/x/sys v0.32.0 // indirect
 	golang.org/x/text v0.24.0 // indirect
 	golang.org/x/tools v0.32.0 // indirect
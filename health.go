@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func renderHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprint(w, "ok")
+}
+
+// renderReadyz returns a handler that is only happy if at least one of the given relays
+// can be reached with the given check before the timeout, the answer is reused for cacheFor
+// so probes (or anyone else hitting it) don't open connections to all the relays every time
+func renderReadyz(
+	relays func() []string,
+	check func(ctx context.Context, url string) error,
+	timeout time.Duration,
+	cacheFor time.Duration,
+) http.HandlerFunc {
+	var mu sync.Mutex
+	var checkedAt time.Time
+	var ready bool
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+
+		// requests that come while a check is running wait for it and take its answer
+		mu.Lock()
+		if time.Since(checkedAt) >= cacheFor {
+			ready = anyRelayReachable(relays(), check, timeout)
+			checkedAt = time.Now()
+		}
+		isReady := ready
+		mu.Unlock()
+
+		if !isReady {
+			http.Error(w, "no relays reachable", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "ready")
+	}
+}
+
+// anyRelayReachable doesn't depend on the request context since its result is shared with other requests
+func anyRelayReachable(
+	urls []string,
+	check func(ctx context.Context, url string) error,
+	timeout time.Duration,
+) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	results := make(chan error, len(urls))
+	for _, url := range urls {
+		go func() {
+			results <- check(ctx, url)
+		}()
+	}
+
+	for range urls {
+		select {
+		case err := <-results:
+			if err == nil {
+				return true
+			}
+			log.Debug().Err(err).Msg("relay not reachable on readiness check")
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	return false
+}
+
+func connectToRelay(ctx context.Context, url string) error {
+	relay, err := nostr.RelayConnect(ctx, url)
+	if err != nil {
+		return err
+	}
+	return relay.Close()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadyz(t *testing.T) {
+	relays := func() []string { return []string{"wss://down.example.com", "wss://up.example.com"} }
+	check := func(ctx context.Context, url string) error {
+		if url == "wss://up.example.com" {
+			return nil
+		}
+		return errors.New("connection refused")
+	}
+
+	w := httptest.NewRecorder()
+	renderReadyz(relays, check, time.Second, 0)(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, 200, w.Code)
+}
+
+func TestReadyzWithNoRelaysReachable(t *testing.T) {
+	relays := func() []string { return []string{"wss://down.example.com", "wss://hanging.example.com"} }
+	check := func(ctx context.Context, url string) error {
+		if url == "wss://hanging.example.com" {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return errors.New("connection refused")
+	}
+
+	w := httptest.NewRecorder()
+	renderReadyz(relays, check, time.Millisecond*50, 0)(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, 503, w.Code)
+}
+
+func TestReadyzIsCached(t *testing.T) {
+	relays := func() []string { return []string{"wss://up.example.com", "wss://other.example.com"} }
+	var checks atomic.Int32
+	var down atomic.Bool
+	check := func(ctx context.Context, url string) error {
+		checks.Add(1)
+		if down.Load() {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+
+	readyz := renderReadyz(relays, check, time.Second, time.Millisecond*100)
+	for range 5 {
+		w := httptest.NewRecorder()
+		readyz(w, httptest.NewRequest("GET", "/readyz", nil))
+		assert.Equal(t, 200, w.Code)
+	}
+	assert.LessOrEqual(t, checks.Load(), int32(2))
+
+	// once it expires the relays are checked again
+	down.Store(true)
+	time.Sleep(time.Millisecond * 150)
+	w := httptest.NewRecorder()
+	readyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, 503, w.Code)
+}
+
+func TestHealthz(t *testing.T) {
+	w := httptest.NewRecorder()
+	renderHealthz(w, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, 200, w.Code)
+}
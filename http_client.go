@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip05"
+	"github.com/nbd-wtf/go-nostr/nip11"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/sdk"
+)
+
+// httpClient is used for all our outbound http requests so connections are reused
+var httpClient = newHTTPClient(10 * time.Second)
+
+func newHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   5 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   10,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+	}
+}
+
+// maxWellKnownSize is as much as we read of a nostr.json or a relay information document
+const maxWellKnownSize = 1 << 20
+
+// queryNIP05 is nip05.QueryIdentifier going through our httpClient, without following redirects as NIP-05 asks
+func queryNIP05(ctx context.Context, fullname string) (*nostr.ProfilePointer, error) {
+	name, domain, err := nip05.ParseIdentifier(fullname)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse '%s': %w", fullname, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", nip05.IdentifierToURL(name+"@"+domain), nil)
+	if err != nil {
+		return nil, err
+	}
+	client := *httpClient
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request to %s failed: %w", domain, err)
+	}
+	defer res.Body.Close()
+
+	var result nip05.WellKnownResponse
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxWellKnownSize)).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid nostr.json from %s: %w", domain, err)
+	}
+	pubkey, ok := result.Names[name]
+	if !ok {
+		return nil, fmt.Errorf("no entry for name '%s'", name)
+	}
+	if !nostr.IsValidPublicKey(pubkey) {
+		return nil, fmt.Errorf("got an invalid public key '%s'", pubkey)
+	}
+	return &nostr.ProfilePointer{PublicKey: pubkey, Relays: result.Relays[pubkey]}, nil
+}
+
+// fetchProfileFromInput is sys.FetchProfileFromInput with NIP-05 identifiers resolved by queryNIP05
+func fetchProfileFromInput(ctx context.Context, input string) (sdk.ProfileMetadata, error) {
+	if nip05.IsValidIdentifier(input) {
+		pp, err := queryNIP05(ctx, input)
+		if err != nil {
+			return sdk.ProfileMetadata{}, fmt.Errorf("couldn't decode input '%s': %w", input, err)
+		}
+		input, _ = nip19.EncodeProfile(pp.PublicKey, pp.Relays)
+	}
+	return sys.FetchProfileFromInput(ctx, input)
+}
+
+// fetchRelayInfo is nip11.Fetch going through our httpClient, the returned document always has at least the URL
+func fetchRelayInfo(ctx context.Context, url string) (nip11.RelayInformationDocument, error) {
+	url = nostr.NormalizeURL(url)
+	info := nip11.RelayInformationDocument{URL: url}
+	if len(url) < 8 {
+		return info, fmt.Errorf("invalid url %s", url)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", "http"+url[2:], nil)
+	if err != nil {
+		return info, err
+	}
+	req.Header.Set("Accept", "application/nostr+json")
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return info, fmt.Errorf("request failed: %w", err)
+	}
+	defer res.Body.Close()
+
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxWellKnownSize)).Decode(&info); err != nil {
+		return info, fmt.Errorf("invalid json: %w", err)
+	}
+	info.URL = url
+	return info, nil
+}
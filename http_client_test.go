@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestWellKnownFetching(t *testing.T) {
+	previous := httpClient
+	defer func() { httpClient = previous }()
+
+	requested := []string{}
+	httpClient = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		requested = append(requested, r.URL.String())
+		w := httptest.NewRecorder()
+		switch r.URL.Host {
+		case "example.com":
+			w.WriteString(`{"names":{"alice":"` + testPubkey1 + `"},"relays":{"` + testPubkey1 + `":["wss://relay.example.com"]}}`)
+		case "moved.example.com":
+			http.Redirect(w, r, "https://example.com"+r.URL.RequestURI(), http.StatusFound)
+		case "relay.example.com":
+			assert.Equal(t, "application/nostr+json", r.Header.Get("Accept"))
+			w.WriteString(`{"name":"Example","description":"` + strings.Repeat("a", 10) + `"}`)
+		}
+		res := w.Result()
+		res.Request = r
+		return res, nil
+	})}
+
+	pp, err := queryNIP05(context.Background(), "alice@example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, testPubkey1, pp.PublicKey)
+	assert.Equal(t, []string{"wss://relay.example.com"}, pp.Relays)
+
+	_, err = queryNIP05(context.Background(), "bob@example.com")
+	assert.Error(t, err)
+
+	// redirects are not followed
+	requested = nil
+	_, err = queryNIP05(context.Background(), "alice@moved.example.com")
+	assert.Error(t, err)
+	assert.Equal(t, []string{"https://moved.example.com/.well-known/nostr.json?name=alice"}, requested)
+
+	info, err := fetchRelayInfo(context.Background(), "relay.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "Example", info.Name)
+	assert.Equal(t, "wss://relay.example.com", info.URL)
+
+	info, err = fetchRelayInfo(context.Background(), "wss://nothing.example.com")
+	assert.Error(t, err)
+	assert.Equal(t, "wss://nothing.example.com", info.URL)
+}
+
+func TestHTTPClientTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(2 * time.Second):
+		case <-r.Context().Done():
+		}
+		w.Write([]byte("too late"))
+	}))
+	defer server.Close()
+
+	client := newHTTPClient(100 * time.Millisecond)
+	start := time.Now()
+	_, err := client.Get(server.URL)
+	assert.Error(t, err)
+	var netErr net.Error
+	assert.ErrorAs(t, err, &netErr)
+	assert.True(t, netErr.Timeout())
+	assert.Less(t, time.Since(start), time.Second)
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
+	"math"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -30,6 +34,72 @@ func loggingMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	})
 }
 
+// headMiddleware answers HEAD requests by running the normal handler and throwing the body away,
+// so crawlers get the same status and headers, with the Content-Length of the page they would get
+func headMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		hw := &headResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(hw, r)
+
+		if w.Header().Get("Content-Length") == "" && hw.status != http.StatusNotModified {
+			w.Header().Set("Content-Length", strconv.Itoa(hw.size))
+		}
+		if hw.status == 0 {
+			hw.status = http.StatusOK
+		}
+		w.WriteHeader(hw.status)
+	}
+}
+
+type headResponseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (hw *headResponseWriter) WriteHeader(status int) {
+	if hw.status == 0 {
+		hw.status = status
+	}
+}
+
+func (hw *headResponseWriter) Write(b []byte) (int, error) {
+	if hw.status == 0 {
+		hw.status = http.StatusOK
+	}
+	hw.size += len(b)
+	return len(b), nil
+}
+
+var nip19PathMatcher = regexp.MustCompile(`(?i)^/((npub|nprofile|note|nevent|naddr)1[a-z0-9]+)/*$`)
+
+// canonicalPathMiddleware permanently redirects things like /NOTE1.../ to /note1...
+func canonicalPathMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.CanonicalRedirects {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if match := nip19PathMatcher.FindStringSubmatch(r.URL.Path); match != nil {
+			if canonical := "/" + strings.ToLower(match[1]); canonical != r.URL.Path {
+				if r.URL.RawQuery != "" {
+					canonical += "?" + r.URL.RawQuery
+				}
+				http.Redirect(w, r, canonical, http.StatusMovedPermanently)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
 var (
 	queue              = [26]sync.Mutex{}
 	concurrentRequests = [26]atomic.Uint32{}
@@ -95,3 +165,108 @@ func queueMiddleware(next http.HandlerFunc) http.HandlerFunc {
 func stupidHash(s string) int {
 	return int(s[3] + s[7] + s[18] + s[29])
 }
+
+// rateLimiter is a token bucket per client ip, a nil *rateLimiter doesn't limit anything
+type rateLimiter struct {
+	mu         sync.Mutex
+	buckets    map[string]*tokenBucket
+	maxBuckets int
+	rate       float64 // tokens per second
+	burst      float64
+	now        func() time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+const maxRateLimitBuckets = 50000
+
+func newRateLimiter(perMinute int, burst int, now func() time.Time) *rateLimiter {
+	return &rateLimiter{
+		buckets:    make(map[string]*tokenBucket),
+		maxBuckets: maxRateLimitBuckets,
+		rate:       float64(perMinute) / 60,
+		burst:      float64(max(burst, 1)),
+		now:        now,
+	}
+}
+
+// allow takes a token from the bucket of the given key, when there are none left
+// it returns how long the caller has to wait until the next one is available
+func (rl *rateLimiter) allow(key string) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	b, ok := rl.buckets[key]
+	if !ok {
+		if len(rl.buckets) >= rl.maxBuckets {
+			// full until the next sweep, drop any one of them so this stays cheap
+			for other := range rl.buckets {
+				delete(rl.buckets, other)
+				break
+			}
+		}
+		b = &tokenBucket{tokens: rl.burst, last: now}
+		rl.buckets[key] = b
+	} else {
+		b.tokens = min(rl.burst, b.tokens+now.Sub(b.last).Seconds()*rl.rate)
+		b.last = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - b.tokens) / rl.rate * float64(time.Second))
+}
+
+// prune forgets all buckets that would be full by now anyway
+func (rl *rateLimiter) prune() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	for key, b := range rl.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rl.rate >= rl.burst {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// pruneEvery runs prune in the background so requests never have to go through all the buckets
+func (rl *rateLimiter) pruneEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rl.prune()
+		}
+	}
+}
+
+func (rl *rateLimiter) middleware(next http.HandlerFunc) http.HandlerFunc {
+	if rl == nil {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		// this is the address that connected to us unless it is one of our proxies, so the buckets
+		// can't be dodged by sending a different X-Forwarded-For every time
+		if ok, wait := rl.allow(actualIP(r)); !ok {
+			log.Debug().Str("ip", actualIP(r)).Str("path", r.URL.Path).Msg("rate limited")
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	previousTrust, previous := s.TrustProxyHeaders, trustedProxies
+	s.TrustProxyHeaders = true
+	trustedProxies, _ = parseTrustedProxies([]string{"192.0.2.1", "10.0.0.0/8"}) // httptest requests come from 192.0.2.1
+	defer func() { s.TrustProxyHeaders, trustedProxies = previousTrust, previous }()
+
+	now := time.Unix(1700000000, 0)
+	limiter := newRateLimiter(60, 3, func() time.Time { return now })
+	handler := limiter.middleware(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	})
+
+	request := func(ip string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/npub1xyz", nil)
+		r.Header.Set("X-Forwarded-For", ip+", 10.0.0.1")
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	// a burst within the limit goes through
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, 200, request("1.1.1.1").Code)
+	}
+
+	// the next one doesn't
+	w := request("1.1.1.1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	// other ips have their own buckets
+	assert.Equal(t, 200, request("2.2.2.2").Code)
+
+	// tokens come back with time
+	now = now.Add(time.Second)
+	assert.Equal(t, 200, request("1.1.1.1").Code)
+	assert.Equal(t, http.StatusTooManyRequests, request("1.1.1.1").Code)
+}
+
+func TestRateLimitIgnoresUntrustedForwardedFor(t *testing.T) {
+	previousTrust, previous := s.TrustProxyHeaders, trustedProxies
+	defer func() { s.TrustProxyHeaders, trustedProxies = previousTrust, previous }()
+
+	for _, trust := range []bool{false, true} {
+		// even when proxy headers are trusted they don't count from a peer that isn't one of our proxies
+		s.TrustProxyHeaders = trust
+		trustedProxies, _ = parseTrustedProxies([]string{"10.0.0.0/8"})
+
+		now := time.Unix(1700000000, 0)
+		limiter := newRateLimiter(60, 1, func() time.Time { return now })
+		handler := limiter.middleware(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(200)
+		})
+
+		for i, expected := range []int{200, http.StatusTooManyRequests, http.StatusTooManyRequests} {
+			r := httptest.NewRequest("GET", "/npub1xyz", nil)
+			r.RemoteAddr = "3.3.3.3:" + strconv.Itoa(1000+i)
+			r.Header.Set("X-Forwarded-For", []string{"4.4.4.4", "5.5.5.5", "6.6.6.6"}[i])
+			r.Header.Set("CF-Connecting-IP", []string{"4.4.4.4", "5.5.5.5", "6.6.6.6"}[i])
+			w := httptest.NewRecorder()
+			handler(w, r)
+			assert.Equal(t, expected, w.Code)
+		}
+	}
+}
+
+func TestRateLimitBuckets(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	limiter := newRateLimiter(60, 2, func() time.Time { return now })
+	limiter.maxBuckets = 3
+
+	// there are never more buckets than the cap, no matter how many ips show up
+	for i := 0; i < 10; i++ {
+		limiter.allow("10.0.0." + strconv.Itoa(i))
+		assert.LessOrEqual(t, len(limiter.buckets), 3)
+	}
+
+	// buckets that have filled up again are swept away, the ones still in use are kept
+	limiter.allow("10.0.0.9")
+	now = now.Add(time.Second)
+	limiter.prune()
+	assert.Len(t, limiter.buckets, 1)
+	assert.Contains(t, limiter.buckets, "10.0.0.9")
+
+	now = now.Add(time.Second)
+	limiter.prune()
+	assert.Empty(t, limiter.buckets)
+}
+
+func TestCanonicalPathRedirect(t *testing.T) {
+	previous := s.CanonicalRedirects
+	s.CanonicalRedirects = true
+	defer func() { s.CanonicalRedirects = previous }()
+
+	handler := canonicalPathMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	})
+
+	const note = "note1xsqjs7v8aw0ln3sd4swasnjgldtzst80crpdfzgqnnqqt4a7508qc4pf6j"
+	for path, expected := range map[string]string{
+		"/" + note + "/":                     "/" + note,
+		"/" + note + "//?foo=bar":            "/" + note + "?foo=bar",
+		"/" + strings.ToUpper(note):          "/" + note,
+		"/Note1" + note[len("note1"):] + "/": "/" + note,
+	} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, http.StatusMovedPermanently, w.Code, path)
+		assert.Equal(t, expected, w.Header().Get("Location"), path)
+	}
+
+	for _, path := range []string{"/" + note, "/r/relay.damus.io/", "/njump/static/logo.png", "/fiatjaf.com"} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, 200, w.Code, path)
+	}
+}
+
+func TestHeadRequest(t *testing.T) {
+	handler := headMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Cache-Control", cacheControlForKind(1))
+		noteTemplate(NotePageParams{
+			BaseEventPageParams: BaseEventPageParams{Event: testEnhancedEvent(&nostr.Event{Kind: 1, Content: "hello"})},
+			Content:             "hello",
+		}, false).Render(r.Context(), w)
+	})
+
+	get := httptest.NewRecorder()
+	handler(get, httptest.NewRequest("GET", "/note", nil))
+	assert.Equal(t, 200, get.Code)
+	assert.Contains(t, get.Body.String(), "hello")
+
+	head := httptest.NewRecorder()
+	handler(head, httptest.NewRequest("HEAD", "/note", nil))
+	assert.Equal(t, 200, head.Code)
+	assert.Equal(t, 0, head.Body.Len())
+	assert.Equal(t, "text/html", head.Header().Get("Content-Type"))
+	assert.Equal(t, get.Header().Get("Cache-Control"), head.Header().Get("Cache-Control"))
+	assert.Equal(t, strconv.Itoa(get.Body.Len()), head.Header().Get("Content-Length"))
+
+	// errors are kept too
+	head = httptest.NewRecorder()
+	headMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "event banned", http.StatusNotFound)
+	})(head, httptest.NewRequest("HEAD", "/note", nil))
+	assert.Equal(t, http.StatusNotFound, head.Code)
+	assert.Equal(t, 0, head.Body.Len())
+}
+
+func TestCacheControlForKind(t *testing.T) {
+	previousMaxAge, previousMaxAgeMutable := s.CacheMaxAge, s.CacheMaxAgeMutable
+	t.Cleanup(func() { s.CacheMaxAge, s.CacheMaxAgeMutable = previousMaxAge, previousMaxAgeMutable })
+	s.CacheMaxAge = 604800
+	s.CacheMaxAgeMutable = 300
+
+	assert.Equal(t, "max-age=604800, s-maxage=604800, immutable", cacheControlForKind(1))
+	assert.Equal(t, "max-age=604800, s-maxage=604800, immutable", cacheControlForKind(6))
+	assert.Equal(t, "max-age=300, s-maxage=300, must-revalidate", cacheControlForKind(0))
+	assert.Equal(t, "max-age=300, s-maxage=300, must-revalidate", cacheControlForKind(10002))
+	assert.Equal(t, "max-age=300, s-maxage=300, must-revalidate", cacheControlForKind(30023))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/nip52"
+)
+
+const (
+	icsDateFormat     = "20060102"
+	icsDateTimeFormat = "20060102T150405Z"
+)
+
+// calendarICS turns a NIP-52 calendar event into an iCalendar file with a single VEVENT, times are
+// given in UTC and whole days as dates, the way the event has them
+func calendarICS(evt *nostr.Event) string {
+	calev := nip52.ParseCalendarEvent(*evt)
+	if calev.Title == "" {
+		// fallback for the deprecated 'name' field
+		if tag := evt.Tags.Find("name"); tag != nil {
+			calev.Title = tag[1]
+		}
+	}
+
+	var ics strings.Builder
+	line := func(name string, value string) {
+		writeICSLine(&ics, name+":"+value)
+	}
+	when := func(name string, t time.Time) {
+		if calev.CalendarEventKind == nip52.DateBased {
+			line(name+";VALUE=DATE", t.Format(icsDateFormat))
+		} else {
+			line(name, t.UTC().Format(icsDateTimeFormat))
+		}
+	}
+
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", "-//njump//calendar//EN")
+	line("CALSCALE", "GREGORIAN")
+	line("BEGIN", "VEVENT")
+	line("UID", evt.ID)
+	line("DTSTAMP", evt.CreatedAt.Time().UTC().Format(icsDateTimeFormat))
+	when("DTSTART", calev.Start)
+	if !calev.End.IsZero() {
+		when("DTEND", calev.End)
+	}
+	line("SUMMARY", escapeICSText(calev.Title))
+	if len(calev.Locations) != 0 {
+		line("LOCATION", escapeICSText(calev.Locations[0]))
+	}
+	if content := strings.TrimSpace(evt.Content); content != "" {
+		line("DESCRIPTION", escapeICSText(content))
+	}
+	if naddr, err := nip19.EncodeEntity(evt.PubKey, evt.Kind, calev.Identifier, nil); err == nil {
+		line("URL", canonicalURL(naddr))
+	}
+	line("END", "VEVENT")
+	line("END", "VCALENDAR")
+
+	return ics.String()
+}
+
+var icsTextEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+func escapeICSText(text string) string {
+	return icsTextEscaper.Replace(text)
+}
+
+// writeICSLine ends the line with CRLF and folds it every 75 bytes, without breaking characters apart,
+// as RFC 5545 asks
+func writeICSLine(ics *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		ics.WriteString(line[:cut])
+		ics.WriteString("\r\n ")
+		line = line[cut:]
+		// the space starting the continuation counts towards its length
+		limit = 74
+	}
+	ics.WriteString(line)
+	ics.WriteString("\r\n")
+}
+
+func renderICS(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	code := r.PathValue("code")
+
+	evt, _, err := getEvent(ctx, code, false)
+	if err != nil {
+		w.Header().Set("Cache-Control", "max-age=60")
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if evt.Kind != nip52.DateBased && evt.Kind != nip52.TimeBased {
+		w.Header().Set("Cache-Control", "max-age=60")
+		http.Error(w, "not a calendar event", http.StatusNotFound)
+		return
+	}
+	if renderIfNotAllowed(ctx, w, NewEnhancedEvent(ctx, evt)) {
+		return
+	}
+
+	w.Header().Set("Cache-Control", cacheControlForKind(evt.Kind))
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+code+`.ics"`)
+	w.Write([]byte(calendarICS(evt)))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalendarICS(t *testing.T) {
+	lines := func(ics string) []string {
+		assert.True(t, strings.HasSuffix(ics, "\r\n"))
+		for _, line := range strings.Split(ics, "\r\n") {
+			assert.LessOrEqual(t, len(line), 75)
+		}
+		// unfolded
+		return strings.Split(strings.TrimSuffix(strings.ReplaceAll(ics, "\r\n ", ""), "\r\n"), "\r\n")
+	}
+
+	dateBased := &nostr.Event{
+		ID:        "d1e2f3a4b5c6d7e8f9a0b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9c0d1e2",
+		PubKey:    testPubkey1,
+		Kind:      31922,
+		CreatedAt: 1710000000,
+		Tags: nostr.Tags{
+			{"d", "pickling-festival"},
+			{"title", "Pickling festival; bring jars, lids"},
+			{"start", "2024-05-01"},
+			{"end", "2024-05-03"},
+			{"location", "Town square"},
+		},
+		Content: "Three days of pickles.\nEveryone is welcome! " + strings.Repeat("🥒", 30),
+	}
+	ics := lines(calendarICS(dateBased))
+	assert.Equal(t, []string{"BEGIN:VCALENDAR", "VERSION:2.0", "PRODID:-//njump//calendar//EN", "CALSCALE:GREGORIAN", "BEGIN:VEVENT"}, ics[:5])
+	assert.Equal(t, []string{"END:VEVENT", "END:VCALENDAR"}, ics[len(ics)-2:])
+	assert.Contains(t, ics, "UID:"+dateBased.ID)
+	assert.Contains(t, ics, "DTSTAMP:20240309T160000Z")
+	assert.Contains(t, ics, "DTSTART;VALUE=DATE:20240501")
+	assert.Contains(t, ics, "DTEND;VALUE=DATE:20240503")
+	assert.Contains(t, ics, `SUMMARY:Pickling festival\; bring jars\, lids`)
+	assert.Contains(t, ics, "LOCATION:Town square")
+	assert.Contains(t, ics, `DESCRIPTION:Three days of pickles.\nEveryone is welcome! `+strings.Repeat("🥒", 30))
+	assert.Contains(t, ics[len(ics)-3], "URL:https://")
+	assert.Contains(t, ics[len(ics)-3], "/naddr1")
+
+	timeBased := &nostr.Event{
+		ID:        "a1e2f3a4b5c6d7e8f9a0b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9c0d1e2",
+		PubKey:    testPubkey1,
+		Kind:      31923,
+		CreatedAt: 1710000000,
+		Tags: nostr.Tags{
+			{"d", "meetup"},
+			{"name", "Meetup"},
+			{"start", "1714539600"},
+			{"start_tzid", "Asia/Tokyo"},
+		},
+	}
+	ics = lines(calendarICS(timeBased))
+	assert.Contains(t, ics, "DTSTART:20240501T050000Z")
+	assert.Contains(t, ics, "SUMMARY:Meetup")
+	for _, line := range ics {
+		assert.False(t, strings.HasPrefix(line, "DTEND"), line)
+		assert.False(t, strings.HasPrefix(line, "LOCATION"), line)
+		assert.False(t, strings.HasPrefix(line, "DESCRIPTION"), line)
+	}
+}
+
+func TestRenderICSModeration(t *testing.T) {
+	get := func(evt *nostr.Event) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/njump/ics/"+evt.ID, nil)
+		req.SetPathValue("code", evt.ID)
+		w := httptest.NewRecorder()
+		renderICS(w, req.WithContext(withLocalOnly(req.Context())))
+		return w
+	}
+	calendarEvent := func(d string) *nostr.Event {
+		evt := &nostr.Event{Kind: 31922, CreatedAt: 1710000000, Tags: nostr.Tags{{"d", d}, {"title", d}, {"start", "2024-05-01"}}}
+		assert.NoError(t, evt.Sign(nostr.GeneratePrivateKey()))
+		assert.NoError(t, sys.Store.SaveEvent(context.Background(), evt))
+		return evt
+	}
+
+	fine := calendarEvent("fine")
+	w := get(fine)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "SUMMARY:fine")
+
+	// the author was banned
+	banned := calendarEvent("banned")
+	assert.NoError(t, internal.banPubkey(banned.PubKey, "spam"))
+	defer internal.unbanPubkey(banned.PubKey)
+	w = get(banned)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NotContains(t, w.Body.String(), "BEGIN:VCALENDAR")
+
+	// the author deleted it
+	deleted := calendarEvent("deleted")
+	deletion := &nostr.Event{Kind: nostr.KindDeletion, CreatedAt: 1710000001, Tags: nostr.Tags{{"a", eventAddress(deleted)}}}
+	deletion.PubKey = deleted.PubKey
+	deletion.ID = deletion.GetID()
+	assert.NoError(t, sys.Store.SaveEvent(context.Background(), deletion))
+	w = get(deleted)
+	assert.Equal(t, http.StatusGone, w.Code)
+	assert.NotContains(t, w.Body.String(), "BEGIN:VCALENDAR")
+}
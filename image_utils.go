@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
@@ -11,6 +12,7 @@ import (
 	_ "image/jpeg"
 	"image/png"
 	_ "image/png"
+	"io"
 	"math"
 	"net/http"
 	"net/url"
@@ -273,17 +275,44 @@ gotScriptIndex:
 	return lng, script, direction, face
 }
 
+const (
+	// maxFetchedImageSize is how many bytes of a picture we download before giving up on it
+	maxFetchedImageSize = 10 << 20
+	// maxImagePixels is the biggest canvas we decode, a tiny file can say it is huge and we would
+	// allocate all of it
+	maxImagePixels = 6000 * 6000
+)
+
+var errImageTooBig = errors.New("image too big")
+
+// imageClient fetches the pictures that anyone can point us at, so it doesn't connect to internal hosts
+var imageClient = &http.Client{Timeout: 10 * time.Second, Transport: newGuardedTransport(isPublicIP)}
+
 func fetchImageFromURL(ctx context.Context, url string) (image.Image, error) {
 	ctx, cancel := context.WithTimeout(ctx, time.Millisecond*350)
 	defer cancel()
-	req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
-	response, err := http.DefaultClient.Do(req)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image url %s: %w", url, err)
+	}
+	response, err := imageClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch image from %s: %w", url, err)
 	}
 	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch image from %s: status %d", url, response.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxFetchedImageSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image from %s: %w", url, err)
+	}
+	if len(data) > maxFetchedImageSize {
+		return nil, fmt.Errorf("%w: %s is over %d bytes", errImageTooBig, url, maxFetchedImageSize)
+	}
 
-	img, _, err := image.Decode(response.Body)
+	img, err := decodeImage(data, maxImagePixels)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode image from %s: %w", url, err)
 	}
@@ -291,6 +320,20 @@ func fetchImageFromURL(ctx context.Context, url string) (image.Image, error) {
 	return img, nil
 }
 
+// decodeImage decodes the image only after checking from its header that it isn't bigger than maxPixels
+func decodeImage(data []byte, maxPixels int) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width > maxPixels/config.Height {
+		return nil, fmt.Errorf("%w: %dx%d", errImageTooBig, config.Width, config.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
 func roundImage(img image.Image) image.Image {
 	bounds := img.Bounds()
 	diameter := math.Min(float64(bounds.Dx()), float64(bounds.Dy()))
@@ -793,7 +836,7 @@ func isImageURL(input string) bool {
 			return true // URL points to a valid image
 		}
 	}
-	return false
+	return isMediaHostURL(input) && !isVideoURL(input)
 }
 
 func isVideoURL(input string) bool {
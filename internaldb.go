@@ -21,6 +21,7 @@ const (
 	TypeEventInRelay      leafdb.DataType = 5
 	TypeBannedEvent       leafdb.DataType = 6
 	TypeBannedPubkey      leafdb.DataType = 7
+	TypeShortLink         leafdb.DataType = 8
 )
 
 func NewInternalDB(path string) (*InternalDB, error) {
@@ -43,6 +44,8 @@ func NewInternalDB(path string) (*InternalDB, error) {
 				v = &BannedEvent{}
 			case TypeBannedPubkey:
 				v = &BannedPubkey{}
+			case TypeShortLink:
+				v = &ShortLink{}
 			default:
 				return nil, fmt.Errorf("what is this? %v", t)
 			}
@@ -96,6 +99,14 @@ func NewInternalDB(path string) (*InternalDB, error) {
 					emit(ban.Pk[0:8])
 				},
 			},
+			"short-link": {
+				Version: 1,
+				Types:   []leafdb.DataType{TypeShortLink},
+				Emit: func(t leafdb.DataType, value proto.Message, emit func([]byte)) {
+					sl := value.(*ShortLink)
+					emit([]byte(sl.Id))
+				},
+			},
 		},
 		Views: map[string]leafdb.ViewDefinition[proto.Message]{
 			"pubkey-archive": {
@@ -1,16 +1,98 @@
 package main
 
 import (
+	"fmt"
+	"net"
 	"net/http"
 	"strings"
 )
 
+// trustedProxies are the addresses allowed to tell us who the client is with X-Forwarded-For and such,
+// when it's empty these headers are not believed from anyone
+var trustedProxies []*net.IPNet
+
+// parseTrustedProxies takes CIDRs or single addresses
+func parseTrustedProxies(entries []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipnet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		nets = append(nets, ipnet)
+	}
+	return nets, nil
+}
+
 func actualIP(r *http.Request) string {
-	if cf := r.Header.Get("CF-Connecting-IP"); cf != "" {
+	if !s.TrustProxyHeaders {
+		return remoteIP(r)
+	}
+	return clientIP(r, trustedProxies)
+}
+
+// clientIP takes the client address from the proxy headers, but only if the request came
+// from one of the trusted proxies, anyone else could be making them up
+func clientIP(r *http.Request, trusted []*net.IPNet) string {
+	remote := remoteIP(r)
+	if !isTrustedProxy(remote, trusted) {
+		return remote
+	}
+
+	// each proxy appends the address it got the request from, so we go from the end
+	// and the first one that isn't one of our proxies is the client
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			if !isTrustedProxy(hop, trusted) || i == 0 {
+				return hop
+			}
+		}
+	}
+	if cf := r.Header.Get("CF-Connecting-IP"); net.ParseIP(cf) != nil {
 		return cf
-	} else if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		return strings.Split(xff, ",")[0]
-	} else {
-		return r.RemoteAddr
 	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return remote
+}
+
+func isTrustedProxy(addr string, trusted []*net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, ipnet := range trusted {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"})
+	assert.NoError(t, err)
+
+	request := func(remote string, headers map[string]string) string {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remote
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		return clientIP(r, trusted)
+	}
+
+	// from a trusted proxy we skip our own hops from the end of the list
+	assert.Equal(t, "1.1.1.1", request("10.0.0.2:4444", map[string]string{"X-Forwarded-For": "6.6.6.6, 1.1.1.1, 10.0.0.5"}))
+	assert.Equal(t, "1.1.1.1", request("192.168.1.1:4444", map[string]string{"X-Forwarded-For": "1.1.1.1"}))
+	assert.Equal(t, "2.2.2.2", request("10.0.0.2:4444", map[string]string{"X-Real-IP": "2.2.2.2"}))
+
+	// from anywhere else the headers are ignored
+	assert.Equal(t, "3.3.3.3", request("3.3.3.3:4444", map[string]string{"X-Forwarded-For": "1.1.1.1"}))
+	assert.Equal(t, "3.3.3.3", request("3.3.3.3:4444", map[string]string{"X-Real-IP": "1.1.1.1"}))
+	assert.Equal(t, "192.168.1.2", request("192.168.1.2:4444", map[string]string{"X-Forwarded-For": "1.1.1.1"}))
+
+	// without headers it is just the address that connected to us
+	assert.Equal(t, "10.0.0.2", request("10.0.0.2:4444", nil))
+
+	// and without trusted proxies nobody is believed
+	trusted = nil
+	assert.Equal(t, "3.3.3.3", request("3.3.3.3:4444", map[string]string{"X-Forwarded-For": "1.1.1.1"}))
+	assert.Equal(t, "3.3.3.3", request("3.3.3.3:4444", map[string]string{"CF-Connecting-IP": "1.1.1.1"}))
+
+	_, err = parseTrustedProxies([]string{"not-an-ip"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type jsonLDPerson struct {
+	Type string `json:"@type"`
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type jsonLDDocument struct {
+	Context       string       `json:"@context"`
+	Type          string       `json:"@type"`
+	URL           string       `json:"url"`
+	Headline      string       `json:"headline,omitempty"`
+	ArticleBody   string       `json:"articleBody,omitempty"`
+	Image         string       `json:"image,omitempty"`
+	DatePublished string       `json:"datePublished"`
+	DateModified  string       `json:"dateModified,omitempty"`
+	Author        jsonLDPerson `json:"author"`
+}
+
+// eventJSONLD builds the schema.org structured data we put in the page head for search engines,
+// it returns an empty string for kinds we don't describe
+func eventJSONLD(ee EnhancedEvent, code string, headline string, image string) string {
+	createdAt := time.Unix(int64(ee.CreatedAt), 0).UTC().Format(time.RFC3339)
+
+	doc := jsonLDDocument{
+		Context:  "https://schema.org",
+		URL:      canonicalURL(code),
+		Headline: headline,
+		Image:    image,
+		Author: jsonLDPerson{
+			Type: "Person",
+			Name: ProfileDisplayName(ee.author),
+			URL:  canonicalURL(ee.author.Npub()),
+		},
+	}
+
+	switch ee.Kind {
+	case 1:
+		doc.Type = "SocialMediaPosting"
+		doc.ArticleBody = ee.content
+		doc.DatePublished = createdAt
+	case 30023:
+		doc.Type = "Article"
+		doc.DatePublished = createdAt
+		if ee.publishedAt != 0 {
+			doc.DatePublished = time.Unix(int64(ee.publishedAt), 0).UTC().Format(time.RFC3339)
+			doc.DateModified = createdAt
+		}
+	default:
+		return ""
+	}
+
+	j, _ := json.Marshal(doc)
+	return string(j)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONLD(t *testing.T) {
+	article := testEnhancedEvent(&nostr.Event{
+		Kind:      30023,
+		CreatedAt: 1700000000,
+		Content:   "# hello\n\nworld",
+		Tags: nostr.Tags{
+			{"d", "hello"},
+			{"title", "Hello World"},
+			{"published_at", "1690000000"},
+		},
+	})
+	var doc map[string]any
+	assert.NoError(t, json.Unmarshal([]byte(eventJSONLD(article, "naddr1xyz", "Hello World", "https://example.com/cover.png")), &doc))
+	assert.Equal(t, "https://schema.org", doc["@context"])
+	assert.Equal(t, "Article", doc["@type"])
+	assert.Equal(t, "Hello World", doc["headline"])
+	assert.Equal(t, "https://example.com/cover.png", doc["image"])
+	assert.Equal(t, "2023-07-22T04:26:40Z", doc["datePublished"])
+	assert.Equal(t, "2023-11-14T22:13:20Z", doc["dateModified"])
+	assert.Equal(t, "fiatjaf", doc["author"].(map[string]any)["name"])
+
+	note := testEnhancedEvent(&nostr.Event{Kind: 1, CreatedAt: 1700000000, Content: "gm"})
+	doc = nil
+	assert.NoError(t, json.Unmarshal([]byte(eventJSONLD(note, "nevent1xyz", "gm", "")), &doc))
+	assert.Equal(t, "SocialMediaPosting", doc["@type"])
+	assert.Equal(t, "gm", doc["articleBody"])
+	assert.Equal(t, "2023-11-14T22:13:20Z", doc["datePublished"])
+	assert.NotContains(t, doc, "image")
+
+	assert.Empty(t, eventJSONLD(testEnhancedEvent(&nostr.Event{Kind: 7}), "nevent1xyz", "", ""))
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, headCommonTemplate(HeadParams{JSONLD: eventJSONLD(note, "nevent1xyz", "gm", "")}).Render(context.Background(), buf))
+	assert.Contains(t, buf.String(), `<script type="application/ld+json">{"@context":"https://schema.org","@type":"SocialMediaPosting"`)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/sdk"
+)
+
+// kindTemplates are the templates that replace the built-in ones for some kinds, so forks can
+// render things their own way without touching the rest of the code
+var kindTemplates = make(map[int]*template.Template)
+
+// KindTemplateParams is what an override template gets to work with
+type KindTemplateParams struct {
+	Event      *nostr.Event
+	Author     sdk.ProfileMetadata
+	AuthorName string
+	Code       string
+	URL        string
+	Title      string
+	Text       string
+	Image      string
+	CreatedAt  string
+	IsEmbed    bool
+
+	// Content is already formatted and safe to put in the page as it is
+	Content template.HTML
+}
+
+func registerKindTemplate(kind int, tmpl *template.Template) {
+	kindTemplates[kind] = tmpl
+}
+
+// loadKindTemplates registers every <kind>.html file in dir as the template for that kind
+func loadKindTemplates(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".html")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		kind, err := strconv.Atoi(name)
+		if err != nil || kind < 0 {
+			continue
+		}
+
+		tmpl, err := template.ParseFiles(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		registerKindTemplate(kind, tmpl)
+		log.Info().Int("kind", kind).Str("file", entry.Name()).Msg("using custom template")
+	}
+	return nil
+}
+
+// renderKindTemplate renders the event with the template registered for its kind, if there is one,
+// it returns false when the built-in template should be used instead
+func renderKindTemplate(w http.ResponseWriter, data Data, og OpenGraphParams, isEmbed bool) bool {
+	tmpl, ok := kindTemplates[data.event.Kind]
+	if !ok {
+		return false
+	}
+
+	code := data.neventNaked
+	if data.naddrNaked != "" {
+		code = data.naddrNaked
+	}
+
+	// render it somewhere else first so a broken template doesn't leave us with half a page
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, KindTemplateParams{
+		Event:      data.event.Event,
+		Author:     data.event.author,
+		AuthorName: ProfileDisplayName(data.event.author),
+		Code:       code,
+		URL:        og.URL,
+		Title:      og.Subscript,
+		Text:       og.Text,
+		Image:      data.image,
+		CreatedAt:  data.createdAt,
+		IsEmbed:    isEmbed,
+		Content:    template.HTML(data.content),
+	}); err != nil {
+		log.Warn().Err(err).Int("kind", data.event.Kind).Msg("custom template failed, using the built-in one")
+		return false
+	}
+
+	w.Write(buf.Bytes())
+	return true
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"html/template"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKindTemplates(t *testing.T) {
+	defer func(original map[int]*template.Template) { kindTemplates = original }(kindTemplates)
+	kindTemplates = make(map[int]*template.Template)
+
+	preview := func(kind int, content string) *goquery.Document {
+		evt := nostr.Event{Kind: kind, CreatedAt: 1710000000, Tags: nostr.Tags{}, Content: content}
+		return testPreviewPage(t, evt)
+	}
+
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "1.html"),
+		[]byte(`<div class="custom-note"><b>{{.AuthorName}}</b> {{.Content}}</div>`), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "notes.html"), []byte(`{{.Nope`), 0644))
+	assert.NoError(t, loadKindTemplates(dir))
+	assert.Len(t, kindTemplates, 1)
+
+	doc := preview(1, "pickled <b>radishes</b>")
+	assert.Equal(t, 1, doc.Find(".custom-note").Length())
+	assert.Contains(t, doc.Find(".custom-note").Text(), "pickled <b>radishes</b>")
+	assert.Equal(t, 1, doc.Find(".custom-note b").Length()) // only the author's name, the content is escaped
+	assert.Equal(t, 0, doc.Find("article").Length())
+
+	// kinds without their own template look as always
+	doc = preview(1111, "pickled radishes")
+	assert.Equal(t, 0, doc.Find(".custom-note").Length())
+	assert.Contains(t, doc.Find("article").Text(), "pickled radishes")
+
+	// and so do the ones whose template breaks
+	registerKindTemplate(1, template.Must(template.New("broken").Parse(`{{.Event.Nope}}`)))
+	doc = preview(1, "pickled radishes")
+	assert.Contains(t, doc.Find("article").Text(), "pickled radishes")
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// contentLanguage is the language the event is written in, as declared in its NIP-32 ISO-639-1 label,
+// or as told by the script it is written in when that script is used for a single language,
+// it is empty when we can't be sure
+func contentLanguage(evt *nostr.Event) string {
+	for tag := range evt.Tags.FindAll("l") {
+		if len(tag) >= 3 && tag[2] == "ISO-639-1" && isLanguageCode(tag[1]) {
+			return strings.ToLower(tag[1])
+		}
+	}
+
+	var letters, kana, hangul, thai, han int
+	for _, r := range evt.Content {
+		switch {
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			kana++
+		case unicode.Is(unicode.Hangul, r):
+			hangul++
+		case unicode.Is(unicode.Thai, r):
+			thai++
+		case unicode.Is(unicode.Han, r):
+			han++
+		case !unicode.IsLetter(r):
+			continue
+		}
+		letters++
+	}
+	if letters == 0 {
+		return ""
+	}
+
+	// links and names are in latin letters everywhere, so it is enough for most of the text to be in the script
+	switch {
+	case kana > 0 && (kana+han)*2 > letters:
+		return "ja"
+	case hangul*2 > letters:
+		return "ko"
+	case thai*2 > letters:
+		return "th"
+	case han*2 > letters:
+		return "zh"
+	}
+	return ""
+}
+
+func isLanguageCode(code string) bool {
+	if len(code) != 2 {
+		return false
+	}
+	for _, r := range code {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContentLanguage(t *testing.T) {
+	languageOf := func(evt nostr.Event) string {
+		evt.Kind = 1
+		evt.CreatedAt = 1710000000
+		return testPreview(t, "/preview", evt).Header().Get("Content-Language")
+	}
+
+	assert.Equal(t, "ja", languageOf(nostr.Event{Content: "おはようございます、今日もいい天気ですね https://example.com"}))
+	assert.Equal(t, "", languageOf(nostr.Event{Content: "good morning, nice weather today"}))
+	assert.Equal(t, "pt", languageOf(nostr.Event{
+		Content: "bom dia",
+		Tags:    nostr.Tags{{"L", "ISO-639-1"}, {"l", "PT", "ISO-639-1"}},
+	}))
+
+	assert.Equal(t, "ko", contentLanguage(&nostr.Event{Content: "안녕하세요 여러분 nostr"}))
+	assert.Equal(t, "zh", contentLanguage(&nostr.Event{Content: "今天天气很好"}))
+	assert.Equal(t, "", contentLanguage(&nostr.Event{Content: "gm 日"}))
+	assert.Equal(t, "", contentLanguage(&nostr.Event{Content: "hello", Tags: nostr.Tags{{"l", "english", "ISO-639-1"}}}))
+}
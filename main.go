@@ -10,26 +10,64 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"time"
 
 	"github.com/fiatjaf/khatru"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/nbd-wtf/go-nostr"
+	"github.com/pelletier/go-toml"
 	"github.com/rs/cors"
 	"github.com/rs/zerolog"
 )
 
 type Settings struct {
-	Port                string   `envconfig:"PORT" default:"2999"`
-	Domain              string   `envconfig:"DOMAIN" default:"njump.me"`
-	ServiceURL          string   `envconfig:"SERVICE_URL"`
-	InternalDBPath      string   `envconfig:"DISK_CACHE_PATH" default:"/tmp/njump-internal"`
-	EventStorePath      string   `envconfig:"EVENT_STORE_PATH" default:"/tmp/njump-db"`
-	KVStorePath         string   `envconfig:"KV_STORE_PATH" default:"/tmp/njump-kv"`
-	HintsMemoryDumpPath string   `envconfig:"HINTS_SAVE_PATH" default:"/tmp/njump-hints.json"`
-	TailwindDebug       bool     `envconfig:"TAILWIND_DEBUG"`
-	RelayConfigPath     string   `envconfig:"RELAY_CONFIG_PATH"`
-	TrustedPubKeys      []string `envconfig:"TRUSTED_PUBKEYS"`
-	MediaAlertAPIKey    string   `envconfig:"MEDIA_ALERT_API_KEY"`
+	Port                string        `envconfig:"PORT" default:"2999"`
+	Domain              string        `envconfig:"DOMAIN" default:"njump.me"`
+	ServiceURL          string        `envconfig:"SERVICE_URL"`
+	BaseURL             string        `envconfig:"BASE_URL"`
+	InternalDBPath      string        `envconfig:"DISK_CACHE_PATH" default:"/tmp/njump-internal"`
+	EventStorePath      string        `envconfig:"EVENT_STORE_PATH" default:"/tmp/njump-db"`
+	KVStorePath         string        `envconfig:"KV_STORE_PATH" default:"/tmp/njump-kv"`
+	HintsMemoryDumpPath string        `envconfig:"HINTS_SAVE_PATH" default:"/tmp/njump-hints.json"`
+	TailwindDebug       bool          `envconfig:"TAILWIND_DEBUG"`
+	RelayConfigPath     string        `envconfig:"RELAY_CONFIG_PATH"`
+	FallbackImagesPath  string        `envconfig:"FALLBACK_IMAGES_PATH"`
+	TrustedPubKeys      []string      `envconfig:"TRUSTED_PUBKEYS"`
+	MediaAlertAPIKey    string        `envconfig:"MEDIA_ALERT_API_KEY"`
+	TrustProxyHeaders   bool          `envconfig:"TRUST_PROXY_HEADERS" default:"false"`
+	TrustedProxies      []string      `envconfig:"TRUSTED_PROXIES"`
+	MaxRelayOverrides   int           `envconfig:"RELAY_OVERRIDE_MAX" default:"5"`
+	AllowPrivateRelays  bool          `envconfig:"RELAY_OVERRIDE_ALLOW_PRIVATE"`
+	RateLimitPerMinute  int           `envconfig:"RATE_LIMIT_PER_MINUTE"`
+	RateLimitBurst      int           `envconfig:"RATE_LIMIT_BURST" default:"20"`
+	CanonicalRedirects  bool          `envconfig:"CANONICAL_REDIRECTS" default:"true"`
+	ProxyMaxSize        int64         `envconfig:"PROXY_MAX_SIZE" default:"10485760"`
+	ProxyTranscode      bool          `envconfig:"PROXY_TRANSCODE"`
+	ImageProxies        []string      `envconfig:"IMAGE_PROXIES"`
+	ShortLinks          bool          `envconfig:"SHORT_LINKS"`
+	CacheMaxAge         int           `envconfig:"CACHE_MAX_AGE" default:"604800"`
+	CacheMaxAgeMutable  int           `envconfig:"CACHE_MAX_AGE_REPLACEABLE" default:"300"`
+	HTTPTimeout         time.Duration `envconfig:"HTTP_TIMEOUT" default:"10s"`
+	BlurUntrustedMedia  bool          `envconfig:"BLUR_UNTRUSTED_MEDIA"`
+	MediaAllowlist      []string      `envconfig:"MEDIA_AUTHOR_ALLOWLIST"`
+	MediaHosts          []string      `envconfig:"MEDIA_HOSTS"`
+	TrackingParams      []string      `envconfig:"STRIP_TRACKING_PARAMS"`
+	FooterHTML          string        `envconfig:"FOOTER_HTML"`
+	KindTemplatesPath   string        `envconfig:"KIND_TEMPLATES_PATH"`
+	Notice              string        `envconfig:"NOTICE"`
+	NoticeDismissible   bool          `envconfig:"NOTICE_DISMISSIBLE"`
+	TorProxy            string        `envconfig:"TOR_PROXY"`
+	MaxRelayConnections int           `envconfig:"RELAY_MAX_CONNECTIONS"`
+	RelayConnectionWait time.Duration `envconfig:"RELAY_CONNECTION_WAIT" default:"5s"`
+	StripZeroWidth      bool          `envconfig:"STRIP_ZERO_WIDTH"`
+	HomeFeedSize        int           `envconfig:"HOME_FEED_SIZE" default:"12"`
+	HomeFeedRelays      []string      `envconfig:"HOME_FEED_RELAYS"`
+	ReadingWPM          int           `envconfig:"READING_WPM" default:"200"`
+	SearchIndexSize     int           `envconfig:"SEARCH_INDEX_SIZE" default:"10000"`
+	ExpiredEventsGone   bool          `envconfig:"EXPIRED_EVENTS_GONE"`
+	BlockedPubkeys      []string      `envconfig:"BLOCKED_PUBKEYS"`
+	BlockedEvents       []string      `envconfig:"BLOCKED_EVENTS"`
+	RelayDebug          bool          `envconfig:"RELAY_DEBUG"`
 }
 
 //go:embed static/*
@@ -41,6 +79,7 @@ var (
 		With().Timestamp().Logger()
 	internal           *InternalDB
 	tailwindDebugStuff template.HTML
+	customFooterHTML   template.HTML
 )
 
 func main() {
@@ -54,10 +93,49 @@ func main() {
 		}
 	}
 
+	mediaHosts = s.MediaHosts
+	imageProxies = s.ImageProxies
+	trackingParams = s.TrackingParams
+
 	if len(s.TrustedPubKeys) == 0 {
 		s.TrustedPubKeys = defaultTrustedPubKeys
 	}
 
+	blocklist, err = newBlocklist(s.BlockedPubkeys, s.BlockedEvents)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid blocklist")
+		return
+	}
+
+	trustedProxies, err = parseTrustedProxies(s.TrustedProxies)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid trusted proxies")
+		return
+	}
+	if s.TrustProxyHeaders && len(trustedProxies) == 0 {
+		log.Warn().Msg("TRUST_PROXY_HEADERS is on but TRUSTED_PROXIES is empty, proxy headers will be ignored")
+	}
+
+	httpClient = newHTTPClient(s.HTTPTimeout)
+	setupRelayTransport()
+
+	// only the relays users give us are checked, the ones we are configured with can be anywhere
+	var guardRelayHost func(string) bool
+	if !s.AllowPrivateRelays {
+		guardRelayHost = overrideRelayHosts.has
+	}
+	if err := setupTorProxy(s.TorProxy, guardRelayHost); err != nil {
+		log.Fatal().Err(err).Str("proxy", s.TorProxy).Msg("invalid tor proxy")
+		return
+	}
+	// after the tor proxy, so connections to .onion relays are counted too
+	if s.MaxRelayConnections > 0 {
+		setupRelayConnectionLimit(s.MaxRelayConnections, s.RelayConnectionWait)
+	}
+
+	// has to exist before the store is set up, as it feeds it
+	search = newSearchIndex(s.SearchIndexSize)
+
 	// eventstore and nostr system
 	defer initSystem()()
 
@@ -80,6 +158,19 @@ func main() {
 		}
 	}
 
+	if s.FallbackImagesPath != "" {
+		configr, err := os.ReadFile(s.FallbackImagesPath)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("failed to load %q", s.FallbackImagesPath)
+			return
+		}
+		err = toml.Unmarshal(configr, &fallbackImages)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("failed to load %q", s.FallbackImagesPath)
+			return
+		}
+	}
+
 	// if we're in tailwind debug mode, initialize the runtime tailwind stuff
 	if s.TailwindDebug {
 		configb, err := os.ReadFile("tailwind.config.js")
@@ -105,6 +196,19 @@ func main() {
 		tailwindDebugStuff = template.HTML(fmt.Sprintf("<script src=\"https://cdn.tailwindcss.com?plugins=typography\"></script><script>\n%s</script><style type=\"text/tailwindcss\">%s</style>", config, style))
 	}
 
+	// operators can add their own stuff to the footer, but not their own scripts
+	if s.FooterHTML != "" {
+		customFooterHTML = template.HTML(sanitizeXSS(s.FooterHTML))
+	}
+	setInstanceNotice(s.Notice)
+
+	if s.KindTemplatesPath != "" {
+		if err := loadKindTemplates(s.KindTemplatesPath); err != nil {
+			log.Fatal().Err(err).Str("path", s.KindTemplatesPath).Msg("failed to load custom templates")
+			return
+		}
+	}
+
 	// image rendering stuff
 	initializeImageDrawingStuff()
 
@@ -115,6 +219,10 @@ func main() {
 		return
 	}
 
+	if s.ShortLinks {
+		shortLinks = newShortLinks(internalShortLinks{internal})
+	}
+
 	// initialize routines
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -124,7 +232,7 @@ func main() {
 
 	// expose our internal cache as a relay (mostly for debugging purposes)
 	relay := khatru.NewRelay()
-	relay.ServiceURL = "https://" + s.Domain
+	relay.ServiceURL = baseURL()
 	relay.QueryEvents = append(relay.QueryEvents, sys.Store.QueryEvents)
 	relay.DeleteEvent = append(relay.DeleteEvent, sys.Store.DeleteEvent)
 	relay.RejectEvent = append(relay.RejectEvent,
@@ -136,6 +244,13 @@ func main() {
 	// admin
 	setupRelayManagement(relay)
 
+	// per-ip rate limiting for the handlers that hit relays
+	var limiter *rateLimiter
+	if s.RateLimitPerMinute > 0 {
+		limiter = newRateLimiter(s.RateLimitPerMinute, s.RateLimitBurst, time.Now)
+		go limiter.pruneEvery(ctx, time.Minute)
+	}
+
 	// routes
 	mux := relay.Router()
 	mux.Handle("/njump/static/", http.StripPrefix("/njump/", http.FileServer(http.FS(static))))
@@ -143,10 +258,23 @@ func main() {
 	mux.HandleFunc("/relays-archive.xml", renderArchive)
 	mux.HandleFunc("/npubs-archive.xml", renderArchive)
 	mux.HandleFunc("/npubs-sitemaps.xml", renderSitemapIndex)
-	mux.HandleFunc("/services/oembed", renderOEmbed)
-	mux.HandleFunc("/njump/image/", renderImage)
-	mux.HandleFunc("/njump/proxy/", proxy)
+	mux.HandleFunc("/services/oembed", limiter.middleware(renderOEmbed))
+	mux.HandleFunc("/njump/image/", limiter.middleware(renderImage))
+	mux.HandleFunc("/njump/raw/{code}", limiter.middleware(renderRawEvent(getEvent)))
+	mux.HandleFunc("/njump/ics/{code}", limiter.middleware(renderICS))
+	mux.HandleFunc("/njump/favicon/{code}", limiter.middleware(renderProfileFavicon(fetchProfileForFavicon, fetchImageFromURL)))
+	mux.HandleFunc("/njump/proxy/", newImageProxy(s.ProxyMaxSize, isPublicIP, s.ProxyTranscode))
 	mux.HandleFunc("/robots.txt", renderRobots)
+	mux.HandleFunc("/healthz", renderHealthz)
+	mux.HandleFunc("/readyz", renderReadyz(
+		func() []string { return sys.FallbackRelays.URLs },
+		connectToRelay,
+		time.Second*5,
+		time.Second*10,
+	))
+	mux.HandleFunc("/n/{id}", renderShortLink)
+	mux.HandleFunc("/thread/{code}", limiter.middleware(renderThread))
+	mux.HandleFunc("/preview", limiter.middleware(renderPreview))
 	mux.HandleFunc("/r/", renderRelayPage)
 	mux.HandleFunc("/random", redirectToRandom)
 	mux.HandleFunc("/e/", redirectFromESlash)
@@ -154,7 +282,8 @@ func main() {
 	mux.HandleFunc("/favicon.ico", redirectToFavicon)
 	mux.HandleFunc("/embed/{code}", renderEmbedjs)
 	mux.HandleFunc("/about", renderAbout)
-	mux.HandleFunc("/{code}", renderEvent)
+	mux.HandleFunc("/search", limiter.middleware(renderSearch))
+	mux.HandleFunc("/{code}", limiter.middleware(renderEvent))
 	mux.HandleFunc("/{$}", renderHomepage)
 
 	corsH := cors.Default()
@@ -166,9 +295,17 @@ func main() {
 		ipBlock(
 			agentBlock(
 				loggingMiddleware(
-					queueMiddleware(
-						corsM(
-							relay.ServeHTTP,
+					canonicalPathMiddleware(
+						queueMiddleware(
+							headMiddleware(
+								timezoneMiddleware(
+									noticeMiddleware(
+										corsM(
+											relay.ServeHTTP,
+										),
+									),
+								),
+							),
 						),
 					),
 				),
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	stdhtml "html"
 	"io"
+	"math"
+	"regexp"
 	"strings"
+	"unicode"
 
 	"github.com/gomarkdown/markdown"
 	"github.com/gomarkdown/markdown/ast"
@@ -22,12 +26,46 @@ var mdrenderer = html.NewRenderer(html.RendererOptions{
 		case *ast.HTMLBlock:
 			w.Write([]byte(stdhtml.EscapeString(string(v.Literal))))
 			return ast.GoToNext, true
+		case *ast.Text:
+			if checked, rest, ok := taskListMarker(v); ok {
+				if checked {
+					w.Write([]byte(`<input type="checkbox" checked disabled> `))
+				} else {
+					w.Write([]byte(`<input type="checkbox" disabled> `))
+				}
+				html.EscapeHTML(w, rest)
+				return ast.GoToNext, true
+			}
 		}
 
 		return ast.GoToNext, false
 	},
 })
 
+// taskListMarker tells if this text starts a GFM task list item, like "- [ ] something" or "- [x] done",
+// returning the text after the marker
+func taskListMarker(text *ast.Text) (checked bool, rest []byte, ok bool) {
+	paragraph, isParagraph := text.Parent.(*ast.Paragraph)
+	if !isParagraph || ast.GetFirstChild(paragraph) != text {
+		return false, nil, false
+	}
+	if item, isItem := paragraph.Parent.(*ast.ListItem); !isItem || ast.GetFirstChild(item) != paragraph {
+		return false, nil, false
+	}
+
+	literal := text.Literal
+	if len(literal) < 4 || literal[0] != '[' || literal[2] != ']' || literal[3] != ' ' {
+		return false, nil, false
+	}
+	switch literal[1] {
+	case ' ':
+		return false, literal[4:], true
+	case 'x', 'X':
+		return true, literal[4:], true
+	}
+	return false, nil, false
+}
+
 var tgivmdrenderer = html.NewRenderer(html.RendererOptions{
 	Flags: html.CommonFlags | html.HrefTargetBlank,
 	RenderNodeHook: func(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
@@ -53,7 +91,7 @@ var tgivmdrenderer = html.NewRenderer(html.RendererOptions{
 	},
 })
 
-func mdToHTML(md string, usingTelegramInstantView bool) string {
+func mdToHTML(ctx context.Context, md string, usingTelegramInstantView bool) string {
 	md = strings.ReplaceAll(md, "\u00A0", " ")
 
 	// create markdown parser with extensions
@@ -65,7 +103,8 @@ func mdToHTML(md string, usingTelegramInstantView bool) string {
 			parser.Autolink |
 			parser.Footnotes |
 			parser.SpaceHeadings |
-			parser.Tables,
+			parser.Tables |
+			parser.Strikethrough,
 	).Parse([]byte(md))
 
 	renderer := mdrenderer
@@ -80,16 +119,64 @@ func mdToHTML(md string, usingTelegramInstantView bool) string {
 	output = sanitizeXSS(output)
 
 	// nostr urls
-	output = replaceNostrURLsWithHTMLTags(nostrEveryMatcher, output)
+	output = replaceNostrURLsWithHTMLTags(ctx, nostrEveryMatcher, output)
 
 	return output
 }
 
+var checkboxInputType = regexp.MustCompile(`^checkbox$`)
+
 func sanitizeXSS(html string) string {
 	p := bluemonday.UGCPolicy()
 	p.RequireNoFollowOnLinks(false)
 	p.AllowElements("video", "source")
 	p.AllowAttrs("controls", "width").OnElements("video")
 	p.AllowAttrs("src", "width").OnElements("source")
+	// only for the task list checkboxes
+	p.AllowAttrs("type").Matching(checkboxInputType).OnElements("input")
+	p.AllowAttrs("checked", "disabled").OnElements("input")
 	return p.Sanitize(html)
 }
+
+const defaultReadingWPM = 200
+
+// ReadingTime estimates how long an article takes to read at the configured words per minute.
+// CJK text has no spaces between words so there we count each character as a word, but as these
+// are read about twice as fast as whole words they only weigh half a word in the minutes
+func ReadingTime(md string) (words int, minutes int) {
+	text := md
+	if plain, err := markdownExtractor.PlainText(md); err == nil {
+		text = *plain
+	}
+
+	latin, cjk := 0, 0
+	inWord := false
+	for _, r := range text {
+		switch {
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana):
+			cjk++
+			inWord = false
+		case unicode.IsLetter(r), unicode.IsNumber(r):
+			if !inWord {
+				latin++
+				inWord = true
+			}
+		case inWord && (r == '\'' || r == '’' || r == '-'):
+			// still the same word
+		default:
+			inWord = false
+		}
+	}
+
+	wpm := s.ReadingWPM
+	if wpm <= 0 {
+		wpm = defaultReadingWPM
+	}
+
+	words = latin + cjk
+	if words == 0 {
+		return 0, 0
+	}
+	weighted := float64(latin) + float64(cjk)/2
+	return words, max(1, int(math.Ceil(weighted/float64(wpm))))
+}
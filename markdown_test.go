@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCustomFooter(t *testing.T) {
+	defer func(original template.HTML) { customFooterHTML = original }(customFooterHTML)
+	customFooterHTML = template.HTML(sanitizeXSS(`hosted by <a href="https://example.com">example</a>` +
+		`<script>alert("pwned")</script><img src="https://example.com/logo.png" onerror="alert(1)">`))
+
+	var buf bytes.Buffer
+	assert.NoError(t, errorTemplate(ErrorPageParams{Errors: "whatever"}).Render(context.Background(), &buf))
+	doc, err := goquery.NewDocumentFromReader(&buf)
+	assert.NoError(t, err)
+
+	footer := doc.Find("footer .custom-footer")
+	assert.Equal(t, 1, footer.Length())
+	assert.Contains(t, footer.Text(), "hosted by example")
+	assert.Equal(t, "https://example.com", footer.Find("a").AttrOr("href", ""))
+	assert.Equal(t, "https://example.com/logo.png", footer.Find("img").AttrOr("src", ""))
+	assert.Equal(t, 0, footer.Find("script").Length())
+	_, hasOnerror := footer.Find("img").Attr("onerror")
+	assert.False(t, hasOnerror)
+	assert.NotContains(t, footer.Text(), "pwned")
+}
+
+func TestReadingTime(t *testing.T) {
+	// 50 paragraphs of 9 words with some markdown around
+	english := "# Why nostr\n\n" + strings.Repeat("Nostr is **a simple**, open protocol that [isn't](https://nostr.com) owned.\n\n", 50)
+	words, minutes := ReadingTime(english)
+	assert.Equal(t, 452, words)
+	assert.Equal(t, 3, minutes)
+
+	// 30 lines of 32 characters, read at double speed
+	japanese := "## ノストルとは\n\n" + strings.Repeat("ノストルは誰にも所有されていない、シンプルで開かれたプロトコルです。\n", 30)
+	words, minutes = ReadingTime(japanese)
+	assert.Equal(t, 6+30*32, words)
+	assert.Equal(t, 3, minutes)
+
+	// a short post is still a minute
+	_, minutes = ReadingTime("gm")
+	assert.Equal(t, 1, minutes)
+	words, minutes = ReadingTime("")
+	assert.Equal(t, 0, words)
+	assert.Equal(t, 0, minutes)
+}
+
+func TestMarkdownExtensions(t *testing.T) {
+	md := "| pickle | days |\n|---|---|\n| cucumber | 7 |\n\n" +
+		"- [ ] buy jars\n- [x] find a recipe <script>alert(1)</script>\n- plain [ ] item\n\n" +
+		"the ~~sugar~~ salt goes in first"
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(mdToHTML(context.Background(), md, false)))
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, doc.Find("table").Length())
+	assert.Equal(t, []string{"pickle", "days"}, doc.Find("table th").Map(func(_ int, s *goquery.Selection) string { return s.Text() }))
+	assert.Equal(t, "cucumber", doc.Find("table td").First().Text())
+
+	checkboxes := doc.Find(`li input[type="checkbox"]`)
+	assert.Equal(t, 2, checkboxes.Length())
+	_, firstChecked := checkboxes.Eq(0).Attr("checked")
+	_, secondChecked := checkboxes.Eq(1).Attr("checked")
+	assert.False(t, firstChecked)
+	assert.True(t, secondChecked)
+	assert.Equal(t, " buy jars", doc.Find("li").Eq(0).Text())
+	assert.Equal(t, "plain [ ] item", doc.Find("li").Eq(2).Text())
+	assert.Equal(t, 0, doc.Find("script").Length())
+
+	assert.Equal(t, "sugar", doc.Find("del").Text())
+}
@@ -62,7 +62,7 @@ func checkMediaAlert(ctx context.Context, mediaURL string, retry bool) (bool, er
 		return false, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return false, fmt.Errorf("request failed: %w", err)
 	}
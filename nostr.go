@@ -9,6 +9,7 @@ import (
 
 	"github.com/fiatjaf/eventstore/badger"
 	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
 	"github.com/nbd-wtf/go-nostr/sdk"
 	badger_kv "github.com/nbd-wtf/go-nostr/sdk/kvstore/badger"
 )
@@ -58,18 +59,54 @@ func initSystem() func() {
 		panic(err)
 	}
 
+	go loadSearchIndex(context.Background(), db, search)
+
 	sys = sdk.NewSystem(
-		sdk.WithStore(db),
+		sdk.WithStore(indexedStore{Store: db, index: search}),
 		sdk.WithKVStore(kv),
 	)
+	mentionResolver = profileResolver{cache: sys.MetadataCache, fetch: fetchProfilesBatch}
+	threads = threadResolver{fetchEvent: fetchThreadEvent, fetchReplies: fetchThreadReplies}
 
 	return db.Close
 }
 
+type localOnlyKey struct{}
+
+// withLocalOnly marks a context in which nothing is to be fetched from relays, only what we already have is used
+func withLocalOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, localOnlyKey{}, true)
+}
+
+func localOnly(ctx context.Context) bool {
+	only, _ := ctx.Value(localOnlyKey{}).(bool)
+	return only
+}
+
+func fetchEnhancedEvent(ctx context.Context, code string) (EnhancedEvent, error) {
+	evt, _, err := getEvent(ctx, code, false)
+	if err != nil {
+		return EnhancedEvent{}, err
+	}
+	return NewEnhancedEvent(ctx, evt), nil
+}
+
 func getEvent(ctx context.Context, code string, withRelays bool) (*nostr.Event, []string, error) {
+	if localOnly(ctx) {
+		evt, err := getLocalEvent(ctx, code)
+		if err != nil {
+			return nil, nil, err
+		}
+		return evt, nil, nil
+	}
+
+	start := time.Now()
 	evt, relays, err := sys.FetchSpecificEventFromInput(ctx, code, sdk.FetchSpecificEventParameters{
 		WithRelays: withRelays,
 	})
+	if rec := getFetchRecord(ctx); rec != nil {
+		rec.fromStore, rec.relays, rec.took = err == nil && relays == nil, relays, time.Since(start)
+	}
 	if err != nil {
 		return nil, nil, fmt.Errorf("couldn't find this event, did you include accurate relay or author hints in it?")
 	}
@@ -88,6 +125,40 @@ func getEvent(ctx context.Context, code string, withRelays bool) (*nostr.Event,
 	return evt, allRelays, nil
 }
 
+// getLocalEvent is like getEvent but only looks in our store
+func getLocalEvent(ctx context.Context, code string) (*nostr.Event, error) {
+	var filter nostr.Filter
+	if nostr.IsValid32ByteHex(code) {
+		filter.IDs = []string{code}
+	} else {
+		prefix, decoded, err := parseNostrCode(code)
+		if err != nil {
+			return nil, err
+		}
+		switch v := decoded.(type) {
+		case nostr.EventPointer:
+			filter.IDs = []string{v.ID}
+		case nostr.EntityPointer:
+			filter.Kinds = []int{v.Kind}
+			filter.Authors = []string{v.PublicKey}
+			filter.Tags = nostr.TagMap{"d": []string{v.Identifier}}
+		case string:
+			if prefix != "note" {
+				return nil, fmt.Errorf("'%s' is not an event code", code)
+			}
+			filter.IDs = []string{v}
+		default:
+			return nil, fmt.Errorf("'%s' is not an event code", code)
+		}
+	}
+
+	res, _ := sys.StoreRelay.QuerySync(ctx, filter)
+	if len(res) == 0 {
+		return nil, fmt.Errorf("we don't have this event")
+	}
+	return res[0], nil
+}
+
 func authorLastNotes(ctx context.Context, pubkey string) (lastNotes []EnhancedEvent, justFetched bool) {
 	limit := 100
 
@@ -155,6 +226,64 @@ func authorLastNotes(ctx context.Context, pubkey string) (lastNotes []EnhancedEv
 	return lastNotes, justFetched
 }
 
+// goalZapRefresh keeps the background fetches for zap goal receipts to one per goal every so often
+var goalZapRefresh = newRelayRefresher(time.Minute*5, time.Second*10, 8)
+
+// fetchGoalZaps reads the zap receipts for a zap goal we have locally and asks the given relays, which must
+// have been sanitized already, or the relays we have seen the goal on, for more in the background
+func fetchGoalZaps(ctx context.Context, goalID string, relays []string) []*nostr.Event {
+	filter := nostr.Filter{
+		Kinds: []int{nostr.KindZap},
+		Tags:  nostr.TagMap{"e": []string{goalID}},
+		Limit: 1000,
+	}
+
+	receipts, _ := sys.StoreRelay.QuerySync(ctx, filter)
+	if localOnly(ctx) {
+		return receipts
+	}
+
+	if len(relays) == 0 {
+		relays = internal.getRelaysForEvent(goalID)
+	}
+	if len(relays) > 0 {
+		goalZapRefresh.refresh(goalID, func(ctx context.Context) {
+			for ie := range sys.Pool.FetchMany(ctx, relays, filter, nostr.WithLabel("goalzaps")) {
+				sys.Store.SaveEvent(ctx, ie.Event)
+			}
+		})
+	}
+
+	return receipts
+}
+
+// badgeRefresh keeps the background fetches for profile badges to one per pubkey every so often
+var badgeRefresh = newRelayRefresher(time.Minute*30, time.Second*10, 8)
+
+// fetchProfileBadges gets the badges a pubkey has chosen to show, from their kind 30008 list, with what we
+// have locally, the list and the definitions in it are fetched in the background for the next visits
+func fetchProfileBadges(ctx context.Context, pubkey string) []BadgeDefinition {
+	code, _ := nip19.EncodeEntity(pubkey, 30008, "profile_badges", nil)
+
+	badgeRefresh.refresh(pubkey, func(ctx context.Context) {
+		evt, _, err := getEvent(ctx, code, false)
+		if err != nil {
+			return
+		}
+		accepted := parseKind30008Metadata(*evt).Accepted
+		for _, badge := range accepted[:min(len(accepted), maxProfileBadges)] {
+			getEvent(ctx, badge.Definition, false)
+		}
+	})
+
+	ctx = withLocalOnly(ctx)
+	evt, _, err := getEvent(ctx, code, false)
+	if err != nil {
+		return nil
+	}
+	return resolveProfileBadges(ctx, parseKind30008Metadata(*evt).Accepted, fetchEnhancedEvent)
+}
+
 func relayLastNotes(ctx context.Context, hostname string, limit int) iter.Seq[*nostr.Event] {
 	ctx, cancel := context.WithTimeout(ctx, time.Second*4)
 
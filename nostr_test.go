@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchProfileBadgesFromStore(t *testing.T) {
+	// no room for background refreshes, tests have no relays to ask
+	defer func(original *relayRefresher) { badgeRefresh = original }(badgeRefresh)
+	badgeRefresh = newRelayRefresher(time.Hour, time.Second, 0)
+
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	save := func(evt *nostr.Event) *nostr.Event {
+		assert.NoError(t, evt.Sign(sk))
+		assert.NoError(t, sys.Store.SaveEvent(context.Background(), evt))
+		return evt
+	}
+
+	assert.Empty(t, fetchProfileBadges(context.Background(), pk))
+
+	save(&nostr.Event{Kind: 30009, CreatedAt: 1000, Tags: nostr.Tags{{"d", "bravery"}, {"name", "Medal of bravery"}}})
+	award := save(&nostr.Event{Kind: 8, CreatedAt: 1000, Tags: nostr.Tags{{"a", "30009:" + pk + ":bravery"}, {"p", pk}}})
+	save(&nostr.Event{Kind: 30008, CreatedAt: 1000, Tags: nostr.Tags{
+		{"d", "profile_badges"},
+		{"a", "30009:" + pk + ":bravery"}, {"e", award.ID},
+		{"a", "30009:" + pk + ":missing"}, {"e", award.ID},
+	}})
+
+	badges := fetchProfileBadges(context.Background(), pk)
+	assert.Len(t, badges, 1)
+	assert.Equal(t, "Medal of bravery", badges[0].Name)
+}
+
+func TestFetchGoalZapsFromStore(t *testing.T) {
+	defer func(original *relayRefresher) { goalZapRefresh = original }(goalZapRefresh)
+	goalZapRefresh = newRelayRefresher(time.Hour, time.Second, 0)
+
+	goalID := nostr.GeneratePrivateKey() // any 32-byte hex will do
+	receipt := &nostr.Event{Kind: nostr.KindZap, CreatedAt: 1000, Tags: nostr.Tags{{"e", goalID}}}
+	assert.NoError(t, receipt.Sign(nostr.GeneratePrivateKey()))
+	assert.NoError(t, sys.Store.SaveEvent(context.Background(), receipt))
+
+	// the relays are only asked in the background, what we get is what we already had
+	receipts := fetchGoalZaps(context.Background(), goalID, []string{"wss://relay.example.com"})
+	assert.Len(t, receipts, 1)
+	assert.Equal(t, receipt.ID, receipts[0].ID)
+}
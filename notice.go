@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"net/http"
+)
+
+const noticeCookie = "notice_dismissed"
+
+// instanceNotice is the operator's banner shown at the top of every page, noticeID changes with its text
+// so a dismissed notice comes back when there is a new one
+var (
+	instanceNotice template.HTML
+	noticeID       string
+)
+
+func setInstanceNotice(notice string) {
+	instanceNotice = template.HTML(sanitizeXSS(notice))
+	noticeID = ""
+	if instanceNotice != "" {
+		hash := sha256.Sum256([]byte(instanceNotice))
+		noticeID = hex.EncodeToString(hash[:4])
+	}
+}
+
+type noticeDismissedKey struct{}
+
+// noticeMiddleware marks the request when the viewer has dismissed the current notice
+func noticeMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if instanceNotice != "" && s.NoticeDismissible {
+			if cookie, err := r.Cookie(noticeCookie); err == nil {
+				// the same url renders differently depending on the cookie
+				w.Header().Add("Vary", "Cookie")
+				if cookie.Value == noticeID {
+					r = r.WithContext(context.WithValue(r.Context(), noticeDismissedKey{}, true))
+				}
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+func showNotice(ctx context.Context) bool {
+	dismissed, _ := ctx.Value(noticeDismissedKey{}).(bool)
+	return instanceNotice != "" && !dismissed
+}
+
+// noticeDismissScript is the hyperscript for the dismiss button, it remembers the notice for a month
+func noticeDismissScript() string {
+	return "on click set document.cookie to '" + noticeCookie + "=" + noticeID +
+		"; path=/; max-age=2592000; samesite=lax' then remove closest .instance-notice"
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstanceNotice(t *testing.T) {
+	defer func(notice string, dismissible bool) {
+		setInstanceNotice(notice)
+		s.NoticeDismissible = dismissible
+	}(string(instanceNotice), s.NoticeDismissible)
+
+	about := func(cookies ...*http.Cookie) (*goquery.Document, *httptest.ResponseRecorder) {
+		r := httptest.NewRequest("GET", "/about", nil)
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		noticeMiddleware(renderAbout)(w, r)
+		doc, err := goquery.NewDocumentFromReader(w.Body)
+		assert.NoError(t, err)
+		return doc, w
+	}
+
+	setInstanceNotice("")
+	doc, _ := about()
+	assert.Equal(t, 0, doc.Find(".instance-notice").Length())
+
+	setInstanceNotice(`maintenance tonight, <a href="https://status.example.com">status</a><script>alert("pwned")</script>`)
+	s.NoticeDismissible = false
+	doc, _ = about()
+	notice := doc.Find(".instance-notice")
+	assert.Equal(t, 1, notice.Length())
+	assert.Contains(t, notice.Text(), "maintenance tonight")
+	assert.Equal(t, "https://status.example.com", notice.Find("a").AttrOr("href", ""))
+	assert.Equal(t, 0, notice.Find("script").Length())
+	assert.NotContains(t, notice.Text(), "pwned")
+	assert.Equal(t, 0, notice.Find(".notice-dismiss").Length())
+
+	// dismissing only hides the notice it was done for
+	s.NoticeDismissible = true
+	doc, _ = about()
+	assert.Contains(t, doc.Find(".notice-dismiss").AttrOr("_", ""), noticeCookie+"="+noticeID)
+
+	doc, w := about(&http.Cookie{Name: noticeCookie, Value: noticeID})
+	assert.Equal(t, 0, doc.Find(".instance-notice").Length())
+	assert.Equal(t, "Cookie", w.Header().Get("Vary"))
+
+	setInstanceNotice("donation drive this week")
+	doc, _ = about(&http.Cookie{Name: noticeCookie, Value: "somethingelse"})
+	assert.Contains(t, doc.Find(".instance-notice").Text(), "donation drive this week")
+}
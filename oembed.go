@@ -51,7 +51,7 @@ func renderOEmbed(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	host := r.Header.Get("X-Forwarded-Host")
+	base := requestBaseURL(r)
 
 	data, err := grabData(ctx, code, false)
 	if err != nil {
@@ -61,13 +61,19 @@ func renderOEmbed(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if blocklist.blocks(data.event.Event) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		http.Error(w, "unavailable", http.StatusUnavailableForLegalReasons)
+		return
+	}
+
 	res := OEmbedResponse{
 		Version:      "1.0",
 		ProviderName: "njump",
-		ProviderURL:  "https://" + host,
+		ProviderURL:  base,
 		Title:        data.event.author.Name + " wrote",
 		AuthorName:   data.event.authorLong(),
-		AuthorURL:    fmt.Sprintf("https://%s/%s", host, data.event.Npub()),
+		AuthorURL:    base + "/" + data.event.Npub(),
 	}
 
 	switch {
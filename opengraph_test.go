@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"net/http/httptest"
@@ -9,12 +11,17 @@ import (
 	"testing"
 
 	"github.com/PuerkitoBio/goquery"
+	"github.com/fiatjaf/eventstore"
+	"github.com/fiatjaf/eventstore/slicestore"
+	"github.com/nbd-wtf/go-nostr/sdk"
 	"github.com/stretchr/testify/assert"
 )
 
 type OpengraphFields struct {
 	TwitterCard        string
 	TwitterTitle       string
+	TwitterPlayer      string
+	TwitterImage       string
 	Superscript        string
 	Subscript          string
 	Image              string
@@ -25,7 +32,21 @@ type OpengraphFields struct {
 }
 
 func TestMain(m *testing.M) {
+	// tests only get a local store and an internal db, anything that tries to reach the relays blows up
+	dir, err := os.MkdirTemp("", "njump-test-")
+	if err != nil {
+		panic(err)
+	}
+	internal, err = NewInternalDB(dir)
+	if err != nil {
+		panic(err)
+	}
+	store := &slicestore.SliceStore{}
+	store.Init()
+	sys = &sdk.System{Store: store, StoreRelay: eventstore.RelayWrapper{Store: store}}
+
 	code := m.Run()
+	os.RemoveAll(dir)
 	os.Exit(code)
 }
 
@@ -63,6 +84,91 @@ func TestNoteAsTelegramInstantView(t *testing.T) {
 	assert.Equal(t, og.TelegramAndroidApp, "Medium", "")
 }
 
+func TestNoteWithVideoFirst(t *testing.T) {
+	og := renderOpenGraph(t, OpenGraphParams{
+		Video:        "https://example.com/clip.mp4",
+		VideoType:    "mp4",
+		VideoFirst:   true,
+		Image:        "https://example.com/thumb.jpg",
+		ProxiedImage: "https://njump.me/njump/proxy?src=https://example.com/thumb.jpg",
+		Text:         "look at this",
+	})
+
+	// twitter can't play a bare video file, so it gets a big thumbnail instead of a player
+	assert.Equal(t, "summary_large_image", og.TwitterCard)
+	assert.Equal(t, "", og.TwitterPlayer)
+	assert.Equal(t, "https://njump.me/njump/proxy?src=https://example.com/thumb.jpg", og.TwitterImage)
+	assert.Equal(t, "https://example.com/clip.mp4", og.Video)
+	assert.Equal(t, "video/mp4", og.VideoFullType)
+
+	// and without anything to show as the thumbnail the card stays small
+	og = renderOpenGraph(t, OpenGraphParams{
+		Video:      "https://example.com/clip.mp4",
+		VideoType:  "mp4",
+		VideoFirst: true,
+		Text:       "look at this",
+	})
+	assert.Equal(t, "summary", og.TwitterCard)
+	assert.Equal(t, "", og.TwitterPlayer)
+	assert.Equal(t, "https://example.com/clip.mp4", og.Video)
+}
+
+func TestNoteWithOnlyImage(t *testing.T) {
+	og := renderOpenGraph(t, OpenGraphParams{
+		Image:        "https://example.com/pic.jpg",
+		ProxiedImage: "https://njump.me/njump/proxy?src=https://example.com/pic.jpg",
+		Text:         "look at this",
+	})
+
+	assert.Equal(t, "summary", og.TwitterCard)
+	assert.Equal(t, "https://example.com/pic.jpg", og.Image)
+	assert.Equal(t, "", og.TwitterPlayer)
+	assert.Equal(t, "", og.Video)
+}
+
+func TestFallbackImagesPerKind(t *testing.T) {
+	fallbackImages = FallbackImages{
+		Default: "https://example.com/nostr.png",
+		Kinds: map[string]string{
+			"1":     "https://example.com/note.png",
+			"30023": "https://example.com/article.png",
+		},
+	}
+	defer func() { fallbackImages = FallbackImages{} }()
+
+	assert.Equal(t, "https://example.com/note.png", fallbackImages.forKind(1))
+	assert.Equal(t, "https://example.com/article.png", fallbackImages.forKind(30023))
+	assert.Equal(t, "https://example.com/nostr.png", fallbackImages.forKind(9802))
+
+	// an article without a banner
+	og := renderOpenGraph(t, OpenGraphParams{FallbackImage: fallbackImages.forKind(30023), Text: "an article"})
+	assert.Equal(t, "https://example.com/article.png", og.Image)
+
+	// a note without images
+	og = renderOpenGraph(t, OpenGraphParams{FallbackImage: fallbackImages.forKind(1), Text: "gm"})
+	assert.Equal(t, "https://example.com/note.png", og.Image)
+
+	// a note with an image doesn't use the fallback
+	og = renderOpenGraph(t, OpenGraphParams{
+		Image:         "https://example.com/pic.jpg",
+		FallbackImage: fallbackImages.forKind(1),
+		Text:          "gm",
+	})
+	assert.Equal(t, "https://example.com/pic.jpg", og.Image)
+}
+
+func renderOpenGraph(t *testing.T, params OpenGraphParams) *OpengraphFields {
+	buf := &bytes.Buffer{}
+	if err := openGraphTemplate(params).Render(context.Background(), buf); err != nil {
+		t.Fatal(err)
+	}
+
+	og := &OpengraphFields{}
+	parseHead(buf, og)
+
+	return og
+}
+
 func makeRequest(t *testing.T, path string, ua string) *OpengraphFields {
 	r := httptest.NewRequest("GET", path, nil)
 	r.Header.Set("user-agent", ua)
@@ -92,6 +198,12 @@ func parseHead(resp io.Reader, og *OpengraphFields) error {
 	doc.Find(`meta[name="twitter:title"]`).Each(func(_ int, s *goquery.Selection) {
 		og.TwitterTitle, _ = s.Attr("content")
 	})
+	doc.Find(`meta[name="twitter:player"]`).Each(func(_ int, s *goquery.Selection) {
+		og.TwitterPlayer, _ = s.Attr("content")
+	})
+	doc.Find(`meta[name="twitter:image"]`).Each(func(_ int, s *goquery.Selection) {
+		og.TwitterImage, _ = s.Attr("content")
+	})
 	doc.Find(`meta[property="og:site_name"]`).Each(func(_ int, s *goquery.Selection) {
 		og.Superscript, _ = s.Attr("content")
 	})
@@ -116,3 +228,34 @@ func parseHead(resp io.Reader, og *OpengraphFields) error {
 
 	return nil
 }
+
+func TestPreviewStyleFromUserAgent(t *testing.T) {
+	for ua, expected := range map[string]Style{
+		"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)": StyleFacebook,
+		"facebookcatalog/1.0": StyleFacebook,
+		"LinkedInBot/1.0 (compatible; Mozilla/5.0; Apache-HttpClient +http://www.linkedin.com)": StyleLinkedIn,
+		"TelegramBot (like TwitterBot)": StyleTelegram,
+		"Twitterbot/1.0":                StyleTwitter,
+		"curl/8.0.1":                    StyleUnknown,
+	} {
+		r := httptest.NewRequest("GET", "/note1xyz", nil)
+		r.Header.Set("User-Agent", ua)
+		assert.Equal(t, expected, getPreviewStyle(r), ua)
+	}
+}
+
+func TestPreviewStyleOverride(t *testing.T) {
+	style := func(target string, ua string) Style {
+		r := httptest.NewRequest("GET", target, nil)
+		r.Header.Set("User-Agent", ua)
+		return getPreviewStyle(r)
+	}
+
+	assert.Equal(t, Style(StyleDiscord), style("/note1xyz?s=discord", "TelegramBot (like TwitterBot)"))
+	assert.Equal(t, Style(StyleTelegram), style("/note1xyz?s=Telegram", "curl/8.0.1"))
+
+	// anything else is as if it wasn't there
+	assert.Equal(t, StyleTelegram, style("/note1xyz?s=myspace", "TelegramBot (like TwitterBot)"))
+	assert.Equal(t, Style(StyleUnknown), style("/note1xyz?s=unknown", "curl/8.0.1"))
+	assert.Equal(t, Style(StyleUnknown), style("/note1xyz?s=", "curl/8.0.1"))
+}
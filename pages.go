@@ -6,7 +6,11 @@ package main
 
 import (
 	_ "embed"
+	"fmt"
 	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/a-h/templ"
 	"github.com/nbd-wtf/go-nostr/sdk"
@@ -25,6 +29,14 @@ const (
 	CalendarEvent
 	WikiEvent
 	Highlight
+	Classified
+	Report
+	Badge
+	Comment
+	Encrypted
+	ZapGoal
+	Git
+	RelayList
 	Other
 )
 
@@ -38,17 +50,41 @@ type OpenGraphParams struct {
 	// x (we will always render just the bigimage if we have that)
 	Video        string
 	VideoType    string
+	VideoFirst   bool // when the video is the first media the card is a big one with its thumbnail
 	Image        string
 	ProxiedImage string
 
+	// used when there is no image at all
+	FallbackImage string
+
 	// this is the main text we should always have
 	Text string
+
+	// the canonical address of the page
+	URL string
+}
+
+// FallbackImages are the card images we use for events that don't have any
+type FallbackImages struct {
+	Default string            `toml:"default"`
+	Kinds   map[string]string `toml:"kinds"`
+}
+
+var fallbackImages FallbackImages
+
+func (fi FallbackImages) forKind(kind int) string {
+	if image, ok := fi.Kinds[strconv.Itoa(kind)]; ok {
+		return image
+	}
+	return fi.Default
 }
 
 type DetailsParams struct {
 	HideDetails     bool
 	CreatedAt       string
 	EventJSON       template.HTML
+	RawEventCode    string
+	ShortLink       string
 	Metadata        sdk.ProfileMetadata
 	Nevent          string
 	Nprofile        string
@@ -56,6 +92,8 @@ type DetailsParams struct {
 	Kind            int
 	KindNIP         string
 	KindDescription string
+	RelayFetch      *fetchRecord
+	RelayProbes     []RelayProbe
 	Extra           templ.Component
 }
 
@@ -63,9 +101,86 @@ type HeadParams struct {
 	IsHome      bool
 	IsAbout     bool
 	IsProfile   bool
+	NoIndex     bool
 	NaddrNaked  string
 	NeventNaked string
+	Npub        string
 	Oembed      string
+	JSONLD      string
+	Alternates  []AlternateLink
+}
+
+// CanonicalURL is the address of this page in its bech32 form, however it was reached
+func (hp HeadParams) CanonicalURL() string {
+	switch {
+	case hp.NaddrNaked != "":
+		return canonicalURL(hp.NaddrNaked)
+	case hp.NeventNaked != "":
+		return canonicalURL(hp.NeventNaked)
+	case hp.Npub != "":
+		return canonicalURL(hp.Npub)
+	default:
+		return ""
+	}
+}
+
+func canonicalURL(code string) string {
+	return baseURL() + "/" + code
+}
+
+// baseURL is where absolute links to this instance point, BASE_URL if it's set, otherwise https://DOMAIN,
+// so all the hostnames we may be reached on end up linking to the same pages
+func baseURL() string {
+	if s.BaseURL != "" {
+		return strings.TrimSuffix(s.BaseURL, "/")
+	}
+	if s.Domain == "" {
+		return "https://njump.me"
+	}
+	return "https://" + s.Domain
+}
+
+// requestBaseURL is like baseURL, but when no BASE_URL is configured it falls back to the host being
+// requested instead of DOMAIN, for links that must be reachable from wherever the page was loaded
+func requestBaseURL(r *http.Request) string {
+	if s.BaseURL != "" {
+		return strings.TrimSuffix(s.BaseURL, "/")
+	}
+	host := r.Header.Get("X-Forwarded-Host")
+	if host == "" {
+		host = r.Host
+	}
+	return "https://" + host
+}
+
+// AlternateLink is another representation of the same page, advertised both in the
+// <head> and in the Link header
+type AlternateLink struct {
+	Type  string
+	Title string
+	Href  string
+}
+
+func (al AlternateLink) header() string {
+	return fmt.Sprintf(`<%s>; rel="alternate"; type="%s"; title="%s"`, al.Href, al.Type, al.Title)
+}
+
+func eventAlternateLinks(neventNaked string) []AlternateLink {
+	return []AlternateLink{
+		{Type: "application/json", Title: "Event JSON", Href: "/njump/raw/" + neventNaked},
+	}
+}
+
+func profileAlternateLinks(npub string) []AlternateLink {
+	return []AlternateLink{
+		{Type: "application/atom+xml", Title: "RSS", Href: "/" + npub + ".rss"},
+	}
+}
+
+func setAlternateLinkHeaders(h http.Header, links []AlternateLink) {
+	for _, link := range links {
+		h.Add("Link", link.header())
+	}
 }
 
 type BaseEventPageParams struct {
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlternateLinks(t *testing.T) {
+	nevent, _ := nip19.EncodeEvent(strings.Repeat("a", 64), nil, testPubkey1)
+	note := NotePageParams{
+		BaseEventPageParams: BaseEventPageParams{Event: testEnhancedEvent(&nostr.Event{Kind: 1, Content: "hello"})},
+		HeadParams:          HeadParams{NeventNaked: nevent, Alternates: eventAlternateLinks(nevent)},
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, noteTemplate(note, false).Render(context.Background(), &buf))
+	doc, err := goquery.NewDocumentFromReader(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "/njump/raw/"+nevent, doc.Find(`head link[rel="alternate"][type="application/json"]`).AttrOr("href", ""))
+
+	w := httptest.NewRecorder()
+	setAlternateLinkHeaders(w.Header(), eventAlternateLinks(nevent))
+	assert.Equal(t, []string{`</njump/raw/` + nevent + `>; rel="alternate"; type="application/json"; title="Event JSON"`}, w.Header().Values("Link"))
+
+	npub, _ := nip19.EncodePublicKey(testPubkey1)
+	buf.Reset()
+	assert.NoError(t, headCommonTemplate(HeadParams{IsProfile: true, Alternates: profileAlternateLinks(npub)}).Render(context.Background(), &buf))
+	doc, err = goquery.NewDocumentFromReader(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "/"+npub+".rss", doc.Find(`link[rel="alternate"][type="application/atom+xml"]`).AttrOr("href", ""))
+
+	w = httptest.NewRecorder()
+	setAlternateLinkHeaders(w.Header(), profileAlternateLinks(npub))
+	assert.Contains(t, w.Header().Get("Link"), `</`+npub+`.rss>; rel="alternate"; type="application/atom+xml"`)
+}
+
+func TestCanonicalURL(t *testing.T) {
+	note := nostr.Event{Kind: 1, CreatedAt: 1710000000, Content: "gm"}
+	article := nostr.Event{Kind: 30023, CreatedAt: 1710000000, Content: "# hi", Tags: nostr.Tags{{"d", "hello"}}}
+	sk := nostr.GeneratePrivateKey()
+	assert.NoError(t, note.Sign(sk))
+	assert.NoError(t, article.Sign(sk))
+	nevent, _ := nip19.EncodeEvent(note.ID, nil, note.PubKey)
+	naddr, _ := nip19.EncodeEntity(article.PubKey, 30023, "hello", nil)
+
+	for evt, expected := range map[*nostr.Event]string{&note: nevent, &article: naddr} {
+		// whatever the path, the page says where it really lives
+		for _, path := range []string{"/preview", "/preview?relays=wss://nos.lol&tgiv=false"} {
+			w := testPreview(t, path, *evt)
+			doc, err := goquery.NewDocumentFromReader(w.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, "https://njump.me/"+expected, doc.Find(`link[rel="canonical"]`).AttrOr("href", ""))
+			assert.Equal(t, "https://njump.me/"+expected, doc.Find(`meta[property="og:url"]`).AttrOr("content", ""))
+			assert.Equal(t, 1, doc.Find(`link[rel="canonical"]`).Length())
+		}
+	}
+
+	npub, _ := nip19.EncodePublicKey(testPubkey2)
+	assert.Equal(t, "https://njump.me/"+npub, HeadParams{IsProfile: true, Npub: npub}.CanonicalURL())
+	assert.Equal(t, "", HeadParams{IsHome: true}.CanonicalURL())
+}
+
+func TestConfiguredBaseURL(t *testing.T) {
+	previous := s.BaseURL
+	s.BaseURL = "https://nostr.example.com/"
+	defer func() { s.BaseURL = previous }()
+
+	note := nostr.Event{Kind: 1, CreatedAt: 1710000000, Content: "gm"}
+	assert.NoError(t, note.Sign(nostr.GeneratePrivateKey()))
+	nevent, _ := nip19.EncodeEvent(note.ID, nil, note.PubKey)
+	body, _ := json.Marshal(note)
+
+	r := httptest.NewRequest("POST", "/preview", bytes.NewReader(body))
+	r.Host = "mirror.example.org"
+	r.Header.Set("X-Forwarded-Host", "other.example.net")
+	w := httptest.NewRecorder()
+	renderPreview(w, r)
+	doc, err := goquery.NewDocumentFromReader(w.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://nostr.example.com/"+nevent, doc.Find(`meta[property="og:url"]`).AttrOr("content", ""))
+	assert.Equal(t, "https://nostr.example.com/"+nevent, doc.Find(`link[rel="canonical"]`).AttrOr("href", ""))
+	assert.Contains(t, strings.Join(w.Header().Values("Link"), "\n"), "<https://nostr.example.com/services/oembed?")
+
+	r = httptest.NewRequest("GET", "/relays-archive.xml", nil)
+	r.Host = "mirror.example.org"
+	w = httptest.NewRecorder()
+	renderArchive(w, r)
+	sitemap := w.Body.String()
+	assert.Contains(t, sitemap, "<loc>https://nostr.example.com/nostr.wine</loc>")
+	assert.NotContains(t, sitemap, "mirror.example.org")
+	assert.NotContains(t, sitemap, "njump.me")
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/sdk"
+)
+
+const maxPreviewSize = 1 << 19
+
+// renderPreview renders an event POSTed as JSON the same way we would render it if we had fetched it,
+// the event is not fetched from or stored anywhere. a missing id is computed, a wrong id or signature
+// is rejected, otherwise anyone could put words in anybody's mouth on our domain
+func renderPreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "POST an event JSON here to preview it", http.StatusMethodNotAllowed)
+		return
+	}
+
+	evt := &nostr.Event{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPreviewSize)).Decode(evt); err != nil {
+		http.Error(w, "invalid event JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !nostr.IsValidPublicKey(evt.PubKey) {
+		http.Error(w, "invalid pubkey", http.StatusBadRequest)
+		return
+	}
+	if evt.Kind < 0 || evt.Kind > 65535 {
+		http.Error(w, "invalid kind", http.StatusBadRequest)
+		return
+	}
+	if evt.ID == "" {
+		evt.ID = evt.GetID()
+	} else if !evt.CheckID() {
+		http.Error(w, "id doesn't match the event", http.StatusBadRequest)
+		return
+	}
+	if ok, err := evt.CheckSignature(); !ok {
+		msg := "invalid signature"
+		if err != nil {
+			msg += ": " + err.Error()
+		}
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	// nothing the event refers to is fetched, we only use what we already have
+	r = r.WithContext(withLocalOnly(r.Context()))
+	ee := enhanceEvent(evt, previewAuthor(evt))
+	if renderIfNotAllowed(r.Context(), w, ee) {
+		return
+	}
+
+	data := prepareData(r.Context(), ee, false)
+	renderEventData(w, r, data.nevent, nil, data, r.URL.Query().Get("embed") != "")
+}
+
+// previewAuthor only uses profiles we already have in memory, as previews shouldn't wait on relays
+func previewAuthor(evt *nostr.Event) sdk.ProfileMetadata {
+	if evt.Kind == 0 {
+		return parseProfileMetadata(evt)
+	}
+	if mentionResolver.cache != nil {
+		if pm, ok := mentionResolver.cache.Get(evt.PubKey); ok {
+			return pm
+		}
+	}
+	return sdk.ProfileMetadata{PubKey: evt.PubKey}
+}
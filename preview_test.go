@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/sdk"
+	cache_memory "github.com/nbd-wtf/go-nostr/sdk/cache/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreviewPastedEvent(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	evt := nostr.Event{
+		Kind:      1,
+		CreatedAt: 1710000000,
+		Tags:      nostr.Tags{},
+		Content:   "testing how this looks before publishing",
+	}
+	assert.NoError(t, evt.Sign(sk))
+	body, _ := json.Marshal(evt)
+
+	r := httptest.NewRequest("POST", "/preview", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	renderPreview(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+	assert.Contains(t, w.Body.String(), "testing how this looks before publishing")
+
+	// a tampered or unsigned event isn't rendered at all
+	evt.Content = "something else"
+	evt.ID = evt.GetID()
+	body, _ = json.Marshal(evt)
+	w = httptest.NewRecorder()
+	renderPreview(w, httptest.NewRequest("POST", "/preview", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NotContains(t, w.Body.String(), "something else")
+
+	evt.Sig = ""
+	body, _ = json.Marshal(evt)
+	w = httptest.NewRecorder()
+	renderPreview(w, httptest.NewRequest("POST", "/preview", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	for _, invalid := range []string{
+		`{"kind":1,"content":"unterminated`,
+		`{"kind":1,"pubkey":"nothex","content":"hi"}`,
+		`{"id":"` + testPubkey2 + `","kind":1,"pubkey":"` + testPubkey1 + `","content":"wrong id"}`,
+	} {
+		w = httptest.NewRecorder()
+		renderPreview(w, httptest.NewRequest("POST", "/preview", strings.NewReader(invalid)))
+		assert.Equal(t, http.StatusBadRequest, w.Code, invalid)
+	}
+
+	w = httptest.NewRecorder()
+	renderPreview(w, httptest.NewRequest("GET", "/preview", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	// mentioned profiles only come from what we already have, going to the relays would blow up here
+	previous := mentionResolver
+	defer func() { mentionResolver = previous }()
+	profiles := cache_memory.New32[sdk.ProfileMetadata](100)
+	profiles.SetWithTTL(testPubkey2, sdk.ProfileMetadata{PubKey: testPubkey2, Name: "alice"}, time.Hour)
+	profiles.Cache.Wait()
+	mentionResolver = profileResolver{cache: profiles}
+
+	npub, _ := nip19.EncodePublicKey(testPubkey2)
+	evt = nostr.Event{Kind: 1, CreatedAt: 1710000000, Tags: nostr.Tags{{"p", testPubkey2}}, Content: "hi nostr:" + npub}
+	assert.NoError(t, evt.Sign(sk))
+	body, _ = json.Marshal(evt)
+	w = httptest.NewRecorder()
+	renderPreview(w, httptest.NewRequest("POST", "/preview", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "alice")
+}
+
+func TestPreviewOnlyUsesLocalEvents(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	quoted := nostr.Event{Kind: 1, CreatedAt: 1710000000, Tags: nostr.Tags{}, Content: "the note being quoted"}
+	assert.NoError(t, quoted.Sign(sk))
+	assert.NoError(t, sys.Store.SaveEvent(context.Background(), &quoted))
+
+	// the relays in these hints are never reached, the test system has no pool
+	quotedNevent, _ := nip19.EncodeEvent(quoted.ID, []string{"wss://relay.example.com"}, quoted.PubKey)
+	missingNevent, _ := nip19.EncodeEvent(fmt.Sprintf("%064x", 1), []string{"wss://relay.example.com"}, testPubkey2)
+	evt := nostr.Event{
+		Kind:      1,
+		CreatedAt: 1710000001,
+		Tags:      nostr.Tags{{"q", quoted.ID}},
+		Content:   "look at this nostr:" + quotedNevent + " and this nostr:" + missingNevent,
+	}
+	assert.NoError(t, evt.Sign(sk))
+	body, _ := json.Marshal(evt)
+
+	w := httptest.NewRecorder()
+	renderPreview(w, httptest.NewRequest("POST", "/preview?debug=1", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "the note being quoted")
+	assert.Contains(t, w.Body.String(), "and this nostr:"+missingNevent)
+
+	// and the same moderation as the event page applies
+	assert.NoError(t, internal.banPubkey(evt.PubKey, "spam"))
+	defer internal.unbanPubkey(evt.PubKey)
+	w = httptest.NewRecorder()
+	renderPreview(w, httptest.NewRequest("POST", "/preview", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NotContains(t, w.Body.String(), "look at this")
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/sdk"
+	"github.com/nbd-wtf/go-nostr/sdk/cache"
+)
+
+// profileResolver gets the metadata for all the pubkeys mentioned in a page at once,
+// so a page with many mentions doesn't do one relay query for each
+type profileResolver struct {
+	cache cache.Cache32[sdk.ProfileMetadata]
+	fetch func(ctx context.Context, pubkeys []string) []sdk.ProfileMetadata
+}
+
+var mentionResolver profileResolver
+
+// fetchProfileMetadata is sys.FetchProfileMetadata, unless the context says only what we have locally can be used
+func fetchProfileMetadata(ctx context.Context, pubkey string) sdk.ProfileMetadata {
+	if !localOnly(ctx) {
+		return sys.FetchProfileMetadata(ctx, pubkey)
+	}
+	if mentionResolver.cache != nil {
+		if pm, ok := mentionResolver.cache.Get(pubkey); ok {
+			return pm
+		}
+	}
+	if res, _ := sys.StoreRelay.QuerySync(ctx, nostr.Filter{Kinds: []int{0}, Authors: []string{pubkey}}); len(res) != 0 {
+		return parseProfileMetadata(res[0])
+	}
+	return sdk.ProfileMetadata{PubKey: pubkey}
+}
+
+func (pr profileResolver) resolve(ctx context.Context, pubkeys []string) map[string]sdk.ProfileMetadata {
+	profiles := make(map[string]sdk.ProfileMetadata, len(pubkeys))
+	if pr.cache == nil {
+		return profiles
+	}
+
+	missing := make([]string, 0, len(pubkeys))
+	for _, pubkey := range pubkeys {
+		if pm, ok := pr.cache.Get(pubkey); ok {
+			profiles[pubkey] = pm
+		} else {
+			missing = appendUnique(missing, pubkey)
+		}
+	}
+
+	if len(missing) > 0 && pr.fetch != nil {
+		for _, pm := range pr.fetch(ctx, missing) {
+			profiles[pm.PubKey] = pm
+			pr.cache.SetWithTTL(pm.PubKey, pm, time.Hour*6)
+		}
+	}
+
+	return profiles
+}
+
+// resolveList is resolve keeping the order of pubkeys, with just the pubkey for the ones that weren't found
+func (pr profileResolver) resolveList(ctx context.Context, pubkeys []string) []sdk.ProfileMetadata {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*3)
+	defer cancel()
+
+	found := pr.resolve(ctx, pubkeys)
+	profiles := make([]sdk.ProfileMetadata, len(pubkeys))
+	for i, pubkey := range pubkeys {
+		if pm, ok := found[pubkey]; ok {
+			profiles[i] = pm
+		} else {
+			profiles[i] = sdk.ProfileMetadata{PubKey: pubkey}
+		}
+	}
+	return profiles
+}
+
+// fetchProfilesBatch gets kind 0s for all the pubkeys from the local store and then,
+// for the ones we don't have, in a single query to the metadata relays
+func fetchProfilesBatch(ctx context.Context, pubkeys []string) []sdk.ProfileMetadata {
+	profiles := make([]sdk.ProfileMetadata, 0, len(pubkeys))
+
+	found := make(map[string]bool, len(pubkeys))
+	local, _ := sys.StoreRelay.QuerySync(ctx, nostr.Filter{Kinds: []int{0}, Authors: pubkeys})
+	for _, evt := range local {
+		if !found[evt.PubKey] {
+			found[evt.PubKey] = true
+			profiles = append(profiles, parseProfileMetadata(evt))
+		}
+	}
+
+	remaining := make([]string, 0, len(pubkeys)-len(found))
+	for _, pubkey := range pubkeys {
+		if !found[pubkey] {
+			remaining = append(remaining, pubkey)
+		}
+	}
+	if len(remaining) == 0 || localOnly(ctx) {
+		return profiles
+	}
+
+	results := sys.Pool.FetchManyReplaceable(ctx, sys.MetadataRelays.URLs, nostr.Filter{
+		Kinds:   []int{0},
+		Authors: remaining,
+	}, nostr.WithLabel("mentions"))
+	results.Range(func(_ nostr.ReplaceableKey, evt *nostr.Event) bool {
+		sys.Store.SaveEvent(ctx, evt)
+		profiles = append(profiles, parseProfileMetadata(evt))
+		return true
+	})
+
+	return profiles
+}
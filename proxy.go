@@ -1,31 +1,258 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/HugoSmits86/nativewebp"
+)
+
+var (
+	errProxyForbiddenAddress = errors.New("address not allowed")
+	errProxyNotImage         = errors.New("not an image")
+	errProxyTooLarge         = errors.New("image too large")
+	errProxyBadStatus        = errors.New("unexpected upstream status")
 )
 
-func proxy(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Cache-Control", "max-age=6048000")
+// proxyStrippedHeaders are the request headers that say something about the client or ask for something
+// other than the whole image, none of which the image host needs to know
+var proxyStrippedHeaders = []string{
+	"Range", "If-Range", "Cookie", "Authorization",
+	"Forwarded", "X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto", "X-Real-Ip", "Cf-Connecting-Ip",
+}
+
+var carrierGradeNAT = net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// isPublicIP tells if we can let the image proxy connect to this address
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() &&
+		!ip.IsPrivate() &&
+		!ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() &&
+		!ip.IsMulticast() &&
+		!ip.IsUnspecified() &&
+		!carrierGradeNAT.Contains(ip)
+}
+
+// imageEncoder writes an image in some format, used by the proxy to serve lighter images
+type imageEncoder func(w io.Writer, img image.Image) error
+
+// proxyTranscoders are the formats the image proxy can convert jpegs and pngs into, keyed by mime type
+// and in order of preference (AVIF is not here because there is no pure Go encoder for it)
+var proxyTranscoders = []struct {
+	mimeType string
+	encode   imageEncoder
+}{
+	{"image/webp", func(w io.Writer, img image.Image) error { return nativewebp.Encode(w, img, nil) }},
+}
+
+// newGuardedDialer only connects to addresses allowed by allowIP, which is checked on the address
+// actually dialed, after DNS resolution, so names pointing to internal hosts don't get through
+func newGuardedDialer(timeout time.Duration, allowIP func(net.IP) bool) *net.Dialer {
+	return &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !allowIP(ip) {
+				return fmt.Errorf("%w: %s", errProxyForbiddenAddress, host)
+			}
+			return nil
+		},
+	}
+}
+
+// newGuardedTransport is a transport that dials with newGuardedDialer
+func newGuardedTransport(allowIP func(net.IP) bool) *http.Transport {
+	return &http.Transport{
+		DialContext:           newGuardedDialer(10*time.Second, allowIP).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 15 * time.Second,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+	}
+}
+
+// isProxiableImage tells if a content type is an image we can serve from our domain, SVGs are not
+// as they can carry scripts
+func isProxiableImage(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && strings.HasPrefix(mediaType, "image/") && mediaType != "image/svg+xml"
+}
+
+// setProxyHeaders makes sure nothing served by the proxy runs in the context of our domain
+func setProxyHeaders(h http.Header) {
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Security-Policy", "sandbox")
+}
 
-	src := r.URL.Query().Get("src")
-	urlParsed, err := url.Parse(src)
-	if err != nil {
-		http.Error(w, "Invalid URL", http.StatusBadRequest)
+// newImageProxy returns a handler that proxies images from ?src=, it only connects to addresses
+// allowed by allowIP (checked after DNS resolution) and only passes through successful image/* responses
+// (but not SVGs) up to maxSize bytes. when transcode is true jpegs and pngs are converted to a format the client says it accepts,
+// if that makes them smaller
+func newImageProxy(maxSize int64, allowIP func(net.IP) bool, transcode bool) http.HandlerFunc {
+	transport := newGuardedTransport(allowIP)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		src := r.URL.Query().Get("src")
+		urlParsed, err := url.Parse(src)
+		if err != nil {
+			http.Error(w, "Invalid URL", http.StatusBadRequest)
+			return
+		}
+		if urlParsed.Scheme != "http" && urlParsed.Scheme != "https" {
+			http.Error(w, "The URL scheme is neither HTTP nor HTTPS", http.StatusBadRequest)
+			return
+		}
+
+		proxy := httputil.ReverseProxy{
+			Transport: transport,
+			Director: func(r *http.Request) {
+				r.URL = urlParsed
+				r.Host = urlParsed.Host
+				for _, header := range proxyStrippedHeaders {
+					r.Header.Del(header)
+				}
+				// a nil value stops the reverse proxy from adding the client address itself
+				r.Header["X-Forwarded-For"] = nil
+			},
+			ModifyResponse: func(resp *http.Response) error {
+				// error pages would be served as if they were ours, so anything but a whole image is refused
+				if resp.StatusCode != http.StatusOK {
+					return fmt.Errorf("%w: %d", errProxyBadStatus, resp.StatusCode)
+				}
+				if !isProxiableImage(resp.Header.Get("Content-Type")) {
+					return errProxyNotImage
+				}
+				if resp.ContentLength > maxSize {
+					return errProxyTooLarge
+				}
+
+				// the size may not be known in advance, so we also cut the body when it gets too big
+				resp.Body = &limitedReadCloser{Reader: io.LimitReader(resp.Body, maxSize), Closer: resp.Body}
+				resp.Header.Del("Set-Cookie")
+				resp.Header.Set("Cache-Control", "max-age=6048000")
+				setProxyHeaders(resp.Header)
+				if transcode {
+					resp.Header.Add("Vary", "Accept")
+					transcodeImage(resp, r.Header.Get("Accept"))
+				}
+				return nil
+			},
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				setProxyHeaders(w.Header())
+				w.Header().Set("Cache-Control", "max-age=60")
+				switch {
+				case errors.Is(err, errProxyForbiddenAddress):
+					http.Error(w, "The URL points to a forbidden address", http.StatusForbidden)
+				case errors.Is(err, errProxyNotImage):
+					http.Error(w, "The URL is not an image", http.StatusUnsupportedMediaType)
+				case errors.Is(err, errProxyTooLarge):
+					http.Error(w, "The image is too large", http.StatusRequestEntityTooLarge)
+				case errors.Is(err, errProxyBadStatus):
+					http.Error(w, "The URL didn't return an image", http.StatusBadGateway)
+				default:
+					log.Debug().Err(err).Str("src", src).Msg("failed to proxy image")
+					http.Error(w, "Failed to fetch image", http.StatusBadGateway)
+				}
+			},
+		}
+
+		proxy.ServeHTTP(w, r)
+	}
+}
+
+// transcodeImage replaces the body of resp with the image converted to a lighter format accepted
+// by the client, when there is one, otherwise the original image goes through untouched
+func transcodeImage(resp *http.Response, accept string) {
+	contentType := resp.Header.Get("Content-Type")
+	if contentType != "image/jpeg" && contentType != "image/png" {
 		return
 	}
-	if urlParsed.Scheme != "http" && urlParsed.Scheme != "https" {
-		http.Error(w, "The URL scheme is neither HTTP nor HTTPS", http.StatusBadRequest)
+
+	for _, transcoder := range proxyTranscoders {
+		if !strings.Contains(accept, transcoder.mimeType) {
+			continue
+		}
+
+		original, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(original))
+		if err != nil {
+			return
+		}
+
+		img, err := decodeImage(original, maxImagePixels)
+		if err != nil {
+			return
+		}
+		converted := &bytes.Buffer{}
+		if err := transcoder.encode(converted, img); err != nil || converted.Len() >= len(original) {
+			return
+		}
+
+		resp.Body = io.NopCloser(converted)
+		resp.ContentLength = int64(converted.Len())
+		resp.Header.Set("Content-Length", strconv.Itoa(converted.Len()))
+		resp.Header.Set("Content-Type", transcoder.mimeType)
 		return
 	}
+}
 
-	proxy := httputil.ReverseProxy{
-		Director: func(r *http.Request) {
-			r.URL = urlParsed
-			r.Host = urlParsed.Host
-		},
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// imageProxies are the external proxies images in the content are loaded through, as url prefixes that
+// the escaped image url is appended to, like "https://wsrv.nl/?url=", when there are many the next ones
+// are tried when one fails and in the end the image is loaded directly
+var imageProxies []string
+
+var contentImageMatcher = regexp.MustCompile(`<img ([^>]*?)src="(https?://[^"]+)"`)
+
+// imageFallbackScript moves an <img> to the next source in its data-fallbacks every time it fails to load
+const imageFallbackScript = `var f=this.dataset.fallbacks.split(' ');this.src=f.shift();this.dataset.fallbacks=f.join(' ');if(!f.length)this.onerror=null`
+
+// proxyContentImages rewrites the images in the rendered content to go through the image proxies
+func proxyContentImages(content string, proxies []string) string {
+	if len(proxies) == 0 {
+		return content
 	}
 
-	proxy.ServeHTTP(w, r)
+	return contentImageMatcher.ReplaceAllStringFunc(content, func(match string) string {
+		parts := contentImageMatcher.FindStringSubmatch(match)
+		src := html.UnescapeString(parts[2])
+
+		sources := make([]string, 0, len(proxies)+1)
+		for _, proxy := range proxies {
+			sources = append(sources, html.EscapeString(proxy+url.QueryEscape(src)))
+		}
+		if len(sources) == 1 {
+			return `<img ` + parts[1] + `src="` + sources[0] + `"`
+		}
+
+		sources = append(sources, parts[2])
+		return `<img ` + parts[1] + `src="` + sources[0] + `" data-fallbacks="` + strings.Join(sources[1:], " ") +
+			`" onerror="` + imageFallbackScript + `"`
+	})
 }
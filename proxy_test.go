@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/webp"
+)
+
+func proxyRequest(handler http.HandlerFunc, src string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/njump/proxy/?src="+url.QueryEscape(src), nil))
+	return w
+}
+
+func TestImageProxyRejectsPrivateAddresses(t *testing.T) {
+	for _, ip := range []string{"127.0.0.1", "10.1.2.3", "192.168.0.1", "169.254.169.254", "100.64.0.1", "::1", "fe80::1", "0.0.0.0"} {
+		assert.False(t, isPublicIP(net.ParseIP(ip)), ip)
+	}
+	assert.True(t, isPublicIP(net.ParseIP("1.1.1.1")))
+	assert.True(t, isPublicIP(net.ParseIP("2606:4700:4700::1111")))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png"))
+	}))
+	defer server.Close()
+
+	handler := newImageProxy(1024, isPublicIP, false)
+	assert.Equal(t, http.StatusForbidden, proxyRequest(handler, server.URL+"/image.png").Code)
+	assert.Equal(t, http.StatusBadRequest, proxyRequest(handler, "file:///etc/passwd").Code)
+}
+
+func TestImageProxyContentTypeAndSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html></html>"))
+		case "/drawing.svg":
+			w.Header().Set("Content-Type", "Image/SVG+XML; charset=utf-8")
+			w.Write([]byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(document.cookie)</script></svg>`))
+		case "/big.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(bytes.Repeat([]byte{'x'}, 2048))
+		case "/big-chunked.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("x"))
+			w.(http.Flusher).Flush() // no content-length
+			w.Write(bytes.Repeat([]byte{'x'}, 2047))
+		default:
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("png"))
+		}
+	}))
+	defer server.Close()
+
+	handler := newImageProxy(1024, func(net.IP) bool { return true }, false)
+
+	w := proxyRequest(handler, server.URL+"/image.png")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "png", w.Body.String())
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, "sandbox", w.Header().Get("Content-Security-Policy"))
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, proxyRequest(handler, server.URL+"/page.html").Code)
+	w = proxyRequest(handler, server.URL+"/drawing.svg")
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	assert.NotContains(t, w.Body.String(), "<script>")
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, "sandbox", w.Header().Get("Content-Security-Policy"))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, proxyRequest(handler, server.URL+"/big.png").Code)
+
+	w = proxyRequest(handler, server.URL+"/big-chunked.png")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, 1024, w.Body.Len())
+}
+
+func TestImageProxyUpstreamErrors(t *testing.T) {
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		switch r.URL.Path {
+		case "/missing":
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("<script>alert(document.cookie)</script>"))
+		case "/broken":
+			w.Header().Set("Content-Type", "image/png")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write(bytes.Repeat([]byte{'x'}, 4096))
+		case "/partial.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte("pn"))
+		default:
+			w.Header().Set("Content-Type", "image/png")
+			w.Header().Set("Set-Cookie", "tracking=1")
+			w.Write([]byte("png"))
+		}
+	}))
+	defer server.Close()
+
+	handler := newImageProxy(1024, func(net.IP) bool { return true }, false)
+	for _, path := range []string{"/missing", "/broken", "/partial.png"} {
+		w := proxyRequest(handler, server.URL+path)
+		assert.Equal(t, http.StatusBadGateway, w.Code, path)
+		assert.NotContains(t, w.Body.String(), "<script>", path)
+		assert.NotEqual(t, "text/html", w.Header().Get("Content-Type"), path)
+	}
+
+	// nothing about the client reaches the image host
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/njump/proxy/?src="+url.QueryEscape(server.URL+"/image.png"), nil)
+	r.Header.Set("Range", "bytes=0-1")
+	r.Header.Set("Cookie", "tz=Europe/Paris")
+	r.Header.Set("X-Forwarded-For", "1.2.3.4")
+	r.Header.Set("X-Real-IP", "1.2.3.4")
+	handler(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "png", w.Body.String())
+	assert.Empty(t, w.Header().Get("Set-Cookie"))
+	for _, header := range []string{"Range", "Cookie", "X-Forwarded-For", "X-Real-Ip"} {
+		assert.Empty(t, received.Get(header), header)
+	}
+}
+
+func TestImageProxyTranscoding(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 128, 128))
+	for x := range 128 {
+		for y := range 128 {
+			img.Set(x, y, color.RGBA{uint8(x * 2), uint8(y * 2), uint8((x + y) % 4 * 60), 255})
+		}
+	}
+	// an unoptimized png, like the ones we often get from people's screenshots
+	original := &bytes.Buffer{}
+	assert.NoError(t, (&png.Encoder{CompressionLevel: png.NoCompression}).Encode(original, img))
+	// and one that says it is much bigger than it is
+	huge := bytes.Clone(original.Bytes())
+	binary.BigEndian.PutUint32(huge[16:], 100000)
+	binary.BigEndian.PutUint32(huge[20:], 100000)
+	binary.BigEndian.PutUint32(huge[29:], crc32.ChecksumIEEE(huge[12:29]))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/image.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(original.Bytes())
+		case "/broken.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("not really a png"))
+		case "/huge.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(huge)
+		case "/anim.gif":
+			w.Header().Set("Content-Type", "image/gif")
+			w.Write([]byte("GIF89a"))
+		}
+	}))
+	defer server.Close()
+
+	handler := newImageProxy(1<<20, func(net.IP) bool { return true }, true)
+	request := func(src string, accept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/njump/proxy/?src="+url.QueryEscape(src), nil)
+		r.Header.Set("Accept", accept)
+		handler(w, r)
+		return w
+	}
+
+	// client accepting webp
+	w := request(server.URL+"/image.png", "image/avif,image/webp,*/*")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/webp", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Values("Vary"), "Accept")
+	assert.Less(t, w.Body.Len(), original.Len())
+	decoded, err := webp.Decode(w.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, img.Bounds(), decoded.Bounds())
+
+	// client not accepting it
+	w = request(server.URL+"/image.png", "image/png,*/*")
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	assert.Equal(t, original.Bytes(), w.Body.Bytes())
+
+	// stuff we can't decode or don't convert goes through as it is
+	w = request(server.URL+"/broken.png", "image/webp")
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	assert.Equal(t, "not really a png", w.Body.String())
+	w = request(server.URL+"/huge.png", "image/webp")
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	assert.Equal(t, huge, w.Body.Bytes())
+	w = request(server.URL+"/anim.gif", "image/webp")
+	assert.Equal(t, "image/gif", w.Header().Get("Content-Type"))
+	assert.Equal(t, "GIF89a", w.Body.String())
+
+	// disabled
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/njump/proxy/?src="+url.QueryEscape(server.URL+"/image.png"), nil)
+	r.Header.Set("Accept", "image/webp")
+	newImageProxy(1<<20, func(net.IP) bool { return true }, false)(w, r)
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+}
+
+func TestContentImageProxies(t *testing.T) {
+	content := basicFormatting(context.Background(), "look https://example.com/pickle.png?size=large&v=2 and https://example.com/page", false, false, false)
+	assert.Equal(t, content, proxyContentImages(content, nil))
+
+	// a single proxy is just used
+	single := proxyContentImages(content, []string{"https://wsrv.nl/?url="})
+	assert.Contains(t, single, `<img src="https://wsrv.nl/?url=https%3A%2F%2Fexample.com%2Fpickle.png%3Fsize%3Dlarge%26v%3D2">`)
+	assert.NotContains(t, single, "onerror")
+	assert.Contains(t, single, `<a href="https://example.com/page">`)
+
+	// many are tried one after the other, then the image itself
+	doc, err := goquery.NewDocumentFromReader(bytes.NewBufferString(
+		proxyContentImages(content, []string{"https://wsrv.nl/?url=", "/njump/proxy?src="})))
+	assert.NoError(t, err)
+	img := doc.Find("img")
+	assert.Equal(t, 1, img.Length())
+	assert.Equal(t, "https://wsrv.nl/?url=https%3A%2F%2Fexample.com%2Fpickle.png%3Fsize%3Dlarge%26v%3D2", img.AttrOr("src", ""))
+	assert.Equal(t, "/njump/proxy?src=https%3A%2F%2Fexample.com%2Fpickle.png%3Fsize%3Dlarge%26v%3D2 https://example.com/pickle.png?size=large&v=2",
+		img.AttrOr("data-fallbacks", ""))
+	assert.Equal(t, imageFallbackScript, img.AttrOr("onerror", ""))
+}
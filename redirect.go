@@ -18,7 +18,7 @@ func redirectToRandom(w http.ResponseWriter, r *http.Request) {
 	defer func() {
 		switch r.Method {
 		case "POST":
-			fmt.Fprintf(w, target[1:])
+			fmt.Fprint(w, target[1:])
 		case "GET":
 			http.Redirect(w, r, target, http.StatusFound)
 		}
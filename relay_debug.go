@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// RelayProbe is what we know about a relay and an event after fetching it for the page, shown with ?debug=1
+type RelayProbe struct {
+	URL    string
+	Found  bool
+	Status string
+}
+
+// fetchRecord is filled by getEvent with what happened while fetching the event, when the context has one
+type fetchRecord struct {
+	fromStore bool
+	relays    []string // the ones that sent us the event
+	took      time.Duration
+}
+
+type fetchRecordKey struct{}
+
+// withFetchRecord makes getEvent write down where the event came from, so the debug panel can show it
+// without asking the relays again
+func withFetchRecord(ctx context.Context) (context.Context, *fetchRecord) {
+	rec := &fetchRecord{}
+	return context.WithValue(ctx, fetchRecordKey{}, rec), rec
+}
+
+func getFetchRecord(ctx context.Context) *fetchRecord {
+	rec, _ := ctx.Value(fetchRecordKey{}).(*fetchRecord)
+	return rec
+}
+
+func (fr *fetchRecord) Summary() string {
+	if fr.fromStore {
+		return "we already had it, no relays were asked"
+	}
+	return "fetched in " + fr.took.Round(time.Millisecond).String()
+}
+
+// relayProbes lists the relays we were told to look in and the ones that sent us the event this time,
+// followed by the others we've seen it on before
+func relayProbes(hints []string, seenOn []string, rec *fetchRecord) []RelayProbe {
+	fetched := make([]string, len(rec.relays))
+	for i, url := range rec.relays {
+		fetched[i] = nostr.NormalizeURL(url)
+	}
+
+	probes := make([]RelayProbe, 0, len(hints)+len(fetched)+len(seenOn))
+	has := func(url string) bool {
+		return slices.ContainsFunc(probes, func(rp RelayProbe) bool { return rp.URL == url })
+	}
+
+	for _, url := range hints {
+		url = nostr.NormalizeURL(url)
+		switch {
+		case has(url):
+		case slices.Contains(fetched, url):
+			probes = append(probes, RelayProbe{URL: url, Found: true, Status: "found"})
+		case rec.fromStore:
+			probes = append(probes, RelayProbe{URL: url, Status: "not asked"})
+		default:
+			probes = append(probes, RelayProbe{URL: url, Status: "not found"})
+		}
+	}
+	for _, url := range fetched {
+		if !has(url) {
+			probes = append(probes, RelayProbe{URL: url, Found: true, Status: "found"})
+		}
+	}
+	for _, url := range seenOn {
+		if url = nostr.NormalizeURL(url); !has(url) {
+			probes = append(probes, RelayProbe{URL: url, Status: "seen before"})
+		}
+	}
+
+	return probes
+}
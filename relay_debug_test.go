@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelayDebugPanel(t *testing.T) {
+	_, rec := withFetchRecord(context.Background())
+	rec.relays = []string{"wss://has.example.com/", "wss://other.example.com"}
+	rec.took = time.Millisecond * 1234
+
+	probes := relayProbes(
+		[]string{"wss://has.example.com", "wss://hasnot.example.com"},
+		[]string{"wss://has.example.com/", "wss://old.example.com"},
+		rec,
+	)
+	assert.Equal(t, []RelayProbe{
+		{URL: "wss://has.example.com", Found: true, Status: "found"},
+		{URL: "wss://hasnot.example.com", Status: "not found"},
+		{URL: "wss://other.example.com", Found: true, Status: "found"},
+		{URL: "wss://old.example.com", Status: "seen before"},
+	}, probes)
+
+	var buf bytes.Buffer
+	err := detailsTemplate(DetailsParams{RelayFetch: rec, RelayProbes: probes}).Render(context.Background(), &buf)
+	assert.NoError(t, err)
+	doc, err := goquery.NewDocumentFromReader(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "fetched in 1.234s", doc.Find(".relay-debug-summary").Text())
+	rows := doc.Find("table.relay-debug tr")
+	assert.Equal(t, 4, rows.Length())
+	assert.Equal(t, "wss://hasnot.example.com", rows.Eq(1).Find("td").Eq(0).Text())
+	assert.Equal(t, "not found", rows.Eq(1).Find("td").Eq(1).Text())
+
+	// when we had the event already nobody was asked
+	rec = &fetchRecord{fromStore: true}
+	probes = relayProbes([]string{"wss://hasnot.example.com"}, nil, rec)
+	assert.Equal(t, []RelayProbe{{URL: "wss://hasnot.example.com", Status: "not asked"}}, probes)
+	assert.Equal(t, "we already had it, no relays were asked", rec.Summary())
+
+	// and without a record there is no panel at all
+	buf.Reset()
+	assert.NoError(t, detailsTemplate(DetailsParams{}).Render(context.Background(), &buf))
+	assert.NotContains(t, buf.String(), "relay-debug")
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var errTooManyRelayConnections = errors.New("too many relay connections open")
+
+// connectionLimiter keeps the number of open connections under a maximum, new ones wait for a slot
+// to be freed for a while before giving up, a slot is freed when its connection is closed
+type connectionLimiter struct {
+	slots chan struct{}
+	wait  time.Duration
+	dial  func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+func newConnectionLimiter(
+	max int,
+	wait time.Duration,
+	dial func(ctx context.Context, network, addr string) (net.Conn, error),
+) *connectionLimiter {
+	return &connectionLimiter{slots: make(chan struct{}, max), wait: wait, dial: dial}
+}
+
+func (cl *connectionLimiter) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	timer := time.NewTimer(cl.wait)
+	defer timer.Stop()
+
+	select {
+	case cl.slots <- struct{}{}:
+	case <-timer.C:
+		return nil, errTooManyRelayConnections
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	conn, err := cl.dial(ctx, network, addr)
+	if err != nil {
+		<-cl.slots
+		return nil, err
+	}
+	return &limitedConn{Conn: conn, release: func() { <-cl.slots }}, nil
+}
+
+type limitedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (lc *limitedConn) Close() error {
+	err := lc.Conn.Close()
+	lc.once.Do(lc.release)
+	return err
+}
+
+// setupRelayConnectionLimit limits the connections made through relayTransport
+func setupRelayConnectionLimit(max int, wait time.Duration) {
+	relayTransport.DialContext = newConnectionLimiter(max, wait, relayTransport.DialContext).DialContext
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelayConnectionLimit(t *testing.T) {
+	var open, highest atomic.Int32
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		n := open.Add(1)
+		for {
+			h := highest.Load()
+			if n <= h || highest.CompareAndSwap(h, n) {
+				break
+			}
+		}
+		client, server := net.Pipe()
+		server.Close()
+		return &countedConn{Conn: client, open: &open}, nil
+	}
+
+	limiter := newConnectionLimiter(3, time.Second, dial)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := limiter.DialContext(context.Background(), "tcp", "relay.example.com:443")
+			if !assert.NoError(t, err) {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+			conn.Close()
+			conn.Close() // closing twice doesn't free two slots
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(3), highest.Load())
+	assert.Equal(t, int32(0), open.Load())
+
+	// while the slots are taken others wait, but only for so long
+	limiter = newConnectionLimiter(1, 20*time.Millisecond, dial)
+	held, err := limiter.DialContext(context.Background(), "tcp", "relay.example.com:443")
+	assert.NoError(t, err)
+	_, err = limiter.DialContext(context.Background(), "tcp", "relay.example.com:443")
+	assert.ErrorIs(t, err, errTooManyRelayConnections)
+
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		held.Close()
+	}()
+	conn, err := limiter.DialContext(context.Background(), "tcp", "relay.example.com:443")
+	assert.NoError(t, err)
+	conn.Close()
+}
+
+func TestRelayTransport(t *testing.T) {
+	previousClient, previousDial := http.DefaultClient, relayTransport.DialContext
+	previousWebsocketClient := relayWebsocketOptions.HTTPClient
+	defaultDial := reflect.ValueOf(http.DefaultTransport.(*http.Transport).DialContext).Pointer()
+	defer func() {
+		http.DefaultClient, relayTransport.DialContext = previousClient, previousDial
+		relayWebsocketOptions.HTTPClient = previousWebsocketClient
+	}()
+
+	// these are really the options go-nostr dials relays with
+	assert.Equal(t, "github.com/nbd-wtf/go-nostr", relayWebsocketOptions.HTTPHeader.Get("User-Agent"))
+
+	// the relay limits and the tor proxy only touch the transport used by the relay websockets
+	setupRelayTransport()
+	setupRelayConnectionLimit(1, time.Millisecond)
+	assert.NoError(t, setupTorProxy("127.0.0.1:9050", overrideRelayHosts.has))
+	assert.Same(t, relayTransport, relayWebsocketOptions.HTTPClient.Transport)
+	assert.Same(t, previousClient, http.DefaultClient)
+	assert.Equal(t, defaultDial, reflect.ValueOf(http.DefaultTransport.(*http.Transport).DialContext).Pointer())
+}
+
+type countedConn struct {
+	net.Conn
+	open *atomic.Int32
+	once sync.Once
+}
+
+func (cc *countedConn) Close() error {
+	cc.once.Do(func() { cc.open.Add(-1) })
+	return cc.Conn.Close()
+}
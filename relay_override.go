@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"golang.org/x/net/proxy"
+)
+
+// overrideRelayHosts are the hosts of the relays given to us with ?relays=, when connecting to these the
+// relay transport checks the address they resolve to, which it doesn't for the relays we picked ourselves
+var overrideRelayHosts = newHostSet(50000, 24*time.Hour)
+
+// relayOverridesFromQuery reads the relays given with ?relays=, which can be repeated and/or
+// have many comma-separated values, and keeps only the valid ones as in sanitizeRelayOverrides.
+// when rejectPrivate is set their hosts are added to overrideRelayHosts so the names resolving
+// to private addresses are refused when they are dialed
+func relayOverridesFromQuery(query url.Values, max int, rejectPrivate bool) []string {
+	var urls []string
+	for _, value := range query["relays"] {
+		for _, raw := range strings.Split(value, ",") {
+			if raw = strings.TrimSpace(raw); raw != "" {
+				urls = append(urls, raw)
+			}
+		}
+	}
+
+	relays := sanitizeRelayOverrides(urls, max, rejectPrivate)
+	if rejectPrivate {
+		relays = slices.DeleteFunc(relays, func(relay string) bool {
+			u, _ := url.Parse(relay)
+			return !overrideRelayHosts.add(u.Hostname())
+		})
+	}
+	return relays
+}
+
+// withRelayHints encodes the decoded event pointer again with the given relays added to its hints
+func withRelayHints(decoded any, relays []string) string {
+	switch v := decoded.(type) {
+	case nostr.EventPointer:
+		for _, relay := range relays {
+			v.Relays = appendUnique(v.Relays, relay)
+		}
+		code, _ := nip19.EncodeEvent(v.ID, v.Relays, v.Author)
+		return code
+	case nostr.EntityPointer:
+		for _, relay := range relays {
+			v.Relays = appendUnique(v.Relays, relay)
+		}
+		code, _ := nip19.EncodeEntity(v.PublicKey, v.Kind, v.Identifier, v.Relays)
+		return code
+	case string:
+		code, _ := nip19.EncodeEvent(v, relays, "")
+		return code
+	}
+	return ""
+}
+
+// sanitizeRelayOverrides keeps only the user-given relays we are willing to connect to: websocket urls,
+// each relay once and at most max of them. when rejectPrivate is set relays on local or private
+// addresses are also dropped (hostnames are not resolved here, only literal ips and local names are caught,
+// names resolving to private addresses are refused later when the relay transport dials them, see hostGuard)
+func sanitizeRelayOverrides(urls []string, max int, rejectPrivate bool) []string {
+	relays := make([]string, 0, min(len(urls), max))
+	for _, raw := range urls {
+		if len(relays) >= max {
+			break
+		}
+
+		u, err := url.Parse(nostr.NormalizeURL(strings.TrimSpace(raw)))
+		if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Hostname() == "" || u.User != nil {
+			continue
+		}
+		// nostr.NormalizeURL turns anything without a scheme into wss://, so check what we were given
+		if trimmed := strings.ToLower(strings.TrimSpace(raw)); !strings.HasPrefix(trimmed, "ws://") && !strings.HasPrefix(trimmed, "wss://") {
+			continue
+		}
+		if rejectPrivate && isPrivateHost(u.Hostname()) {
+			continue
+		}
+
+		relays = appendUnique(relays, u.String())
+	}
+	return relays
+}
+
+func isPrivateHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if ip := net.ParseIP(host); ip != nil {
+		return !isPublicIP(ip)
+	}
+	return host == "localhost" ||
+		strings.HasSuffix(host, ".localhost") ||
+		strings.HasSuffix(host, ".local") ||
+		strings.HasSuffix(host, ".internal") ||
+		!strings.Contains(host, ".")
+}
+
+// hostSet is a set of hostnames that forgets them after ttl, it doesn't take more than max at a time
+type hostSet struct {
+	mu    sync.Mutex
+	hosts map[string]time.Time
+	max   int
+	ttl   time.Duration
+}
+
+func newHostSet(max int, ttl time.Duration) *hostSet {
+	return &hostSet{hosts: make(map[string]time.Time), max: max, ttl: ttl}
+}
+
+// add returns false when the set is full even after dropping the expired hosts
+func (hs *hostSet) add(host string) bool {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	host = normalizeHost(host)
+	if _, ok := hs.hosts[host]; !ok && len(hs.hosts) >= hs.max {
+		now := time.Now()
+		for other, expires := range hs.hosts {
+			if now.After(expires) {
+				delete(hs.hosts, other)
+			}
+		}
+		if len(hs.hosts) >= hs.max {
+			return false
+		}
+	}
+	hs.hosts[host] = time.Now().Add(hs.ttl)
+	return true
+}
+
+func (hs *hostSet) has(host string) bool {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	expires, ok := hs.hosts[normalizeHost(host)]
+	return ok && time.Now().Before(expires)
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// hostGuard dials the hosts guard says so with guarded, which only connects to public addresses,
+// and all the others with plain
+type hostGuard struct {
+	plain   proxy.ContextDialer
+	guarded proxy.ContextDialer
+	guard   func(host string) bool
+}
+
+func (hg hostGuard) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if host, _, err := net.SplitHostPort(address); err == nil && hg.guard(host) {
+		return hg.guarded.DialContext(ctx, network, address)
+	}
+	return hg.plain.DialContext(ctx, network, address)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/dgraph-io/ristretto"
+	"github.com/puzpuzpuz/xsync/v3"
+)
+
+// relayRefresher asks relays for more of something in the background, outside of the request that wanted it,
+// each key at most once every interval and only a few at the same time, when all of them are busy
+// the refresh is skipped and the next request for that key tries again
+type relayRefresher struct {
+	interval time.Duration
+	timeout  time.Duration
+	slots    chan struct{}
+	running  *xsync.MapOf[string, struct{}]
+	recent   *ristretto.Cache[string, struct{}]
+}
+
+func newRelayRefresher(interval time.Duration, timeout time.Duration, concurrency int) *relayRefresher {
+	recent, _ := ristretto.NewCache(&ristretto.Config[string, struct{}]{
+		NumCounters: 1e6,     // number of keys to track frequency of (1M)
+		MaxCost:     1 << 17, // maximum number of keys remembered (128k)
+		BufferItems: 64,      // number of keys per Get buffer
+	})
+
+	return &relayRefresher{
+		interval: interval,
+		timeout:  timeout,
+		slots:    make(chan struct{}, concurrency),
+		running:  xsync.NewMapOf[string, struct{}](),
+		recent:   recent,
+	}
+}
+
+// refresh calls fetch in a goroutine unless key was refreshed recently, is being refreshed right now
+// or there are too many refreshes going on already
+func (rr *relayRefresher) refresh(key string, fetch func(ctx context.Context)) {
+	if _, ok := rr.recent.Get(key); ok {
+		return
+	}
+	if _, running := rr.running.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+
+	select {
+	case rr.slots <- struct{}{}:
+	default:
+		rr.running.Delete(key)
+		return
+	}
+
+	go func() {
+		defer func() { <-rr.slots }()
+		defer rr.running.Delete(key)
+
+		ctx, cancel := context.WithTimeout(context.Background(), rr.timeout)
+		defer cancel()
+		fetch(ctx)
+
+		rr.recent.SetWithTTL(key, struct{}{}, 1, rr.interval)
+		rr.recent.Wait()
+	}()
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelayRefresher(t *testing.T) {
+	rr := newRelayRefresher(time.Minute, time.Second, 2)
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fetch := func(ctx context.Context) {
+		calls.Add(1)
+		<-release
+	}
+
+	// the same key is only fetched once while it is running
+	rr.refresh("a", fetch)
+	rr.refresh("a", fetch)
+	rr.refresh("b", fetch)
+
+	// and there are never more than the given number running
+	rr.refresh("c", fetch)
+
+	assert.Eventually(t, func() bool { return calls.Load() == 2 }, time.Second, time.Millisecond*10)
+	close(release)
+	assert.Eventually(t, func() bool { return len(rr.slots) == 0 }, time.Second, time.Millisecond*10)
+
+	// recently refreshed keys are left alone, the one that was skipped isn't
+	rr.refresh("a", fetch)
+	rr.refresh("b", fetch)
+	rr.refresh("c", fetch)
+	assert.Eventually(t, func() bool { return calls.Load() == 3 }, time.Second, time.Millisecond*10)
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(t, int32(3), calls.Load())
+}
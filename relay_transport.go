@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"time"
+	_ "unsafe"
+
+	ws "github.com/coder/websocket"
+)
+
+// relayWebsocketOptions are the options go-nostr dials every relay with (unless it is given custom
+// headers or tls settings, which we never do), it has no other way of taking a transport
+//
+//go:linkname relayWebsocketOptions github.com/nbd-wtf/go-nostr.defaultConnectionOptions
+var relayWebsocketOptions *ws.DialOptions
+
+// relayTransport is only for the relay websocket connections, it is given to the websocket dials of
+// go-nostr so http.DefaultClient and http.DefaultTransport are left alone for anything else that uses them
+var relayTransport = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	MaxIdleConns:          100,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: 1 * time.Second,
+}
+
+func setupRelayTransport() {
+	relayWebsocketOptions.HTTPClient = &http.Client{Transport: relayTransport}
+}
@@ -34,6 +34,9 @@ func renderArchive(w http.ResponseWriter, r *http.Request) {
 		params.Limit = 5000
 		for val := range internal.View(params) {
 			pka := val.(*PubKeyArchive)
+			if blocklist.blocksPubkey(pka.Pubkey) {
+				continue
+			}
 			npub, _ := nip19.EncodePublicKey(pka.Pubkey)
 			data = append(data, npub)
 		}
@@ -62,7 +65,7 @@ func renderArchive(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("content-type", "text/xml")
 	w.Write([]byte(XML_HEADER))
 	SitemapTemplate.Render(w, &SitemapPage{
-		Host:       s.Domain,
+		BaseURL:    baseURL(),
 		ModifiedAt: modifiedAt,
 		PathPrefix: pathPrefix,
 		Data:       data,
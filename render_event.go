@@ -3,12 +3,12 @@ package main
 import (
 	_ "embed"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"html"
 	"html/template"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
 	"time"
 
@@ -16,7 +16,6 @@ import (
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip05"
 	"github.com/nbd-wtf/go-nostr/nip19"
-	"github.com/pelletier/go-toml"
 )
 
 func renderEvent(w http.ResponseWriter, r *http.Request) {
@@ -32,7 +31,7 @@ func renderEvent(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// decode the nip19 code we've received
-	prefix, decoded, err := nip19.Decode(code)
+	prefix, decoded, err := parseNostrCode(code)
 	if err != nil {
 		// if it's a 32-byte hex assume it's an event id
 		if _, err := hex.DecodeString(code); err == nil && len(code) == 64 {
@@ -62,8 +61,22 @@ func renderEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// relays given in the query are tried along with the hints in the code
+	fetchCode := code
+	if relays := relayOverridesFromQuery(r.URL.Query(), s.MaxRelayOverrides, !s.AllowPrivateRelays); len(relays) > 0 {
+		if withHints := withRelayHints(decoded, relays); withHints != "" {
+			fetchCode = withHints
+		}
+	}
+
+	// with the relay debug panel on we keep track of where the event comes from
+	if s.RelayDebug && r.URL.Query().Get("debug") == "1" {
+		ctx, _ = withFetchRecord(ctx)
+		r = r.WithContext(ctx)
+	}
+
 	// get data for this event
-	data, err := grabData(ctx, code, true)
+	data, err := grabData(ctx, fetchCode, true)
 	if err != nil {
 		w.Header().Set("Cache-Control", "max-age=60")
 		log.Warn().Err(err).Str("code", code).Msg("event not found on render_event")
@@ -87,40 +100,40 @@ func renderEvent(w http.ResponseWriter, r *http.Request) {
 	//
 
 	// banned or unallowed conditions
-	if banned, reason := internal.isBannedEvent(data.event.ID); banned {
-		w.Header().Set("Cache-Control", "max-age=60")
-		log.Warn().Err(err).Str("code", code).Str("reason", reason).Msg("event banned")
-		http.Error(w, "event banned", http.StatusNotFound)
+	if renderIfNotAllowed(ctx, w, data.event) {
 		return
 	}
-	if banned, reason := internal.isBannedPubkey(data.event.PubKey); banned {
-		w.Header().Set("Cache-Control", "max-age=60")
-		log.Warn().Err(err).Str("code", code).Str("reason", reason).Msg("pubkey banned")
-		http.Error(w, "pubkey banned", http.StatusNotFound)
-		return
+
+	var hints []string
+	switch v := decoded.(type) {
+	case nostr.EventPointer:
+		hints = v.Relays
+	case nostr.EntityPointer:
+		hints = v.Relays
 	}
-	hasURL := urlRegex.MatchString(data.event.Content)
-	if isMaliciousBridged(data.event.author) ||
-		(hasURL && hasProhibitedWordOrTag(data.event.Event)) ||
-		(hasURL && hasExplicitMedia(ctx, data.event.Event)) {
-		log.Warn().Str("event", data.nevent).Msg("detect prohibited content")
-		http.Error(w, "event is not allowed", http.StatusNotFound)
+
+	renderEventData(w, r, code, hints, data, isEmbed)
+}
+
+// renderEventData writes the page for an event we already have, code is how it will be
+// linked to from that page (for the text image and oembed) and hints are relays it should be on
+func renderEventData(w http.ResponseWriter, r *http.Request, code string, hints []string, data Data, isEmbed bool) {
+	ctx := r.Context()
+
+	if renderIfExpired(ctx, w, data.event) {
 		return
 	}
 
 	// gather page style from user-agent
 	style := getPreviewStyle(r)
 
-	// gather host
-	host := r.Header.Get("X-Forwarded-Host")
-	if host == "" {
-		host = r.Host
-	}
+	// gather the address we're being reached on
+	base := requestBaseURL(r)
 
 	useTextImage := false
 
 	if data.event.Kind == 1 || data.event.Kind == 30023 {
-		if data.image == "" && data.video == "" && len(data.event.Content) > 133 {
+		if data.image == "" && data.cover == "" && data.video == "" && len(data.event.content) > 133 {
 			useTextImage = true
 		}
 		if style == StyleTwitter {
@@ -133,11 +146,11 @@ func renderEvent(w http.ResponseWriter, r *http.Request) {
 	if tgiv := r.URL.Query().Get("tgiv"); tgiv == "true" || (style == StyleTelegram && tgiv != "false") {
 		// do telegram instant preview (only works on telegram mobile apps, not desktop)
 		if data.event.Kind == 30023 || // do it for longform articles
-			(data.event.Kind == 1 && len(data.event.Content) > 650) || // or very long notes
+			(data.event.Kind == 1 && len(data.event.content) > 650) || // or very long notes
 			(data.parentLink != "") || // or notes that are replies (so we can navigate to them from telegram)
 			(strings.Contains(data.content, "nostr:")) || // or notes that quote other stuff (idem)
 			// or shorter notes that should be using text-to-image stuff but are not because they have video or images
-			(data.event.Kind == 1 && len(data.event.Content)-len(data.image) > 133 && !useTextImage) ||
+			(data.event.Kind == 1 && len(data.event.content)-len(data.image) > 133 && !useTextImage) ||
 			(data.event.Kind == 20) {
 
 			data.templateId = TelegramInstantView
@@ -166,7 +179,7 @@ func renderEvent(w http.ResponseWriter, r *http.Request) {
 		subscript += " (" + data.event.subject + ")"
 	}
 
-	subscript += " by " + data.event.author.ShortName()
+	subscript += " by " + ProfileDisplayName(data.event.author)
 	if data.event.isReply() {
 		subscript += " (reply)"
 	}
@@ -180,10 +193,35 @@ func renderEvent(w http.ResponseWriter, r *http.Request) {
 		seenOnRelays = fmt.Sprintf("seen on %s", strings.Join(relays, ", "))
 	}
 
+	// quote reposts get the quoted event displayed as a card below the content
+	var quote *QuotedEvent
+	if data.templateId == Note {
+		if pointer := data.event.quotedEvent(); pointer != nil {
+			if quote = resolveQuote(ctx, pointer, fetchEnhancedEvent); quote != nil {
+				data.content = removeQuoteReference(data.content, pointer)
+			}
+		}
+	}
+
 	textImageURL := ""
 	description := ""
+	warning, hasWarning := data.event.contentWarning()
+	if hasWarning {
+		// the text image would show the very content the author asked to be hidden
+		useTextImage = false
+	}
 	if useTextImage {
-		textImageURL = fmt.Sprintf("https://%s/njump/image/%s?%s", host, code, r.URL.RawQuery)
+		textImageURL = fmt.Sprintf("%s/njump/image/%s?%s", base, code, r.URL.RawQuery)
+	}
+	if hasWarning {
+		description = "Sensitive content"
+		if warning != "" {
+			description += ": " + warning
+		}
+	} else if data.event.summary != "" {
+		// articles say what they are about, that's better than anything we can come up with
+		description = data.event.summary
+	} else if useTextImage {
 		if data.event.subject != "" {
 			if seenOnRelays != "" {
 				description = fmt.Sprintf("%s -- %s", data.event.subject, seenOnRelays)
@@ -193,18 +231,18 @@ func renderEvent(w http.ResponseWriter, r *http.Request) {
 		} else {
 			description = seenOnRelays
 		}
-	} else if data.event.summary != "" {
-		description = data.event.summary
 	} else {
-		// if content is valid JSON, parse that and print as TOML for easier readability
-		var parsedJson any
-		if err := json.Unmarshal([]byte(data.event.Content), &parsedJson); err == nil {
-			if t, err := toml.Marshal(parsedJson); err == nil && len(t) > 0 {
-				description = string(t)
-			}
+		// if content is valid JSON print it as TOML for easier readability
+		if formatted, ok := FormatContent(data.event.content); ok {
+			description = formatted
 		} else {
 			// otherwise replace npub/nprofiles with names and trim length
-			description = replaceUserReferencesWithNames(ctx, []string{data.event.Content}, "")[0]
+			description = hideCashuTokens(replaceUserReferencesWithNames(ctx, []string{data.event.content}, "")[0])
+			description = normalizeInvisibleCharacters(description, s.StripZeroWidth)
+			if quote != nil {
+				description = quotePreviewDescription(description, data.event.quotedEvent(), quote)
+			}
+			description = collapseWhitespace(description)
 			if len(description) > 240 {
 				description = description[:240]
 			}
@@ -212,17 +250,13 @@ func renderEvent(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// titleizedContent
-	titleizedContent := urlRegex.ReplaceAllString(
-		strings.TrimSpace(
-			strings.Replace(
-				strings.Replace(
-					replaceUserReferencesWithNames(ctx, []string{data.event.Content}, "")[0],
-					"\r\n", " ", -1),
-				"\n", " ", -1,
-			),
-		),
+	titleizedContent := collapseWhitespace(urlRegex.ReplaceAllString(
+		hideCashuTokens(normalizeInvisibleCharacters(
+			replaceUserReferencesWithNames(ctx, []string{data.event.content}, "")[0],
+			s.StripZeroWidth,
+		)),
 		"",
-	)
+	))
 
 	if titleizedContent == "" {
 		titleizedContent = subscript
@@ -245,41 +279,53 @@ func renderEvent(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// content massaging
-	for i, tag := range data.event.Tags {
-		if len(tag) < 2 {
-			continue
-		}
+	data.content = replaceTagPlaceholders(data.content, data.event.Tags)
+	data.content = normalizeInvisibleCharacters(data.content, s.StripZeroWidth)
 
-		placeholderTag := "#[" + fmt.Sprintf("%d", i) + "]"
-		nreplace := ""
-		if tag[0] == "p" {
-			nreplace, _ = nip19.EncodePublicKey(tag[1])
-		} else if tag[0] == "e" {
-			nreplace, _ = nip19.EncodeEvent(tag[1], []string{}, "")
-		} else {
-			continue
+	// multiple images declared with imeta are shown together in a grid, in the order of the tags
+	var gallery []GalleryImage
+	if (data.event.Kind == 1 || data.event.Kind == 20) && data.templateId != TelegramInstantView {
+		gallery = parseImageGallery(data.event.Tags)
+		for _, image := range gallery {
+			data.content = strings.ReplaceAll(data.content, image.URL, "")
+		}
+		if len(gallery) > 0 {
+			data.content = strings.TrimSpace(data.content)
+			data.image = gallery[0].URL
 		}
-		data.content = strings.ReplaceAll(data.content, placeholderTag, "nostr:"+nreplace)
 	}
-	if data.event.Kind == 30023 || data.event.Kind == 30024 {
+
+	if data.event.Kind == 30023 || data.event.Kind == 30024 || data.event.Kind == 30402 {
 		// Remove duplicate title inside the body
 		data.content = strings.ReplaceAll(data.content, "# "+data.event.subject, "")
-		data.content = mdToHTML(data.content, data.templateId == TelegramInstantView)
+		data.content = mdToHTML(ctx, data.content, data.templateId == TelegramInstantView)
 	} else if data.event.Kind == 30818 {
-		data.content = asciidocToHTML(data.content)
+		data.content = wikiContentToHTML(ctx, data.content)
 	} else {
 		// first we run basicFormatting, which turns URLs into their appropriate HTML tags
-		data.content = basicFormatting(html.EscapeString(data.content), true, false, false)
+		data.content = basicFormatting(ctx, html.EscapeString(data.content), true, false, false)
+		data.content = applyImageMetadata(data.content, data.event.Tags)
 		// then we render quotes as HTML, which will also apply basicFormatting to all the internal quotes
 		data.content = renderQuotesAsHTML(ctx, data.content, data.templateId == TelegramInstantView)
 		// we must do this because inside <blockquotes> we must treat <img>s differently when telegram_instant_view
+		data.content += renderImageGallery(gallery)
+	}
+	if shouldBlurMedia(data.event.PubKey, s.BlurUntrustedMedia, slices.Concat(s.MediaAllowlist, s.TrustedPubKeys)) {
+		data.content = blurMedia(data.content)
+	} else {
+		data.content = blurSensitiveImages(data.content, data.event.sensitiveImages())
 	}
+	data.content = proxyContentImages(data.content, imageProxies)
 
 	w.Header().Set("Content-Type", "text/html")
-	if data.templateId == TelegramInstantView {
+	if lang := contentLanguage(data.event.Event); lang != "" {
+		w.Header().Set("Content-Language", lang)
+	}
+	fetched := getFetchRecord(ctx)
+	if data.templateId == TelegramInstantView || fetched != nil || r.Method == http.MethodPost {
 		w.Header().Set("Cache-Control", "no-cache")
 	} else if len(data.content) != 0 {
-		w.Header().Set("Cache-Control", "max-age=604800")
+		w.Header().Set("Cache-Control", cacheControlForKind(data.event.Kind))
 	} else {
 		w.Header().Set("Cache-Control", "max-age=60")
 	}
@@ -287,14 +333,9 @@ func renderEvent(w http.ResponseWriter, r *http.Request) {
 	// oembed discovery
 	oembed := ""
 	if data.templateId == Note {
-		oembed = (&url.URL{
-			Scheme: "https",
-			Host:   host,
-			Path:   "/services/oembed",
-			RawQuery: (url.Values{
-				"url": {fmt.Sprintf("https://%s/%s", host, code)},
-			}).Encode(),
-		}).String()
+		oembed = base + "/services/oembed?" + (url.Values{
+			"url": {base + "/" + code},
+		}).Encode()
 		w.Header().Add("Link", "<"+oembed+"&format=json>; rel=\"alternate\"; type=\"application/json+oembed\"")
 		w.Header().Add("Link", "<"+oembed+"&format=xml>; rel=\"alternate\"; type=\"text/xml+oembed\"")
 	}
@@ -304,22 +345,49 @@ func renderEvent(w http.ResponseWriter, r *http.Request) {
 		CreatedAt:       data.createdAt,
 		KindDescription: data.kindDescription,
 		KindNIP:         data.kindNIP,
-		EventJSON:       toJSONHTML(data.event.Event),
+		EventJSON:       toJSONHTML(data.event.Event, inlineTagsLimit(r)),
+		RawEventCode:    data.neventNaked,
 		Kind:            data.event.Kind,
 		SeenOn:          data.event.relays,
 		Metadata:        data.event.author,
 	}
+	if shortLinks != nil {
+		canonical := data.nevent
+		if data.naddr != "" {
+			canonical = data.naddr
+		}
+		if id := shortLinks.ShortenCode(canonical); id != "" {
+			detailsData.ShortLink = base + "/n/" + id
+		}
+	}
+	if fetched != nil {
+		detailsData.HideDetails = false
+		detailsData.RelayFetch = fetched
+		detailsData.RelayProbes = relayProbes(hints, data.event.relays, fetched)
+	}
 
 	opengraph := OpenGraphParams{
 		BigImage:     textImageURL,
 		Image:        data.image,
 		Video:        data.video,
 		VideoType:    data.videoType,
-		ProxiedImage: "https://" + host + "/njump/proxy?src=" + data.image,
+		VideoFirst:   data.videoFirst,
+		ProxiedImage: base + "/njump/proxy?src=" + data.image,
+
+		FallbackImage: fallbackImages.forKind(data.event.Kind),
 
 		Superscript: data.event.authorLong() + " on Nostr",
 		Subscript:   subscript,
 		Text:        strings.TrimSpace(description),
+
+		URL: HeadParams{NaddrNaked: data.naddrNaked, NeventNaked: data.neventNaked}.CanonicalURL(),
+	}
+
+	alternates := eventAlternateLinks(data.neventNaked)
+	setAlternateLinkHeaders(w.Header(), alternates)
+
+	if renderKindTemplate(w, data, opengraph, isEmbed) {
+		return
 	}
 
 	var component templ.Component
@@ -359,6 +427,9 @@ func renderEvent(w http.ResponseWriter, r *http.Request) {
 			opengraph.Text = opengraph.Text[0 : len(opengraph.Text)-len(opengraph.Image)]
 			opengraph.BigImage = opengraph.Image
 		}
+		if caption := mediaOnlyCaption(data.event, data.image, data.video); caption != "" && !hasWarning {
+			opengraph.Text = caption
+		}
 
 		content := data.content
 		for tag := range data.event.Tags.FindAll("emoji") {
@@ -379,11 +450,21 @@ func renderEvent(w http.ResponseWriter, r *http.Request) {
 				Oembed:      oembed,
 				NaddrNaked:  data.naddrNaked,
 				NeventNaked: data.neventNaked,
+				Alternates:  alternates,
+				JSONLD:      eventJSONLD(data.event, data.neventNaked, titleizedContent, data.image),
 			},
-			Clients:          generateClientList(data.event.Kind, data.nevent),
+			Clients:          generateClientList(data.event.Kind, data.neventNaked, withRelaysInCode(data.neventNaked, data.relayHints)),
 			Details:          detailsData,
 			Content:          template.HTML(content),
 			TitleizedContent: titleizedContent,
+			Mentions:         mentionResolver.resolveList(ctx, limitAt(data.event.mentionedPubkeys(), maxInlineTags)),
+			Quote:            quote,
+			Addresses:        resolveAddressReferences(ctx, data.event.addressReferences(), fetchEnhancedEvent),
+			ZapSplits:        resolveZapSplits(ctx, limitAt(zapSplits(data.event.Tags), maxZapSplits)),
+		}
+		if r.Method != http.MethodPost {
+			// a previewed event isn't published yet, so nobody could have replied to it
+			params.Replies = replyCounts.summarize(ctx, data.event.ID)
 		}
 
 		component = noteTemplate(params, isEmbed)
@@ -409,13 +490,17 @@ func renderEvent(w http.ResponseWriter, r *http.Request) {
 				Oembed:      oembed,
 				NaddrNaked:  data.naddrNaked,
 				NeventNaked: data.neventNaked,
+				Alternates:  alternates,
+				JSONLD:      eventJSONLD(data.event, data.naddrNaked, data.event.subject, data.cover),
 			},
-			Clients:          generateClientList(data.event.Kind, data.naddr),
+			Clients:          generateClientList(data.event.Kind, data.naddrNaked, withRelaysInCode(data.naddrNaked, data.relayHints)),
 			Details:          detailsData,
 			Content:          template.HTML(data.content),
 			Cover:            data.cover,
+			Summary:          data.event.summary,
 			TitleizedContent: data.event.subject, // we store the "title" tag here too
 		}
+		_, params.ReadingMinutes = ReadingTime(data.event.content)
 
 		component = noteTemplate(params, isEmbed)
 
@@ -428,10 +513,11 @@ func renderEvent(w http.ResponseWriter, r *http.Request) {
 				IsProfile:   false,
 				NaddrNaked:  data.naddrNaked,
 				NeventNaked: data.neventNaked,
+				Alternates:  alternates,
 			},
 
 			Details: detailsData,
-			Clients: generateClientList(data.event.Kind, data.nevent),
+			Clients: generateClientList(data.event.Kind, data.neventNaked, withRelaysInCode(data.neventNaked, data.relayHints)),
 
 			FileMetadata: *data.kind1063Metadata,
 			IsImage:      data.kind1063Metadata.IsImage(),
@@ -443,6 +529,12 @@ func renderEvent(w http.ResponseWriter, r *http.Request) {
 
 	case LiveEvent:
 		opengraph.Image = data.kind30311Metadata.Image
+		if data.kind30311Metadata.Title != "" {
+			opengraph.Subscript = data.kind30311Metadata.title()
+		}
+		if data.kind30311Metadata.Summary != "" {
+			opengraph.Text = data.kind30311Metadata.Summary
+		}
 		params := LiveEventPageParams{
 			BaseEventPageParams: baseEventPageParams,
 			OpenGraphParams:     opengraph,
@@ -450,11 +542,12 @@ func renderEvent(w http.ResponseWriter, r *http.Request) {
 				IsProfile:   false,
 				NaddrNaked:  data.naddrNaked,
 				NeventNaked: data.neventNaked,
+				Alternates:  alternates,
 			},
 
 			Details:   detailsData,
 			LiveEvent: *data.kind30311Metadata,
-			Clients: generateClientList(data.event.Kind, data.naddr,
+			Clients: generateClientList(data.event.Kind, data.naddrNaked, withRelaysInCode(data.naddrNaked, data.relayHints),
 				func(c ClientReference, s string) string {
 					if c == nostrudel {
 						s = strings.Replace(s, "/u/", "/streams/", 1)
@@ -467,6 +560,11 @@ func renderEvent(w http.ResponseWriter, r *http.Request) {
 		component = liveEventTemplate(params, isEmbed)
 
 	case LiveEventMessage:
+		live := resolveLiveEventContext(ctx, data.event, fetchEnhancedEvent)
+		if live != nil && live.Title != "" {
+			opengraph.Subscript = "in " + live.Title
+		}
+
 		params := LiveEventMessagePageParams{
 			BaseEventPageParams: baseEventPageParams,
 			OpenGraphParams:     opengraph,
@@ -474,12 +572,14 @@ func renderEvent(w http.ResponseWriter, r *http.Request) {
 				IsProfile:   false,
 				NaddrNaked:  data.naddrNaked,
 				NeventNaked: data.neventNaked,
+				Alternates:  alternates,
 			},
 
 			Details:          detailsData,
 			Content:          template.HTML(data.content),
 			TitleizedContent: titleizedContent,
-			Clients:          generateClientList(data.event.Kind, data.naddr),
+			LiveEvent:        live,
+			Clients:          generateClientList(data.event.Kind, data.naddrNaked, withRelaysInCode(data.naddrNaked, data.relayHints)),
 		}
 
 		component = liveEventMessageTemplate(params, isEmbed)
@@ -507,12 +607,16 @@ func renderEvent(w http.ResponseWriter, r *http.Request) {
 			// Set default TimeZone to UTC
 			location = time.UTC
 		}
+		endLocation, err := time.LoadLocation(data.kind31922Or31923Metadata.EndTzid)
+		if err != nil || data.kind31922Or31923Metadata.EndTzid == "" {
+			endLocation = location
+		}
 
 		startAtDate = data.kind31922Or31923Metadata.Start.In(location).Format("02 Jan 2006")
-		endAtDate = data.kind31922Or31923Metadata.End.In(location).Format("02 Jan 2006")
+		endAtDate = data.kind31922Or31923Metadata.End.In(endLocation).Format("02 Jan 2006")
 		if data.kind31922Or31923Metadata.CalendarEventKind == 31923 {
 			startAtTime = data.kind31922Or31923Metadata.Start.In(location).Format("15:04")
-			endAtTime = data.kind31922Or31923Metadata.End.In(location).Format("15:04")
+			endAtTime = data.kind31922Or31923Metadata.End.In(endLocation).Format("15:04")
 		}
 
 		// Reset EndDate/Time if it is non initialized (beginning of the Unix epoch)
@@ -528,8 +632,10 @@ func renderEvent(w http.ResponseWriter, r *http.Request) {
 				IsProfile:   false,
 				NaddrNaked:  data.naddrNaked,
 				NeventNaked: data.neventNaked,
+				Alternates:  alternates,
 			},
 			TimeZone:      getUTCOffset(location),
+			EndTimeZone:   getUTCOffset(endLocation),
 			StartAtDate:   startAtDate,
 			StartAtTime:   startAtTime,
 			EndAtDate:     endAtDate,
@@ -537,14 +643,14 @@ func renderEvent(w http.ResponseWriter, r *http.Request) {
 			CalendarEvent: *data.kind31922Or31923Metadata,
 			Details:       detailsData,
 			Content:       template.HTML(data.content),
-			Clients:       generateClientList(data.event.Kind, data.naddr),
+			Clients:       generateClientList(data.event.Kind, data.naddrNaked, withRelaysInCode(data.naddrNaked, data.relayHints)),
 		}
 
 		component = calendarEventTemplate(params, isEmbed)
 
 	case WikiEvent:
 		opengraph.Superscript = "wiki entry: " + data.Kind30818Metadata.Title
-		if strings.ToLower(data.Kind30818Metadata.Title) == data.Kind30818Metadata.Handle {
+		if normalizeWikiHandle(data.Kind30818Metadata.Title) == data.Kind30818Metadata.Handle {
 			opengraph.Subscript = "by " + data.event.author.ShortName()
 		} else {
 			opengraph.Subscript = fmt.Sprintf("\"%s\" by %s", data.Kind30818Metadata.Handle, data.event.author.ShortName())
@@ -557,6 +663,7 @@ func renderEvent(w http.ResponseWriter, r *http.Request) {
 				IsProfile:   false,
 				NaddrNaked:  data.naddrNaked,
 				NeventNaked: data.neventNaked,
+				Alternates:  alternates,
 			},
 			PublishedAt: data.Kind30818Metadata.PublishedAt.Format("02 Jan 2006"),
 			WikiEvent:   data.Kind30818Metadata,
@@ -564,7 +671,8 @@ func renderEvent(w http.ResponseWriter, r *http.Request) {
 			Content:     data.content,
 			Clients: generateClientList(
 				data.event.Kind,
-				data.naddr,
+				data.naddrNaked,
+				withRelaysInCode(data.naddrNaked, data.relayHints),
 				func(client ClientReference, url string) string {
 					return strings.Replace(url, "{handle}", data.Kind30818Metadata.Handle, -1)
 				},
@@ -597,15 +705,249 @@ func renderEvent(w http.ResponseWriter, r *http.Request) {
 				IsProfile:   false,
 				NaddrNaked:  data.naddrNaked,
 				NeventNaked: data.neventNaked,
+				Alternates:  alternates,
 			},
 			Content:        template.HTML(data.content),
 			HighlightEvent: data.Kind9802Metadata,
 			Details:        detailsData,
-			Clients:        generateClientList(data.event.Kind, data.nevent),
+			Clients:        generateClientList(data.event.Kind, data.neventNaked, withRelaysInCode(data.neventNaked, data.relayHints)),
 		}
 
 		component = highlightTemplate(params, isEmbed)
 
+	case Classified:
+		if data.kind30402Metadata.Title != "" {
+			opengraph.Subscript = data.kind30402Metadata.Title
+		}
+		if data.kind30402Metadata.Summary != "" {
+			opengraph.Text = data.kind30402Metadata.Summary
+		}
+		if data.kind30402Metadata.Price != "" {
+			opengraph.Text = data.kind30402Metadata.Price + " — " + opengraph.Text
+		}
+		if len(data.kind30402Metadata.Images) > 0 {
+			opengraph.Image = data.kind30402Metadata.Images[0]
+		}
+
+		params := ClassifiedPageParams{
+			BaseEventPageParams: baseEventPageParams,
+			OpenGraphParams:     opengraph,
+			HeadParams: HeadParams{
+				IsProfile:   false,
+				NaddrNaked:  data.naddrNaked,
+				NeventNaked: data.neventNaked,
+				Alternates:  alternates,
+			},
+			Details:    detailsData,
+			Content:    template.HTML(data.content),
+			Classified: data.kind30402Metadata,
+			Clients:    generateClientList(data.event.Kind, data.naddrNaked, withRelaysInCode(data.naddrNaked, data.relayHints)),
+		}
+
+		component = classifiedTemplate(params, isEmbed)
+
+	case Report:
+		opengraph.Subscript = "Report by " + data.event.author.ShortName()
+		if len(data.kind1984Metadata.Targets) > 0 && data.kind1984Metadata.Targets[0].ReportType != "" {
+			opengraph.Subscript += " (" + data.kind1984Metadata.Targets[0].ReportType + ")"
+		}
+
+		params := ReportPageParams{
+			BaseEventPageParams: baseEventPageParams,
+			OpenGraphParams:     opengraph,
+			HeadParams: HeadParams{
+				IsProfile:   false,
+				NoIndex:     true,
+				NaddrNaked:  data.naddrNaked,
+				NeventNaked: data.neventNaked,
+				Alternates:  alternates,
+			},
+			Details: detailsData,
+			Content: template.HTML(data.content),
+			Report:  data.kind1984Metadata,
+			Clients: generateClientList(data.event.Kind, data.neventNaked, withRelaysInCode(data.neventNaked, data.relayHints)),
+		}
+
+		component = reportTemplate(params, isEmbed)
+
+	case Badge:
+		params := BadgePageParams{
+			BaseEventPageParams: baseEventPageParams,
+			HeadParams: HeadParams{
+				IsProfile:   false,
+				NaddrNaked:  data.naddrNaked,
+				NeventNaked: data.neventNaked,
+				Alternates:  alternates,
+			},
+			Details: detailsData,
+			Content: template.HTML(data.content),
+			Badge:   data.kind30009Metadata,
+			Clients: generateClientList(data.event.Kind, data.neventNaked, withRelaysInCode(data.neventNaked, data.relayHints)),
+		}
+		if data.event.Kind == 8 {
+			params.IsAward = true
+			params.Badge = resolveBadgeDefinition(ctx, data.kind8Metadata, fetchEnhancedEvent)
+			awardees := data.kind8Metadata.Awardees
+			params.MoreAwardees = max(0, len(awardees)-maxBadgeAwardees)
+			params.Awardees = fetchProfiles(ctx, awardees[:min(len(awardees), maxBadgeAwardees)], fetchProfileMetadata)
+			opengraph.Subscript = "Badge awarded by " + data.event.author.ShortName()
+		} else if data.event.Kind == 30008 {
+			params.IsProfileBadges = true
+			params.Accepted = resolveProfileBadges(ctx, data.kind30008Metadata.Accepted, fetchEnhancedEvent)
+			params.Clients = generateClientList(data.event.Kind, data.naddrNaked, withRelaysInCode(data.naddrNaked, data.relayHints))
+			opengraph.Subscript = "Badges of " + data.event.author.ShortName()
+			names := make([]string, len(params.Accepted))
+			for i, badge := range params.Accepted {
+				names[i] = badge.Name
+			}
+			params.Badge = BadgeDefinition{Name: strings.Join(names, ", ")}
+			if len(params.Accepted) != 0 {
+				params.Badge.Image = params.Accepted[0].Picture()
+			}
+		} else {
+			params.Clients = generateClientList(data.event.Kind, data.naddrNaked, withRelaysInCode(data.naddrNaked, data.relayHints))
+			opengraph.Subscript = "Badge by " + data.event.author.ShortName()
+		}
+		opengraph.Text = params.Badge.Name
+		if params.Badge.Description != "" {
+			opengraph.Text += ": " + params.Badge.Description
+		}
+		if picture := params.Badge.Picture(); picture != "" {
+			opengraph.Image = picture
+		}
+		params.OpenGraphParams = opengraph
+
+		component = badgeTemplate(params, isEmbed)
+
+	case Comment:
+		opengraph.Subscript = "Comment by " + data.event.author.ShortName()
+		if root := data.kind1111Metadata.Root; root != nil {
+			opengraph.Subscript = "Comment on " + root.Label
+		}
+
+		params := CommentPageParams{
+			BaseEventPageParams: baseEventPageParams,
+			OpenGraphParams:     opengraph,
+			HeadParams: HeadParams{
+				IsProfile:   false,
+				NaddrNaked:  data.naddrNaked,
+				NeventNaked: data.neventNaked,
+				Alternates:  alternates,
+			},
+			Details: detailsData,
+			Content: template.HTML(data.content),
+			Comment: data.kind1111Metadata,
+			Clients: generateClientList(data.event.Kind, data.neventNaked, withRelaysInCode(data.neventNaked, data.relayHints)),
+		}
+
+		component = commentTemplate(params, isEmbed)
+
+	case Encrypted:
+		opengraph.Text = data.encryptedMetadata.Label
+		if redacted, ok := redactEvent(*data.event.Event); ok {
+			detailsData.EventJSON = toJSONHTML(&redacted, inlineTagsLimit(r))
+		}
+
+		params := EncryptedPageParams{
+			BaseEventPageParams: baseEventPageParams,
+			OpenGraphParams:     opengraph,
+			HeadParams: HeadParams{
+				IsProfile:   false,
+				NoIndex:     true,
+				NaddrNaked:  data.naddrNaked,
+				NeventNaked: data.neventNaked,
+				Alternates:  alternates,
+			},
+			Details:   detailsData,
+			Encrypted: *data.encryptedMetadata,
+			Clients:   generateClientList(data.event.Kind, data.neventNaked, withRelaysInCode(data.neventNaked, data.relayHints)),
+		}
+
+		component = encryptedTemplate(params, isEmbed)
+
+	case ZapGoal:
+		goal := data.kind9041Metadata
+		progress := resolveZapGoalProgress(ctx, goal, fetchGoalZaps)
+
+		opengraph.Subscript = "Zap goal by " + data.event.author.ShortName()
+		opengraph.Text = fmt.Sprintf("%s (%d of %d sats raised, unverified)", goal.Title, progress.Raised, goal.TargetSats())
+		if goal.Image != "" {
+			opengraph.Image = goal.Image
+		}
+
+		params := ZapGoalPageParams{
+			BaseEventPageParams: baseEventPageParams,
+			OpenGraphParams:     opengraph,
+			HeadParams: HeadParams{
+				IsProfile:   false,
+				NaddrNaked:  data.naddrNaked,
+				NeventNaked: data.neventNaked,
+				Alternates:  alternates,
+			},
+			Details:  detailsData,
+			Goal:     goal,
+			Progress: progress,
+			Clients:  generateClientList(data.event.Kind, data.neventNaked, withRelaysInCode(data.neventNaked, data.relayHints)),
+		}
+
+		component = zapGoalTemplate(params, isEmbed)
+
+	case Git:
+		params := GitPageParams{
+			BaseEventPageParams: baseEventPageParams,
+			HeadParams: HeadParams{
+				IsProfile:   false,
+				NaddrNaked:  data.naddrNaked,
+				NeventNaked: data.neventNaked,
+				Alternates:  alternates,
+			},
+			Details: detailsData,
+		}
+		if data.event.Kind == 30617 {
+			repo := data.kind30617Metadata
+			params.Repository = &repo
+			params.Clients = generateClientList(data.event.Kind, data.naddrNaked, withRelaysInCode(data.naddrNaked, data.relayHints))
+			opengraph.Subscript = "Git repository by " + data.event.author.ShortName()
+			opengraph.Text = repo.Name
+			if repo.Description != "" {
+				opengraph.Text += ": " + repo.Description
+			}
+		} else {
+			patch := data.kind1617Metadata
+			params.Patch = &patch
+			params.Clients = generateClientList(data.event.Kind, data.neventNaked, withRelaysInCode(data.neventNaked, data.relayHints))
+			opengraph.Subscript = "Patch by " + data.event.author.ShortName()
+			opengraph.Text = patch.Subject
+		}
+		params.OpenGraphParams = opengraph
+
+		component = gitTemplate(params, isEmbed)
+
+	case RelayList:
+		list := data.kind10002Metadata
+		hostnames := make([]string, len(list.Relays))
+		for i, relay := range list.Relays {
+			hostnames[i] = relay.Hostname()
+		}
+		opengraph.Subscript = "Relays used by " + data.event.author.ShortName()
+		opengraph.Text = fmt.Sprintf("%d relays: %s", len(list.Relays), strings.Join(hostnames, ", "))
+
+		params := RelayListPageParams{
+			BaseEventPageParams: baseEventPageParams,
+			OpenGraphParams:     opengraph,
+			HeadParams: HeadParams{
+				IsProfile:   false,
+				NaddrNaked:  data.naddrNaked,
+				NeventNaked: data.neventNaked,
+				Alternates:  alternates,
+			},
+			Details:   detailsData,
+			RelayList: list,
+			Clients:   generateClientList(data.event.Kind, data.neventNaked, withRelaysInCode(data.neventNaked, data.relayHints)),
+		}
+
+		component = relayListTemplate(params, isEmbed)
+
 	case Other:
 		detailsData.HideDetails = false // always open this since we know nothing else about the event
 
@@ -615,6 +957,7 @@ func renderEvent(w http.ResponseWriter, r *http.Request) {
 				IsProfile:   false,
 				NaddrNaked:  data.naddrNaked,
 				NeventNaked: data.neventNaked,
+				Alternates:  alternates,
 			},
 
 			Details:         detailsData,
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/nip31"
+	"github.com/nbd-wtf/go-nostr/nip53"
+	"github.com/nbd-wtf/go-nostr/sdk"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testPubkey1 = "3bf0c63fcb93463407af97a5e5ee64fa883d107ef9e558472c4eb9aaaefa459d"
+	testPubkey2 = "97c70a44366a6535c145b333f973ea86dfdc2d7a99da618c40c64705ad98e322"
+)
+
+// testEnhancedEvent is evt as the pages get it, by default from testPubkey1 who is called fiatjaf
+func testEnhancedEvent(evt *nostr.Event) EnhancedEvent {
+	if evt.PubKey == "" {
+		evt.PubKey = testPubkey1
+	}
+	return enhanceEvent(evt, sdk.ProfileMetadata{PubKey: evt.PubKey, Name: "fiatjaf"})
+}
+
+// testPreview renders evt through /preview at target, signing it with a new key unless it is signed already,
+// so the whole event page can be tested without relays
+func testPreview(t *testing.T, target string, evt nostr.Event) *httptest.ResponseRecorder {
+	t.Helper()
+	if evt.Sig == "" {
+		assert.NoError(t, evt.Sign(nostr.GeneratePrivateKey()))
+	}
+	body, _ := json.Marshal(evt)
+	w := httptest.NewRecorder()
+	renderPreview(w, httptest.NewRequest("POST", target, bytes.NewReader(body)))
+	return w
+}
+
+// testPreviewPage is testPreview for when all that matters is the page
+func testPreviewPage(t *testing.T, evt nostr.Event) *goquery.Document {
+	t.Helper()
+	w := testPreview(t, "/preview", evt)
+	assert.Equal(t, http.StatusOK, w.Code)
+	doc, err := goquery.NewDocumentFromReader(w.Body)
+	assert.NoError(t, err)
+	return doc
+}
+
+type testMetadataCache map[string]sdk.ProfileMetadata
+
+func (c testMetadataCache) Get(k string) (sdk.ProfileMetadata, bool) { v, ok := c[k]; return v, ok }
+func (c testMetadataCache) Delete(k string)                          { delete(c, k) }
+func (c testMetadataCache) Set(k string, v sdk.ProfileMetadata) bool { c[k] = v; return true }
+func (c testMetadataCache) SetWithTTL(k string, v sdk.ProfileMetadata, _ time.Duration) bool {
+	return c.Set(k, v)
+}
+
+func TestUnknownKindAltDescription(t *testing.T) {
+	withAlt := &nostr.Event{Kind: 32767, Tags: nostr.Tags{{"alt", "A chess game between alice and bob"}}}
+	params := OtherPageParams{
+		BaseEventPageParams: BaseEventPageParams{Event: testEnhancedEvent(withAlt), Alt: nip31.GetAlt(*withAlt)},
+		Kind:                withAlt.Kind,
+		KindDescription:     "Kind 32767",
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, otherTemplate(params).Render(context.Background(), &buf))
+	doc, err := goquery.NewDocumentFromReader(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "Nostr Event 32767 - A chess game between alice and bob", doc.Find("title").Text())
+	assert.Equal(t, "A chess game between alice and bob", doc.Find("h1").Text())
+	assert.Equal(t, "A chess game between alice and bob", doc.Find(`meta[property="og:title"]`).AttrOr("content", ""))
+	assert.Equal(t, "Kind 32767", doc.Find("header h1 + div").Text())
+
+	withoutAlt := &nostr.Event{Kind: 32767}
+	params = OtherPageParams{
+		BaseEventPageParams: BaseEventPageParams{Event: testEnhancedEvent(withoutAlt), Alt: nip31.GetAlt(*withoutAlt)},
+		Kind:                withoutAlt.Kind,
+		KindDescription:     "Kind 32767",
+	}
+	buf.Reset()
+	assert.NoError(t, otherTemplate(params).Render(context.Background(), &buf))
+	doc, err = goquery.NewDocumentFromReader(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "Nostr Event 32767 - Kind 32767", doc.Find("title").Text())
+	assert.Equal(t, "Kind 32767", doc.Find("h1").Text())
+	assert.Equal(t, 0, doc.Find("header h1 + div").Length())
+}
+
+func TestClientTag(t *testing.T) {
+	render := func(tags nostr.Tags) *goquery.Selection {
+		note := NotePageParams{
+			BaseEventPageParams: BaseEventPageParams{Event: testEnhancedEvent(&nostr.Event{Kind: 1, Content: "hello", Tags: tags})},
+		}
+		var buf bytes.Buffer
+		assert.NoError(t, noteTemplate(note, false).Render(context.Background(), &buf))
+		doc, err := goquery.NewDocumentFromReader(&buf)
+		assert.NoError(t, err)
+		return doc.Find(".client-credit")
+	}
+
+	plain := render(nostr.Tags{{"client", "Some App"}})
+	assert.Equal(t, 1, plain.Length())
+	assert.Contains(t, plain.Text(), "posted via")
+	assert.Contains(t, plain.Text(), "Some App")
+	assert.Equal(t, 0, plain.Find("a").Length())
+
+	handler := "31990:" + testPubkey2 + ":1700000000"
+	linked := render(nostr.Tags{{"client", "Other App", handler, "wss://relay.example.com"}})
+	naddr, _ := nip19.EncodeEntity(testPubkey2, 31990, "1700000000", []string{"wss://relay.example.com"})
+	assert.Equal(t, "Other App", linked.Find("a").Text())
+	assert.Equal(t, "/"+naddr, linked.Find("a").AttrOr("href", ""))
+
+	// only handler information events are linked
+	notHandler := render(nostr.Tags{{"client", "Other App", "30023:" + testPubkey2 + ":x"}})
+	assert.Equal(t, 0, notHandler.Find("a").Length())
+
+	assert.Equal(t, 0, render(nil).Length())
+}
+
+func TestArticleSummaryImageAndDate(t *testing.T) {
+	evt := nostr.Event{
+		Kind:      30023,
+		CreatedAt: 1720000000,
+		Tags: nostr.Tags{
+			{"d", "on-pickles"},
+			{"title", "On pickles"},
+			{"summary", "Why everything tastes better after a week in brine."},
+			{"image", "https://example.com/jars.jpg"},
+			{"published_at", "1700000000"},
+		},
+		Content: "Pickling is one of the oldest ways of keeping food around for longer. " + strings.Repeat("It also makes it taste better. ", 20),
+	}
+	doc := testPreviewPage(t, evt)
+
+	assert.Equal(t, "Why everything tastes better after a week in brine.", doc.Find(`meta[property="og:description"]`).AttrOr("content", ""))
+	assert.Equal(t, "https://example.com/jars.jpg", doc.Find(`meta[property="og:image"]`).AttrOr("content", ""))
+	assert.Equal(t, "Why everything tastes better after a week in brine.", doc.Find(".article-summary").Text())
+	assert.Equal(t, "https://example.com/jars.jpg", doc.Find("article img").First().AttrOr("src", ""))
+	assert.Equal(t, "published "+time.Unix(1700000000, 0).UTC().Format("2006-01-02 15:04:05 MST"), strings.TrimSpace(doc.Find(`[itemprop="datePublished"]`).Text()))
+	assert.Equal(t, "updated "+time.Unix(1720000000, 0).UTC().Format("2006-01-02 15:04:05 MST"), strings.TrimSpace(doc.Find(`[itemprop="dateModified"]`).Text()))
+	assert.Equal(t, 0, doc.Find(`[itemprop="dateCreated"]`).Length())
+}
+
+func TestArticleDates(t *testing.T) {
+	render := func(createdAt nostr.Timestamp, tags nostr.Tags) *goquery.Document {
+		article := NotePageParams{
+			BaseEventPageParams: BaseEventPageParams{Event: testEnhancedEvent(&nostr.Event{Kind: 30023, Content: "hello", CreatedAt: createdAt, Tags: tags})},
+		}
+		var buf bytes.Buffer
+		assert.NoError(t, noteTemplate(article, false).Render(context.Background(), &buf))
+		doc, err := goquery.NewDocumentFromReader(&buf)
+		assert.NoError(t, err)
+		return doc
+	}
+
+	edited := render(1720000000, nostr.Tags{{"d", "x"}, {"published_at", "1700000000"}})
+	assert.Equal(t, "published 2023-11-14 22:13:20 UTC", strings.TrimSpace(edited.Find(`[itemprop="datePublished"]`).Text()))
+	assert.Equal(t, "updated 2024-07-03 09:46:40 UTC", strings.TrimSpace(edited.Find(`[itemprop="dateModified"]`).Text()))
+
+	// never edited
+	untouched := render(1700000000, nostr.Tags{{"d", "x"}, {"published_at", "1700000000"}})
+	assert.Equal(t, 1, untouched.Find(`[itemprop="datePublished"]`).Length())
+	assert.Equal(t, 0, untouched.Find(`[itemprop="dateModified"]`).Length())
+
+	// we don't know when it was first published
+	for _, tags := range []nostr.Tags{{{"d", "x"}}, {{"d", "x"}, {"published_at", "garbage"}}} {
+		unknown := render(1720000000, tags)
+		assert.Equal(t, 0, unknown.Find(`[itemprop="datePublished"]`).Length())
+		assert.Equal(t, "updated 2024-07-03 09:46:40 UTC", strings.TrimSpace(unknown.Find(`[itemprop="dateModified"]`).Text()))
+		assert.Equal(t, 0, unknown.Find(`[itemprop="dateCreated"]`).Length())
+	}
+}
+
+func TestInvalidTimestamps(t *testing.T) {
+	render := func(createdAt nostr.Timestamp) *goquery.Selection {
+		note := NotePageParams{
+			BaseEventPageParams: BaseEventPageParams{Event: testEnhancedEvent(&nostr.Event{Kind: 1, Content: "hello", CreatedAt: createdAt})},
+		}
+		var buf bytes.Buffer
+		assert.NoError(t, noteTemplate(note, false).Render(context.Background(), &buf))
+		doc, err := goquery.NewDocumentFromReader(&buf)
+		assert.NoError(t, err)
+		return doc.Find(`[itemprop="dateCreated"]`)
+	}
+
+	zero := render(0)
+	assert.Equal(t, "unknown date", strings.TrimSpace(zero.Text()))
+	assert.Equal(t, 0, zero.Find(".future-dated").Length())
+
+	future := render(nostr.Timestamp(time.Now().AddDate(10, 0, 0).Unix()))
+	assert.Equal(t, 1, future.Find(".future-dated").Length())
+	assert.Contains(t, future.Text(), "future-dated")
+
+	// a little bit ahead is just a clock being off
+	ahead := nostr.Timestamp(time.Now().Add(time.Minute).Unix())
+	assert.Equal(t, 0, render(ahead).Find(".future-dated").Length())
+
+	normal := render(1710000000)
+	assert.Equal(t, time.Unix(1710000000, 0).Format("2006-01-02 15:04:05 MST"), strings.TrimSpace(normal.Text()))
+	assert.Equal(t, 0, normal.Find(".future-dated").Length())
+}
+
+func TestContentWarningDescription(t *testing.T) {
+	preview := func(tags nostr.Tags, content string) *goquery.Document {
+		evt := nostr.Event{Kind: 1, CreatedAt: 1710000000, Tags: tags, Content: content}
+		return testPreviewPage(t, evt)
+	}
+
+	long := "the ending of the movie is that " + strings.Repeat("everybody was a ghost all along. ", 10)
+	doc := preview(nostr.Tags{{"content-warning", "spoilers"}}, long)
+	description := doc.Find(`meta[property="og:description"]`).AttrOr("content", "")
+	assert.Equal(t, "Sensitive content: spoilers", description)
+	assert.NotContains(t, doc.Find(`meta[name="description"]`).AttrOr("content", ""), "ghost")
+	assert.NotContains(t, doc.Find(`meta[property="og:image"]`).AttrOr("content", ""), "/njump/image/")
+
+	doc = preview(nostr.Tags{{"content-warning"}}, "everybody was a ghost")
+	assert.Equal(t, "Sensitive content", doc.Find(`meta[property="og:description"]`).AttrOr("content", ""))
+
+	doc = preview(nostr.Tags{}, "everybody was a ghost")
+	assert.Contains(t, doc.Find(`meta[property="og:description"]`).AttrOr("content", ""), "ghost")
+}
+
+func TestProxyTag(t *testing.T) {
+	render := func(tags nostr.Tags) *goquery.Selection {
+		note := NotePageParams{
+			BaseEventPageParams: BaseEventPageParams{Event: testEnhancedEvent(&nostr.Event{Kind: 1, Content: "hello", Tags: tags})},
+		}
+		var buf bytes.Buffer
+		assert.NoError(t, noteTemplate(note, false).Render(context.Background(), &buf))
+		doc, err := goquery.NewDocumentFromReader(&buf)
+		assert.NoError(t, err)
+		return doc.Find(".bridged-from")
+	}
+
+	activitypub := render(nostr.Tags{{"proxy", "https://mastodon.example.com/users/alice/statuses/1234", "activitypub"}})
+	assert.Equal(t, 1, activitypub.Length())
+	assert.Contains(t, activitypub.Text(), "bridged from")
+	assert.Equal(t, "ActivityPub", activitypub.Find("a").Text())
+	assert.Equal(t, "https://mastodon.example.com/users/alice/statuses/1234", activitypub.Find("a").AttrOr("href", ""))
+
+	atproto := render(nostr.Tags{{"proxy", "at://did:plc:abc123/app.bsky.feed.post/3kxyz", "atproto"}})
+	assert.Equal(t, "https://bsky.app/profile/did:plc:abc123/post/3kxyz", atproto.Find("a").AttrOr("href", ""))
+
+	// ids that aren't links still say where it came from
+	unlinked := render(nostr.Tags{{"proxy", "javascript:alert(1)", "Nonsense"}})
+	assert.Contains(t, unlinked.Text(), "nonsense")
+	assert.Equal(t, 0, unlinked.Find("a").Length())
+
+	assert.Equal(t, 0, render(nil).Length())
+	assert.Equal(t, 0, render(nostr.Tags{{"proxy", "https://example.com/post"}}).Length())
+}
+
+func TestCalendarEvents(t *testing.T) {
+	text := func(s *goquery.Selection) string {
+		if lines := s.Children(); lines.Length() > 0 {
+			return strings.Join(strings.Fields(strings.Join(lines.Map(func(_ int, l *goquery.Selection) string { return l.Text() }), " ")), " ")
+		}
+		return strings.Join(strings.Fields(s.Text()), " ")
+	}
+
+	// whole days, without times or timezones
+	doc := testPreviewPage(t, nostr.Event{
+		Kind:      31922,
+		CreatedAt: 1710000000,
+		Tags: nostr.Tags{
+			{"d", "pickling-festival"},
+			{"title", "Pickling festival"},
+			{"start", "2024-05-01"},
+			{"end", "2024-05-03"},
+			{"g", "u4pruydqqvj"},
+		},
+	})
+	assert.Equal(t, "01 May 2024", text(doc.Find(".calendar-start")))
+	assert.Equal(t, "03 May 2024", text(doc.Find(".calendar-end")))
+	assert.Equal(t, 0, doc.Find(".calendar-location").Find("div").Length())
+	assert.Contains(t, doc.Find(".calendar-map").AttrOr("href", ""), "mlat=57.64911&mlon=10.40744")
+	assert.True(t, strings.HasPrefix(doc.Find(".calendar-ics").AttrOr("href", ""), "/njump/ics/naddr1"))
+
+	// times in the timezone of the event
+	doc = testPreviewPage(t, nostr.Event{
+		Kind:      31923,
+		CreatedAt: 1710000000,
+		Tags: nostr.Tags{
+			{"d", "workshop"},
+			{"title", "Kimchi workshop"},
+			{"start", "1714539600"}, // 2024-05-01 05:00 UTC
+			{"end", "1714546800"},
+			{"start_tzid", "Asia/Tokyo"},
+			{"location", "Community kitchen"},
+		},
+	})
+	assert.Equal(t, "01 May 2024 From 14:00 to 16:00 (UTC+9)", text(doc.Find(".calendar-start")))
+	assert.Equal(t, 0, doc.Find(".calendar-end").Length())
+	assert.Equal(t, "Community kitchen", text(doc.Find(".calendar-location")))
+
+	// without an end or a location
+	doc = testPreviewPage(t, nostr.Event{
+		Kind:      31923,
+		CreatedAt: 1710000000,
+		Tags:      nostr.Tags{{"d", "meetup"}, {"title", "Meetup"}, {"start", "1714572000"}},
+	})
+	assert.Equal(t, "01 May 2024 At 14:00 (UTC+0)", text(doc.Find(".calendar-start")))
+	assert.Equal(t, 0, doc.Find(".calendar-location").Length())
+}
+
+func TestLiveEventStatus(t *testing.T) {
+	ee := testEnhancedEvent(&nostr.Event{
+		Kind: 30311,
+		Tags: nostr.Tags{
+			{"d", "stream"},
+			{"title", "Building njump live"},
+			{"summary", "coding session"},
+			{"status", "live"},
+		},
+	})
+
+	params := LiveEventPageParams{
+		BaseEventPageParams: BaseEventPageParams{Event: ee},
+		LiveEvent:           Kind30311Metadata{LiveEvent: nip53.ParseLiveEvent(*ee.Event)},
+	}
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, liveEventInnerBlock(params).Render(context.Background(), buf))
+	page := buf.String()
+
+	assert.Contains(t, page, "Building njump live")
+	assert.Contains(t, page, "Live now!")
+	assert.Contains(t, page, "coding session")
+}
@@ -1,14 +1,119 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/sdk"
+	"golang.org/x/sync/singleflight"
 )
 
+// homeFeed gives the most recent notes to show in the homepage, it can be replaced in tests
+var homeFeed = &cachedHomeFeed{fetch: fetchHomeFeed, ttl: time.Minute * 5}
+
+type cachedHomeFeed struct {
+	fetch func(ctx context.Context, relays []string, limit int) []*nostr.Event
+	ttl   time.Duration
+
+	mu        sync.Mutex
+	notes     []*nostr.Event
+	limit     int // what the notes were fetched for, there may be fewer of them
+	fetchedAt time.Time
+	fetching  singleflight.Group
+}
+
+func (hf *cachedHomeFeed) get(ctx context.Context, relays []string, limit int) []*nostr.Event {
+	hf.mu.Lock()
+	if time.Since(hf.fetchedAt) <= hf.ttl && hf.limit >= limit {
+		notes := hf.notes
+		hf.mu.Unlock()
+		return notes[:min(len(notes), limit)]
+	}
+	hf.mu.Unlock()
+
+	// everybody who comes while the relays are being asked waits for the same answer
+	// and nobody else is kept waiting
+	res, _, _ := hf.fetching.Do(strconv.Itoa(limit), func() (any, error) {
+		notes := hf.fetch(context.WithoutCancel(ctx), relays, limit)
+
+		hf.mu.Lock()
+		hf.notes, hf.limit, hf.fetchedAt = notes, limit, time.Now()
+		hf.mu.Unlock()
+
+		return notes, nil
+	})
+	notes := res.([]*nostr.Event)
+	return notes[:min(len(notes), limit)]
+}
+
+// fetchHomeFeed gets the latest top-level notes from the given relays, newest first,
+// leaving out what we wouldn't render anyway
+func fetchHomeFeed(ctx context.Context, relays []string, limit int) []*nostr.Event {
+	if len(relays) == 0 {
+		relays = sys.FallbackRelays.URLs
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*4)
+	defer cancel()
+
+	notes := make([]*nostr.Event, 0, limit)
+	for ie := range sys.Pool.FetchMany(ctx, relays, nostr.Filter{Kinds: []int{1}, Limit: limit * 2}, nostr.WithLabel("home")) {
+		ee := EnhancedEvent{Event: ie.Event}
+		if ee.isReply() || hasProhibitedWordOrTag(ie.Event) || blocklist.blocks(ie.Event) {
+			continue
+		}
+		if banned, _ := internal.isBannedPubkey(ie.Event.PubKey); banned {
+			continue
+		}
+		if slices.ContainsFunc(notes, func(evt *nostr.Event) bool { return evt.ID == ie.Event.ID }) {
+			continue
+		}
+		internal.attachRelaysToEvent(ie.Event.ID, ie.Relay.URL)
+		notes = append(notes, ie.Event)
+	}
+
+	slices.SortFunc(notes, func(a, b *nostr.Event) int { return int(b.CreatedAt - a.CreatedAt) })
+	return notes[:min(len(notes), limit)]
+}
+
 func renderHomepage(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Cache-Control", "max-age=3600")
+	ctx := r.Context()
+
+	var lastNotes []EnhancedEvent
+	if s.HomeFeedSize > 0 {
+		notes := homeFeed.get(ctx, s.HomeFeedRelays, s.HomeFeedSize)
+
+		pubkeys := make([]string, 0, len(notes))
+		for _, evt := range notes {
+			pubkeys = appendUnique(pubkeys, evt.PubKey)
+		}
+		authors := mentionResolver.resolve(ctx, pubkeys)
+
+		lastNotes = make([]EnhancedEvent, len(notes))
+		for i, evt := range notes {
+			author, ok := authors[evt.PubKey]
+			if !ok {
+				author = sdk.ProfileMetadata{PubKey: evt.PubKey}
+			}
+			lastNotes[i] = enhanceEvent(evt, author)
+		}
+	}
+
+	if len(lastNotes) != 0 {
+		// so the list doesn't get stale for too long
+		w.Header().Set("Cache-Control", "max-age=300")
+	} else {
+		w.Header().Set("Cache-Control", "max-age=3600")
+	}
 	err := homepageTemplate(HomePageParams{
 		HeadParams: HeadParams{IsHome: true, IsProfile: false},
-	}).Render(r.Context(), w)
+		LastNotes:  lastNotes,
+	}).Render(ctx, w)
 	if err != nil {
 		log.Warn().Err(err).Msg("error rendering tmpl")
 	}
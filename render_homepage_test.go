@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHomeFeed(t *testing.T) {
+	var asked []int
+	old := homeFeed
+	homeFeed = &cachedHomeFeed{ttl: time.Minute, fetch: func(ctx context.Context, relays []string, limit int) []*nostr.Event {
+		asked = append(asked, limit)
+		notes := make([]*nostr.Event, 0, limit)
+		for i := range limit {
+			evt := &nostr.Event{Kind: 1, PubKey: testPubkey2, CreatedAt: nostr.Timestamp(1710000000 - i), Content: fmt.Sprintf("note number %d", i)}
+			evt.ID = evt.GetID()
+			notes = append(notes, evt)
+		}
+		return notes
+	}}
+	defer func() {
+		homeFeed = old
+		s.HomeFeedSize = 0
+	}()
+
+	s.HomeFeedSize = 4
+	w := httptest.NewRecorder()
+	renderHomepage(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	doc, err := goquery.NewDocumentFromReader(w.Body)
+	assert.NoError(t, err)
+	cards := doc.Find("a.home-note-card")
+	assert.Equal(t, 4, cards.Length())
+	assert.Contains(t, cards.First().Text(), "note number 0")
+	assert.True(t, strings.HasPrefix(cards.First().AttrOr("href", ""), "/nevent1"))
+
+	// the second time it comes from the cache
+	w = httptest.NewRecorder()
+	renderHomepage(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, []int{4}, asked)
+
+	// and it can be turned off
+	s.HomeFeedSize = 0
+	w = httptest.NewRecorder()
+	renderHomepage(w, httptest.NewRequest("GET", "/", nil))
+	doc, _ = goquery.NewDocumentFromReader(w.Body)
+	assert.Equal(t, 0, doc.Find("a.home-note-card").Length())
+	assert.Equal(t, []int{4}, asked)
+}
+
+func TestHomeFeedCache(t *testing.T) {
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	feed := &cachedHomeFeed{ttl: time.Minute, fetch: func(ctx context.Context, relays []string, limit int) []*nostr.Event {
+		fetches.Add(1)
+		<-release
+		// the relays had fewer notes than we asked for
+		return []*nostr.Event{{ID: "a"}, {ID: "b"}}
+	}}
+
+	// everybody who asks while it is fetching gets the same answer
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Len(t, feed.get(context.Background(), nil, 4), 2)
+		}()
+	}
+	time.Sleep(time.Millisecond * 50)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), fetches.Load())
+
+	// a short feed is kept until it expires like any other
+	assert.Len(t, feed.get(context.Background(), nil, 4), 2)
+	assert.Len(t, feed.get(context.Background(), nil, 1), 1)
+	assert.Equal(t, int32(1), fetches.Load())
+
+	// but asking for more than it was fetched for, or after it expires, fetches again
+	feed.get(context.Background(), nil, 8)
+	assert.Equal(t, int32(2), fetches.Load())
+	feed.fetchedAt = time.Now().Add(-time.Hour)
+	feed.get(context.Background(), nil, 8)
+	assert.Equal(t, int32(3), fetches.Load())
+}
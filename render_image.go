@@ -60,6 +60,11 @@ func renderImage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// banned or unallowed conditions
+	if blocklist.blocks(data.event.Event) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		http.Error(w, "unavailable", http.StatusUnavailableForLegalReasons)
+		return
+	}
 	if banned, _ := internal.isBannedEvent(data.event.ID); banned {
 		w.WriteHeader(http.StatusNotFound)
 		http.Error(w, "event banned", http.StatusNotFound)
@@ -78,7 +83,11 @@ func renderImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	content := data.event.Content
+	content := data.event.content
+	if data.encryptedMetadata != nil {
+		// there is nothing to read in a ciphertext, and a wallet could have secrets in it
+		content = data.encryptedMetadata.Label
+	}
 	content = strings.Replace(content, "\r\n", "\n", -1)
 	content = multiNewlineRe.ReplaceAllString(content, "\n\n")
 	content = strings.Replace(content, "\t", "  ", -1)
@@ -139,6 +148,8 @@ func drawImage(
 		width -= 10
 	case StyleTwitter:
 		height = width * 268 / 512
+	case StyleLinkedIn:
+		height = width * 627 / 1200
 	case StyleFacebook:
 		height = width * 355 / 680
 		paddingLeft = 180
@@ -218,7 +229,7 @@ func drawImage(
 	}
 
 	img.SetColor(color.White)
-	textImg, _ = drawParagraphs(ctx, []string{metadata.ShortName()}, fontSize, width, barHeight)
+	textImg, _ = drawParagraphs(ctx, []string{ProfileDisplayName(metadata)}, fontSize, width, barHeight)
 	img.DrawImage(textImg, authorTextX, authorTextY)
 
 	// a gradient to cover too long names
@@ -24,7 +24,7 @@ func renderProfile(ctx context.Context, r *http.Request, w http.ResponseWriter,
 		isRSS = true
 	}
 
-	profile, err := sys.FetchProfileFromInput(ctx, code)
+	profile, err := fetchProfileFromInput(ctx, code)
 	if err != nil {
 		log.Warn().Err(err).Str("code", code).Msg("error fetching profile on render_profile")
 		w.Header().Set("Cache-Control", "max-age=60")
@@ -34,9 +34,14 @@ func renderProfile(ctx context.Context, r *http.Request, w http.ResponseWriter,
 		return
 	} else if profile.Event != nil {
 		internal.scheduleEventExpiration(profile.Event.ID)
+		profile = parseProfileMetadata(profile.Event)
 	}
 
 	// banned or unallowed conditions
+	if blocklist.blocksPubkey(profile.PubKey) {
+		renderBlocked(ctx, w)
+		return
+	}
 	if banned, reason := internal.isBannedPubkey(profile.PubKey); banned {
 		w.Header().Set("Cache-Control", "max-age=60")
 		log.Warn().Err(err).Str("code", code).Str("reason", reason).Msg("pubkey banned")
@@ -58,10 +63,11 @@ func renderProfile(ctx context.Context, r *http.Request, w http.ResponseWriter,
 	}
 
 	var lastNotes []EnhancedEvent
-	var cacheControl string = "max-age=86400"
+	var cacheControl string = cacheControlForKind(0)
 	if !isEmbed {
 		var justFetched bool
 		lastNotes, justFetched = authorLastNotes(ctx, profile.PubKey)
+		lastNotes = blocklist.filter(lastNotes)
 		if justFetched && profile.Event != nil {
 			cacheControl = "only-if-cached"
 		}
@@ -73,7 +79,7 @@ func renderProfile(ctx context.Context, r *http.Request, w http.ResponseWriter,
 		w.Header().Add("content-type", "text/xml")
 		w.Write([]byte(XML_HEADER))
 		err = SitemapTemplate.Render(w, &SitemapPage{
-			Host:       s.Domain,
+			BaseURL:    baseURL(),
 			ModifiedAt: createdAt,
 			LastNotes:  lastNotes,
 		})
@@ -81,7 +87,7 @@ func renderProfile(ctx context.Context, r *http.Request, w http.ResponseWriter,
 		w.Header().Add("content-type", "text/xml")
 		w.Write([]byte(XML_HEADER))
 		err = RSSTemplate.Render(w, &RSSPage{
-			Host:       s.Domain,
+			BaseURL:    baseURL(),
 			ModifiedAt: createdAt,
 			Metadata:   profile,
 			LastNotes:  lastNotes,
@@ -90,20 +96,22 @@ func renderProfile(ctx context.Context, r *http.Request, w http.ResponseWriter,
 		w.Header().Add("content-type", "text/html")
 
 		nprofile := profile.Nprofile(ctx, sys, 2)
+		alternates := profileAlternateLinks(profile.Npub())
+		setAlternateLinkHeaders(w.Header(), alternates)
 		params := ProfilePageParams{
-			HeadParams: HeadParams{IsProfile: true},
+			HeadParams: HeadParams{IsProfile: true, Npub: profile.Npub(), Alternates: alternates},
 			Details: DetailsParams{
 				HideDetails:     true,
 				CreatedAt:       createdAt,
 				KindDescription: kindNames[0],
 				KindNIP:         kindNIPs[0],
-				EventJSON:       toJSONHTML(profile.Event),
+				EventJSON:       toJSONHTML(profile.Event, inlineTagsLimit(r)),
 				Kind:            0,
 				Metadata:        profile,
 			},
 			Metadata:                   profile,
 			NormalizedAuthorWebsiteURL: normalizeWebsiteURL(profile.Website),
-			RenderedAuthorAboutText:    template.HTML(basicFormatting(html.EscapeString(profile.About), false, false, false)),
+			RenderedAuthorAboutText:    template.HTML(basicFormatting(ctx, html.EscapeString(profile.About), false, false, false)),
 			Nprofile:                   nprofile,
 			AuthorRelays:               relaysPretty(ctx, profile.PubKey),
 			LastNotes:                  lastNotes,
@@ -122,6 +130,11 @@ func renderProfile(ctx context.Context, r *http.Request, w http.ResponseWriter,
 			),
 		}
 
+		if !isEmbed {
+			params.Activity = profileActivity.summarize(ctx, profile.PubKey)
+			params.Badges = fetchProfileBadges(ctx, profile.PubKey)
+		}
+
 		// give this global context a timeout because it may used inside the template to validate the nip05 address
 		ctx, cancel := context.WithTimeout(ctx, time.Second*3)
 		defer cancel()
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+type RawEvent struct {
+	ID string `json:"id"`
+	// the NIP-01 [0,pubkey,created_at,kind,tags,content] array that is hashed into the id,
+	// missing when the event is redacted as then it wouldn't hash into anything
+	Serialized string       `json:"serialized,omitempty"`
+	Event      *nostr.Event `json:"event"`
+	// the relays we got the event from, or have seen it in before, to be used as hints
+	SeenOn []string `json:"seen_on"`
+}
+
+func newRawEvent(evt *nostr.Event, relays []string) RawEvent {
+	seenOn := make([]string, 0, len(relays))
+	for _, url := range relays {
+		if nostr.IsValidRelayURL(url) {
+			seenOn = appendUnique(seenOn, nostr.NormalizeURL(url))
+		}
+	}
+
+	raw := RawEvent{ID: evt.ID, Event: evt, SeenOn: seenOn}
+	if redacted, ok := redactEvent(*evt); ok {
+		raw.Event = &redacted
+	} else {
+		raw.Serialized = string(evt.Serialize())
+	}
+	return raw
+}
+
+func renderRawEvent(
+	fetch func(ctx context.Context, code string, withRelays bool) (*nostr.Event, []string, error),
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		code := r.PathValue("code")
+
+		evt, relays, err := fetch(ctx, code, true)
+		if err != nil {
+			w.Header().Set("Cache-Control", "max-age=60")
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		ee := NewEnhancedEvent(ctx, evt)
+		switch decision, _ := moderate(ctx, ee); decision {
+		case allowed:
+		case blockedByConfig:
+			w.Header().Set("Cache-Control", "max-age=60")
+			http.Error(w, "unavailable", http.StatusUnavailableForLegalReasons)
+			return
+		case deletedByAuthor:
+			w.Header().Set("Cache-Control", "max-age=3600")
+			http.Error(w, "event deleted", http.StatusGone)
+			return
+		default:
+			w.Header().Set("Cache-Control", "max-age=60")
+			http.Error(w, "event not allowed", http.StatusNotFound)
+			return
+		}
+		if s.ExpiredEventsGone && ee.isExpired() {
+			w.Header().Set("Cache-Control", "max-age=3600")
+			http.Error(w, "event expired", http.StatusGone)
+			return
+		}
+
+		raw := newRawEvent(evt, relays)
+		w.Header().Set("Cache-Control", cacheControlForKind(evt.Kind))
+		if r.URL.Query().Get("format") == "serialized" {
+			if raw.Serialized == "" {
+				http.Error(w, "this event is redacted", http.StatusForbidden)
+				return
+			}
+			// just the exact bytes, for piping into sha256sum and such
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write([]byte(raw.Serialized))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		enc.Encode(raw)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRawEventSerialization(t *testing.T) {
+	evt := &nostr.Event{
+		Kind:      1,
+		CreatedAt: 1700000000,
+		Content:   "line\nbreak \"quoted\" \\ tab\t </script> & ünïcødé 🎉",
+		Tags:      nostr.Tags{{"p", testPubkey2, "wss://relay.example.com/"}, {"t", "nostr"}},
+	}
+	assert.NoError(t, evt.Sign(nostr.GeneratePrivateKey()))
+
+	raw := newRawEvent(evt, nil)
+	assert.Equal(t, evt.ID, raw.ID)
+	assert.Equal(t,
+		`[0,"`+evt.PubKey+`",1700000000,1,[["p","`+testPubkey2+`","wss://relay.example.com/"],["t","nostr"]],"line\nbreak \"quoted\" \\ tab\t </script> & ünïcødé 🎉"]`,
+		raw.Serialized,
+	)
+
+	hash := sha256.Sum256([]byte(raw.Serialized))
+	assert.Equal(t, evt.ID, hex.EncodeToString(hash[:]))
+	assert.Equal(t, []string{}, raw.SeenOn)
+
+	previous := internal
+	var err error
+	internal, err = NewInternalDB(t.TempDir())
+	assert.NoError(t, err)
+	defer func() { internal = previous }()
+
+	fetch := func(ctx context.Context, code string, withRelays bool) (*nostr.Event, []string, error) {
+		assert.True(t, withRelays)
+		return evt, []string{"wss://relay.example.com/", "wss://Relay.Example.com", "relay.nostr.band", "not a relay", "wss://nos.lol"}, nil
+	}
+	get := func(fetch func(ctx context.Context, code string, withRelays bool) (*nostr.Event, []string, error), query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/njump/raw/"+evt.ID+query, nil)
+		req.SetPathValue("code", evt.ID)
+		w := httptest.NewRecorder()
+		renderRawEvent(fetch)(w, req.WithContext(withLocalOnly(req.Context())))
+		return w
+	}
+	w := get(fetch, "")
+	assert.Equal(t, cacheControlForKind(1), w.Header().Get("Cache-Control"))
+
+	var envelope struct {
+		ID     string   `json:"id"`
+		SeenOn []string `json:"seen_on"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &envelope))
+	assert.Equal(t, evt.ID, envelope.ID)
+	assert.Equal(t, []string{"wss://relay.example.com", "wss://nos.lol"}, envelope.SeenOn)
+
+	// the same moderation as the event page
+	assert.NoError(t, internal.banPubkey(evt.PubKey, "spam"))
+	assert.Equal(t, http.StatusNotFound, get(fetch, "").Code)
+	assert.NoError(t, internal.unbanPubkey(evt.PubKey))
+
+	// and the same redaction
+	wallet := &nostr.Event{Kind: 17375, CreatedAt: 1700000000, Content: "ciphertext",
+		Tags: nostr.Tags{{"mint", "https://mint.example.com"}, {"privkey", "secret"}}}
+	assert.NoError(t, wallet.Sign(nostr.GeneratePrivateKey()))
+	fetchWallet := func(ctx context.Context, code string, withRelays bool) (*nostr.Event, []string, error) {
+		return wallet, nil, nil
+	}
+	w = get(fetchWallet, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, cacheControlForKind(17375), w.Header().Get("Cache-Control"))
+	assert.Contains(t, w.Header().Get("Cache-Control"), "must-revalidate")
+	assert.NotContains(t, w.Body.String(), "ciphertext")
+	assert.NotContains(t, w.Body.String(), "secret")
+	assert.NotContains(t, w.Body.String(), `"serialized"`)
+	assert.Contains(t, w.Body.String(), "https://mint.example.com")
+	assert.Equal(t, http.StatusForbidden, get(fetchWallet, "?format=serialized").Code)
+}
@@ -4,8 +4,6 @@ import (
 	"net/http"
 	"strings"
 	"time"
-
-	"github.com/nbd-wtf/go-nostr/nip11"
 )
 
 func renderRelayPage(w http.ResponseWriter, r *http.Request) {
@@ -35,7 +33,7 @@ func renderRelayPage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// relay metadata
-	info, _ := nip11.Fetch(r.Context(), hostname)
+	info, _ := fetchRelayInfo(r.Context(), hostname)
 	if info.Name == "" {
 		info.Name = hostname
 	}
@@ -48,6 +46,9 @@ func renderRelayPage(w http.ResponseWriter, r *http.Request) {
 	renderableLastNotes := make([]EnhancedEvent, 0, limit)
 	var lastEventAt *time.Time
 	for evt := range relayLastNotes(r.Context(), hostname, limit) {
+		if blocklist.blocks(evt) {
+			continue
+		}
 		ee := NewEnhancedEvent(r.Context(), evt)
 		ee.relays = []string{"wss://" + hostname}
 		renderableLastNotes = append(renderableLastNotes, ee)
@@ -71,7 +72,7 @@ func renderRelayPage(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("content-type", "text/xml")
 		w.Write([]byte(XML_HEADER))
 		SitemapTemplate.Render(w, &SitemapPage{
-			Host:          s.Domain,
+			BaseURL:       baseURL(),
 			ModifiedAt:    lastEventAt.Format("2006-01-02T15:04:05Z07:00"),
 			LastNotes:     renderableLastNotes,
 			RelayHostname: hostname,
@@ -82,7 +83,7 @@ func renderRelayPage(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("content-type", "text/xml")
 		w.Write([]byte(XML_HEADER))
 		RSSTemplate.Render(w, &RSSPage{
-			Host:          s.Domain,
+			BaseURL:       baseURL(),
 			ModifiedAt:    lastEventAt.Format("2006-01-02T15:04:05Z07:00"),
 			LastNotes:     renderableLastNotes,
 			RelayHostname: hostname,
@@ -94,7 +95,7 @@ func renderRelayPage(w http.ResponseWriter, r *http.Request) {
 			HeadParams: HeadParams{IsProfile: false},
 			Info:       info,
 			Hostname:   hostname,
-			Proxy:      "https://" + hostname + "/njump/proxy?src=",
+			Proxy:      baseURL() + "/njump/proxy?src=",
 			LastNotes:  renderableLastNotes,
 			ModifiedAt: lastEventAt.Format("2006-01-02T15:04:05Z07:00"),
 			Clients:    generateClientList(-1, hostname),
@@ -32,8 +32,8 @@ Disallow: /
 User-agent: *
 Allow: /
 
-Sitemap: https://%s/npubs-archive.xml
-Sitemap: https://%s/npubs-sitemaps.xml
-Sitemap: https://%s/relays-archive.xml
-`, s.Domain, s.Domain, s.Domain)
+Sitemap: %s/npubs-archive.xml
+Sitemap: %s/npubs-sitemaps.xml
+Sitemap: %s/relays-archive.xml
+`, baseURL(), baseURL(), baseURL())
 }
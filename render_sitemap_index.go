@@ -26,7 +26,7 @@ func renderSitemapIndex(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("content-type", "text/xml")
 	w.Write([]byte(XML_HEADER))
 	SitemapIndexTemplate.Render(w, &SitemapIndexPage{
-		Host:  s.Domain,
-		Npubs: npubs,
+		BaseURL: baseURL(),
+		Npubs:   npubs,
 	})
 }
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// ThreadSummary is how many replies a note got and from how many different people
+type ThreadSummary struct {
+	Replies      int
+	Participants []string
+}
+
+func (ts ThreadSummary) String() string {
+	replies, people := "replies", "people"
+	if ts.Replies == 1 {
+		replies = "reply"
+	}
+	if len(ts.Participants) == 1 {
+		people = "person"
+	}
+	return fmt.Sprintf("%d %s from %d %s", ts.Replies, replies, len(ts.Participants), people)
+}
+
+// replyAggregator counts the replies to an event
+type replyAggregator struct {
+	// fetch may return anything referencing the event, the summary sorts out what is a reply
+	fetch func(ctx context.Context, id string) []*nostr.Event
+}
+
+var replyCounts = replyAggregator{fetch: fetchReplies}
+
+func (ra replyAggregator) summarize(ctx context.Context, id string) ThreadSummary {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*2)
+	defer cancel()
+
+	return summarizeReplies(id, ra.fetch(ctx, id))
+}
+
+func summarizeReplies(id string, events []*nostr.Event) ThreadSummary {
+	summary := ThreadSummary{}
+	seen := make(map[string]struct{}, len(events))
+	participants := make(map[string]struct{})
+
+	for _, evt := range events {
+		if evt.ID == id || !isReplyTo(evt, id) {
+			continue
+		}
+		if _, ok := seen[evt.ID]; ok {
+			continue
+		}
+		seen[evt.ID] = struct{}{}
+
+		summary.Replies++
+		if _, ok := participants[evt.PubKey]; !ok {
+			participants[evt.PubKey] = struct{}{}
+			summary.Participants = append(summary.Participants, evt.PubKey)
+		}
+	}
+
+	return summary
+}
+
+// isReplyTo tells if the event is a note (NIP-10) or a comment (NIP-22) somewhere in the thread under id,
+// notes that only mention it are not replies
+func isReplyTo(evt *nostr.Event, id string) bool {
+	switch evt.Kind {
+	case 1:
+		for tag := range evt.Tags.FindAll("e") {
+			if tag[1] == id && (len(tag) < 4 || tag[3] != "mention") {
+				return true
+			}
+		}
+	case 1111:
+		return evt.Tags.FindWithValue("E", id) != nil || evt.Tags.FindWithValue("e", id) != nil
+	}
+	return false
+}
+
+// replyRefresh keeps the background fetches for reply counts to one per event every so often
+var replyRefresh = newRelayRefresher(time.Minute*10, time.Second*10, 8)
+
+// fetchReplies reads the replies we have locally and asks the relays the event was seen on for more in
+// the background, unlike fetchThreadReplies, as the count is only an aside on the note page
+func fetchReplies(ctx context.Context, id string) []*nostr.Event {
+	filters := nostr.Filters{
+		{Kinds: []int{1, 1111}, Tags: nostr.TagMap{"e": []string{id}}, Limit: 500},
+		{Kinds: []int{1111}, Tags: nostr.TagMap{"E": []string{id}}, Limit: 500},
+	}
+
+	var events []*nostr.Event
+	for _, filter := range filters {
+		res, _ := sys.StoreRelay.QuerySync(ctx, filter)
+		events = append(events, res...)
+	}
+
+	replyRefresh.refresh(id, func(ctx context.Context) {
+		relays := internal.getRelaysForEvent(id)
+		for len(relays) < 3 {
+			relays = appendUnique(relays, sys.FallbackRelays.Next())
+		}
+		for _, filter := range filters {
+			for ie := range sys.Pool.FetchMany(ctx, relays, filter, nostr.WithLabel("replies")) {
+				sys.Store.SaveEvent(ctx, ie.Event)
+			}
+		}
+	})
+
+	return events
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThreadSummary(t *testing.T) {
+	const noteID = "3406a4f6bd8ee2c4a0bdcb6e7d9ff76a8b0c5fcaa3f6f2a1fdc6ab0f5cde6c6e"
+	events := []*nostr.Event{
+		{ID: "r1", PubKey: testPubkey1, Kind: 1, Tags: nostr.Tags{{"e", noteID, "", "root"}}},
+		{ID: "r1", PubKey: testPubkey1, Kind: 1, Tags: nostr.Tags{{"e", noteID, "", "root"}}}, // the same reply from another relay
+		{ID: "r2", PubKey: testPubkey2, Kind: 1, Tags: nostr.Tags{{"e", noteID}}},
+		{ID: "r3", PubKey: testPubkey1, Kind: 1, Tags: nostr.Tags{{"e", noteID, "", "root"}, {"e", "r2", "", "reply"}}},
+		{ID: "c1", PubKey: testPubkey2, Kind: 1111, Tags: nostr.Tags{{"E", noteID}, {"e", noteID}}},
+		{ID: "q1", PubKey: testPubkey2, Kind: 1, Tags: nostr.Tags{{"e", noteID, "", "mention"}}},
+		{ID: "x1", PubKey: testPubkey2, Kind: 7, Tags: nostr.Tags{{"e", noteID}}},
+	}
+	aggregator := replyAggregator{fetch: func(ctx context.Context, id string) []*nostr.Event {
+		assert.Equal(t, noteID, id)
+		return events
+	}}
+
+	summary := aggregator.summarize(context.Background(), noteID)
+	assert.Equal(t, 4, summary.Replies)
+	assert.Equal(t, []string{testPubkey1, testPubkey2}, summary.Participants)
+	assert.Equal(t, "4 replies from 2 people", summary.String())
+	assert.Equal(t, "1 reply from 1 person", summarizeReplies(noteID, events[:1]).String())
+
+	render := func(summary ThreadSummary) *goquery.Document {
+		var buf bytes.Buffer
+		params := NotePageParams{Replies: summary}
+		params.Event = testEnhancedEvent(&nostr.Event{ID: noteID, Kind: 1, Content: "gm"})
+		assert.NoError(t, noteInnerBlock(params).Render(context.Background(), &buf))
+		doc, err := goquery.NewDocumentFromReader(&buf)
+		assert.NoError(t, err)
+		return doc
+	}
+	assert.Equal(t, "4 replies from 2 people", render(summary).Find(".thread-summary").Text())
+	assert.Equal(t, 0, render(summarizeReplies(noteID, nil)).Find(".thread-summary").Length())
+}
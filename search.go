@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/fiatjaf/eventstore"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/sdk"
+)
+
+const searchPageSize = 20
+
+// searchableKinds are the kinds whose content goes into the search index
+var searchableKinds = []int{1, 20, 1111, 9802, 30023}
+
+// searchIndex keeps in memory the cached events we can search through and the names of their authors,
+// it is fed by the event store as things are saved and only holds the last max events and profiles it got
+type searchIndex struct {
+	max int
+
+	mu           sync.RWMutex
+	events       map[string]indexedEvent
+	order        []string
+	profiles     map[string]indexedProfile
+	profileOrder []string
+}
+
+// indexedEvent is an event in the search index with its content lowercased once for all searches
+type indexedEvent struct {
+	*nostr.Event
+	content string
+}
+
+// indexedProfile is a profile in the search index with its names lowercased once for all searches
+type indexedProfile struct {
+	sdk.ProfileMetadata
+	names string
+}
+
+var search = newSearchIndex(10000)
+
+func newSearchIndex(max int) *searchIndex {
+	return &searchIndex{
+		max:      max,
+		events:   make(map[string]indexedEvent),
+		profiles: make(map[string]indexedProfile),
+	}
+}
+
+func (si *searchIndex) add(evt *nostr.Event) {
+	if si.max <= 0 {
+		return
+	}
+
+	si.mu.Lock()
+	defer si.mu.Unlock()
+
+	switch {
+	case evt.Kind == 0:
+		if existing, ok := si.profiles[evt.PubKey]; ok {
+			if existing.Event.CreatedAt >= evt.CreatedAt {
+				return
+			}
+		} else {
+			si.profileOrder = append(si.profileOrder, evt.PubKey)
+		}
+		profile := parseProfileMetadata(evt)
+		si.profiles[evt.PubKey] = indexedProfile{profile, strings.ToLower(profile.Name + "\n" + profile.DisplayName)}
+		for len(si.profiles) > si.max {
+			delete(si.profiles, si.profileOrder[0])
+			si.profileOrder = si.profileOrder[1:]
+		}
+	case evt.Kind == nostr.KindDeletion:
+		// only what is already indexed is removed, an event arriving after its deletion stays
+		deletion := []*nostr.Event{evt}
+		for id, indexed := range si.events {
+			if indexed.PubKey == evt.PubKey && isDeleted(indexed.Event, deletion) {
+				delete(si.events, id)
+			}
+		}
+	case slices.Contains(searchableKinds, evt.Kind):
+		if _, ok := si.events[evt.ID]; ok || hasProhibitedWordOrTag(evt) {
+			return
+		}
+		si.events[evt.ID] = indexedEvent{evt, strings.ToLower(evt.Content)}
+		si.order = append(si.order, evt.ID)
+		// ids of events removed in the meantime may still be in the order, deleting them again is harmless
+		for len(si.events) > si.max {
+			delete(si.events, si.order[0])
+			si.order = si.order[1:]
+		}
+	}
+}
+
+func (si *searchIndex) remove(evt *nostr.Event) {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+
+	delete(si.events, evt.ID)
+	if existing, ok := si.profiles[evt.PubKey]; ok && existing.Event.ID == evt.ID {
+		delete(si.profiles, evt.PubKey)
+	}
+}
+
+// search returns a page of the events matching the query, newest first, with the profiles of their authors
+// (when we have them), and how many events matched in total
+func (si *searchIndex) search(query searchQuery, offset int, limit int) ([]EnhancedEvent, int) {
+	if len(query.terms) == 0 {
+		return nil, 0
+	}
+
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+
+	matches := make([]*nostr.Event, 0, limit)
+	for _, evt := range si.events {
+		if query.kind != 0 && evt.Kind != query.kind {
+			continue
+		}
+		if blocklist.blocks(evt.Event) || !query.matches(evt, si.profiles[evt.PubKey]) {
+			continue
+		}
+		// only for the matches, as these are lookups in the database
+		if banned, _ := internal.isBannedEvent(evt.ID); banned {
+			continue
+		}
+		if banned, _ := internal.isBannedPubkey(evt.PubKey); banned {
+			continue
+		}
+		matches = append(matches, evt.Event)
+	}
+
+	slices.SortFunc(matches, func(a, b *nostr.Event) int {
+		if a.CreatedAt != b.CreatedAt {
+			return int(b.CreatedAt - a.CreatedAt)
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	if offset >= len(matches) {
+		return nil, len(matches)
+	}
+	page := matches[offset:min(offset+limit, len(matches))]
+
+	results := make([]EnhancedEvent, len(page))
+	for i, evt := range page {
+		author, ok := si.profiles[evt.PubKey]
+		if !ok {
+			author.ProfileMetadata = sdk.ProfileMetadata{PubKey: evt.PubKey}
+		}
+		results[i] = enhanceEvent(evt, author.ProfileMetadata)
+	}
+	return results, len(matches)
+}
+
+// searchQuery is what we make of the ?q= text: words that must all be found, in the content or in the
+// author's names, "quoted phrases" that must be found as they are, and kind:N to look only at one kind
+type searchQuery struct {
+	terms []string
+	kind  int
+}
+
+func parseSearchQuery(q string) searchQuery {
+	query := searchQuery{}
+
+	for q = strings.TrimSpace(q); q != ""; q = strings.TrimSpace(q) {
+		var term string
+		if q[0] == '"' {
+			if end := strings.IndexByte(q[1:], '"'); end != -1 {
+				term, q = q[1:end+1], q[end+2:]
+			} else {
+				term, q = q[1:], ""
+			}
+		} else {
+			if end := strings.IndexFunc(q, unicode.IsSpace); end != -1 {
+				term, q = q[:end], q[end:]
+			} else {
+				term, q = q, ""
+			}
+			if k, ok := strings.CutPrefix(term, "kind:"); ok {
+				if kind, err := strconv.Atoi(k); err == nil && kind > 0 {
+					query.kind = kind
+					continue
+				}
+			}
+		}
+
+		if term = strings.ToLower(strings.TrimSpace(term)); term != "" {
+			query.terms = append(query.terms, term)
+		}
+	}
+
+	return query
+}
+
+func (query searchQuery) matches(evt indexedEvent, author indexedProfile) bool {
+	for _, term := range query.terms {
+		if !strings.Contains(evt.content, term) && !strings.Contains(author.names, term) && !hasHashtag(evt.Event, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasHashtag tells if a "#term" is in the event's "t" tags, which don't always appear in the content
+func hasHashtag(evt *nostr.Event, term string) bool {
+	hashtag, ok := strings.CutPrefix(term, "#")
+	if !ok || hashtag == "" {
+		return false
+	}
+	for tag := range evt.Tags.FindAll("t") {
+		if strings.EqualFold(strings.TrimPrefix(tag[1], "#"), hashtag) {
+			return true
+		}
+	}
+	return false
+}
+
+// hashtagURL is where a hashtag links to, the search for it, or nowhere if there is no search
+func hashtagURL(hashtag string) string {
+	if search.max <= 0 {
+		return ""
+	}
+	return "/search?" + (url.Values{"q": {"#" + hashtag}}).Encode()
+}
+
+// indexedStore is the event store with everything that goes in and out of it mirrored in the search index
+type indexedStore struct {
+	eventstore.Store
+	index *searchIndex
+}
+
+func (is indexedStore) SaveEvent(ctx context.Context, evt *nostr.Event) error {
+	err := is.Store.SaveEvent(ctx, evt)
+	if err == nil {
+		is.index.add(evt)
+	}
+	return err
+}
+
+func (is indexedStore) ReplaceEvent(ctx context.Context, evt *nostr.Event) error {
+	err := is.Store.ReplaceEvent(ctx, evt)
+	if err == nil {
+		is.index.add(evt)
+	}
+	return err
+}
+
+func (is indexedStore) DeleteEvent(ctx context.Context, evt *nostr.Event) error {
+	err := is.Store.DeleteEvent(ctx, evt)
+	if err == nil {
+		is.index.remove(evt)
+	}
+	return err
+}
+
+// loadSearchIndex fills the index with what was already in the store from previous runs
+func loadSearchIndex(ctx context.Context, store eventstore.Store, index *searchIndex) {
+	for _, filter := range []nostr.Filter{
+		{Kinds: []int{0}, Limit: index.max},
+		{Kinds: searchableKinds, Limit: index.max},
+		// after the events, so the deletions find them
+		{Kinds: []int{nostr.KindDeletion}, Limit: index.max},
+	} {
+		ch, err := store.QueryEvents(ctx, filter)
+		if err != nil {
+			log.Warn().Err(err).Msg("failed to load search index")
+			continue
+		}
+		for evt := range ch {
+			index.add(evt)
+		}
+	}
+}
+
+func renderSearch(w http.ResponseWriter, r *http.Request) {
+	if search.max <= 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	params := SearchPageParams{
+		HeadParams: HeadParams{NoIndex: true},
+		Query:      q,
+		Page:       page,
+	}
+	if q != "" {
+		params.Results, params.Total = search.search(parseSearchQuery(q), (page-1)*searchPageSize, searchPageSize)
+		params.HasNext = page*searchPageSize < params.Total
+	}
+
+	w.Header().Set("Cache-Control", "max-age=60")
+	err := searchTemplate(params).Render(r.Context(), w)
+	if err != nil {
+		log.Warn().Err(err).Msg("error rendering tmpl")
+	}
+}
+
+func (sp SearchPageParams) PageURL(page int) string {
+	return "/search?" + (url.Values{"q": {sp.Query}, "page": {strconv.Itoa(page)}}).Encode()
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearch(t *testing.T) {
+	var err error
+	previousInternal := internal
+	internal, err = NewInternalDB(t.TempDir())
+	assert.NoError(t, err)
+	defer func() { internal = previousInternal }()
+
+	index := newSearchIndex(100)
+	index.add(&nostr.Event{Kind: 0, PubKey: testPubkey2, CreatedAt: 1700000000, Content: `{"name": "alice", "display_name": "Alice Wonder"}`})
+	for i := 0; i < 25; i++ {
+		index.add(&nostr.Event{ID: fmt.Sprintf("%064x", i), Kind: 1, PubKey: testPubkey1, CreatedAt: nostr.Timestamp(1710000000 + i), Content: fmt.Sprintf("bitcoin pizza day number %d", i)})
+	}
+	index.add(&nostr.Event{ID: fmt.Sprintf("%064x", 100), Kind: 1, PubKey: testPubkey2, CreatedAt: 1710000000, Content: "gm"})
+	index.add(&nostr.Event{ID: fmt.Sprintf("%064x", 101), Kind: 30023, PubKey: testPubkey2, CreatedAt: 1710000001, Content: "a long pizza recipe"})
+	index.add(&nostr.Event{ID: fmt.Sprintf("%064x", 102), Kind: 7, PubKey: testPubkey2, CreatedAt: 1710000002, Content: "pizza"})
+
+	previous := search
+	search = index
+	defer func() { search = previous }()
+
+	find := func(target string) *goquery.Document {
+		w := httptest.NewRecorder()
+		renderSearch(w, httptest.NewRequest("GET", target, nil))
+		doc, err := goquery.NewDocumentFromReader(w.Body)
+		assert.NoError(t, err)
+		return doc
+	}
+
+	// content, newest first
+	doc := find("/search?q=Bitcoin+PIZZA")
+	assert.Equal(t, searchPageSize, doc.Find(".search-result").Length())
+	assert.Contains(t, doc.Find(".search-result").First().Text(), "number 24")
+	assert.Contains(t, doc.Find(".search-summary").Text(), "25 cached events found")
+	assert.Equal(t, 0, doc.Find(".search-prev").Length())
+	assert.Equal(t, "/search?page=2&q=Bitcoin+PIZZA", doc.Find(".search-next").AttrOr("href", ""))
+
+	// the last page has what's left and nothing after it
+	doc = find("/search?q=Bitcoin+PIZZA&page=2")
+	assert.Equal(t, 5, doc.Find(".search-result").Length())
+	assert.Contains(t, doc.Find(".search-result").Last().Text(), "number 0")
+	assert.Equal(t, 1, doc.Find(".search-prev").Length())
+	assert.Equal(t, 0, doc.Find(".search-next").Length())
+
+	doc = find("/search?q=Bitcoin+PIZZA&page=3")
+	assert.Equal(t, 0, doc.Find(".search-result").Length())
+	assert.Equal(t, 0, doc.Find(".search-next").Length())
+
+	doc = find("/search?q=Bitcoin+PIZZA&page=-1")
+	assert.Equal(t, searchPageSize, doc.Find(".search-result").Length())
+
+	// author names, reactions aren't searchable
+	doc = find("/search?q=wonder")
+	assert.Equal(t, 2, doc.Find(".search-result").Length())
+	assert.Contains(t, doc.Find(".search-result").First().Text(), "Alice Wonder")
+
+	doc = find("/search?q=alice+pizza")
+	assert.Equal(t, 1, doc.Find(".search-result").Length())
+	assert.Contains(t, doc.Find(".search-result").Text(), "long pizza recipe")
+
+	// phrases and kinds
+	assert.Equal(t, searchQuery{terms: []string{"pizza day", "bitcoin"}, kind: 1}, parseSearchQuery(` "Pizza Day" kind:1 bitcoin`))
+	results, total := index.search(parseSearchQuery(`"pizza day" kind:30023`), 0, searchPageSize)
+	assert.Empty(t, results)
+	assert.Equal(t, 0, total)
+	_, total = index.search(parseSearchQuery(`"day number 2"`), 0, searchPageSize)
+	assert.Equal(t, 6, total) // 2, 20..24
+
+	// drafts, deleted, banned and prohibited events are not found
+	// the ids differ at the start, as bans only look at the first bytes
+	calzoneID := func(n int) string { return fmt.Sprintf("%04d", n) + strings.Repeat("0", 60) }
+	banned := "ee11a5dff40c19a555f41fe42b48f00e618c91225622ae37b6c2bb67b76c4e49"
+	index.add(&nostr.Event{ID: calzoneID(200), Kind: 30024, PubKey: testPubkey2, CreatedAt: 1710000003, Content: "secret calzone"})
+	index.add(&nostr.Event{ID: calzoneID(201), Kind: 1, PubKey: testPubkey2, CreatedAt: 1710000004, Content: "deleted calzone"})
+	index.add(&nostr.Event{ID: calzoneID(202), Kind: 1, PubKey: testPubkey2, CreatedAt: 1710000005, Content: "banned calzone"})
+	index.add(&nostr.Event{ID: calzoneID(203), Kind: 1, PubKey: banned, CreatedAt: 1710000006, Content: "banned author calzone"})
+	index.add(&nostr.Event{ID: calzoneID(204), Kind: 1, PubKey: testPubkey2, CreatedAt: 1710000007, Content: "calzone", Tags: nostr.Tags{{"t", "nsfw"}}})
+	index.add(&nostr.Event{ID: calzoneID(205), Kind: 1, PubKey: testPubkey2, CreatedAt: 1710000008, Content: "good calzone"})
+	_, total = index.search(parseSearchQuery("calzone"), 0, searchPageSize)
+	assert.Equal(t, 4, total)
+
+	// deletions from someone else don't count
+	index.add(&nostr.Event{Kind: 5, PubKey: testPubkey1, Tags: nostr.Tags{{"e", calzoneID(201)}}})
+	index.add(&nostr.Event{Kind: 5, PubKey: testPubkey2, Tags: nostr.Tags{{"e", calzoneID(201)}}})
+	assert.NoError(t, internal.banEvent(calzoneID(202), "spam"))
+	assert.NoError(t, internal.banPubkey(banned, "spam"))
+	results, total = index.search(parseSearchQuery("calzone"), 0, searchPageSize)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, "good calzone", results[0].Content)
+
+	// an empty query finds nothing
+	doc = find("/search?q=")
+	assert.Equal(t, 0, doc.Find(".search-result").Length())
+	assert.Equal(t, 0, doc.Find(".search-summary").Length())
+}
+
+func TestHashtagList(t *testing.T) {
+	render := func(tags nostr.Tags) *goquery.Selection {
+		note := NotePageParams{
+			BaseEventPageParams: BaseEventPageParams{Event: testEnhancedEvent(&nostr.Event{Kind: 1, Content: "hello", Tags: tags})},
+		}
+		var buf bytes.Buffer
+		assert.NoError(t, noteInnerBlock(note).Render(context.Background(), &buf))
+		doc, err := goquery.NewDocumentFromReader(&buf)
+		assert.NoError(t, err)
+		return doc.Find(".hashtags")
+	}
+
+	hashtags := render(nostr.Tags{{"t", "Pickles"}, {"t", "fermentation"}, {"t", "pickles"}, {"t", "#fermentation"}, {"t", " "}})
+	links := hashtags.Find("a.hashtag")
+	assert.Equal(t, 2, links.Length())
+	assert.Equal(t, "#pickles", links.First().Text())
+	assert.Equal(t, "/search?q=%23pickles", links.First().AttrOr("href", ""))
+	assert.Equal(t, "#fermentation", links.Last().Text())
+
+	assert.Equal(t, 0, render(nostr.Tags{{"p", testPubkey1}}).Length())
+
+	// the search finds events by the tags they have even if they're not in the text
+	assert.True(t, hasHashtag(&nostr.Event{Tags: nostr.Tags{{"t", "Pickles"}}}, "#pickles"))
+	assert.False(t, hasHashtag(&nostr.Event{Tags: nostr.Tags{{"t", "pickles"}}}, "pickles"))
+
+	// without a search to go to they're just text
+	defer func(previous *searchIndex) { search = previous }(search)
+	search = newSearchIndex(0)
+	hashtags = render(nostr.Tags{{"t", "pickles"}})
+	assert.Equal(t, 0, hashtags.Find("a").Length())
+	assert.Equal(t, "#pickles", hashtags.Find(".hashtag").Text())
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"net/http"
+	"strings"
+
+	"fiatjaf.com/leafdb"
+	"google.golang.org/protobuf/proto"
+)
+
+// ShortLinkStore is where the short ids are kept, xsync.MapOf satisfies it
+// but anything persistent can be plugged in
+type ShortLinkStore interface {
+	Load(id string) (code string, ok bool)
+	LoadOrStore(id string, code string) (actual string, loaded bool)
+}
+
+// internalShortLinks keeps the short links in the internal db, so they survive restarts
+type internalShortLinks struct {
+	*InternalDB
+}
+
+func (isl internalShortLinks) Load(id string) (string, bool) {
+	for value := range isl.DB.Query(leafdb.ExactQuery("short-link", []byte(id))) {
+		return value.(*ShortLink).Code, true
+	}
+	return "", false
+}
+
+func (isl internalShortLinks) LoadOrStore(id string, code string) (actual string, loaded bool) {
+	actual = code
+	if _, err := isl.DB.Upsert("short-link", []byte(id), TypeShortLink, func(t leafdb.DataType, value proto.Message) (proto.Message, error) {
+		if value != nil {
+			actual = value.(*ShortLink).Code
+			loaded = true
+			return value, nil
+		}
+		return &ShortLink{Id: id, Code: code}, nil
+	}); err != nil {
+		log.Warn().Err(err).Str("id", id).Msg("failed to store short link")
+		return "", false
+	}
+	return actual, loaded
+}
+
+type ShortLinks struct {
+	store ShortLinkStore
+}
+
+var shortLinks *ShortLinks
+
+const minShortIDLength = 6
+
+var shortIDEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+func newShortLinks(store ShortLinkStore) *ShortLinks {
+	return &ShortLinks{store: store}
+}
+
+// ShortenCode returns a short id that resolves to the given code, the same code always gets the same id
+// and when the id is already taken by another code we use a longer prefix of the hash
+func (sl *ShortLinks) ShortenCode(code string) string {
+	hash := sha256.Sum256([]byte(code))
+	full := shortIDEncoding.EncodeToString(hash[:])
+
+	for n := minShortIDLength; n <= len(full); n++ {
+		id := full[:n]
+		if actual, _ := sl.store.LoadOrStore(id, code); actual == code {
+			return id
+		}
+	}
+
+	return ""
+}
+
+func (sl *ShortLinks) resolve(id string) (string, bool) {
+	if len(id) < minShortIDLength {
+		return "", false
+	}
+	return sl.store.Load(strings.ToLower(id))
+}
+
+func renderShortLink(w http.ResponseWriter, r *http.Request) {
+	if shortLinks == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	code, ok := shortLinks.resolve(r.PathValue("id"))
+	if !ok {
+		w.Header().Set("Cache-Control", "max-age=60")
+		http.Error(w, "unknown short link", http.StatusNotFound)
+		return
+	}
+
+	http.Redirect(w, r, "/"+code, http.StatusFound)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/puzpuzpuz/xsync/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShortLinksRoundTrip(t *testing.T) {
+	shortLinks = newShortLinks(xsync.NewMapOf[string, string]())
+	defer func() { shortLinks = nil }()
+
+	const naddr = "naddr1qqxnzd3cxqmrzv3exgmr2wfeqgsxu35yyt0mwjjh8pcz4zprhxegz69t4wr9t74vk6zne58wzh0waycrqsqqqa28pjfdhz"
+	id := shortLinks.ShortenCode(naddr)
+	assert.Len(t, id, minShortIDLength)
+	assert.Equal(t, id, shortLinks.ShortenCode(naddr))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/n/"+id, nil)
+	r.SetPathValue("id", id)
+	renderShortLink(w, r)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "/"+naddr, w.Header().Get("Location"))
+}
+
+func TestShortLinksCollision(t *testing.T) {
+	store := xsync.NewMapOf[string, string]()
+	sl := newShortLinks(store)
+
+	// someone else already has the short form of this code
+	const nevent = "nevent1qqsrhuxx8l9ex335q7he0f09aej04zpazpl0ne2cgukyawd24mayt8gzyqewrqnkx4zsaweutf739s0cu7et29zrntqs5elw70vlm8zudr3y2t9v7jg"
+	id := sl.ShortenCode(nevent)
+	store.Clear()
+	store.Store(id, "note1other")
+
+	longer := sl.ShortenCode(nevent)
+	assert.Len(t, longer, minShortIDLength+1)
+	code, ok := sl.resolve(longer)
+	assert.True(t, ok)
+	assert.Equal(t, nevent, code)
+	code, _ = sl.resolve(id)
+	assert.Equal(t, "note1other", code)
+}
+
+func TestShortLinksUnknown(t *testing.T) {
+	shortLinks = newShortLinks(xsync.NewMapOf[string, string]())
+	defer func() { shortLinks = nil }()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/n/abcdefg", nil)
+	r.SetPathValue("id", "abcdefg")
+	renderShortLink(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestShortLinksSurviveRestarts(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewInternalDB(dir)
+	assert.NoError(t, err)
+
+	const nevent = "nevent1qqsrhuxx8l9ex335q7he0f09aej04zpazpl0ne2cgukyawd24mayt8gzyqewrqnkx4zsaweutf739s0cu7et29zrntqs5elw70vlm8zudr3y2t9v7jg"
+	id := newShortLinks(internalShortLinks{db}).ShortenCode(nevent)
+	assert.Len(t, id, minShortIDLength)
+	assert.NoError(t, db.Close())
+
+	db, err = NewInternalDB(dir)
+	assert.NoError(t, err)
+	defer db.Close()
+	sl := newShortLinks(internalShortLinks{db})
+	code, ok := sl.resolve(id)
+	assert.True(t, ok)
+	assert.Equal(t, nevent, code)
+	assert.Equal(t, id, sl.ShortenCode(nevent))
+
+	// taken ids are kept for the code that has them
+	store := internalShortLinks{db}
+	actual, loaded := store.LoadOrStore(id, "note1other")
+	assert.True(t, loaded)
+	assert.Equal(t, nevent, actual)
+	_, ok = sl.resolve("zzzzzzz")
+	assert.False(t, ok)
+}
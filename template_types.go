@@ -1,10 +1,20 @@
 package main
 
 import (
+	"fmt"
+	"html/template"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/nip22"
 	"github.com/nbd-wtf/go-nostr/nip52"
 	"github.com/nbd-wtf/go-nostr/nip53"
+	"github.com/nbd-wtf/go-nostr/nip73"
 	"github.com/nbd-wtf/go-nostr/nip94"
 	"github.com/nbd-wtf/go-nostr/sdk"
 )
@@ -25,10 +35,71 @@ func (le Kind30311Metadata) title() string {
 	return le.Title
 }
 
+// LiveEventContext is the live event a chat message was sent to, Title is empty when we couldn't find it
+type LiveEventContext struct {
+	Code   string
+	Title  string
+	Status string
+}
+
 type Kind31922Or31923Metadata struct {
 	nip52.CalendarEvent
 }
 
+// Location is where the event happens, as the first location tag says
+func (calev Kind31922Or31923Metadata) Location() string {
+	if len(calev.Locations) == 0 {
+		return ""
+	}
+	return calev.Locations[0]
+}
+
+// MapURL is a link to where the first geohash of the event is on a map
+func (calev Kind31922Or31923Metadata) MapURL() string {
+	if len(calev.Geohashes) == 0 {
+		return ""
+	}
+	lat, lon, ok := decodeGeohash(calev.Geohashes[0])
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("https://www.openstreetmap.org/?mlat=%.5f&mlon=%.5f#map=15/%.5f/%.5f", lat, lon, lat, lon)
+}
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// decodeGeohash returns the center of the area a geohash covers
+func decodeGeohash(geohash string) (lat float64, lon float64, ok bool) {
+	if geohash == "" {
+		return 0, 0, false
+	}
+
+	latRange, lonRange := [2]float64{-90, 90}, [2]float64{-180, 180}
+	even := true
+	for _, c := range strings.ToLower(geohash) {
+		idx := strings.IndexRune(geohashAlphabet, c)
+		if idx == -1 {
+			return 0, 0, false
+		}
+		// the bits alternate between longitude and latitude, starting with longitude
+		for bit := 4; bit >= 0; bit-- {
+			r := &latRange
+			if even {
+				r = &lonRange
+			}
+			mid := (r[0] + r[1]) / 2
+			if idx&(1<<bit) != 0 {
+				r[0] = mid
+			} else {
+				r[1] = mid
+			}
+			even = !even
+		}
+	}
+
+	return (latRange[0] + latRange[1]) / 2, (lonRange[0] + lonRange[1]) / 2, true
+}
+
 type Kind30818Metadata struct {
 	Handle      string
 	Title       string
@@ -36,6 +107,640 @@ type Kind30818Metadata struct {
 	PublishedAt time.Time
 }
 
+func parseKind30818Metadata(event nostr.Event) Kind30818Metadata {
+	wiki := Kind30818Metadata{Handle: event.Tags.GetD()}
+	for _, tag := range event.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+
+		switch tag[0] {
+		case "title":
+			wiki.Title = strings.TrimSpace(tag[1])
+		case "summary":
+			wiki.Summary = tag[1]
+		case "published_at":
+			if ts, err := strconv.ParseInt(tag[1], 10, 64); err == nil {
+				wiki.PublishedAt = time.Unix(ts, 0)
+			}
+		}
+	}
+	if wiki.Title == "" {
+		// the d tag is the normalized title: lowercase with dashes instead of spaces
+		wiki.Title = strings.ReplaceAll(wiki.Handle, "-", " ")
+	}
+	if wiki.PublishedAt.IsZero() {
+		wiki.PublishedAt = event.CreatedAt.Time()
+	}
+	return wiki
+}
+
+// CommentScope is one of the things a NIP-22 comment points to, either a nostr event
+// (with Code) or something external, which can be linked if it is a URL
+type CommentScope struct {
+	Code  string
+	URL   string
+	Label string
+}
+
+func (cs CommentScope) Href() string {
+	if cs.Code != "" {
+		return "/" + cs.Code
+	}
+	return cs.URL
+}
+
+type Kind1111Metadata struct {
+	Root   *CommentScope
+	Parent *CommentScope
+}
+
+// IsTopLevel tells if the comment is directly on the root, so there is no parent to show
+func (c Kind1111Metadata) IsTopLevel() bool {
+	return c.Parent == nil || (c.Root != nil && *c.Parent == *c.Root)
+}
+
+func parseKind1111Metadata(event nostr.Event) Kind1111Metadata {
+	scope := func(pointer nostr.Pointer, kindTag string) *CommentScope {
+		if pointer == nil {
+			return nil
+		}
+
+		label := ""
+		if tag := event.Tags.Find(kindTag); tag != nil {
+			if kind, err := strconv.Atoi(tag[1]); err == nil {
+				label = kindNames[kind]
+				if label == "" {
+					label = fmt.Sprintf("kind %d event", kind)
+				}
+			} else {
+				label = tag[1]
+			}
+		}
+
+		switch p := pointer.(type) {
+		case nostr.EventPointer:
+			if !nostr.IsValid32ByteHex(p.ID) {
+				return nil
+			}
+		case nostr.EntityPointer:
+			if p.PublicKey == "" {
+				return nil
+			}
+		case nip73.ExternalPointer:
+			cs := &CommentScope{Label: p.Thing}
+			if u, err := url.Parse(p.Thing); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+				cs.URL = u.String()
+			}
+			return cs
+		}
+
+		if label == "" {
+			label = "event"
+		}
+		return &CommentScope{Code: nip19.EncodePointer(pointer), Label: label}
+	}
+
+	return Kind1111Metadata{
+		Root:   scope(nip22.GetThreadRoot(event.Tags), "K"),
+		Parent: scope(nip22.GetImmediateParent(event.Tags), "k"),
+	}
+}
+
+// BadgeDefinition is what a kind 30009 event says about a badge, Code is where it can be found
+type BadgeDefinition struct {
+	Code        string
+	Name        string
+	Description string
+	Image       string
+	Thumb       string
+}
+
+// Picture is the image to show on the badge page, the thumbnail is only used if there is nothing else
+func (bd BadgeDefinition) Picture() string {
+	if bd.Image != "" {
+		return bd.Image
+	}
+	return bd.Thumb
+}
+
+func parseKind30009Metadata(event nostr.Event) BadgeDefinition {
+	badge := BadgeDefinition{}
+	badge.Code, _ = nip19.EncodeEntity(event.PubKey, event.Kind, event.Tags.GetD(), nil)
+	for _, tag := range event.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+
+		switch tag[0] {
+		case "name":
+			badge.Name = tag[1]
+		case "description":
+			badge.Description = tag[1]
+		case "image":
+			badge.Image = tag[1]
+		case "thumb":
+			// there may be many thumbnails of different sizes, the first is enough
+			if badge.Thumb == "" {
+				badge.Thumb = tag[1]
+			}
+		}
+	}
+	if badge.Name == "" {
+		badge.Name = event.Tags.GetD()
+	}
+	return badge
+}
+
+type Kind8Metadata struct {
+	// only the Code and Name (from the d tag) are known until the definition is fetched
+	Definition BadgeDefinition
+	Awardees   []string
+}
+
+func parseKind8Metadata(event nostr.Event) Kind8Metadata {
+	award := Kind8Metadata{}
+	for tag := range event.Tags.FindAll("a") {
+		pointer, err := nostr.EntityPointerFromTag(tag)
+		if err != nil || pointer.Kind != 30009 {
+			continue
+		}
+		award.Definition = BadgeDefinition{Code: nip19.EncodePointer(pointer), Name: pointer.Identifier}
+		break
+	}
+	for tag := range event.Tags.FindAll("p") {
+		if nostr.IsValidPublicKey(tag[1]) {
+			award.Awardees = appendUnique(award.Awardees, tag[1])
+		}
+	}
+	return award
+}
+
+// Kind30008Metadata is the list of badges a profile has accepted and wants to show
+type Kind30008Metadata struct {
+	Accepted []AcceptedBadge
+}
+
+// AcceptedBadge is where the badge definition can be found and the id of the award that was accepted
+type AcceptedBadge struct {
+	Definition string
+	AwardID    string
+}
+
+func parseKind30008Metadata(event nostr.Event) Kind30008Metadata {
+	badges := Kind30008Metadata{}
+	// badges come in pairs of an a tag followed by an e tag, anything else is ignored
+	for i := 0; i < len(event.Tags)-1; i++ {
+		atag, etag := event.Tags[i], event.Tags[i+1]
+		if len(atag) < 2 || atag[0] != "a" || len(etag) < 2 || etag[0] != "e" || !nostr.IsValid32ByteHex(etag[1]) {
+			continue
+		}
+		pointer, err := nostr.EntityPointerFromTag(atag)
+		if err != nil || pointer.Kind != 30009 {
+			continue
+		}
+		code := nip19.EncodePointer(pointer)
+		if !slices.ContainsFunc(badges.Accepted, func(ab AcceptedBadge) bool { return ab.Definition == code }) {
+			badges.Accepted = append(badges.Accepted, AcceptedBadge{Definition: code, AwardID: etag[1]})
+		}
+		i++
+	}
+	return badges
+}
+
+// Kind9041Metadata is a NIP-75 zap goal, the content is what the goal is for
+type Kind9041Metadata struct {
+	ID      string
+	Title   string
+	Summary string
+	Image   string
+	// Target is in millisatoshis, as in the amount tag
+	Target   int64
+	ClosedAt nostr.Timestamp
+	Relays   []string
+}
+
+func (goal Kind9041Metadata) TargetSats() int64 {
+	return goal.Target / 1000
+}
+
+func (goal Kind9041Metadata) IsClosed() bool {
+	return goal.ClosedAt != 0 && goal.ClosedAt < nostr.Now()
+}
+
+func parseKind9041Metadata(event nostr.Event) Kind9041Metadata {
+	goal := Kind9041Metadata{ID: event.ID, Title: strings.TrimSpace(event.Content)}
+	for _, tag := range event.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+
+		switch tag[0] {
+		case "amount":
+			goal.Target, _ = strconv.ParseInt(tag[1], 10, 64)
+		case "closed_at":
+			if ts, err := strconv.ParseInt(tag[1], 10, 64); err == nil {
+				goal.ClosedAt = nostr.Timestamp(ts)
+			}
+		case "summary":
+			goal.Summary = tag[1]
+		case "image":
+			goal.Image = tag[1]
+		case "relays":
+			for _, url := range tag[1:] {
+				if nostr.IsValidRelayURL(url) {
+					goal.Relays = appendUnique(goal.Relays, nostr.NormalizeURL(url))
+				}
+			}
+		}
+	}
+	return goal
+}
+
+// ZapGoalProgress is how much a zap goal has raised so far
+type ZapGoalProgress struct {
+	Raised int64 // in sats
+	Zaps   int
+}
+
+// progress sums the zap receipts for this goal, the ones after the goal was closed don't count
+func (goal Kind9041Metadata) progress(receipts []*nostr.Event) ZapGoalProgress {
+	progress := ZapGoalProgress{}
+	seen := make(map[string]struct{}, len(receipts))
+	for _, receipt := range receipts {
+		if receipt.Kind != nostr.KindZap || receipt.Tags.FindWithValue("e", goal.ID) == nil {
+			continue
+		}
+		if goal.ClosedAt != 0 && receipt.CreatedAt > goal.ClosedAt {
+			continue
+		}
+		if _, ok := seen[receipt.ID]; ok {
+			continue
+		}
+		seen[receipt.ID] = struct{}{}
+
+		progress.Zaps++
+		progress.Raised += zapAmount(receipt) / 1000
+	}
+	return progress
+}
+
+// Percent is capped at 100 so it can be used directly in a progress bar
+func (zgp ZapGoalProgress) Percent(goal Kind9041Metadata) int {
+	if goal.TargetSats() <= 0 {
+		return 0
+	}
+	return int(min(100, zgp.Raised*100/goal.TargetSats()))
+}
+
+// Kind30617Metadata is a NIP-34 git repository announcement
+type Kind30617Metadata struct {
+	ID          string
+	Name        string
+	Description string
+	Web         []string
+	Clone       []string
+}
+
+func parseKind30617Metadata(event nostr.Event) Kind30617Metadata {
+	repo := Kind30617Metadata{ID: event.Tags.GetD()}
+	for _, tag := range event.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+
+		switch tag[0] {
+		case "name":
+			repo.Name = tag[1]
+		case "description":
+			repo.Description = tag[1]
+		case "web":
+			// these become links, so only take actual web addresses
+			for _, u := range tag[1:] {
+				if strings.HasPrefix(u, "https://") || strings.HasPrefix(u, "http://") {
+					repo.Web = appendUnique(repo.Web, u)
+				}
+			}
+		case "clone":
+			for _, u := range tag[1:] {
+				if u = strings.TrimSpace(u); u != "" {
+					repo.Clone = appendUnique(repo.Clone, u)
+				}
+			}
+		}
+	}
+	if repo.Name == "" {
+		repo.Name = repo.ID
+	}
+	return repo
+}
+
+// Kind10002Metadata is a NIP-65 relay list
+type Kind10002Metadata struct {
+	Relays []RelayListEntry
+}
+
+// RelayListEntry is one "r" tag, relays without a marker are used both for reading and writing
+type RelayListEntry struct {
+	URL   string
+	Read  bool
+	Write bool
+}
+
+func (entry RelayListEntry) Hostname() string {
+	return trimProtocolAndEndingSlash(entry.URL)
+}
+
+func parseKind10002Metadata(event nostr.Event) Kind10002Metadata {
+	list := Kind10002Metadata{}
+	index := make(map[string]int)
+	for tag := range event.Tags.FindAll("r") {
+		if !nostr.IsValidRelayURL(tag[1]) {
+			continue
+		}
+		url := nostr.NormalizeURL(tag[1])
+
+		read, write := true, true
+		if len(tag) >= 3 {
+			switch tag[2] {
+			case "read":
+				write = false
+			case "write":
+				read = false
+			}
+		}
+
+		// the same relay listed twice with different markers is used for both
+		if i, ok := index[url]; ok {
+			list.Relays[i].Read = list.Relays[i].Read || read
+			list.Relays[i].Write = list.Relays[i].Write || write
+			continue
+		}
+		index[url] = len(list.Relays)
+		list.Relays = append(list.Relays, RelayListEntry{URL: url, Read: read, Write: write})
+	}
+	return list
+}
+
+// Kind1617Metadata is a NIP-34 patch, the content is what git format-patch gives
+type Kind1617Metadata struct {
+	Subject string
+	// Repository is the naddr of the repository announcement, if the patch says what it is for
+	Repository string
+	Commit     string
+	Patch      string
+}
+
+func parseKind1617Metadata(event nostr.Event) Kind1617Metadata {
+	patch := Kind1617Metadata{Patch: event.Content}
+	for tag := range event.Tags.FindAll("a") {
+		if pointer, err := nostr.EntityPointerFromTag(tag); err == nil && pointer.Kind == 30617 {
+			patch.Repository = nip19.EncodePointer(pointer)
+			break
+		}
+	}
+	if tag := event.Tags.Find("commit"); tag != nil {
+		patch.Commit = tag[1]
+	}
+
+	// the subject is in the email headers before the diff, it may be folded over many lines
+	lines := strings.Split(event.Content, "\n")
+	for i, line := range lines {
+		if line == "" || strings.HasPrefix(line, "diff --git") {
+			break
+		}
+		if subject, ok := strings.CutPrefix(line, "Subject: "); ok {
+			for _, next := range lines[i+1:] {
+				if !strings.HasPrefix(next, " ") && !strings.HasPrefix(next, "\t") {
+					break
+				}
+				subject += " " + strings.TrimSpace(next)
+			}
+			patch.Subject = strings.TrimSpace(subject)
+			break
+		}
+	}
+	// "[PATCH 2/3] fix things" is just "fix things"
+	if rest, ok := strings.CutPrefix(patch.Subject, "["); ok {
+		if end := strings.Index(rest, "] "); end != -1 && strings.HasPrefix(strings.ToUpper(rest), "PATCH") {
+			patch.Subject = rest[end+2:]
+		}
+	}
+	if patch.Subject == "" && patch.Commit != "" {
+		patch.Subject = "commit " + patch.Commit
+	}
+	return patch
+}
+
+type Kind30402Metadata struct {
+	Title    string
+	Summary  string
+	Status   string
+	Location string
+	Price    string
+	Images   []string
+	Hashtags []string
+}
+
+func parseKind30402Metadata(event nostr.Event) Kind30402Metadata {
+	classified := Kind30402Metadata{}
+	for _, tag := range event.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+
+		switch tag[0] {
+		case "title":
+			classified.Title = tag[1]
+		case "summary":
+			classified.Summary = tag[1]
+		case "status":
+			classified.Status = tag[1]
+		case "location":
+			classified.Location = tag[1]
+		case "price":
+			// ["price", "<amount>", "<currency>", "<frequency>"]
+			classified.Price = strings.Join(tag[1:min(len(tag), 3)], " ")
+			if len(tag) >= 4 && tag[3] != "" {
+				classified.Price += " / " + tag[3]
+			}
+		case "image":
+			classified.Images = append(classified.Images, tag[1])
+		case "t":
+			classified.Hashtags = append(classified.Hashtags, tag[1])
+		}
+	}
+	if classified.Title == "" {
+		classified.Title = event.Tags.GetD()
+	}
+	return classified
+}
+
+type ReportTarget struct {
+	Code       string
+	ReportType string
+	IsProfile  bool
+}
+
+type Kind1984Metadata struct {
+	Targets []ReportTarget
+}
+
+func parseKind1984Metadata(event nostr.Event) Kind1984Metadata {
+	report := Kind1984Metadata{}
+
+	// when an event is reported the "p" tag is there just to say who the author is
+	var eventAuthor string
+	hasEventTarget := event.Tags.Find("e") != nil
+
+	for _, tag := range event.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+		reportType := ""
+		if len(tag) >= 3 {
+			reportType = tag[2]
+		}
+
+		switch tag[0] {
+		case "p":
+			if !nostr.IsValidPublicKey(tag[1]) {
+				continue
+			}
+			if hasEventTarget && reportType == "" {
+				eventAuthor = tag[1]
+				continue
+			}
+			npub, _ := nip19.EncodePublicKey(tag[1])
+			report.Targets = append(report.Targets, ReportTarget{Code: npub, ReportType: reportType, IsProfile: true})
+		case "e":
+			if !nostr.IsValid32ByteHex(tag[1]) {
+				continue
+			}
+			report.Targets = append(report.Targets, ReportTarget{Code: tag[1], ReportType: reportType})
+		}
+	}
+
+	for i, target := range report.Targets {
+		if !target.IsProfile {
+			report.Targets[i].Code, _ = nip19.EncodeEvent(target.Code, nil, eventAuthor)
+		}
+	}
+
+	return report
+}
+
+type QuotedEvent struct {
+	Code    string
+	Author  sdk.ProfileMetadata
+	Content template.HTML
+	Text    string
+}
+
+type AddressReference struct {
+	Code  string
+	Title string
+}
+
+type ProofOfWork struct {
+	Difficulty int
+	Target     int
+}
+
+// Met tells if the id has at least the difficulty the author committed to in the nonce tag
+func (pow ProofOfWork) Met() bool {
+	return pow.Target > 0 && pow.Difficulty >= pow.Target
+}
+
+// ClientCredit is the app an event says it was published with, from its NIP-89 "client" tag
+type ClientCredit struct {
+	Name string
+	// Code is the naddr of the app's kind 31990 handler information, when the tag has it
+	Code string
+}
+
+// BridgedOrigin is where an event bridged from another network came from, as in its NIP-48 "proxy" tag
+type BridgedOrigin struct {
+	Protocol string
+	// URL is the original on the web, when the id in the tag can be turned into one
+	URL string
+}
+
+var bridgeProtocolNames = map[string]string{
+	"activitypub": "ActivityPub",
+	"atproto":     "AT Protocol",
+	"rss":         "RSS",
+	"web":         "the web",
+}
+
+func (bo BridgedOrigin) ProtocolName() string {
+	if name, ok := bridgeProtocolNames[bo.Protocol]; ok {
+		return name
+	}
+	return bo.Protocol
+}
+
+type EncryptedMetadata struct {
+	Label      string
+	Recipients []sdk.ProfileMetadata
+	// DraftOf is the kind being drafted, for NIP-37 drafts, which only their author can read
+	DraftOf string
+	// GiftWrap is for NIP-59 gift wraps, which are signed by a throwaway key so even the sender is hidden
+	GiftWrap bool
+	// Wallet is for NIP-60 cashu wallets and their tokens, of these we only ever show the Mints they use
+	Wallet bool
+	Mints  []string
+}
+
+// sensitiveWalletTags are the tags with which a NIP-60 wallet could be spent if they are not encrypted
+var sensitiveWalletTags = []string{"privkey", "proof", "proofs", "secret", "token"}
+
+// parseWalletMints gets the mints from the public "mint" tags of a NIP-60 wallet, only the http ones as
+// anything else isn't a mint we can link to
+func parseWalletMints(tags nostr.Tags) []string {
+	mints := make([]string, 0, 2)
+	for tag := range tags.FindAll("mint") {
+		if !strings.HasPrefix(tag[1], "https://") && !strings.HasPrefix(tag[1], "http://") {
+			continue
+		}
+		if mint := normalizeWebsiteURL(tag[1]); mint != "" && !slices.Contains(mints, mint) {
+			mints = append(mints, mint)
+		}
+	}
+	return mints
+}
+
+// redactEvent is the event as we show it anywhere, with what only its owner should see taken out of
+// gift wraps and wallets, redacted is false when it is shown as it is
+func redactEvent(event nostr.Event) (shown nostr.Event, redacted bool) {
+	switch event.Kind {
+	case 1059:
+		// the ciphertext is of no use to anyone but the recipient
+		event.Content = "[encrypted]"
+		return event, true
+	case 7375, 7376, 17375, 37375:
+		return redactWallet(event), true
+	}
+	return event, false
+}
+
+// redactWallet is the wallet event as we show it in the details, with the content and everything in the
+// tags that could be a key or a token replaced, in case some client published them unencrypted
+func redactWallet(event nostr.Event) nostr.Event {
+	event.Content = "[encrypted]"
+	tags := make(nostr.Tags, len(event.Tags))
+	for i, tag := range event.Tags {
+		tags[i] = slices.Clone(tag)
+		for j := 1; j < len(tag); j++ {
+			if slices.Contains(sensitiveWalletTags, tag[0]) || cashuTokenMatcher.MatchString(tag[j]) {
+				tags[i][j] = "[redacted]"
+			}
+		}
+	}
+	event.Tags = tags
+	return event
+}
+
 type Kind9802Metadata struct {
 	Author        sdk.ProfileMetadata
 	SourceEvent   string
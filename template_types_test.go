@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/sdk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReport(t *testing.T) {
+	const reportedID = "3406a4f6bd8ee2c4a0bdcb6e7d9ff76a8b0c5fcaa3f6f2a1fdc6ab0f5cde6c6e"
+
+	// an event being reported
+	report := parseKind1984Metadata(nostr.Event{
+		Kind: 1984,
+		Tags: nostr.Tags{{"e", reportedID, "spam"}, {"p", testPubkey2}},
+	})
+	nevent, _ := nip19.EncodeEvent(reportedID, nil, testPubkey2)
+	assert.Equal(t, []ReportTarget{{Code: nevent, ReportType: "spam"}}, report.Targets)
+
+	// a profile being reported
+	ee := testEnhancedEvent(&nostr.Event{
+		Kind:    1984,
+		Content: "posting illegal stuff",
+		Tags:    nostr.Tags{{"p", testPubkey2, "nudity"}},
+	})
+	report = parseKind1984Metadata(*ee.Event)
+	npub, _ := nip19.EncodePublicKey(testPubkey2)
+	assert.Equal(t, []ReportTarget{{Code: npub, ReportType: "nudity", IsProfile: true}}, report.Targets)
+
+	params := ReportPageParams{
+		BaseEventPageParams: BaseEventPageParams{Event: ee},
+		HeadParams:          HeadParams{NoIndex: true},
+		Content:             template.HTML(ee.Content),
+		Report:              report,
+	}
+	buf := &bytes.Buffer{}
+	assert.NoError(t, reportTemplate(params, false).Render(context.Background(), buf))
+	page := buf.String()
+	assert.Contains(t, page, `<meta name="robots" content="noindex">`)
+	assert.Contains(t, page, `href="/`+npub+`"`)
+	assert.Contains(t, page, "nudity")
+	assert.Contains(t, page, "posting illegal stuff")
+}
+
+func TestComment(t *testing.T) {
+	const rootID = "3406a4f6bd8ee2c4a0bdcb6e7d9ff76a8b0c5fcaa3f6f2a1fdc6ab0f5cde6c6e"
+	const parentID = "a7b5c8d1e2f3a4b5c6d7e8f9a0b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9"
+
+	// a top-level comment on a website
+	onURL := parseKind1111Metadata(nostr.Event{
+		Kind: 1111,
+		Tags: nostr.Tags{
+			{"I", "https://example.com/post"}, {"K", "https://example.com"},
+			{"i", "https://example.com/post"}, {"k", "https://example.com"},
+		},
+	})
+	assert.Equal(t, &CommentScope{URL: "https://example.com/post", Label: "https://example.com/post"}, onURL.Root)
+	assert.True(t, onURL.IsTopLevel())
+
+	// a reply to another comment under an article
+	ee := testEnhancedEvent(&nostr.Event{
+		Kind:    1111,
+		Content: "agreed",
+		Tags: nostr.Tags{
+			{"A", "30023:" + testPubkey2 + ":why-nostr", "wss://relay.example.com"}, {"K", "30023"},
+			{"e", parentID, "", testPubkey1}, {"k", "1111"}, {"p", testPubkey1},
+		},
+	})
+	reply := parseKind1111Metadata(*ee.Event)
+	naddr, _ := nip19.EncodeEntity(testPubkey2, 30023, "why-nostr", []string{"wss://relay.example.com"})
+	nevent, _ := nip19.EncodeEvent(parentID, nil, testPubkey1)
+	assert.Equal(t, &CommentScope{Code: naddr, Label: "Long-form Content"}, reply.Root)
+	assert.Equal(t, &CommentScope{Code: nevent, Label: "Comment"}, reply.Parent)
+	assert.False(t, reply.IsTopLevel())
+
+	params := CommentPageParams{
+		BaseEventPageParams: BaseEventPageParams{Event: ee},
+		Content:             template.HTML(ee.Content),
+		Comment:             reply,
+	}
+	buf := &bytes.Buffer{}
+	assert.NoError(t, commentTemplate(params, false).Render(context.Background(), buf))
+	doc, err := goquery.NewDocumentFromReader(buf)
+	assert.NoError(t, err)
+	root, _ := doc.Find(".comment-root a").Attr("href")
+	assert.Equal(t, "/"+naddr, root)
+	parent, _ := doc.Find(".comment-parent a").Attr("href")
+	assert.Equal(t, "/"+nevent, parent)
+
+	// a comment directly on an event doesn't show the parent again
+	params.Comment = parseKind1111Metadata(nostr.Event{
+		Kind: 1111,
+		Tags: nostr.Tags{
+			{"E", rootID, "", testPubkey2}, {"K", "1"},
+			{"e", rootID, "", testPubkey2}, {"k", "1"},
+		},
+	})
+	buf.Reset()
+	assert.NoError(t, commentTemplate(params, false).Render(context.Background(), buf))
+	doc, err = goquery.NewDocumentFromReader(buf)
+	assert.NoError(t, err)
+	rootNevent, _ := nip19.EncodeEvent(rootID, nil, testPubkey2)
+	root, _ = doc.Find(".comment-root a").Attr("href")
+	assert.Equal(t, "/"+rootNevent, root)
+	assert.Contains(t, doc.Find(".comment-root").Text(), "Note")
+	assert.Equal(t, 0, doc.Find(".comment-parent").Length())
+}
+
+func TestBadges(t *testing.T) {
+	definition := testEnhancedEvent(&nostr.Event{
+		Kind: 30009,
+		Tags: nostr.Tags{
+			{"d", "bravery"},
+			{"name", "Medal of Bravery"},
+			{"description", "Awarded to users demonstrating bravery"},
+			{"image", "https://example.com/bravery.png", "1024x1024"},
+			{"thumb", "https://example.com/bravery_256.png", "256x256"},
+		},
+	})
+	badge := parseKind30009Metadata(*definition.Event)
+	naddr, _ := nip19.EncodeEntity(testPubkey1, 30009, "bravery", nil)
+	assert.Equal(t, BadgeDefinition{
+		Code:        naddr,
+		Name:        "Medal of Bravery",
+		Description: "Awarded to users demonstrating bravery",
+		Image:       "https://example.com/bravery.png",
+		Thumb:       "https://example.com/bravery_256.png",
+	}, badge)
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, badgeTemplate(BadgePageParams{
+		BaseEventPageParams: BaseEventPageParams{Event: definition},
+		Badge:               badge,
+	}, false).Render(context.Background(), buf))
+	doc, err := goquery.NewDocumentFromReader(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/bravery.png", doc.Find(".badge img").AttrOr("src", ""))
+	assert.Equal(t, "Medal of Bravery", strings.TrimSpace(doc.Find(".badge h1").Text()))
+	assert.Equal(t, 0, doc.Find(".badge-definition").Length())
+
+	// an award points to the definition and to who got it
+	award := parseKind8Metadata(nostr.Event{
+		Kind: 8,
+		Tags: nostr.Tags{{"a", "30009:" + testPubkey1 + ":bravery"}, {"p", testPubkey2, "wss://relay"}, {"p", testPubkey2}},
+	})
+	assert.Equal(t, []string{testPubkey2}, award.Awardees)
+	assert.Equal(t, BadgeDefinition{Code: naddr, Name: "bravery"}, award.Definition)
+
+	resolved := resolveBadgeDefinition(context.Background(), award, func(ctx context.Context, code string) (EnhancedEvent, error) {
+		assert.Equal(t, naddr, code)
+		return definition, nil
+	})
+	assert.Equal(t, badge, resolved)
+	unresolved := resolveBadgeDefinition(context.Background(), award, func(ctx context.Context, code string) (EnhancedEvent, error) {
+		return EnhancedEvent{}, fmt.Errorf("not found")
+	})
+	assert.Equal(t, award.Definition, unresolved)
+
+	buf.Reset()
+	assert.NoError(t, badgeTemplate(BadgePageParams{
+		BaseEventPageParams: BaseEventPageParams{Event: testEnhancedEvent(&nostr.Event{Kind: 8})},
+		Badge:               resolved,
+		IsAward:             true,
+		Awardees:            []sdk.ProfileMetadata{{PubKey: testPubkey2, Name: "hodlbod"}},
+	}, false).Render(context.Background(), buf))
+	doc, err = goquery.NewDocumentFromReader(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "/"+naddr, doc.Find(".badge-definition").AttrOr("href", ""))
+	npub, _ := nip19.EncodePublicKey(testPubkey2)
+	assert.Equal(t, "/"+npub, doc.Find(".badge-awardee").AttrOr("href", ""))
+}
+
+func TestZapGoal(t *testing.T) {
+	goalEvent := &nostr.Event{
+		ID:      strings.Repeat("9", 64),
+		Kind:    9041,
+		Content: "New pickling jars for the community kitchen",
+		Tags: nostr.Tags{
+			{"amount", "210000000"},
+			{"relays", "wss://relay.example.com", "not a relay", "ws://localhost:7777", "wss://10.0.0.1", "wss://a.example.com", "wss://b.example.com", "wss://c.example.com", "wss://d.example.com", "wss://e.example.com"},
+			{"closed_at", "1800000000"},
+		},
+	}
+	goal := parseKind9041Metadata(*goalEvent)
+	assert.Equal(t, int64(210000), goal.TargetSats())
+	assert.Len(t, goal.Relays, 8)
+
+	zapRequest, _ := json.Marshal(nostr.Event{Kind: 9734, Tags: nostr.Tags{{"amount", "21000000"}}})
+	receipts := []*nostr.Event{
+		{ID: "z1", Kind: 9735, CreatedAt: 1700000000, Tags: nostr.Tags{{"e", goalEvent.ID}, {"bolt11", "lnbc500u1pvjluezpp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypq"}}},
+		{ID: "z1", Kind: 9735, CreatedAt: 1700000000, Tags: nostr.Tags{{"e", goalEvent.ID}, {"bolt11", "lnbc500u1pvjluezpp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypq"}}},
+		{ID: "z2", Kind: 9735, CreatedAt: 1700000000, Tags: nostr.Tags{{"e", goalEvent.ID}, {"description", string(zapRequest)}}},
+		{ID: "late", Kind: 9735, CreatedAt: 1900000000, Tags: nostr.Tags{{"e", goalEvent.ID}, {"description", string(zapRequest)}}},
+		{ID: "other", Kind: 9735, CreatedAt: 1700000000, Tags: nostr.Tags{{"e", strings.Repeat("8", 64)}, {"description", string(zapRequest)}}},
+	}
+
+	var askedRelays []string
+	progress := resolveZapGoalProgress(context.Background(), goal, func(ctx context.Context, goalID string, relays []string) []*nostr.Event {
+		assert.Equal(t, goalEvent.ID, goalID)
+		askedRelays = relays
+		return receipts
+	})
+	// the goal's author can't have us connect to internal addresses or to as many relays as they want
+	assert.Equal(t, []string{"wss://relay.example.com", "wss://a.example.com", "wss://b.example.com", "wss://c.example.com", "wss://d.example.com"}, askedRelays)
+	assert.Equal(t, ZapGoalProgress{Raised: 50000 + 21000, Zaps: 2}, progress)
+	assert.Equal(t, 33, progress.Percent(goal))
+	assert.Equal(t, 100, ZapGoalProgress{Raised: 999999}.Percent(goal))
+
+	var buf bytes.Buffer
+	params := ZapGoalPageParams{BaseEventPageParams: BaseEventPageParams{Event: testEnhancedEvent(goalEvent)}, Goal: goal, Progress: progress}
+	assert.NoError(t, zapGoalInnerBlock(params).Render(context.Background(), &buf))
+	doc, err := goquery.NewDocumentFromReader(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "New pickling jars for the community kitchen", doc.Find(".zap-goal h1").Text())
+	assert.Equal(t, "210000", doc.Find("progress").AttrOr("max", ""))
+	assert.Equal(t, "71000", doc.Find("progress").AttrOr("value", ""))
+	assert.Contains(t, doc.Find(".zap-goal-raised").Text(), "71000 sats raised from 2 zaps")
+	assert.Equal(t, "(unverified)", doc.Find(".zap-goal-unverified").Text())
+	assert.Equal(t, "33% of 210000 sats", strings.TrimSpace(doc.Find(".zap-goal-target").Text()))
+}
+
+func TestGitEvents(t *testing.T) {
+
+	repo := nostr.Event{
+		Kind:      30617,
+		CreatedAt: 1710000000,
+		Tags: nostr.Tags{
+			{"d", "pickles"},
+			{"name", "pickles"},
+			{"description", "tools for fermenting vegetables"},
+			{"web", "https://git.example.com/pickles", "javascript:alert(1)"},
+			{"clone", "https://git.example.com/pickles.git", "git@example.com:pickles.git"},
+		},
+	}
+	meta := parseKind30617Metadata(repo)
+	assert.Equal(t, []string{"https://git.example.com/pickles"}, meta.Web)
+
+	doc := testPreviewPage(t, repo)
+	assert.Equal(t, "pickles", doc.Find(".git-repo h1").Text())
+	assert.Equal(t, 2, doc.Find(".git-clone").Length())
+	assert.Equal(t, "git clone https://git.example.com/pickles.git", doc.Find(".git-clone code").First().Text())
+	assert.Equal(t, "https://git.example.com/pickles", doc.Find(".git-web a").AttrOr("href", ""))
+	assert.Contains(t, doc.Find(`meta[property="og:description"]`).AttrOr("content", ""), "tools for fermenting vegetables")
+
+	patch := nostr.Event{
+		Kind:      1617,
+		CreatedAt: 1710000000,
+		Tags:      nostr.Tags{{"a", "30617:" + testPubkey1 + ":pickles"}, {"commit", "abc123"}},
+		Content: "From abc123 Mon Sep 17 00:00:00 2001\n" +
+			"From: someone <someone@example.com>\n" +
+			"Subject: [PATCH 1/2] add brine ratios\n" +
+			" for cucumbers\n" +
+			"\n" +
+			"diff --git a/brine.md b/brine.md\n" +
+			"+salt <3%\n",
+	}
+	patchMeta := parseKind1617Metadata(patch)
+	assert.Equal(t, "add brine ratios for cucumbers", patchMeta.Subject)
+	assert.True(t, strings.HasPrefix(patchMeta.Repository, "naddr1"))
+
+	doc = testPreviewPage(t, patch)
+	assert.Equal(t, "add brine ratios for cucumbers", doc.Find(".git-patch-subject").Text())
+	assert.Contains(t, doc.Find("pre.git-patch").Text(), "+salt <3%")
+	assert.Equal(t, "/"+patchMeta.Repository, doc.Find(".git-patch-repository").AttrOr("href", ""))
+
+	// without headers the commit is all we can say
+	assert.Equal(t, "commit abc123", parseKind1617Metadata(nostr.Event{Kind: 1617, Tags: nostr.Tags{{"commit", "abc123"}}, Content: "diff --git a/x b/x"}).Subject)
+}
+
+func TestGiftWrap(t *testing.T) {
+	ciphertext := "AhKN0rwKZkJSoa7xMP9cOv1eMyss3IIVl0eqdFnQNzm2Yk1Rz0gs6ec5obDBTZrSUJ7mVXkfa623kKRS3nBaANXiE7wYe4BdrRz"
+	evt := nostr.Event{
+		Kind:      1059,
+		CreatedAt: 1710000000,
+		Tags:      nostr.Tags{},
+		Content:   ciphertext,
+	}
+	w := testPreview(t, "/preview", evt)
+	assert.Equal(t, http.StatusOK, w.Code)
+	page := w.Body.String()
+	doc, err := goquery.NewDocumentFromReader(bytes.NewBufferString(page))
+	assert.NoError(t, err)
+
+	assert.Equal(t, "🎁 Gift-wrapped (encrypted) event", doc.Find("article h1").Text())
+	assert.Contains(t, doc.Find("article").Text(), "from someone")
+	assert.Equal(t, "noindex", doc.Find(`meta[name="robots"]`).AttrOr("content", ""))
+	assert.NotContains(t, page, ciphertext)
+}
+
+func TestRelayList(t *testing.T) {
+	evt := nostr.Event{
+		Kind:      10002,
+		CreatedAt: 1710000000,
+		Tags: nostr.Tags{
+			{"r", "wss://inbox.example.com", "read"},
+			{"r", "wss://outbox.example.com/", "write"},
+			{"r", "wss://relay.example.com"},
+			{"r", "https://not-a-relay.example.com"},
+		},
+	}
+	doc := testPreviewPage(t, evt)
+
+	type row struct{ href, read, write string }
+	var rows []row
+	doc.Find(".relay-list-entry").Each(func(_ int, s *goquery.Selection) {
+		rows = append(rows, row{
+			s.Find("a").AttrOr("href", ""),
+			strings.TrimSpace(s.Find(".relay-read").Text()),
+			strings.TrimSpace(s.Find(".relay-write").Text()),
+		})
+	})
+	assert.Equal(t, []row{
+		{"/r/inbox.example.com", "✓", "–"},
+		{"/r/outbox.example.com", "–", "✓"},
+		{"/r/relay.example.com", "✓", "✓"},
+	}, rows)
+
+	// the same relay with both markers is used for both
+	assert.Equal(t, []RelayListEntry{{URL: "wss://relay.example.com", Read: true, Write: true}}, parseKind10002Metadata(nostr.Event{
+		Tags: nostr.Tags{{"r", "wss://relay.example.com", "read"}, {"r", "wss://relay.example.com/", "write"}},
+	}).Relays)
+}
+
+func TestCashuWallet(t *testing.T) {
+	privkey := "a6e4f1d2c3b4a5968778695a4b3c2d1e0f1e2d3c4b5a69788796a5b4c3d2e1f0"
+	token := "cashuBo2FteCJodHRwczovL21pbnQuZXhhbXBsZS5jb20vQml0Y29pbmF1Y3NhdA"
+	secret := "407915bc212be61a77e3e6d2aeb4c727980bda51cd06a6afc29e2861768a7837"
+
+	render := func(evt nostr.Event) (*goquery.Document, string) {
+		w := testPreview(t, "/preview", evt)
+		assert.Equal(t, http.StatusOK, w.Code)
+		page := w.Body.String()
+		doc, err := goquery.NewDocumentFromReader(bytes.NewBufferString(page))
+		assert.NoError(t, err)
+		return doc, page
+	}
+
+	// a wallet that (wrongly) has its key and a token out in the open
+	doc, page := render(nostr.Event{
+		Kind:      17375,
+		CreatedAt: 1710000000,
+		Tags: nostr.Tags{
+			{"mint", "https://mint.example.com/Bitcoin"},
+			{"mint", "https://mint.example.com/Bitcoin"},
+			{"mint", "javascript:alert(1)"},
+			{"mint", "https://other-mint.example.org"},
+			{"privkey", privkey},
+			{"unit", "sat"},
+			{"memo", token},
+		},
+		Content: `[["privkey","` + privkey + `"],["proof","{\"secret\":\"` + secret + `\"}"]]`,
+	})
+	assert.Equal(t, "👛 Cashu wallet", doc.Find("article h1").Text())
+	assert.Contains(t, doc.Find("article").Text(), "The keys and tokens of this wallet are encrypted")
+	var mints []string
+	doc.Find("a.wallet-mint").Each(func(_ int, s *goquery.Selection) { mints = append(mints, s.AttrOr("href", "")) })
+	assert.Equal(t, []string{"https://mint.example.com/Bitcoin", "https://other-mint.example.org"}, mints)
+	assert.Equal(t, "noindex", doc.Find(`meta[name="robots"]`).AttrOr("content", ""))
+	assert.Contains(t, page, "sat")
+	for _, sensitive := range []string{privkey, token, secret} {
+		assert.NotContains(t, page, sensitive)
+	}
+
+	// the tokens themselves
+	doc, page = render(nostr.Event{
+		Kind:      7375,
+		CreatedAt: 1710000000,
+		Tags:      nostr.Tags{{"proof", secret}},
+		Content:   `{"mint":"https://mint.example.com","proofs":[{"id":"005c2502034d4f12","amount":1,"secret":"` + secret + `","C":"0241d98a8197ef238a192d47edf191a9de78b657308937b4f7dd0aa53beae72c46"}]}`,
+	})
+	assert.Equal(t, "🥜 Cashu wallet tokens", doc.Find("article h1").Text())
+	assert.Equal(t, 0, doc.Find("a.wallet-mint").Length())
+	assert.NotContains(t, page, secret)
+	assert.NotContains(t, page, "0241d98a8197ef238a192d47edf191a9de78b657308937b4f7dd0aa53beae72c46")
+}
+
+func TestClassifiedListing(t *testing.T) {
+	ee := testEnhancedEvent(&nostr.Event{
+		Kind:    30402,
+		Content: "barely used, comes with the original box",
+		Tags: nostr.Tags{
+			{"d", "bike"},
+			{"title", "Road bike"},
+			{"summary", "a lightweight road bike"},
+			{"price", "300", "EUR"},
+			{"status", "active"},
+			{"location", "Lisbon"},
+			{"t", "bikes"},
+		},
+	})
+
+	classified := parseKind30402Metadata(*ee.Event)
+	assert.Equal(t, "300 EUR", classified.Price)
+	assert.Equal(t, "50000 SAT / month", parseKind30402Metadata(nostr.Event{
+		Tags: nostr.Tags{{"price", "50000", "SAT", "month"}},
+	}).Price)
+
+	params := ClassifiedPageParams{
+		BaseEventPageParams: BaseEventPageParams{Event: ee},
+		OpenGraphParams:     OpenGraphParams{Subscript: classified.Title, Text: classified.Summary},
+		Content:             template.HTML(ee.Content),
+		Classified:          classified,
+	}
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, classifiedTemplate(params, false).Render(context.Background(), buf))
+	page := buf.String()
+
+	assert.Contains(t, page, `<meta property="og:title" content="Road bike">`)
+	assert.Contains(t, page, "300 EUR")
+	assert.Contains(t, page, "a lightweight road bike")
+	assert.Contains(t, page, "Available")
+	assert.Contains(t, page, "Lisbon")
+	assert.Contains(t, page, "barely used")
+}
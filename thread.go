@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip10"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+const threadMaxReplies = 200
+
+// threadResolver finds the root of a conversation and the events replying directly to it
+type threadResolver struct {
+	fetchEvent func(ctx context.Context, code string) (*nostr.Event, error)
+	// fetchReplies may return anything that references the root, we filter them afterwards
+	fetchReplies func(ctx context.Context, root *nostr.Event) []*nostr.Event
+}
+
+var threads threadResolver
+
+func (tr threadResolver) resolve(ctx context.Context, code string) (*nostr.Event, []*nostr.Event, error) {
+	root, err := tr.fetchEvent(ctx, code)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// if we were given a reply we go up to the root of its thread
+	if root.Kind == 1 {
+		if pointer := nip10.GetThreadRoot(root.Tags); pointer != nil && pointer.ID != root.ID {
+			if evt, err := tr.fetchEvent(ctx, nip19.EncodePointer(*pointer)); err == nil {
+				root = evt
+			}
+		}
+	}
+
+	return root, threadReplies(root, tr.fetchReplies(ctx, root)), nil
+}
+
+// threadReplies keeps only the events whose immediate parent is root, sorted from oldest to newest
+func threadReplies(root *nostr.Event, candidates []*nostr.Event) []*nostr.Event {
+	replies := make([]*nostr.Event, 0, len(candidates))
+	for _, evt := range candidates {
+		if evt.Kind != 1 || evt.ID == root.ID {
+			continue
+		}
+		if parent := nip10.GetImmediateParent(evt.Tags); parent == nil || parent.ID != root.ID {
+			continue
+		}
+		if slices.ContainsFunc(replies, func(r *nostr.Event) bool { return r.ID == evt.ID }) {
+			continue
+		}
+		replies = append(replies, evt)
+	}
+
+	slices.SortStableFunc(replies, func(a, b *nostr.Event) int { return int(a.CreatedAt - b.CreatedAt) })
+	if len(replies) > threadMaxReplies {
+		replies = replies[:threadMaxReplies]
+	}
+	return replies
+}
+
+func fetchThreadEvent(ctx context.Context, code string) (*nostr.Event, error) {
+	evt, _, err := getEvent(ctx, code, false)
+	return evt, err
+}
+
+// fetchThreadReplies gets the events tagging root from the local store and from the relays we saw it in
+func fetchThreadReplies(ctx context.Context, root *nostr.Event) []*nostr.Event {
+	filter := nostr.Filter{
+		Kinds: []int{1},
+		Tags:  nostr.TagMap{"e": []string{root.ID}},
+		Limit: threadMaxReplies,
+	}
+
+	replies, _ := sys.StoreRelay.QuerySync(ctx, filter)
+
+	relays := internal.getRelaysForEvent(root.ID)
+	for len(relays) < 3 {
+		relays = appendUnique(relays, sys.FallbackRelays.Next())
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*4)
+	defer cancel()
+	for ie := range sys.Pool.FetchMany(ctx, relays, filter, nostr.WithLabel("thread")) {
+		sys.Store.SaveEvent(ctx, ie.Event)
+		internal.attachRelaysToEvent(ie.Event.ID, ie.Relay.URL)
+		replies = append(replies, ie.Event)
+	}
+
+	return replies
+}
+
+func renderThread(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	code := r.PathValue("code")
+
+	root, replies, err := threads.resolve(ctx, code)
+	if err != nil {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.WriteHeader(http.StatusNotFound)
+		errorTemplate(ErrorPageParams{Errors: err.Error()}).Render(ctx, w)
+		return
+	}
+
+	rootEE := NewEnhancedEvent(ctx, root)
+	if renderIfNotAllowed(ctx, w, rootEE) {
+		return
+	}
+
+	params := ThreadPageParams{
+		HeadParams: HeadParams{NeventNaked: rootEE.Nevent()},
+		Root:       rootEE,
+		Replies:    make([]EnhancedEvent, len(replies)),
+	}
+	for i, evt := range replies {
+		params.Replies[i] = NewEnhancedEvent(ctx, evt)
+	}
+	params.Replies = filterAllowed(ctx, params.Replies)
+
+	// new replies may always come in
+	w.Header().Set("Cache-Control", "max-age=300")
+	w.Header().Set("Content-Type", "text/html")
+	threadTemplate(params).Render(ctx, w)
+}
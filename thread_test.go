@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThreadResolution(t *testing.T) {
+	root := &nostr.Event{ID: strings.Repeat("a", 64), Kind: 1, CreatedAt: 1000, Content: "root"}
+	early := &nostr.Event{ID: strings.Repeat("b", 64), Kind: 1, CreatedAt: 1100, Content: "first",
+		Tags: nostr.Tags{{"e", root.ID, "", "root"}}}
+	late := &nostr.Event{ID: strings.Repeat("c", 64), Kind: 1, CreatedAt: 1200, Content: "second",
+		Tags: nostr.Tags{{"e", root.ID}}}
+	nested := &nostr.Event{ID: strings.Repeat("d", 64), Kind: 1, CreatedAt: 1150, Content: "nested",
+		Tags: nostr.Tags{{"e", root.ID, "", "root"}, {"e", early.ID, "", "reply"}}}
+	mention := &nostr.Event{ID: strings.Repeat("e", 64), Kind: 1, CreatedAt: 1050, Content: "mention",
+		Tags: nostr.Tags{{"e", root.ID, "", "mention"}}}
+	unrelated := &nostr.Event{ID: strings.Repeat("f", 64), Kind: 1, CreatedAt: 1010, Content: "unrelated",
+		Tags: nostr.Tags{{"e", strings.Repeat("9", 64)}}}
+
+	events := map[string]*nostr.Event{root.ID: root, early.ID: early}
+	tr := threadResolver{
+		fetchEvent: func(ctx context.Context, code string) (*nostr.Event, error) {
+			_, decoded, err := parseNostrCode(code)
+			if err != nil {
+				return nil, err
+			}
+			return events[decoded.(nostr.EventPointer).ID], nil
+		},
+		fetchReplies: func(ctx context.Context, r *nostr.Event) []*nostr.Event {
+			return []*nostr.Event{late, unrelated, nested, early, mention, late, r}
+		},
+	}
+
+	// starting from a reply we still get the whole thread
+	code, _ := nip19.EncodeEvent(early.ID, nil, "")
+	gotRoot, replies, err := tr.resolve(context.Background(), code)
+	assert.NoError(t, err)
+	assert.Equal(t, root.ID, gotRoot.ID)
+	assert.Len(t, replies, 2)
+	assert.Equal(t, early.ID, replies[0].ID)
+	assert.Equal(t, late.ID, replies[1].ID)
+
+	params := ThreadPageParams{Root: testEnhancedEvent(gotRoot)}
+	for _, evt := range replies {
+		params.Replies = append(params.Replies, testEnhancedEvent(evt))
+	}
+	var buf bytes.Buffer
+	err = threadTemplate(params).Render(context.Background(), &buf)
+	assert.NoError(t, err)
+	doc, err := goquery.NewDocumentFromReader(&buf)
+	assert.NoError(t, err)
+	articles := doc.Find("article")
+	assert.Equal(t, 3, articles.Length())
+	assert.Equal(t, []string{root.ID, early.ID, late.ID}, articles.Map(func(_ int, s *goquery.Selection) string {
+		return s.AttrOr("id", "")
+	}))
+	assert.Contains(t, doc.Find("h2").Text(), "2 replies")
+	assert.NotContains(t, doc.Text(), "unrelated")
+	assert.NotContains(t, doc.Text(), "nested")
+}
+
+func TestThreadModeration(t *testing.T) {
+	root := &nostr.Event{ID: strings.Repeat("1", 64), PubKey: testPubkey1, Kind: 1, CreatedAt: 1000, Content: "root"}
+	reply := func(id string, pubkey string, content string) *nostr.Event {
+		return &nostr.Event{ID: strings.Repeat(id, 64), PubKey: pubkey, Kind: 1, CreatedAt: 1100, Content: content,
+			Tags: nostr.Tags{{"e", root.ID, "", "root"}}}
+	}
+	good := reply("2", testPubkey1, "a fine reply")
+	fromBanned := reply("3", testPubkey2, "reply from a banned pubkey")
+	banned := reply("4", testPubkey1, "banned reply")
+	deleted := reply("5", testPubkey1, "deleted reply")
+
+	previous := threads
+	defer func() { threads = previous }()
+	threads = threadResolver{
+		fetchEvent: func(ctx context.Context, code string) (*nostr.Event, error) { return root, nil },
+		fetchReplies: func(ctx context.Context, r *nostr.Event) []*nostr.Event {
+			return []*nostr.Event{good, fromBanned, banned, deleted}
+		},
+	}
+	defer func(original func(context.Context, *nostr.Event) []*nostr.Event) { deletionRequests = original }(deletionRequests)
+	deletionRequests = func(ctx context.Context, evt *nostr.Event) []*nostr.Event {
+		if evt.ID == deleted.ID {
+			return []*nostr.Event{{Kind: nostr.KindDeletion, PubKey: evt.PubKey, Tags: nostr.Tags{{"e", evt.ID}}}}
+		}
+		return nil
+	}
+	assert.NoError(t, internal.banPubkey(testPubkey2, "spam"))
+	defer internal.unbanPubkey(testPubkey2)
+	assert.NoError(t, internal.banEvent(banned.ID, "spam"))
+	defer internal.unbanEvent(banned.ID)
+
+	thread := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/thread/x", nil)
+		renderThread(w, r.WithContext(withLocalOnly(r.Context())))
+		return w
+	}
+
+	w := thread()
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "a fine reply")
+	for _, hidden := range []string{"banned pubkey", "banned reply", "deleted reply"} {
+		assert.NotContains(t, w.Body.String(), hidden)
+	}
+
+	// and the root itself goes through the same checks
+	assert.NoError(t, internal.banEvent(root.ID, "spam"))
+	defer internal.unbanEvent(root.ID)
+	w = thread()
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NotContains(t, w.Body.String(), "a fine reply")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+	_ "time/tzdata" // so timezones work even where the system has no zoneinfo
+)
+
+const timezoneCookie = "tz"
+
+type timezoneKey struct{}
+
+// timezoneMiddleware takes the viewer's timezone from ?tz= or from the tz cookie and puts it in the context
+// so dates are shown in their local time, a timezone given in the query is remembered in the cookie
+func timezoneMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if name := r.URL.Query().Get("tz"); name != "" {
+			if loc, ok := parseTimezone(name); ok {
+				http.SetCookie(w, &http.Cookie{
+					Name:     timezoneCookie,
+					Value:    loc.String(),
+					Path:     "/",
+					MaxAge:   60 * 60 * 24 * 365,
+					SameSite: http.SameSiteLaxMode,
+				})
+				r = r.WithContext(context.WithValue(r.Context(), timezoneKey{}, loc))
+			}
+		} else if cookie, err := r.Cookie(timezoneCookie); err == nil {
+			// the same url renders differently depending on the cookie
+			w.Header().Add("Vary", "Cookie")
+			if loc, ok := parseTimezone(cookie.Value); ok {
+				r = r.WithContext(context.WithValue(r.Context(), timezoneKey{}, loc))
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// parseTimezone only takes IANA names, things like "Local" or "" that would mean the server's time are refused
+func parseTimezone(name string) (*time.Location, bool) {
+	if name == "" || name == "Local" || len(name) > 64 {
+		return nil, false
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, false
+	}
+	return loc, true
+}
+
+// viewerTimezone is where the dates in this request should be shown, UTC when we don't know
+func viewerTimezone(ctx context.Context) *time.Location {
+	if loc, ok := ctx.Value(timezoneKey{}).(*time.Location); ok {
+		return loc
+	}
+	return time.UTC
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestViewerTimezone(t *testing.T) {
+	evt := nostr.Event{Kind: 1, CreatedAt: 1710000000, Tags: nostr.Tags{}, Content: "what time is it?"}
+	assert.NoError(t, evt.Sign(nostr.GeneratePrivateKey()))
+	body, _ := json.Marshal(evt)
+
+	render := func(target string, cookie *http.Cookie) (string, *httptest.ResponseRecorder) {
+		r := httptest.NewRequest("POST", target, bytes.NewReader(body))
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		timezoneMiddleware(renderPreview)(w, r)
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(w.Body.Bytes()))
+		assert.NoError(t, err)
+		return strings.TrimSpace(doc.Find(`[itemprop="dateCreated"]`).First().Text()), w
+	}
+
+	date, _ := render("/preview", nil)
+	assert.Equal(t, "2024-03-09 16:00:00 UTC", date)
+
+	date, w := render("/preview?tz=America/New_York", nil)
+	assert.Equal(t, "2024-03-09 11:00:00 EST", date)
+	cookies := w.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	assert.Equal(t, "America/New_York", cookies[0].Value)
+
+	// the cookie is enough for the next pages
+	date, w = render("/preview", cookies[0])
+	assert.Equal(t, "2024-03-09 11:00:00 EST", date)
+	assert.Equal(t, "Cookie", w.Header().Get("Vary"))
+
+	for _, invalid := range []string{"Mars/Olympus_Mons", "Local", "../../etc/passwd"} {
+		date, w = render("/preview?tz="+invalid, nil)
+		assert.Equal(t, "2024-03-09 16:00:00 UTC", date, invalid)
+		assert.Empty(t, w.Result().Cookies())
+
+		date, _ = render("/preview", &http.Cookie{Name: timezoneCookie, Value: invalid})
+		assert.Equal(t, "2024-03-09 16:00:00 UTC", date, invalid)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+var errNoTorProxy = errors.New("no tor proxy configured for .onion address")
+
+// forwardDialer is how we reach the Tor proxy
+type forwardDialer interface {
+	proxy.Dialer
+	proxy.ContextDialer
+}
+
+// onionRouter dials .onion hosts through a Tor SOCKS5 proxy and everything else directly
+type onionRouter struct {
+	direct proxy.ContextDialer
+	tor    proxy.ContextDialer
+}
+
+func (or onionRouter) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if isOnionHost(host) {
+		if or.tor == nil {
+			return nil, errNoTorProxy
+		}
+		return or.tor.DialContext(ctx, network, address)
+	}
+	return or.direct.DialContext(ctx, network, address)
+}
+
+func isOnionHost(host string) bool {
+	return strings.HasSuffix(strings.TrimSuffix(strings.ToLower(host), "."), ".onion")
+}
+
+// newOnionRouter connects to the Tor proxy at socksAddr (like "127.0.0.1:9050") only for .onion hosts,
+// the hostname is passed to the proxy unresolved since these can't be looked up in the normal DNS.
+// the proxy itself is reached with toProxy, as it is usually on an address direct won't connect to
+func newOnionRouter(direct proxy.ContextDialer, toProxy forwardDialer, socksAddr string) (onionRouter, error) {
+	socks, err := proxy.SOCKS5("tcp", socksAddr, nil, toProxy)
+	if err != nil {
+		return onionRouter{}, err
+	}
+	return onionRouter{direct: direct, tor: socks.(proxy.ContextDialer)}, nil
+}
+
+// setupTorProxy makes relayTransport reach .onion relays through the given SOCKS5 proxy, without one
+// they are refused so their names don't go to the normal DNS. the hosts guardHost says so are only
+// connected to if they resolve to a public address, when it is nil all of them are connected to
+func setupTorProxy(socksAddr string, guardHost func(host string) bool) error {
+	toProxy := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	var direct proxy.ContextDialer = toProxy
+	if guardHost != nil {
+		direct = hostGuard{plain: toProxy, guarded: newGuardedDialer(30*time.Second, isPublicIP), guard: guardHost}
+	}
+	router := onionRouter{direct: direct}
+	if socksAddr != "" {
+		var err error
+		router, err = newOnionRouter(direct, toProxy, socksAddr)
+		if err != nil {
+			return err
+		}
+	}
+	relayTransport.DialContext = router.DialContext
+	return nil
+}
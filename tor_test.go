@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type stubDialer struct {
+	dialed []string
+}
+
+func (sd *stubDialer) Dial(network, address string) (net.Conn, error) {
+	return sd.DialContext(context.Background(), network, address)
+}
+
+func (sd *stubDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	sd.dialed = append(sd.dialed, address)
+	return nil, errors.New("stub")
+}
+
+func TestOnionRouter(t *testing.T) {
+	direct := &stubDialer{}
+	toProxy := &stubDialer{}
+	router, err := newOnionRouter(direct, toProxy, "127.0.0.1:9050")
+	assert.NoError(t, err)
+
+	// clearnet relays are dialed directly
+	router.DialContext(context.Background(), "tcp", "relay.damus.io:443")
+	assert.Equal(t, []string{"relay.damus.io:443"}, direct.dialed)
+
+	// onion relays go through the proxy, which is itself reached with its own dialer
+	direct.dialed = nil
+	router.DialContext(context.Background(), "tcp", "oxtrdevav64z64yb7x6rjg4ntzqjhedm5b5zjqulugknhzr46ny2qbad.ONION:443")
+	assert.Equal(t, []string{"127.0.0.1:9050"}, toProxy.dialed)
+	assert.Empty(t, direct.dialed)
+
+	// and with a stub tor dialer we can see the onion address is passed to it untouched
+	tor := &stubDialer{}
+	direct.dialed = nil
+	router = onionRouter{direct: direct, tor: tor}
+	router.DialContext(context.Background(), "tcp", "abcdef.onion:80")
+	router.DialContext(context.Background(), "tcp", "nos.lol:443")
+	assert.Equal(t, []string{"abcdef.onion:80"}, tor.dialed)
+	assert.Equal(t, []string{"nos.lol:443"}, direct.dialed)
+
+	// without a proxy onion addresses are refused instead of leaking to the normal DNS
+	_, err = onionRouter{direct: direct}.DialContext(context.Background(), "tcp", "abcdef.onion:443")
+	assert.ErrorIs(t, err, errNoTorProxy)
+
+	// which is how the relay transport is set up when TOR_PROXY is empty
+	previous := relayTransport.DialContext
+	defer func() { relayTransport.DialContext = previous }()
+	guardAll := func(string) bool { return true }
+	assert.NoError(t, setupTorProxy("", guardAll))
+	_, err = relayTransport.DialContext(context.Background(), "tcp", "abcdef.onion:443")
+	assert.ErrorIs(t, err, errNoTorProxy)
+
+	// relays on private addresses are refused, but not the tor proxy on localhost
+	_, err = relayTransport.DialContext(context.Background(), "tcp", "127.0.0.1:7777")
+	assert.ErrorIs(t, err, errProxyForbiddenAddress)
+	_, err = relayTransport.DialContext(context.Background(), "tcp", "localhost:7777")
+	assert.ErrorIs(t, err, errProxyForbiddenAddress)
+	assert.NoError(t, setupTorProxy("127.0.0.1:1", guardAll))
+	_, err = relayTransport.DialContext(context.Background(), "tcp", "abcdef.onion:443")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, errProxyForbiddenAddress)
+}
@@ -7,17 +7,24 @@ import (
 	"html"
 	"html/template"
 	"net/http"
+	"net/url"
 	"regexp"
 	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	me "github.com/huantt/plaintext-extractor/markdown"
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/nip53"
+	"github.com/nbd-wtf/go-nostr/nip92"
+	"github.com/nbd-wtf/go-nostr/sdk"
+	"github.com/pelletier/go-toml"
 	"github.com/puzpuzpuz/xsync/v3"
+	"golang.org/x/net/idna"
 	"mvdan.cc/xurls/v2"
 )
 
@@ -32,6 +39,10 @@ var (
 	nostrEveryMatcher        = regexp.MustCompile(`nostr:((npub|note|nevent|nprofile|naddr)1[a-z0-9]+)\b`)
 	nostrNoteNeventMatcher   = regexp.MustCompile(`(?:^|<br/>|\s)nostr:((note|nevent|naddr)1[a-z0-9]+)\b(?:\s|<br/>|$)`)
 	nostrNpubNprofileMatcher = regexp.MustCompile(`nostr:((npub|nprofile)1[a-z0-9]+)\b`)
+	cashuTokenMatcher        = regexp.MustCompile(`\b(?:cashu:)?(cashu[AB][A-Za-z0-9_\-+/]{20,}={0,2})`)
+	// the leading @ is what tells these apart from emails and lightning addresses, and it must not come
+	// right after a letter or a slash, as in "someone@@domain" or in a link to a profile
+	fediverseMentionMatcher = regexp.MustCompile(`(^|[\s(])@([a-zA-Z0-9_][a-zA-Z0-9_.-]*)@((?:[a-zA-Z0-9-]+\.)+[a-zA-Z]{2,})\b`)
 
 	urlMatcher = func() *regexp.Regexp {
 		// hack to only allow these schemes while still using this library
@@ -44,6 +55,14 @@ var (
 	videoExtensionMatcher = regexp.MustCompile(`.*\.(mp4|ogg|webm|mov)((\?|\#).*)?$`)
 	urlRegex              = xurls.Strict()
 
+	// mediaHosts are hosts that serve images at urls without an extension, like "image.nostr.build",
+	// or "*.example.com" for all the subdomains of example.com
+	mediaHosts []string
+
+	// trackingParams are the query parameters removed from the links in the content, "utm_*" removes all
+	// the ones starting with "utm_"
+	trackingParams []string
+
 	markdownExtractor = me.NewExtractor()
 )
 
@@ -63,8 +82,14 @@ var kindNames = map[int]string{
 	43:    "Channel Hide Message",
 	44:    "Channel Mute User",
 	1063:  "File Metadata",
+	1059:  "Gift Wrap",
+	1111:  "Comment",
+	1617:  "Patch",
 	1311:  "Live Chat Message",
 	1984:  "Reporting",
+	7375:  "Cashu Wallet Tokens",
+	7376:  "Cashu Wallet History",
+	9041:  "Zap Goal",
 	9734:  "Zap Request",
 	9735:  "Zap",
 	9802:  "Highlight",
@@ -72,6 +97,7 @@ var kindNames = map[int]string{
 	10001: "Pin List",
 	10002: "Relay List Metadata",
 	13194: "Wallet Info",
+	17375: "Cashu Wallet",
 	22242: "Client Authentication",
 	23194: "Wallet Request",
 	23195: "Wallet Response",
@@ -85,7 +111,11 @@ var kindNames = map[int]string{
 	30023: "Long-form Content",
 	30078: "Application-specific Data",
 	30818: "Wiki article",
+	30617: "Repository Announcement",
 	30311: "Live Event",
+	30402: "Classified Listing",
+	31234: "Draft Event",
+	37375: "Cashu Wallet",
 }
 
 var kindNIPs = map[int]string{
@@ -104,8 +134,14 @@ var kindNIPs = map[int]string{
 	43:    "28",
 	44:    "28",
 	1063:  "94",
+	1059:  "59",
+	1111:  "22",
+	1617:  "34",
 	1311:  "53",
 	1984:  "56",
+	7375:  "60",
+	7376:  "60",
+	9041:  "75",
 	9734:  "57",
 	9735:  "57",
 	9802:  "84",
@@ -113,6 +149,7 @@ var kindNIPs = map[int]string{
 	10001: "51",
 	10002: "65",
 	13194: "47",
+	17375: "60",
 	22242: "42",
 	23194: "47",
 	23195: "47",
@@ -126,7 +163,11 @@ var kindNIPs = map[int]string{
 	30023: "23",
 	30078: "78",
 	30818: "54",
+	30617: "34",
 	30311: "53",
+	30402: "99",
+	31234: "37",
+	37375: "60",
 }
 
 type Style string
@@ -135,6 +176,7 @@ const (
 	StyleTelegram   Style = "telegram"
 	StyleTwitter          = "twitter"
 	StyleFacebook         = "facebook" // Both Facebook and Instagram
+	StyleLinkedIn         = "linkedin"
 	StyleIOS              = "ios"
 	StyleAndroid          = "android"
 	StyleMattermost       = "mattermost"
@@ -146,11 +188,22 @@ const (
 	StyleUnknown          = "unknown"
 )
 
+// previewStyles are the styles that can be forced with ?s=, to see how a preview looks somewhere
+// without having to pretend to be its crawler
+var previewStyles = []Style{
+	StyleTelegram, StyleTwitter, StyleFacebook, StyleLinkedIn, StyleIOS, StyleAndroid,
+	StyleMattermost, StyleSlack, StyleDiscord, StyleWhatsapp, StyleIframely, StyleNormal,
+}
+
 func getPreviewStyle(r *http.Request) Style {
 	if style := r.URL.Query().Get("style"); style != "" {
 		// debug mode
 		return Style(style)
 	}
+	// this only changes how the page is rendered, so anyone can ask for any of the known styles
+	if style := Style(strings.ToLower(r.URL.Query().Get("s"))); slices.Contains(previewStyles, style) {
+		return style
+	}
 
 	ua := strings.ToLower(r.Header.Get("User-Agent"))
 	accept := r.Header.Get("Accept")
@@ -159,8 +212,10 @@ func getPreviewStyle(r *http.Request) Style {
 		return StyleTelegram
 	case strings.Contains(ua, "twitterbot"):
 		return StyleTwitter
-	case strings.Contains(ua, "facebookexternalhit"):
+	case strings.Contains(ua, "facebookexternalhit"), strings.Contains(ua, "facebookcatalog"):
 		return StyleFacebook
+	case strings.Contains(ua, "linkedinbot"):
+		return StyleLinkedIn
 	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipad"), strings.Contains(ua, "ipod"):
 		return StyleIOS
 	case strings.Contains(ua, "android"):
@@ -185,59 +240,232 @@ func getPreviewStyle(r *http.Request) Style {
 func replaceURLsWithTags(input string, imageReplacementTemplate, videoReplacementTemplate string, skipLinks bool) string {
 	return urlMatcher.ReplaceAllStringFunc(input, func(match string) string {
 		switch {
-		case imageExtensionMatcher.MatchString(match):
+		case isImageLink(match):
 			// Match and replace image URLs with a custom replacement
 			// Usually is html <img> => ` <img src="%s" alt=""> `
 			// or markdown !()[...] tags for further processing => `![](%s)`
-			return fmt.Sprintf(imageReplacementTemplate, match)
+			return fmt.Sprintf(imageReplacementTemplate, asciiURL(match))
 		case videoExtensionMatcher.MatchString(match):
 			// Match and replace video URLs with a custom replacement
 			// Usually is html <video> => ` <video controls width="100%%"><source src="%s"></video> `
 			// or markdown !()[...] tags for further processing => `![](%s)`
-			return fmt.Sprintf(videoReplacementTemplate, match)
+			return fmt.Sprintf(videoReplacementTemplate, asciiURL(match))
 		default:
 			if skipLinks {
 				return match
 			} else {
-				return "<a href=\"" + match + "\">" + match + "</a>"
+				// the content is already escaped, so the query must be unescaped to be split
+				if unescaped := html.UnescapeString(match); len(trackingParams) != 0 {
+					if stripped := stripTrackingParams(unescaped, trackingParams); stripped != unescaped {
+						match = html.EscapeString(stripped)
+					}
+				}
+				return "<a href=\"" + asciiURL(match) + "\">" + displayURL(match) + "</a>"
 			}
 		}
 	})
 }
 
-func replaceNostrURLsWithHTMLTags(matcher *regexp.Regexp, input string) string {
-	// match and replace npup1, nprofile1, note1, nevent1, etc
-	names := xsync.NewMapOf[string, string]()
-	wg := sync.WaitGroup{}
+// replaceFediverseMentionsWithLinks links "@user@domain.tld" mentions, from bridged or cross-posted notes,
+// to the profile on the remote server
+func replaceFediverseMentionsWithLinks(input string) string {
+	return fediverseMentionMatcher.ReplaceAllString(input,
+		`$1<a href="https://$3/@$2" class="fediverse-mention text-strongpink" rel="nofollow noopener" target="_blank">@$2@$3</a>`)
+}
 
-	// first we run it without waiting for the results of getNameFromNip19() as they will be async
-	for _, match := range matcher.FindAllString(input, len(input)+1) {
-		nip19 := match[len("nostr:"):]
+// isImageLink tells if a link in the content is to an image, by its extension or because it is from one of the media hosts
+func isImageLink(u string) bool {
+	if imageExtensionMatcher.MatchString(u) {
+		return true
+	}
+	return isMediaHostURL(u) && !videoExtensionMatcher.MatchString(u)
+}
 
-		if strings.HasPrefix(nip19, "npub1") || strings.HasPrefix(nip19, "nprofile1") {
-			ctx, cancel := context.WithTimeout(context.Background(), time.Second*4)
-			defer cancel()
-			wg.Add(1)
-			go func() {
-				name, _ := getNameFromNip19(ctx, nip19)
-				names.Store(nip19, name)
-				wg.Done()
-			}()
+func isMediaHostURL(u string) bool {
+	if len(mediaHosts) == 0 {
+		return false
+	}
+
+	parsed, err := url.Parse(u)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Path == "" || parsed.Path == "/" {
+		return false
+	}
+	host := strings.ToLower(parsed.Hostname())
+	for _, pattern := range mediaHosts {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
 		}
 	}
+	return false
+}
+
+// asciiURL converts internationalized domain names in the URL to punycode, so the link works everywhere
+func asciiURL(u string) string { return convertURLHost(u, idna.Lookup.ToASCII) }
+
+// displayURL is how a link is shown: as the author wrote it, unless a label of its host mixes
+// scripts (like a cyrillic "а" among latin letters), then it is shown in punycode so it can't pass
+// for the domain it is imitating
+func displayURL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Hostname() == "" {
+		return u
+	}
+	host, err := idna.Lookup.ToUnicode(parsed.Hostname())
+	if err != nil {
+		return u
+	}
+	for _, label := range strings.Split(host, ".") {
+		if mixesScripts(label) {
+			return asciiURL(u)
+		}
+	}
+	return u
+}
+
+// mixesScripts tells if the letters of s come from more than one script, han mixed with the
+// japanese kanas or with hangul is fine as that is how these languages are written
+func mixesScripts(s string) bool {
+	found := make(map[string]bool, 2)
+	for _, r := range s {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		for name, table := range unicode.Scripts {
+			if name == "Common" || name == "Inherited" || !unicode.Is(table, r) {
+				continue
+			}
+			if name == "Hiragana" || name == "Katakana" || name == "Hangul" {
+				name = "Han"
+			}
+			found[name] = true
+			break
+		}
+	}
+	return len(found) > 1
+}
+
+// stripTrackingParams removes the given query parameters from the URL, the others and their order are kept as they were
+func stripTrackingParams(u string, params []string) string {
+	if len(params) == 0 {
+		return u
+	}
+	base, query, ok := strings.Cut(u, "?")
+	if !ok {
+		return u
+	}
+	query, fragment, hasFragment := strings.Cut(query, "#")
+
+	kept := make([]string, 0, strings.Count(query, "&")+1)
+	for _, pair := range strings.Split(query, "&") {
+		key, _, _ := strings.Cut(pair, "=")
+		if key, err := url.QueryUnescape(key); err == nil && isTrackingParam(key, params) {
+			continue
+		}
+		kept = append(kept, pair)
+	}
+
+	if len(kept) != 0 {
+		base += "?" + strings.Join(kept, "&")
+	}
+	if hasFragment {
+		base += "#" + fragment
+	}
+	return base
+}
+
+func isTrackingParam(key string, params []string) bool {
+	key = strings.ToLower(key)
+	for _, param := range params {
+		param = strings.ToLower(param)
+		if prefix, ok := strings.CutSuffix(param, "*"); ok {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		} else if key == param {
+			return true
+		}
+	}
+	return false
+}
+
+func convertURLHost(u string, convert func(string) (string, error)) string {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Hostname() == "" {
+		return u
+	}
+	host := parsed.Hostname()
+	converted, err := convert(host)
+	if err != nil || converted == host {
+		return u
+	}
+	// replace just the first occurrence so the path and the query are kept exactly as they were
+	return strings.Replace(u, host, converted, 1)
+}
+
+// mentionedNames gets the names for all the npubs and nprofiles matched in the input at once
+func mentionedNames(ctx context.Context, matcher *regexp.Regexp, inputs ...string) map[string]string {
+	codePubkeys := make(map[string]string)
+	pubkeys := make([]string, 0, 8)
+	for _, input := range inputs {
+		for _, submatches := range matcher.FindAllStringSubmatch(input, len(input)+1) {
+			code := submatches[1]
+			prefix, decoded, err := parseNostrCode(code)
+			if err != nil {
+				continue
+			}
+
+			switch prefix {
+			case "npub":
+				codePubkeys[code] = decoded.(string)
+			case "nprofile":
+				codePubkeys[code] = decoded.(nostr.ProfilePointer).PublicKey
+			default:
+				continue
+			}
+			pubkeys = appendUnique(pubkeys, codePubkeys[code])
+		}
+	}
+
+	names := make(map[string]string, len(codePubkeys))
+	if len(pubkeys) == 0 {
+		return names
+	}
+
+	profiles := mentionResolver.resolve(ctx, pubkeys)
+	for code, pubkey := range codePubkeys {
+		if pm, ok := profiles[pubkey]; ok && pm.Name != "" {
+			names[code] = pm.Name
+		}
+	}
+	return names
+}
+
+func replaceNostrURLsWithHTMLTags(ctx context.Context, matcher *regexp.Regexp, input string) string {
+	// match and replace npup1, nprofile1, note1, nevent1, etc
+	ctx, cancel := context.WithTimeout(ctx, time.Second*4)
+	defer cancel()
+	names := mentionedNames(ctx, matcher, input)
 
-	// in the second time now that we got all the names we actually perform replacement
-	wg.Wait()
 	return matcher.ReplaceAllStringFunc(input, func(match string) string {
 		nip19 := match[len("nostr:"):]
 		firstChars := nip19[:8]
 		lastChars := nip19[len(nip19)-4:]
 
+		// mentions are kept in one piece when the line wraps, so long names get cut short instead
 		if strings.HasPrefix(nip19, "npub1") || strings.HasPrefix(nip19, "nprofile1") {
-			name, _ := names.Load(nip19)
-			return fmt.Sprintf(`<span itemprop="mentions" itemscope itemtype="https://schema.org/Person"><a itemprop="url" href="/%s" class="bg-lavender dark:prose:text-neutral-50 dark:text-neutral-50 dark:bg-garnet px-1"><span>%s</span> (<span class="italic">%s</span>)</a></span>`, nip19, name, firstChars+"…"+lastChars)
+			name, ok := names[nip19]
+			if !ok {
+				// the whole code would never fit in a line, the short one is enough
+				return fmt.Sprintf(`<span itemprop="mentions" itemscope itemtype="https://schema.org/Person" class="mention whitespace-nowrap"><a itemprop="url" href="/%s" class="bg-lavender dark:prose:text-neutral-50 dark:text-neutral-50 dark:bg-garnet px-1"><span class="italic">%s</span></a></span>`, nip19, firstChars+"…"+lastChars)
+			}
+			return fmt.Sprintf(`<span itemprop="mentions" itemscope itemtype="https://schema.org/Person" class="mention whitespace-nowrap"><a itemprop="url" href="/%s" class="bg-lavender dark:prose:text-neutral-50 dark:text-neutral-50 dark:bg-garnet px-1"><span class="inline-block max-w-[16rem] truncate align-bottom">%s</span> (<span class="italic">%s</span>)</a></span>`, nip19, name, firstChars+"…"+lastChars)
 		} else {
-			return fmt.Sprintf(`<span itemprop="mentions" itemscope itemtype="https://schema.org/Article"><a itemprop="url" href="/%s" class="bg-lavender dark:prose:text-neutral-50 dark:text-neutral-50 dark:bg-garnet px-1">%s</a></span>`, nip19, firstChars+"…"+lastChars)
+			return fmt.Sprintf(`<span itemprop="mentions" itemscope itemtype="https://schema.org/Article" class="mention whitespace-nowrap"><a itemprop="url" href="/%s" class="bg-lavender dark:prose:text-neutral-50 dark:text-neutral-50 dark:bg-garnet px-1">%s</a></span>`, nip19, firstChars+"…"+lastChars)
 		}
 	})
 }
@@ -262,12 +490,74 @@ func shortenString(input string, before int, after int) string {
 	return firstChars + "…" + lastChars
 }
 
-func getNameFromNip19(ctx context.Context, nip19code string) (string, bool) {
-	metadata, _ := sys.FetchProfileFromInput(ctx, nip19code)
-	if metadata.Name == "" {
-		return nip19code, false
+// parseNostrCode is a nip19.Decode that never panics, as malformed codes from the wild
+// can make the TLV parsing go out of bounds
+func parseNostrCode(code string) (prefix string, decoded any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			prefix, decoded, err = "", nil, fmt.Errorf("failed to decode '%s': %v", code, r)
+		}
+	}()
+
+	prefix, decoded, err = nip19.Decode(code)
+	if err == nil && decoded == nil {
+		return "", nil, fmt.Errorf("failed to decode '%s'", code)
+	}
+	return prefix, decoded, err
+}
+
+// FormatContent prints JSON content as TOML for easier readability, when that isn't possible
+// (not JSON, or JSON that doesn't map to a TOML document, like a bare array) the raw content is
+// returned and ok is false
+func FormatContent(j string) (formatted string, ok bool) {
+	var parsedJson any
+	if err := json.Unmarshal([]byte(j), &parsedJson); err != nil {
+		// only worth mentioning when it looked like it was meant to be JSON
+		if trimmed := strings.TrimSpace(j); strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+			log.Debug().Err(err).Msg("malformed json content, using it raw")
+		}
+		return j, false
+	}
+
+	t, err := toml.Marshal(parsedJson)
+	if err != nil || len(t) == 0 {
+		log.Debug().Err(err).Msg("json content can't be printed as toml, using it raw")
+		return j, false
+	}
+
+	return string(t), true
+}
+
+// cacheControlForKind lets regular events be cached for long since they can't change,
+// while replaceable and addressable ones must be revalidated soon
+func cacheControlForKind(kind int) string {
+	if nostr.IsReplaceableKind(kind) || nostr.IsAddressableKind(kind) {
+		return fmt.Sprintf("max-age=%d, s-maxage=%d, must-revalidate", s.CacheMaxAgeMutable, s.CacheMaxAgeMutable)
+	}
+	return fmt.Sprintf("max-age=%d, s-maxage=%d, immutable", s.CacheMaxAge, s.CacheMaxAge)
+}
+
+// fetchProfiles gets the metadata for all the given pubkeys concurrently, keeping the order
+func fetchProfiles(
+	ctx context.Context,
+	pubkeys []string,
+	fetch func(context.Context, string) sdk.ProfileMetadata,
+) []sdk.ProfileMetadata {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*3)
+	defer cancel()
+
+	profiles := make([]sdk.ProfileMetadata, len(pubkeys))
+	wg := sync.WaitGroup{}
+	for i, pubkey := range pubkeys {
+		wg.Add(1)
+		go func() {
+			profiles[i] = fetch(ctx, pubkey)
+			wg.Done()
+		}()
 	}
-	return metadata.Name, true
+	wg.Wait()
+
+	return profiles
 }
 
 // replaces an npub/nprofile with the name of the author, if possible.
@@ -276,13 +566,14 @@ func replaceUserReferencesWithNames(ctx context.Context, input []string, prefix
 	// match and replace npup1 or nprofile1
 	ctx, cancel := context.WithTimeout(ctx, time.Second*3)
 	defer cancel()
+	names := mentionedNames(ctx, nostrNpubNprofileMatcher, input...)
 
 	for i, line := range input {
 		input[i] = strings.TrimSpace(
 			nostrNpubNprofileMatcher.ReplaceAllStringFunc(line, func(match string) string {
 				submatch := nostrNpubNprofileMatcher.FindStringSubmatch(match)
 				nip19code := submatch[1]
-				name, ok := getNameFromNip19(ctx, nip19code)
+				name, ok := names[nip19code]
 				if ok {
 					return prefix + strings.ReplaceAll(name, " ", string(THIN_SPACE))
 				}
@@ -305,19 +596,17 @@ func renderQuotesAsHTML(ctx context.Context, input string, usingTelegramInstantV
 	for _, submatches := range nostrNoteNeventMatcher.FindAllStringSubmatch(input, len(input)+1) {
 		nip19 := submatches[1]
 
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*4)
-		defer cancel()
 		wg.Add(1)
 		go func() {
 			event, _, err := getEvent(ctx, nip19, false)
 			if err == nil {
-				quotedEvent := basicFormatting(submatches[0], false, usingTelegramInstantView, false)
+				quotedEvent := basicFormatting(ctx, submatches[0], false, usingTelegramInstantView, false)
 
 				var content string
 				if event.Kind == 30023 {
-					content = mdToHTML(event.Content, usingTelegramInstantView)
+					content = mdToHTML(ctx, toValidUTF8(event.Content), usingTelegramInstantView)
 				} else {
-					content = basicFormatting(event.Content, false, usingTelegramInstantView, false)
+					content = basicFormatting(ctx, toValidUTF8(event.Content), false, usingTelegramInstantView, false)
 				}
 				content = fmt.Sprintf(
 					`<blockquote class="border-l-05rem border-l-strongpink border-solid"><div class="-ml-4 bg-gradient-to-r from-gray-100 dark:from-zinc-800 to-transparent mr-0 mt-0 mb-4 pl-4 pr-2 py-2">quoting %s </div> %s </blockquote>`, quotedEvent, content)
@@ -339,6 +628,220 @@ func renderQuotesAsHTML(ctx context.Context, input string, usingTelegramInstantV
 	})
 }
 
+// resolveQuote fetches the event a quote repost points to and formats it to be displayed below the note
+func resolveQuote(
+	ctx context.Context,
+	pointer nostr.Pointer,
+	fetch func(context.Context, string) (EnhancedEvent, error),
+) *QuotedEvent {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*3)
+	defer cancel()
+
+	code := nip19.EncodePointer(pointer)
+	quoted, err := fetch(ctx, code)
+	if err != nil {
+		return nil
+	}
+
+	var content string
+	if quoted.Kind == 30023 {
+		content = mdToHTML(ctx, quoted.content, false)
+	} else {
+		content = basicFormatting(ctx, html.EscapeString(quoted.content), true, false, false)
+	}
+
+	return &QuotedEvent{
+		Code:    code,
+		Author:  quoted.author,
+		Content: template.HTML(content),
+		Text:    hideCashuTokens(quoted.content),
+	}
+}
+
+// resolveAddressReferences turns the pointers into links, fetching articles so we can show their titles
+func resolveAddressReferences(
+	ctx context.Context,
+	pointers []nostr.EntityPointer,
+	fetch func(context.Context, string) (EnhancedEvent, error),
+) []AddressReference {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*3)
+	defer cancel()
+
+	references := make([]AddressReference, len(pointers))
+	for i, pointer := range pointers {
+		code := nip19.EncodePointer(pointer)
+		references[i] = AddressReference{Code: code, Title: kindNames[pointer.Kind]}
+		if references[i].Title == "" {
+			references[i].Title = fmt.Sprintf("Kind %d", pointer.Kind)
+		}
+		if pointer.Identifier != "" {
+			references[i].Title += ": " + pointer.Identifier
+		}
+		if pointer.Kind == 30023 || pointer.Kind == 30024 {
+			if article, err := fetch(ctx, code); err == nil && article.subject != "" {
+				references[i].Title = article.subject
+			}
+		}
+	}
+	return references
+}
+
+// maxGoalRelays is how many of the relays listed by a zap goal we go to for its zaps
+const maxGoalRelays = 5
+
+// resolveZapGoalProgress tallies the zaps a goal has received, from the relays the goal asks for, these
+// are chosen by whoever made the goal so they go through the same checks as the ones given in ?relays=
+func resolveZapGoalProgress(
+	ctx context.Context,
+	goal Kind9041Metadata,
+	fetchZaps func(ctx context.Context, goalID string, relays []string) []*nostr.Event,
+) ZapGoalProgress {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*3)
+	defer cancel()
+
+	relays := sanitizeRelayOverrides(goal.Relays, maxGoalRelays, !s.AllowPrivateRelays)
+	return goal.progress(fetchZaps(ctx, goal.ID, relays))
+}
+
+// resolveBadgeDefinition fetches the definition of the badge given in an award, if that fails
+// we still have the code and the identifier of the badge
+func resolveBadgeDefinition(
+	ctx context.Context,
+	award Kind8Metadata,
+	fetch func(context.Context, string) (EnhancedEvent, error),
+) BadgeDefinition {
+	if award.Definition.Code == "" {
+		return award.Definition
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*3)
+	defer cancel()
+
+	definition, err := fetch(ctx, award.Definition.Code)
+	if err != nil || definition.Kind != 30009 {
+		return award.Definition
+	}
+
+	badge := parseKind30009Metadata(*definition.Event)
+	badge.Code = award.Definition.Code
+	return badge
+}
+
+// resolveProfileBadges fetches the definitions of the badges a profile has accepted, in the order they
+// were listed, the ones we can't find are left out as there would be nothing to show for them
+func resolveProfileBadges(
+	ctx context.Context,
+	accepted []AcceptedBadge,
+	fetch func(context.Context, string) (EnhancedEvent, error),
+) []BadgeDefinition {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*3)
+	defer cancel()
+
+	accepted = accepted[:min(len(accepted), maxProfileBadges)]
+	found := make([]*BadgeDefinition, len(accepted))
+	wg := sync.WaitGroup{}
+	for i, badge := range accepted {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			definition, err := fetch(ctx, badge.Definition)
+			if err != nil || definition.Kind != 30009 {
+				return
+			}
+			resolved := parseKind30009Metadata(*definition.Event)
+			resolved.Code = badge.Definition
+			found[i] = &resolved
+		}()
+	}
+	wg.Wait()
+
+	badges := make([]BadgeDefinition, 0, len(found))
+	for _, badge := range found {
+		if badge != nil {
+			badges = append(badges, *badge)
+		}
+	}
+	return badges
+}
+
+// resolveLiveEventContext finds the live event a kind 1311 chat message was sent to, if the event
+// can't be fetched we still return its code so it can be linked
+func resolveLiveEventContext(
+	ctx context.Context,
+	ee EnhancedEvent,
+	fetch func(context.Context, string) (EnhancedEvent, error),
+) *LiveEventContext {
+	for tag := range ee.Tags.FindAll("a") {
+		pointer, err := nostr.EntityPointerFromTag(tag)
+		if err != nil || pointer.Kind != 30311 {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, time.Second*3)
+		defer cancel()
+
+		live := &LiveEventContext{Code: nip19.EncodePointer(pointer)}
+		if evt, err := fetch(ctx, live.Code); err == nil && evt.Kind == 30311 {
+			le := nip53.ParseLiveEvent(*evt.Event)
+			live.Title = le.Title
+			live.Status = le.Status
+		}
+		return live
+	}
+	return nil
+}
+
+// mediaOnlyCaption is the description for events that are nothing but links to their media, which
+// would otherwise give social cards without any text, it is empty if there is something else to say
+func mediaOnlyCaption(ee EnhancedEvent, image string, video string) string {
+	if strings.TrimSpace(urlRegex.ReplaceAllString(ee.content, "")) != "" {
+		return ""
+	}
+
+	var media string
+	switch {
+	case video != "":
+		media = "Video"
+	case image != "" && ee.Kind == 20:
+		media = "Picture"
+	case image != "":
+		media = "Image"
+	default:
+		return ""
+	}
+	return media + " posted by " + ProfileDisplayName(ee.author)
+}
+
+// quotePreviewDescription is for when a note is mostly a quote of another: as the commentary
+// alone would make for an almost empty social card we bring in the text of the quoted note
+func quotePreviewDescription(description string, pointer nostr.Pointer, quote *QuotedEvent) string {
+	own := removeQuoteReference(description, pointer)
+	if len(own) >= 60 {
+		return own
+	}
+
+	quoted := strings.Join(strings.Fields(quote.Text), " ")
+	if quoted == "" {
+		return own
+	}
+	if own != "" {
+		own += " "
+	}
+	return own + "— " + quote.Author.ShortName() + ": " + quoted
+}
+
+// removeQuoteReference removes the nostr: reference to the quoted event from the content,
+// as we're displaying it separately
+func removeQuoteReference(content string, pointer nostr.Pointer) string {
+	return strings.TrimSpace(nostrEveryMatcher.ReplaceAllStringFunc(content, func(match string) string {
+		if ref, err := nip19.ToPointer(match[len("nostr:"):]); err == nil &&
+			ref.AsTagReference() == pointer.AsTagReference() {
+			return ""
+		}
+		return match
+	}))
+}
+
 func linkQuotes(input string) string {
 	return nostrNoteNeventMatcher.ReplaceAllStringFunc(input, func(match string) string {
 		nip19 := match[len("nostr:"):]
@@ -348,7 +851,7 @@ func linkQuotes(input string) string {
 	})
 }
 
-func basicFormatting(input string, skipNostrEventLinks bool, usingTelegramInstantView bool, skipLinks bool) string {
+func basicFormatting(ctx context.Context, input string, skipNostrEventLinks bool, usingTelegramInstantView bool, skipLinks bool) string {
 	nostrMatcher := nostrEveryMatcher
 	if skipNostrEventLinks {
 		nostrMatcher = nostrNpubNprofileMatcher
@@ -366,13 +869,165 @@ func basicFormatting(input string, skipNostrEventLinks bool, usingTelegramInstan
 
 	lines := strings.Split(input, "\n")
 	for i, line := range lines {
+		line = replaceCashuTokensWithChips(line)
 		line = replaceURLsWithTags(line, imageReplacementTemplate, videoReplacementTemplate, skipLinks)
-		line = replaceNostrURLsWithHTMLTags(nostrMatcher, line)
+		line = replaceNostrURLsWithHTMLTags(ctx, nostrMatcher, line)
+		if !skipLinks {
+			line = replaceFediverseMentionsWithLinks(line)
+		}
 		lines[i] = line
 	}
 	return strings.Join(lines, "<br/>")
 }
 
+// replaceCashuTokensWithChips collapses ecash tokens into a small thing that can be copied,
+// as these are bearer money we don't want to display them in full or link to anywhere.
+// tokens that are part of a link, like a wallet URL with one in the query, are left for the link to keep working
+func replaceCashuTokensWithChips(input string) string {
+	const chip = `<span class="whitespace-nowrap rounded bg-neutral-200 px-2 dark:bg-neutral-700 dark:text-white">🥜 Cashu token` +
+		` <button type="button" class="underline" data-token="$1" _="on click call navigator.clipboard.writeText(@data-token) then put 'copied' into me">copy</button></span>`
+
+	var result strings.Builder
+	last := 0
+	for _, loc := range urlMatcher.FindAllStringIndex(input, -1) {
+		result.WriteString(cashuTokenMatcher.ReplaceAllString(input[last:loc[0]], chip))
+		result.WriteString(input[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	result.WriteString(cashuTokenMatcher.ReplaceAllString(input[last:], chip))
+	return result.String()
+}
+
+// GalleryImage is an image declared in an imeta tag, its dimensions are used to lay out the grid
+type GalleryImage struct {
+	URL    string
+	Alt    string
+	Width  int
+	Height int
+}
+
+// IsWide tells if the image is landscape enough to take the whole row of the grid
+func (gi GalleryImage) IsWide() bool {
+	return gi.Height > 0 && gi.Width*3 > gi.Height*4
+}
+
+// parseImageGallery returns the images from the imeta tags in the order they were declared,
+// or nothing if there are less than two, as a single image is just displayed inline
+func parseImageGallery(tags nostr.Tags) []GalleryImage {
+	imeta := nip92.ParseTags(tags)
+	images := make([]GalleryImage, 0, len(imeta))
+	for _, entry := range imeta {
+		if !strings.HasPrefix(entry.URL, "https://") && !strings.HasPrefix(entry.URL, "http://") ||
+			videoExtensionMatcher.MatchString(entry.URL) {
+			continue
+		}
+		if slices.ContainsFunc(images, func(gi GalleryImage) bool { return gi.URL == entry.URL }) {
+			continue
+		}
+		images = append(images, GalleryImage{URL: entry.URL, Alt: entry.Alt, Width: entry.Width, Height: entry.Height})
+	}
+	if len(images) < 2 {
+		return nil
+	}
+	return images
+}
+
+func renderImageGallery(images []GalleryImage) string {
+	if len(images) == 0 {
+		return ""
+	}
+
+	gallery := strings.Builder{}
+	gallery.WriteString(`<div class="image-grid mt-2 grid grid-cols-2 gap-1">`)
+	for _, image := range images {
+		class := "m-0 h-full w-full object-cover"
+		if image.IsWide() {
+			class += " col-span-2"
+		}
+		gallery.WriteString(`<img src="` + html.EscapeString(asciiURL(image.URL)) + `" alt="` + html.EscapeString(image.Alt) + `" class="` + class + `"`)
+		if image.Width > 0 && image.Height > 0 {
+			gallery.WriteString(fmt.Sprintf(` width="%d" height="%d" style="aspect-ratio: %d / %d"`,
+				image.Width, image.Height, image.Width, image.Height))
+		}
+		gallery.WriteString(` loading="lazy">`)
+	}
+	gallery.WriteString(`</div>`)
+	return gallery.String()
+}
+
+var tagPlaceholderMatcher = regexp.MustCompile(`#\[(\d+)\]`)
+
+// replaceTagPlaceholders turns the old NIP-08 "#[i]" mentions into nostr: links to the tagged pubkey or event,
+// in a single pass over the content, as events with thousands of tags may have none of these
+func replaceTagPlaceholders(content string, tags nostr.Tags) string {
+	if !strings.Contains(content, "#[") {
+		return content
+	}
+
+	return tagPlaceholderMatcher.ReplaceAllStringFunc(content, func(placeholder string) string {
+		i, err := strconv.Atoi(placeholder[2 : len(placeholder)-1])
+		if err != nil || i >= len(tags) || len(tags[i]) < 2 {
+			return placeholder
+		}
+
+		tag := tags[i]
+		var code string
+		switch tag[0] {
+		case "p":
+			code, err = nip19.EncodePublicKey(tag[1])
+		case "e":
+			code, err = nip19.EncodeEvent(tag[1], []string{}, "")
+		default:
+			return placeholder
+		}
+		if err != nil {
+			return placeholder
+		}
+		return "nostr:" + code
+	})
+}
+
+var inlineImageMatcher = regexp.MustCompile(`<img src="[^"]*">`)
+
+// applyImageMetadata gives the images basicFormatting made out of the content the alt text and dimensions
+// declared for them in imeta tags, and drops the ones that repeat, so the same image is only shown once
+func applyImageMetadata(content string, tags nostr.Tags) string {
+	imeta := nip92.ParseTags(tags)
+	seen := make(map[string]bool)
+	return inlineImageMatcher.ReplaceAllStringFunc(content, func(img string) string {
+		src := html.UnescapeString(imageSrcMatcher.FindStringSubmatch(img)[1])
+		if seen[src] {
+			return ""
+		}
+		seen[src] = true
+
+		idx := slices.IndexFunc(imeta, func(entry nip92.IMetaEntry) bool { return asciiURL(entry.URL) == src })
+		if idx == -1 {
+			return img
+		}
+		entry := imeta[idx]
+		attrs := ` alt="` + html.EscapeString(entry.Alt) + `"`
+		if entry.Width > 0 && entry.Height > 0 {
+			attrs += fmt.Sprintf(` width="%d" height="%d"`, entry.Width, entry.Height)
+		}
+		return strings.TrimSuffix(img, ">") + attrs + ">"
+	})
+}
+
+// hideCashuTokens is like replaceCashuTokensWithChips, but for plaintext
+func hideCashuTokens(input string) string {
+	return cashuTokenMatcher.ReplaceAllString(input, "🥜 Cashu token")
+}
+
+// only the ascii whitespace, the thin spaces we put inside names must stay where they are
+var whitespaceRunMatcher = regexp.MustCompile(`[ \t\r\n\v\f]+`)
+
+// collapseWhitespace turns plaintext into a single line for the previews, with line breaks, tabs
+// and runs of spaces becoming one space each
+func collapseWhitespace(input string) string {
+	return strings.TrimSpace(whitespaceRunMatcher.ReplaceAllString(input, " "))
+}
+
 func previewNotesFormatting(input string) string {
 	lines := strings.Split(input, "\n")
 	var processedLines []string
@@ -396,11 +1051,32 @@ func trimProtocolAndEndingSlash(relay string) string {
 	return relay
 }
 
+// displayNIP05 shows root identifiers like "_@example.com" as just "example.com", the
+// verification must still use the identifier as it is
+func displayNIP05(identifier string) string {
+	return strings.TrimPrefix(strings.TrimSpace(identifier), "_@")
+}
+
+// normalizeWebsiteURL turns the website of a profile into an http(s) link, people often write just the domain,
+// it is empty when what they wrote is not an address at all
 func normalizeWebsiteURL(u string) string {
-	if strings.HasPrefix(u, "http") {
-		return u
+	u = strings.TrimSpace(u)
+	if !strings.HasPrefix(u, "https://") && !strings.HasPrefix(u, "http://") {
+		if strings.Contains(u, "://") {
+			return ""
+		}
+		u = "https://" + u
+	}
+
+	parsed, err := url.Parse(u)
+	if err != nil || strings.ContainsAny(u, " \t\n<>\"") {
+		return ""
+	}
+	// a host without a dot can't be reached from anywhere else
+	if host := parsed.Hostname(); !strings.Contains(host, ".") || strings.HasPrefix(host, ".") || strings.HasSuffix(host, ".") {
+		return ""
 	}
-	return "https://" + u
+	return parsed.String()
 }
 
 func limitAt[V any](list []V, n int) []V {
@@ -438,21 +1114,40 @@ func getUTCOffset(loc *time.Location) string {
 	return fmt.Sprintf("UTC%s%d", sign, offsetHours)
 }
 
-func toJSONHTML(evt *nostr.Event) template.HTML {
+// maxInlineTags is how many tags of an event are shown in its JSON, unless ?tags=all asks for all of them,
+// as follow lists and other big lists can have thousands
+const maxInlineTags = 200
+
+func inlineTagsLimit(r *http.Request) int {
+	if r.URL.Query().Get("tags") == "all" {
+		return 0
+	}
+	return maxInlineTags
+}
+
+// toJSONHTML renders the event as indented JSON with links to everything it references, with only the first
+// maxTags tags (or all of them if maxTags is 0) and a link to see the others
+func toJSONHTML(evt *nostr.Event, maxTags int) template.HTML {
 	if evt == nil {
 		return ""
 	}
 
-	tagsHTML := "["
-	for t, tag := range evt.Tags {
-		tagsHTML += "\n    ["
+	tags := evt.Tags
+	if maxTags > 0 && len(tags) > maxTags {
+		tags = tags[:maxTags]
+	}
+
+	tagsHTML := strings.Builder{}
+	tagsHTML.WriteString("[")
+	for t, tag := range tags {
+		tagsHTML.WriteString("\n    [")
 		for i, item := range tag {
 			cls := `"text-zinc-500 dark:text-zinc-50"`
 			if i == 0 {
 				cls = `"text-amber-500 dark:text-amber-200"`
 			}
 
-			tagsHTML += "\n      <span class=" + cls + ">"
+			tagsHTML.WriteString("\n      <span class=" + cls + ">")
 
 			// if it's tagging another event, pubkey or address, make it a clickable link
 			linkCls := "underline underline-offset-4 text-amber-700 dark:text-amber-100 hover:text-amber-600 dark:hover:text-amber-200"
@@ -466,7 +1161,7 @@ func toJSONHTML(evt *nostr.Event) template.HTML {
 					}
 				}
 				nevent, _ := nip19.EncodeEvent(item, relayHints, authorHint)
-				tagsHTML += `<a class="` + linkCls + `" href="/` + nevent + `">"` + item + `"</a>`
+				tagsHTML.WriteString(`<a class="` + linkCls + `" href="/` + nevent + `">"` + item + `"</a>`)
 			} else if spl := strings.Split(item, ":"); i == 1 && tag[0] == "a" && len(spl) == 3 && nostr.IsValidPublicKey(spl[1]) {
 				var relayHints []string
 				if len(tag) > 2 {
@@ -474,34 +1169,39 @@ func toJSONHTML(evt *nostr.Event) template.HTML {
 				}
 				kind, _ := strconv.Atoi(spl[0])
 				naddr, _ := nip19.EncodeEntity(spl[1], kind, spl[2], relayHints)
-				tagsHTML += `<a class="` + linkCls + `" href="/` + naddr + `">"` + item + `"</a>`
+				tagsHTML.WriteString(`<a class="` + linkCls + `" href="/` + naddr + `">"` + item + `"</a>`)
 			} else if i == 1 && strings.ToLower(tag[0]) == "p" && nostr.IsValidPublicKey(item) {
 				var relayHints []string
 				if len(tag) > 2 {
 					relayHints = []string{tag[2]}
 				}
 				nprofile, _ := nip19.EncodeProfile(item, relayHints)
-				tagsHTML += `<a class="` + linkCls + `" href="/` + nprofile + `">"` + item + `"</a>`
+				tagsHTML.WriteString(`<a class="` + linkCls + `" href="/` + nprofile + `">"` + item + `"</a>`)
 			} else {
 				// otherwise just print normally
 				itemJSON, _ := json.Marshal(item)
-				tagsHTML += html.EscapeString(string(itemJSON))
+				tagsHTML.WriteString(html.EscapeString(string(itemJSON)))
 			}
 
 			if i < len(tag)-1 {
-				tagsHTML += ","
+				tagsHTML.WriteString(",")
 			} else {
-				tagsHTML += "\n    "
+				tagsHTML.WriteString("\n    ")
 			}
 		}
-		tagsHTML += "]"
+		tagsHTML.WriteString("]")
 		if t < len(evt.Tags)-1 {
-			tagsHTML += ","
+			tagsHTML.WriteString(",")
 		} else {
-			tagsHTML += "\n  "
+			tagsHTML.WriteString("\n  ")
 		}
 	}
-	tagsHTML += "]"
+	if len(tags) < len(evt.Tags) {
+		tagsHTML.WriteString(fmt.Sprintf(`
+    <a class="show-all-tags underline underline-offset-4 text-amber-700 dark:text-amber-100" href="?details=yes&amp;tags=all">… show all %d tags</a>
+  `, len(evt.Tags)))
+	}
+	tagsHTML.WriteString("]")
 
 	contentJSON, _ := json.Marshal(evt.Content)
 
@@ -516,7 +1216,7 @@ func toJSONHTML(evt *nostr.Event) template.HTML {
   <span class="`+keyCls+`">"tags":</span> %s,
   <span class="`+keyCls+`">"content":</span> <span class="text-zinc-500 dark:text-zinc-50">%s</span>,
   <span class="`+keyCls+`">"sig":</span> <span class="text-zinc-500 dark:text-zinc-50 content">"%s"</span>
-}`, evt.ID, evt.PubKey, evt.CreatedAt, evt.Kind, tagsHTML, html.EscapeString(string(contentJSON)), evt.Sig),
+}`, evt.ID, evt.PubKey, evt.CreatedAt, evt.Kind, tagsHTML.String(), html.EscapeString(string(contentJSON)), evt.Sig),
 	)
 }
 
@@ -0,0 +1,654 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"html/template"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/sdk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuoteRepost(t *testing.T) {
+	const quotedID = "3406a4f6bd8ee2c4a0bdcb6e7d9ff76a8b0c5fcaa3f6f2a1fdc6ab0f5cde6c6e"
+	nevent, _ := nip19.EncodeEvent(quotedID, nil, testPubkey2)
+
+	ee := testEnhancedEvent(&nostr.Event{
+		Kind:    1,
+		Content: "this is so true\n\nnostr:" + nevent,
+		Tags:    nostr.Tags{{"q", quotedID, "wss://relay.damus.io/", testPubkey2}},
+	})
+
+	pointer := ee.quotedEvent()
+	assert.Equal(t, nostr.EventPointer{ID: quotedID, Relays: []string{"wss://relay.damus.io/"}, Author: testPubkey2}, pointer)
+
+	var fetched string
+	quote := resolveQuote(context.Background(), pointer, func(ctx context.Context, code string) (EnhancedEvent, error) {
+		fetched = code
+		return enhanceEvent(
+			&nostr.Event{ID: quotedID, PubKey: testPubkey2, Kind: 1, Content: "quoted <b>words</b>"},
+			sdk.ProfileMetadata{PubKey: testPubkey2, Name: "hodlbod"},
+		), nil
+	})
+	assert.NotNil(t, quote)
+	assert.Equal(t, nip19.EncodePointer(pointer), fetched)
+	assert.Equal(t, "this is so true", removeQuoteReference(ee.Content, pointer))
+
+	buf := &bytes.Buffer{}
+	params := NotePageParams{
+		BaseEventPageParams: BaseEventPageParams{Event: ee},
+		Content:             template.HTML(removeQuoteReference(ee.Content, pointer)),
+		Quote:               quote,
+	}
+	assert.NoError(t, noteInnerBlock(params).Render(context.Background(), buf))
+	page := buf.String()
+	assert.Contains(t, page, "this is so true")
+	assert.Contains(t, page, `quoting <a href="/`+fetched+`" class="text-strongpink">hodlbod</a>`)
+	assert.Contains(t, page, "quoted &lt;b&gt;words&lt;/b&gt;")
+	assert.Less(t, strings.Index(page, "this is so true"), strings.Index(page, "quoting"))
+
+	// a note without a q tag stays as it is
+	plain := testEnhancedEvent(&nostr.Event{Kind: 1, Content: "gm nostr:" + nevent})
+	assert.Nil(t, plain.quotedEvent())
+
+	buf.Reset()
+	params = NotePageParams{BaseEventPageParams: BaseEventPageParams{Event: plain}, Content: template.HTML(plain.Content)}
+	assert.NoError(t, noteInnerBlock(params).Render(context.Background(), buf))
+	assert.NotContains(t, buf.String(), "quoting")
+}
+
+func TestBatchedMentionResolution(t *testing.T) {
+	names := []string{"alice", "bob", "carol", "dave", "erin"}
+	pubkeys := make([]string, len(names))
+	npubs := make([]string, len(names))
+	for i := range names {
+		pubkeys[i], _ = nostr.GetPublicKey(nostr.GeneratePrivateKey())
+		npubs[i], _ = nip19.EncodePublicKey(pubkeys[i])
+	}
+
+	fetches := 0
+	mentionResolver = profileResolver{
+		cache: testMetadataCache{},
+		fetch: func(ctx context.Context, requested []string) []sdk.ProfileMetadata {
+			fetches++
+			profiles := make([]sdk.ProfileMetadata, 0, len(requested))
+			for i, pubkey := range pubkeys {
+				if slices.Contains(requested, pubkey) {
+					profiles = append(profiles, sdk.ProfileMetadata{PubKey: pubkey, Name: names[i]})
+				}
+			}
+			return profiles
+		},
+	}
+	defer func() { mentionResolver = profileResolver{} }()
+
+	// the same person mentioned twice, plus the other four
+	input := "gm nostr:" + npubs[0] + " and nostr:" + npubs[0]
+	for _, npub := range npubs[1:] {
+		input += " nostr:" + npub
+	}
+
+	output := replaceNostrURLsWithHTMLTags(context.Background(), nostrNpubNprofileMatcher, input)
+	assert.Equal(t, 1, fetches)
+	for i, name := range names {
+		assert.Contains(t, output, `href="/`+npubs[i]+`" class="bg-lavender dark:prose:text-neutral-50 dark:text-neutral-50 dark:bg-garnet px-1"><span class="inline-block max-w-[16rem] truncate align-bottom">`+name+`</span>`)
+	}
+
+	// none of them can be broken in the middle
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(output))
+	assert.NoError(t, err)
+	mentions := doc.Find(`[itemprop="mentions"]`)
+	assert.Equal(t, len(npubs)+1, mentions.Length())
+	mentions.Each(func(_ int, mention *goquery.Selection) {
+		assert.True(t, mention.HasClass("whitespace-nowrap"))
+	})
+
+	// now they're all cached
+	plain := replaceUserReferencesWithNames(context.Background(), []string{input}, "@")[0]
+	assert.Equal(t, 1, fetches)
+	assert.Equal(t, "gm @alice and @alice @bob @carol @dave @erin", plain)
+
+	// unknown people and events only get the short code, which doesn't break either
+	stranger, _ := nip19.EncodePublicKey(testPubkey2)
+	nevent, _ := nip19.EncodeEvent(strings.Repeat("a", 64), nil, "")
+	doc, err = goquery.NewDocumentFromReader(strings.NewReader(
+		replaceNostrURLsWithHTMLTags(context.Background(), nostrEveryMatcher, "nostr:"+stranger+" said nostr:"+nevent)))
+	assert.NoError(t, err)
+	mentions = doc.Find(`[itemprop="mentions"]`)
+	assert.Equal(t, 2, mentions.Length())
+	assert.Equal(t, stranger[:8]+"…"+stranger[len(stranger)-4:], mentions.First().Text())
+	mentions.Each(func(_ int, mention *goquery.Selection) {
+		assert.True(t, mention.HasClass("whitespace-nowrap"))
+	})
+}
+
+func TestCashuTokenChip(t *testing.T) {
+	token := "cashuAeyJ0b2tlbiI6W3sibWludCI6Imh0dHBzOi8vODMzMy5zcGFjZTozMzM4IiwicHJvb2ZzIjpbeyJhbW91bnQiOjJ9XX1dfQ"
+	content := basicFormatting(context.Background(), html.EscapeString("here is some ecash for you\n"+token+" enjoy"), true, false, false)
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.Contains(t, doc.Text(), "🥜 Cashu token")
+	assert.Contains(t, doc.Text(), "enjoy")
+	assert.NotContains(t, doc.Text(), token)
+	assert.Equal(t, 0, doc.Find("a").Length())
+	assert.Equal(t, token, doc.Find("button").AttrOr("data-token", ""))
+
+	// a link with a token in it is still a link, while a token next to it is still collapsed
+	link := "https://wallet.example.com/receive?token=" + token
+	content = basicFormatting(context.Background(), html.EscapeString("redeem at "+link+" or copy "+token), true, false, false)
+	doc, err = goquery.NewDocumentFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, doc.Find("a").Length())
+	assert.Equal(t, link, doc.Find("a").AttrOr("href", ""))
+	assert.Equal(t, 1, doc.Find("button").Length())
+
+	assert.Equal(t, "take this 🥜 Cashu token", hideCashuTokens("take this cashu:"+token))
+}
+
+func TestInternationalizedDomainLinks(t *testing.T) {
+	content := basicFormatting(context.Background(), html.EscapeString("look https://例え.jp/パス?q=1&x=2 and https://xn--r8jz45g.jp/ and https://example.com/"), true, false, false)
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+
+	links := doc.Find("a")
+	assert.Equal(t, 3, links.Length())
+	assert.Equal(t, "https://xn--r8jz45g.jp/パス?q=1&x=2", links.Eq(0).AttrOr("href", ""))
+	assert.Equal(t, "https://例え.jp/パス?q=1&x=2", links.Eq(0).Text())
+	assert.Equal(t, "https://xn--r8jz45g.jp/", links.Eq(1).AttrOr("href", ""))
+	assert.Equal(t, "https://xn--r8jz45g.jp/", links.Eq(1).Text())
+	assert.Equal(t, "https://example.com/", links.Eq(2).AttrOr("href", ""))
+	assert.Equal(t, "https://example.com/", links.Eq(2).Text())
+
+	assert.Equal(t, `<img src="https://xn--r8jz45g.jp/cat.png">`, strings.TrimSpace(
+		basicFormatting(context.Background(), "https://例え.jp/cat.png", true, false, false)))
+
+	// a host mixing scripts is shown in punycode, however it was written, so it can't pass for another one
+	lookalike := "https://pаypal.com/login" // with a cyrillic "а"
+	punycode := asciiURL(lookalike)
+	assert.True(t, strings.HasPrefix(punycode, "https://xn--"))
+	for _, written := range []string{lookalike, punycode} {
+		doc, err = goquery.NewDocumentFromReader(strings.NewReader(basicFormatting(context.Background(), written, true, false, false)))
+		assert.NoError(t, err)
+		assert.Equal(t, punycode, doc.Find("a").AttrOr("href", ""))
+		assert.Equal(t, punycode, doc.Find("a").Text())
+	}
+	assert.Equal(t, "https://пример.рф/", displayURL("https://пример.рф/"))
+	assert.Equal(t, "https://例え.jp/", displayURL("https://例え.jp/"))
+}
+
+func TestQuotePreviewDescription(t *testing.T) {
+	const quotedID = "3406a4f6bd8ee2c4a0bdcb6e7d9ff76a8b0c5fcaa3f6f2a1fdc6ab0f5cde6c6e"
+	nevent, _ := nip19.EncodeEvent(quotedID, nil, testPubkey2)
+	pointer := nostr.EventPointer{ID: quotedID, Author: testPubkey2}
+
+	quote := resolveQuote(context.Background(), pointer, func(ctx context.Context, code string) (EnhancedEvent, error) {
+		return enhanceEvent(
+			&nostr.Event{ID: quotedID, PubKey: testPubkey2, Kind: 1, Content: "relays are\njust dumb servers"},
+			sdk.ProfileMetadata{PubKey: testPubkey2, Name: "hodlbod"},
+		), nil
+	})
+	assert.NotNil(t, quote)
+
+	// short commentary gets the quoted text
+	assert.Equal(t, "this! — hodlbod: relays are just dumb servers",
+		quotePreviewDescription("this!\n\nnostr:"+nevent, pointer, quote))
+
+	// no commentary at all
+	assert.Equal(t, "— hodlbod: relays are just dumb servers",
+		quotePreviewDescription("nostr:"+nevent, pointer, quote))
+
+	// long commentary stands on its own
+	long := strings.Repeat("I have a lot of things to say about this. ", 3)
+	assert.Equal(t, strings.TrimSpace(long), quotePreviewDescription(long+"nostr:"+nevent, pointer, quote))
+}
+
+func TestAddressReferences(t *testing.T) {
+	ee := testEnhancedEvent(&nostr.Event{
+		Kind:    1,
+		Content: "great read",
+		Tags: nostr.Tags{
+			{"a", "30023:" + testPubkey2 + ":why-nostr", "wss://relay.example.com"},
+			{"a", "30023:" + testPubkey2 + ":why-nostr"},
+			{"a", "30023:notapubkey:broken"},
+			{"a", "garbage"},
+			{"a", "1:" + testPubkey2 + ":"},
+			{"a", "30818:" + testPubkey2 + ":bitcoin"},
+		},
+	})
+
+	pointers := ee.addressReferences()
+	assert.Len(t, pointers, 2)
+
+	var fetched []string
+	references := resolveAddressReferences(context.Background(), pointers, func(ctx context.Context, code string) (EnhancedEvent, error) {
+		fetched = append(fetched, code)
+		return testEnhancedEvent(&nostr.Event{Kind: 30023, PubKey: testPubkey2, Tags: nostr.Tags{{"title", "Why Nostr?"}}}), nil
+	})
+	assert.Len(t, fetched, 1)
+	assert.Equal(t, nip19.EncodePointer(pointers[0]), references[0].Code)
+	assert.Equal(t, "Why Nostr?", references[0].Title)
+	assert.Equal(t, "Wiki article: bitcoin", references[1].Title)
+
+	var buf bytes.Buffer
+	params := NotePageParams{BaseEventPageParams: BaseEventPageParams{Event: ee}, Addresses: references}
+	assert.NoError(t, noteInnerBlock(params).Render(context.Background(), &buf))
+	doc, err := goquery.NewDocumentFromReader(&buf)
+	assert.NoError(t, err)
+	links := doc.Find(".address-references a")
+	assert.Equal(t, 2, links.Length())
+	assert.Equal(t, "/"+references[0].Code, links.Eq(0).AttrOr("href", ""))
+	assert.Equal(t, "Why Nostr?", links.Eq(0).Text())
+}
+
+func TestLiveChatMessageContext(t *testing.T) {
+	address := "30311:" + testPubkey2 + ":stream-1"
+	message := testEnhancedEvent(&nostr.Event{Kind: 1311, Content: "hello chat", Tags: nostr.Tags{{"a", address, "", "root"}}})
+
+	var fetched string
+	live := resolveLiveEventContext(context.Background(), message, func(ctx context.Context, code string) (EnhancedEvent, error) {
+		fetched = code
+		return testEnhancedEvent(&nostr.Event{Kind: 30311, PubKey: testPubkey2, Tags: nostr.Tags{
+			{"d", "stream-1"}, {"title", "Nostr Talk Show"}, {"status", "live"},
+		}}), nil
+	})
+	assert.Equal(t, nip19.EncodePointer(nostr.EntityPointer{PublicKey: testPubkey2, Kind: 30311, Identifier: "stream-1"}), fetched)
+	assert.Equal(t, &LiveEventContext{Code: fetched, Title: "Nostr Talk Show", Status: "live"}, live)
+
+	render := func(live *LiveEventContext) *goquery.Document {
+		var buf bytes.Buffer
+		params := LiveEventMessagePageParams{
+			BaseEventPageParams: BaseEventPageParams{Event: message},
+			Content:             template.HTML("hello chat"),
+			LiveEvent:           live,
+		}
+		assert.NoError(t, liveEventMessageInnerBlock(params).Render(context.Background(), &buf))
+		doc, err := goquery.NewDocumentFromReader(&buf)
+		assert.NoError(t, err)
+		return doc
+	}
+
+	doc := render(live)
+	header := doc.Find(".live-event-context")
+	assert.Equal(t, "/"+fetched, header.Find("a").AttrOr("href", ""))
+	assert.Equal(t, "Nostr Talk Show", strings.TrimSpace(header.Find("a").Text()))
+	assert.Contains(t, header.Text(), "Live")
+
+	// the live event is gone, we still link to it
+	orphan := resolveLiveEventContext(context.Background(), message, func(ctx context.Context, code string) (EnhancedEvent, error) {
+		return EnhancedEvent{}, fmt.Errorf("not found")
+	})
+	assert.Equal(t, &LiveEventContext{Code: fetched}, orphan)
+	doc = render(orphan)
+	assert.Equal(t, "a live event", strings.TrimSpace(doc.Find(".live-event-context a").Text()))
+	assert.Contains(t, doc.Text(), "hello chat")
+
+	// no reference at all
+	assert.Nil(t, resolveLiveEventContext(context.Background(), testEnhancedEvent(&nostr.Event{Kind: 1311}), nil))
+	assert.Equal(t, 0, render(nil).Find(".live-event-context").Length())
+}
+
+func TestRootNIP05Display(t *testing.T) {
+	assert.Equal(t, "example.com", displayNIP05("_@example.com"))
+	assert.Equal(t, "Example.com", displayNIP05(" _@Example.com "))
+	assert.Equal(t, "alice@example.com", displayNIP05("alice@example.com"))
+
+	for identifier, expected := range map[string]string{
+		"_@example.com":     "example.com",
+		"alice@example.com": "alice@example.com",
+	} {
+		var buf bytes.Buffer
+		params := ProfilePageParams{Metadata: sdk.ProfileMetadata{PubKey: testPubkey1, NIP05: identifier}}
+		assert.NoError(t, embeddedProfileTemplate(params).Render(context.Background(), &buf))
+		doc, err := goquery.NewDocumentFromReader(&buf)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, doc.Find(".nip05").Text())
+	}
+}
+
+func TestProfileWebsite(t *testing.T) {
+	assert.Equal(t, "https://example.com", normalizeWebsiteURL(" example.com "))
+	assert.Equal(t, "http://example.com/blog?x=1", normalizeWebsiteURL("http://example.com/blog?x=1"))
+	for _, garbage := range []string{"", "my cool site", "localhost", "javascript:alert(1)", "ftp://example.com", "https://", "example.com/<script>"} {
+		assert.Equal(t, "", normalizeWebsiteURL(garbage), garbage)
+	}
+
+	render := func(website string) *goquery.Document {
+		var buf bytes.Buffer
+		params := ProfilePageParams{
+			Metadata:                   sdk.ProfileMetadata{PubKey: testPubkey1, Website: website},
+			NormalizedAuthorWebsiteURL: normalizeWebsiteURL(website),
+		}
+		assert.NoError(t, profileTemplate(params).Render(context.Background(), &buf))
+		doc, err := goquery.NewDocumentFromReader(&buf)
+		assert.NoError(t, err)
+		return doc
+	}
+
+	link := render("fiatjaf.com").Find("a.profile-website")
+	assert.Equal(t, "https://fiatjaf.com", link.AttrOr("href", ""))
+	assert.Equal(t, "fiatjaf.com", link.Text())
+
+	assert.Equal(t, 0, render("ask me on a relay").Find("a.profile-website").Length())
+}
+
+func TestFormatContent(t *testing.T) {
+	formatted, ok := FormatContent(`{"name":"app","relays":["wss://a.com"]}`)
+	assert.True(t, ok)
+	assert.Contains(t, formatted, `name = "app"`)
+
+	for _, raw := range []string{`{"name":"app",`, "just some text", `["an","array"]`} {
+		formatted, ok = FormatContent(raw)
+		assert.False(t, ok)
+		assert.Equal(t, raw, formatted)
+	}
+}
+
+func TestImetaGallery(t *testing.T) {
+	evt := nostr.Event{
+		Kind:      1,
+		PubKey:    testPubkey1,
+		CreatedAt: 1710000000,
+		// the urls appear in a different order in the content
+		Content: "my trip https://example.com/c.jpg https://example.com/a.jpg\nhttps://example.com/b.jpg",
+		Tags: nostr.Tags{
+			{"imeta", "url https://example.com/a.jpg", "dim 1600x900", "alt the beach"},
+			{"imeta", "url https://example.com/b.jpg", "dim 600x800"},
+			{"imeta", "url https://example.com/c.jpg", "dim 1000x1000"},
+		},
+	}
+
+	gallery := parseImageGallery(evt.Tags)
+	assert.Len(t, gallery, 3)
+	assert.True(t, gallery[0].IsWide())
+	assert.False(t, gallery[1].IsWide())
+	assert.False(t, gallery[2].IsWide())
+
+	doc := testPreviewPage(t, evt)
+
+	// the images are only in the grid, in the order of the tags
+	assert.Equal(t, 3, doc.Find("[itemprop=articleBody] img").Length())
+	images := doc.Find(".image-grid img")
+	assert.Equal(t, 3, images.Length())
+	var srcs []string
+	images.Each(func(i int, img *goquery.Selection) { srcs = append(srcs, img.AttrOr("src", "")) })
+	assert.Equal(t, []string{"https://example.com/a.jpg", "https://example.com/b.jpg", "https://example.com/c.jpg"}, srcs)
+
+	first := images.First()
+	assert.Equal(t, "1600", first.AttrOr("width", ""))
+	assert.Equal(t, "900", first.AttrOr("height", ""))
+	assert.Equal(t, "aspect-ratio: 1600 / 900", first.AttrOr("style", ""))
+	assert.Equal(t, "the beach", first.AttrOr("alt", ""))
+	assert.True(t, first.HasClass("col-span-2"))
+	assert.False(t, images.Eq(1).HasClass("col-span-2"))
+	assert.Contains(t, doc.Find("[itemprop=articleBody]").Text(), "my trip")
+
+	// a single image is left inline
+	assert.Nil(t, parseImageGallery(evt.Tags[0:1]))
+}
+
+func TestProfileBadges(t *testing.T) {
+	definitions := map[string]EnhancedEvent{}
+	for _, d := range []string{"bravery", "honor"} {
+		evt := testEnhancedEvent(&nostr.Event{
+			Kind: 30009,
+			Tags: nostr.Tags{{"d", d}, {"name", "Medal of " + d}, {"thumb", "https://example.com/" + d + ".png"}},
+		})
+		naddr, _ := nip19.EncodeEntity(testPubkey1, 30009, d, nil)
+		definitions[naddr] = evt
+	}
+
+	award := strings.Repeat("a", 64)
+	accepted := parseKind30008Metadata(nostr.Event{
+		Kind: 30008,
+		Tags: nostr.Tags{
+			{"d", "profile_badges"},
+			{"a", "30009:" + testPubkey1 + ":bravery"}, {"e", award, "wss://relay"},
+			{"a", "30009:" + testPubkey1 + ":vanished"}, {"e", award},
+			{"a", "30009:" + testPubkey1 + ":unpaired"},
+			{"a", "30009:" + testPubkey1 + ":honor"}, {"e", award},
+			{"a", "30023:" + testPubkey1 + ":article"}, {"e", award},
+		},
+	})
+	assert.Len(t, accepted.Accepted, 3)
+	assert.Equal(t, award, accepted.Accepted[0].AwardID)
+
+	badges := resolveProfileBadges(context.Background(), accepted.Accepted, func(ctx context.Context, code string) (EnhancedEvent, error) {
+		if evt, ok := definitions[code]; ok {
+			return evt, nil
+		}
+		return EnhancedEvent{}, fmt.Errorf("not found")
+	})
+	assert.Len(t, badges, 2)
+	assert.Equal(t, "Medal of bravery", badges[0].Name)
+	assert.Equal(t, "Medal of honor", badges[1].Name)
+	assert.Equal(t, accepted.Accepted[2].Definition, badges[1].Code)
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, badgeTemplate(BadgePageParams{
+		BaseEventPageParams: BaseEventPageParams{Event: testEnhancedEvent(&nostr.Event{Kind: 30008})},
+		IsProfileBadges:     true,
+		Accepted:            badges,
+	}, false).Render(context.Background(), buf))
+	doc, err := goquery.NewDocumentFromReader(buf)
+	assert.NoError(t, err)
+	row := doc.Find(".profile-badges .profile-badge")
+	assert.Equal(t, 2, row.Length())
+	assert.Equal(t, "/"+badges[0].Code, row.First().AttrOr("href", ""))
+	assert.Equal(t, "https://example.com/bravery.png", row.First().Find("img").AttrOr("src", ""))
+	assert.Equal(t, "Medal of honor", strings.TrimSpace(row.Last().Text()))
+	assert.Equal(t, 0, doc.Find(".badge").Length())
+}
+
+func TestMediaOnlyCaption(t *testing.T) {
+	for content, expected := range map[string]string{
+		"https://example.com/cat.jpg":                                 "Image posted by ",
+		"  https://example.com/cat.jpg\nhttps://example.com/dog.png ": "Image posted by ",
+		"https://example.com/cat.mp4":                                 "Video posted by ",
+		"look at my cat https://example.com/cat.jpg":                  "look at my cat",
+	} {
+		evt := nostr.Event{Kind: 1, CreatedAt: 1710000000, Tags: nostr.Tags{}, Content: content}
+		doc := testPreviewPage(t, evt)
+
+		description := doc.Find(`meta[property="og:description"]`).AttrOr("content", "")
+		assert.NotEmpty(t, description, content)
+		assert.True(t, strings.HasPrefix(description, expected), description)
+	}
+
+	// a link that isn't media doesn't get a caption, nor does anything with text in it
+	assert.Equal(t, "Image posted by fiatjaf", mediaOnlyCaption(testEnhancedEvent(&nostr.Event{Kind: 1, Content: "https://x.com/a.png"}), "https://x.com/a.png", ""))
+	assert.Equal(t, "Picture posted by fiatjaf", mediaOnlyCaption(testEnhancedEvent(&nostr.Event{Kind: 20}), "https://x.com/a.png", ""))
+	assert.Equal(t, "", mediaOnlyCaption(testEnhancedEvent(&nostr.Event{Kind: 1, Content: "https://x.com/article"}), "", ""))
+	assert.Equal(t, "", mediaOnlyCaption(testEnhancedEvent(&nostr.Event{Kind: 1, Content: "gm https://x.com/a.png"}), "https://x.com/a.png", ""))
+}
+
+func TestMediaHosts(t *testing.T) {
+	previous := mediaHosts
+	mediaHosts = []string{"image.nostr.build", "*.blossom.example"}
+	defer func() { mediaHosts = previous }()
+
+	image := `<img src="%s">`
+	video := `<video src="%s">`
+	for input, expected := range map[string]string{
+		"https://image.nostr.build/3f2a9c":         `<img src="https://image.nostr.build/3f2a9c">`,
+		"https://IMAGE.nostr.build/3f2a9c?w=400":   `<img src="https://image.nostr.build/3f2a9c?w=400">`,
+		"https://cdn.blossom.example/b1674191a88e": `<img src="https://cdn.blossom.example/b1674191a88e">`,
+		"https://image.nostr.build/clip.mp4":       `<video src="https://image.nostr.build/clip.mp4">`,
+		"https://unknown.example/3f2a9c":           `<a href="https://unknown.example/3f2a9c">https://unknown.example/3f2a9c</a>`,
+		"https://blossom.example/b1674191a88e":     `<a href="https://blossom.example/b1674191a88e">https://blossom.example/b1674191a88e</a>`,
+		"https://image.nostr.build/":               `<a href="https://image.nostr.build/">https://image.nostr.build/</a>`,
+		"https://notimage.nostr.build.evil/3f2a9c": `<a href="https://notimage.nostr.build.evil/3f2a9c">https://notimage.nostr.build.evil/3f2a9c</a>`,
+	} {
+		assert.Equal(t, expected, replaceURLsWithTags(input, image, video, false), input)
+	}
+
+	// it is also what the page uses as its image
+	evt := nostr.Event{Kind: 1, CreatedAt: 1710000000, Tags: nostr.Tags{}, Content: "https://image.nostr.build/3f2a9c"}
+	doc := testPreviewPage(t, evt)
+	assert.Equal(t, "https://image.nostr.build/3f2a9c", doc.Find(`meta[property="og:image"]`).AttrOr("content", ""))
+
+	mediaHosts = nil
+	assert.Equal(t, `<a href="https://image.nostr.build/3f2a9c">https://image.nostr.build/3f2a9c</a>`,
+		replaceURLsWithTags("https://image.nostr.build/3f2a9c", image, video, false))
+}
+
+func TestImetaAndInlineImage(t *testing.T) {
+	evt := nostr.Event{
+		Kind:      1,
+		CreatedAt: 1710000000,
+		Tags: nostr.Tags{
+			{"imeta", "url https://example.com/cat.jpg?size=large&v=2", "m image/jpeg", "alt a cat sleeping on a keyboard", "dim 800x600"},
+		},
+		Content: "look at him https://example.com/cat.jpg?size=large&v=2\n\nhere he is again https://example.com/cat.jpg?size=large&v=2",
+	}
+	doc := testPreviewPage(t, evt)
+
+	images := doc.Find(`article img[src="https://example.com/cat.jpg?size=large&v=2"]`)
+	assert.Equal(t, 1, images.Length())
+	assert.Equal(t, "a cat sleeping on a keyboard", images.AttrOr("alt", ""))
+	assert.Equal(t, "800", images.AttrOr("width", ""))
+	assert.Contains(t, doc.Find("article").Text(), "here he is again")
+
+	// images not in imeta are left as they are
+	assert.Equal(t, ` <img src="https://example.com/dog.png"> `, applyImageMetadata(` <img src="https://example.com/dog.png"> `, nil))
+}
+
+func TestFediverseMentions(t *testing.T) {
+	evt := nostr.Event{
+		Kind:      1,
+		CreatedAt: 1710000000,
+		Content:   "cc @Gargron@mastodon.social, mail me at bob@example.com or zap alice@getalby.com (or @bob@pixel.example.org.)",
+	}
+	doc := testPreviewPage(t, evt)
+
+	var links []string
+	doc.Find("article a.fediverse-mention").Each(func(_ int, a *goquery.Selection) {
+		links = append(links, a.Text()+" "+a.AttrOr("href", ""))
+	})
+	assert.Equal(t, []string{
+		"@Gargron@mastodon.social https://mastodon.social/@Gargron",
+		"@bob@pixel.example.org https://pixel.example.org/@bob",
+	}, links)
+	assert.Equal(t, 0, doc.Find(`article a[href*="example.com"], article a[href*="getalby.com"]`).Length())
+	assert.Contains(t, doc.Find("article").Text(), "mail me at bob@example.com")
+
+	// links to profiles are left as they are
+	assert.Equal(t, "https://mastodon.social/@Gargron@mastodon.social", replaceFediverseMentionsWithLinks("https://mastodon.social/@Gargron@mastodon.social"))
+}
+
+func TestStripTrackingParams(t *testing.T) {
+	params := []string{"utm_*", "fbclid"}
+	assert.Equal(t, "https://example.com/article?id=42#comments",
+		stripTrackingParams("https://example.com/article?utm_source=nostr&id=42&fbclid=abc&UTM_Medium=x#comments", params))
+	assert.Equal(t, "https://example.com/article", stripTrackingParams("https://example.com/article?fbclid=abc", params))
+	assert.Equal(t, "https://example.com/?a=1&b=%20", stripTrackingParams("https://example.com/?a=1&b=%20", params))
+
+	previous := trackingParams
+	trackingParams = params
+	defer func() { trackingParams = previous }()
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(
+		basicFormatting(context.Background(), html.EscapeString("read https://example.com/article?utm_source=nostr&id=42&fbclid=abc now"), true, false, false)))
+	assert.NoError(t, err)
+	link := doc.Find("a")
+	assert.Equal(t, "https://example.com/article?id=42", link.AttrOr("href", ""))
+	assert.Equal(t, "https://example.com/article?id=42", link.Text())
+}
+
+func TestLargeTagArrays(t *testing.T) {
+	evt := nostr.Event{Kind: 1, CreatedAt: 1710000000, Content: "so many tags, see #[4999]"}
+	for i := 0; i < 5000; i++ {
+		evt.Tags = append(evt.Tags, nostr.Tag{"x", fmt.Sprintf("value-%d", i)})
+	}
+	assert.NoError(t, evt.Sign(nostr.GeneratePrivateKey()))
+
+	preview := func(target string) *goquery.Document {
+		w := testPreview(t, target, evt)
+		assert.Equal(t, http.StatusOK, w.Code)
+		doc, err := goquery.NewDocumentFromReader(w.Body)
+		assert.NoError(t, err)
+		return doc
+	}
+
+	eventJSON := preview("/preview").Find("#hidden-fields .font-mono")
+	assert.Equal(t, maxInlineTags, strings.Count(eventJSON.Text(), `"x"`))
+	assert.Contains(t, eventJSON.Text(), `"value-199"`)
+	assert.NotContains(t, eventJSON.Text(), `"value-200"`)
+	expander := eventJSON.Find("a.show-all-tags")
+	assert.Equal(t, "… show all 5000 tags", expander.Text())
+	assert.Equal(t, "?details=yes&tags=all", expander.AttrOr("href", ""))
+
+	eventJSON = preview("/preview?tags=all").Find("#hidden-fields .font-mono")
+	assert.Equal(t, 5000, strings.Count(eventJSON.Text(), `"x"`))
+	assert.Equal(t, 0, eventJSON.Find("a.show-all-tags").Length())
+
+	// placeholders pointing to tags that aren't pubkeys or events are left alone
+	assert.Equal(t, "see #[4999] and #[9999]", replaceTagPlaceholders("see #[4999] and #[9999]", evt.Tags))
+	npub, _ := nip19.EncodePublicKey(testPubkey1)
+	assert.Equal(t, "hi nostr:"+npub, replaceTagPlaceholders("hi #[0]", nostr.Tags{{"p", testPubkey1}}))
+}
+
+func TestPlaintextPreviewWhitespace(t *testing.T) {
+	assert.Equal(t, "a b c d", collapseWhitespace("  a \t\tb\n\n  c\r\nd \n"))
+	assert.Equal(t, "Alice"+string(THIN_SPACE)+"Wonder says hi", collapseWhitespace("Alice"+string(THIN_SPACE)+"Wonder   says\thi"))
+
+	evt := nostr.Event{
+		Kind:      1,
+		CreatedAt: 1710000000,
+		Tags:      nostr.Tags{},
+		Content:   "Shopping list:\n\n\t- eggs\t\t(a dozen)\n\t-    milk   \n",
+	}
+	doc := testPreviewPage(t, evt)
+
+	assert.Equal(t, "Shopping list: - eggs (a dozen) - milk", doc.Find(`meta[property="og:description"]`).AttrOr("content", ""))
+	assert.Equal(t, "Shopping list: - eggs (a dozen) - milk", doc.Find(`meta[name="twitter:description"]`).AttrOr("content", ""))
+}
+
+func FuzzParseNostrCode(f *testing.F) {
+	npub, _ := nip19.EncodePublicKey(testPubkey1)
+	nprofile, _ := nip19.EncodeProfile(testPubkey2, []string{"wss://relay.damus.io"})
+	note, _ := nip19.EncodeNote("3406a4f6bd8ee2c4a0bdcb6e7d9ff76a8b0c5fcaa3f6f2a1fdc6ab0f5cde6c6e")
+	nevent, _ := nip19.EncodeEvent("3406a4f6bd8ee2c4a0bdcb6e7d9ff76a8b0c5fcaa3f6f2a1fdc6ab0f5cde6c6e", []string{"wss://nos.lol"}, testPubkey1)
+	naddr, _ := nip19.EncodeEntity(testPubkey1, 30023, "my-article", []string{"wss://nostr.wine"})
+
+	for _, seed := range []string{
+		// good
+		npub, nprofile, note, nevent, naddr, strings.ToUpper(npub),
+		// bad
+		"", "npub1", "note1", "nevent1qqqqqq", "naddr1qqqqqqqqqqqq",
+		npub[:len(npub)-1], nevent[:len(nevent)-10], "Npub" + npub[4:],
+		"nostr:" + npub, "fiatjaf.com", "bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq",
+		strings.Repeat("q", 200), "nevent1" + strings.Repeat("l", 100),
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, code string) {
+		prefix, decoded, err := parseNostrCode(code)
+		if err != nil {
+			return
+		}
+
+		switch prefix {
+		case "npub", "nsec", "note":
+			assert.Len(t, decoded.(string), 64)
+		case "nprofile":
+			assert.NotEmpty(t, decoded.(nostr.ProfilePointer).PublicKey)
+		case "nevent":
+			assert.NotEmpty(t, decoded.(nostr.EventPointer).ID)
+		case "naddr":
+			assert.NotEmpty(t, decoded.(nostr.EntityPointer).PublicKey)
+		default:
+			t.Fatalf("unexpected prefix '%s' decoded without error from '%s'", prefix, code)
+		}
+	})
+}
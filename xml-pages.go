@@ -15,7 +15,7 @@ var (
 )
 
 type SitemapPage struct {
-	Host       string
+	BaseURL    string
 	ModifiedAt string
 
 	// for the profile sitemap
@@ -42,8 +42,8 @@ var (
 )
 
 type SitemapIndexPage struct {
-	Host  string
-	Npubs []string
+	BaseURL string
+	Npubs   []string
 }
 
 func (*SitemapIndexPage) TemplateText() string { return tmplSitemapIndex }
@@ -55,7 +55,7 @@ var (
 )
 
 type RSSPage struct {
-	Host       string
+	BaseURL    string
 	ModifiedAt string
 	Title      string
 
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/sdk"
+)
+
+// ZapSplit is one of the recipients of the zaps sent to an event, as given by its "zap" tags (NIP-57)
+type ZapSplit struct {
+	Recipient sdk.ProfileMetadata
+	Weight    float64
+	Percent   float64
+}
+
+func (zs ZapSplit) PercentStr() string {
+	return strings.TrimSuffix(strconv.FormatFloat(zs.Percent, 'f', 1, 64), ".0") + "%"
+}
+
+// maxZapSplits is how many of the recipients are shown, events can have any number of "zap" tags
+const maxZapSplits = 20
+
+// zapSplits reads the "zap" tags of an event, when none of them has a weight the zaps are split equally,
+// otherwise the ones without a weight get nothing
+func zapSplits(tags nostr.Tags) []ZapSplit {
+	var splits []ZapSplit
+	indexes := make(map[string]int)
+	weighted := false
+	for tag := range tags.FindAll("zap") {
+		if !nostr.IsValidPublicKey(tag[1]) {
+			continue
+		}
+
+		split := ZapSplit{Recipient: sdk.ProfileMetadata{PubKey: tag[1]}}
+		if len(tag) >= 4 {
+			if weight, err := strconv.ParseFloat(tag[3], 64); err == nil && weight >= 0 {
+				split.Weight = weight
+				weighted = true
+			}
+		}
+
+		// the same recipient twice just gets the weights added up
+		if i, ok := indexes[tag[1]]; ok {
+			splits[i].Weight += split.Weight
+			continue
+		}
+		indexes[tag[1]] = len(splits)
+		splits = append(splits, split)
+	}
+
+	var total float64
+	for i := range splits {
+		if !weighted {
+			splits[i].Weight = 1
+		}
+		total += splits[i].Weight
+	}
+	if total == 0 {
+		return nil
+	}
+
+	for i := range splits {
+		splits[i].Percent = splits[i].Weight * 100 / total
+	}
+	return splits
+}
+
+// resolveZapSplits fills in the profiles of the recipients so they can be shown by name
+func resolveZapSplits(ctx context.Context, splits []ZapSplit) []ZapSplit {
+	pubkeys := make([]string, len(splits))
+	for i, split := range splits {
+		pubkeys[i] = split.Recipient.PubKey
+	}
+	for i, profile := range mentionResolver.resolveList(ctx, pubkeys) {
+		splits[i].Recipient = profile
+	}
+	return splits
+}
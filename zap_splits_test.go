@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/sdk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZapSplits(t *testing.T) {
+	names := map[string]string{testPubkey1: "alice", testPubkey2: "bob"}
+	var fetched [][]string
+	mentionResolver = profileResolver{
+		cache: testMetadataCache{},
+		fetch: func(ctx context.Context, requested []string) []sdk.ProfileMetadata {
+			fetched = append(fetched, requested)
+			profiles := make([]sdk.ProfileMetadata, 0, len(requested))
+			for _, pubkey := range requested {
+				profiles = append(profiles, sdk.ProfileMetadata{PubKey: pubkey, Name: names[pubkey]})
+			}
+			return profiles
+		},
+	}
+	defer func() { mentionResolver = profileResolver{} }()
+
+	evt := &nostr.Event{Kind: 1, Content: "split it", Tags: nostr.Tags{
+		{"zap", testPubkey1, "wss://relay.example.com", "1"},
+		{"zap", testPubkey2, "wss://relay.example.com", "3"},
+		{"zap", "not a pubkey", "", "10"},
+	}}
+	splits := resolveZapSplits(context.Background(), zapSplits(evt.Tags))
+	assert.Equal(t, [][]string{{testPubkey1, testPubkey2}}, fetched)
+	assert.Len(t, splits, 2)
+	assert.Equal(t, "alice", splits[0].Recipient.Name)
+	assert.Equal(t, 25.0, splits[0].Percent)
+	assert.Equal(t, 75.0, splits[1].Percent)
+	assert.Equal(t, "75%", splits[1].PercentStr())
+
+	var buf bytes.Buffer
+	params := NotePageParams{BaseEventPageParams: BaseEventPageParams{Event: testEnhancedEvent(evt)}, ZapSplits: splits}
+	assert.NoError(t, noteInnerBlock(params).Render(context.Background(), &buf))
+	doc, err := goquery.NewDocumentFromReader(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, doc.Find(".zap-split").Length())
+	assert.Equal(t, "bob", doc.Find(".zap-split a").Last().Text())
+	assert.Equal(t, "75%", doc.Find(".zap-split-percent").Last().Text())
+
+	// a single recipient gets everything, with or without a weight
+	for _, tag := range []nostr.Tag{{"zap", testPubkey1, "wss://relay.example.com"}, {"zap", testPubkey1, "", "7"}} {
+		splits = zapSplits(nostr.Tags{tag})
+		assert.Len(t, splits, 1)
+		assert.Equal(t, 100.0, splits[0].Percent)
+		assert.Equal(t, "100%", splits[0].PercentStr())
+	}
+
+	// no weights means equal parts, some weights means the others get nothing
+	splits = zapSplits(nostr.Tags{{"zap", testPubkey1}, {"zap", testPubkey2}, {"zap", testPubkey2}})
+	assert.Equal(t, []float64{50, 50}, []float64{splits[0].Percent, splits[1].Percent})
+	splits = zapSplits(nostr.Tags{{"zap", testPubkey1, "", "2"}, {"zap", testPubkey2}})
+	assert.Equal(t, []float64{100, 0}, []float64{splits[0].Percent, splits[1].Percent})
+
+	assert.Empty(t, zapSplits(nostr.Tags{{"p", testPubkey1}}))
+	assert.Empty(t, zapSplits(nostr.Tags{{"zap", testPubkey1, "", "0"}}))
+
+	// a lot of recipients still add up to everything
+	var many nostr.Tags
+	for range 5000 {
+		pubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+		many = append(many, nostr.Tag{"zap", pubkey})
+	}
+	splits = zapSplits(many)
+	assert.Len(t, splits, 5000)
+	assert.InDelta(t, 0.02, splits[0].Percent, 1e-9)
+}